@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"ws/internal/collector"
+	"ws/internal/service_db"
+)
+
+func cmdAPITokensCreate(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("api-tokens create", &opts)
+	name := fs.String("name", "", "Name to recognize the token, for example \"Home Assistant\" (required)")
+	account := fs.Int("account", 0, "Account ID of the member; the token gives access to its shared homes")
+	home := fs.String("home", "", "Limit the token to one home; without -account for a home of the installation")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usagef("-name is required")
+	}
+	if *account <= 0 && *home == "" {
+		return usagef("-account or -home is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.APITokenService{DB: dbConn}
+	token, t, err := svc.Create(ctx, *account, *home, *name)
+	if err != nil {
+		return err
+	}
+	// Alleen het token naar stdout, zodat het in een script kan worden opgevangen
+	fmt.Println(token)
+	fmt.Fprintf(os.Stderr, "✅ Token %d (%s) created; it is shown only once\n", t.ID, t.Name)
+	return nil
+}
+
+func cmdAPITokensList(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("api-tokens list", &opts)
+	account := fs.Int("account", 0, "Only the tokens of this account")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.APITokenService{DB: dbConn}
+	tokens, err := svc.List(ctx, *account)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		account, home, used, status := "-", "*", "-", "active"
+		if t.AccountID != 0 {
+			account = fmt.Sprint(t.AccountID)
+		}
+		if t.HomeID != "" {
+			home = t.HomeID
+		}
+		if t.LastUsedAt != nil {
+			used = t.LastUsedAt.Local().Format("2006-01-02 15:04")
+		}
+		if t.RevokedAt != nil {
+			status = "revoked " + t.RevokedAt.Local().Format("2006-01-02")
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, account, home, t.TokenHint, used, status)
+	}
+	fmt.Fprintf(os.Stderr, "%d tokens\n", len(tokens))
+	return nil
+}
+
+func cmdAPITokensRevoke(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("api-tokens revoke", &opts)
+	id := fs.Int("id", 0, "Token ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.APITokenService{DB: dbConn}
+	if err := svc.Revoke(ctx, *id); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Token %d revoked\n", *id)
+	return nil
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ws/internal/collector"
+	"ws/internal/config"
+	"ws/internal/db"
+	"ws/internal/export"
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/service_db"
+	"ws/internal/tibber"
+)
+
+// command is a subcommand of the collector
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"run", "", "Collect live measurements and run the scheduled jobs until stopped", cmdRun},
+	{"backfill", "-from DATE [-to DATE] [-home ID] [-resolution R]", "Fetch and store consumption and production for a period", cmdBackfill},
+	{"migrate", "", "Apply pending database migrations (-reset drops the Tibber tables first)", cmdMigrate},
+	{"homes sync", "", "Fetch the homes from Tibber and store them", cmdHomesSync},
+	{"prices fetch", "[-home ID]", "Fetch today's and tomorrow's prices and renew the forecasts", cmdPricesFetch},
+	{"price-source show", "-home ID", "Show the price source and supplier tariff of a home", cmdPriceSourceShow},
+	{"price-source set", "-home ID [-source S] [-zone Z] [-markup EUR] [-energy-tax EUR] [-vat RATE]", "Set the price source and supplier tariff of a home", cmdPriceSourceSet},
+	{"verify", "", "Check that the Tibber token works and the house ID exists", cmdVerify},
+	{"export", "-dataset NAME [-home ID] [-from DATE] [-to DATE] [-format F] [-o FILE]", "Export stored data as CSV, JSON Lines or Parquet", cmdExport},
+	{"config check", "", "Validate the configuration for the run command", cmdConfigCheck},
+	{"accounts list", "", "List the Tibber accounts of the members and their homes", cmdAccountsList},
+	{"accounts add", "[-name NAME] [-email ADDRESS] [-live] < token", "Store the Tibber token of a member (read from stdin) and fetch its homes", cmdAccountsAdd},
+	{"accounts token", "-id ID < token", "Replace the token of an account, for example after it was revoked", cmdAccountsToken},
+	{"accounts remove", "-id ID", "Delete an account; its homes fall back to tibber.token", cmdAccountsRemove},
+	{"accounts check", "", "Check every token, notify members of revoked tokens and fetch new homes", cmdAccountsCheck},
+	{"privacy export", "-account ID | -home ID -o FILE", "Write everything stored about a member to a ZIP file", cmdPrivacyExport},
+	{"privacy erase", "-account ID | -home ID [-pseudonymize] -yes", "Delete or pseudonymize the data of a member who leaves", cmdPrivacyErase},
+	{"privacy consent", "-account ID [-grant C | -withdraw C]", "Record and show the consents of a member", cmdPrivacyConsent},
+	{"privacy access-log", "-home ID [-days N]", "Show who viewed the data of a home in the dashboard", cmdPrivacyAccessLog},
+	{"api-tokens create", "-name NAME [-account ID] [-home ID]", "Create a token for the Home Assistant API of a member or home", cmdAPITokensCreate},
+	{"api-tokens list", "[-account ID]", "List the tokens for the Home Assistant API", cmdAPITokensList},
+	{"api-tokens revoke", "-id ID", "Revoke a token for the Home Assistant API", cmdAPITokensRevoke},
+	{"webhooks add", "-url URL -events E,... [-account ID]", "Register a webhook endpoint; prints its secret", cmdWebhooksAdd},
+	{"webhooks list", "[-account ID]", "List the webhook endpoints", cmdWebhooksList},
+	{"webhooks remove", "-id ID", "Remove a webhook endpoint with its deliveries", cmdWebhooksRemove},
+	{"webhooks test", "-id ID", "Queue a test event for a webhook endpoint", cmdWebhooksTest},
+	{"webhooks deliveries", "[-endpoint ID] [-status S]", "Show the webhook delivery log", cmdWebhooksDeliveries},
+	{"webhooks replay", "-id ID", "Queue a webhook delivery again", cmdWebhooksReplay},
+}
+
+// newFlagSet creates the flags of a command with the shared options
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("collector "+name, flag.ContinueOnError)
+	opts.register(fs)
+	return fs
+}
+
+// parseFlags parses the flags of a command; invalid flags are a usage error
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// parseDate parses a YYYY-MM-DD flag as the start of that day in the default time zone; the
+// configuration must be loaded first
+func parseDate(name, value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", value, localtime.Location(""))
+	if err != nil {
+		return time.Time{}, usagef("invalid -%s %q, expected YYYY-MM-DD", name, value)
+	}
+	return t, nil
+}
+
+// openServices connects to the database and creates the collector services
+func openServices(cfg *config.Config) (*collector.Services, func(), error) {
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := collector.NewServices(dbConn, cfg)
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	return services, func() { dbConn.Close() }, nil
+}
+
+// readToken reads a token from the first line of stdin, so that it does not end up in the shell history
+func readToken() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Tibber token: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", usagef("no token on stdin")
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", usagef("no token on stdin")
+	}
+	return token, nil
+}
+
+// accountsRequired are the settings the accounts commands and webhooks add need, for the key of secret.Box
+var accountsRequired = []string{"database.url", "tibber.accounts_key"}
+
+// selectHomes returns the home with the given ID, or all homes with production for "all"
+func selectHomes(ctx context.Context, services *collector.Services, homeID string) ([]model.Home, error) {
+	if homeID == "all" {
+		return services.Homes.GetHomesWithProductionCapability(ctx)
+	}
+	homes, err := services.Homes.GetHomes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, home := range homes {
+		if home.Id == homeID {
+			return []model.Home{home}, nil
+		}
+	}
+	return nil, usagef("unknown home %s", homeID)
+}
+
+func cmdRun(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("run", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load(collector.RunRequired...)
+	if err != nil {
+		return err
+	}
+	// Onbekende taken in [schedules] zijn een configuratiefout, geen mislukte run
+	if _, err := collector.Schedules(cfg); err != nil {
+		return configError{err}
+	}
+	return collector.RunRealTimeCollector(ctx, cfg)
+}
+
+func cmdBackfill(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("backfill", &opts)
+	home := fs.String("home", "all", "Home ID, or all for every home with production")
+	fromFlag := fs.String("from", "", "First day (YYYY-MM-DD, required)")
+	toFlag := fs.String("to", "", "Day after the last day (YYYY-MM-DD, default: up to now)")
+	resolution := fs.String("resolution", "both", "HOURLY, DAILY or both")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *fromFlag == "" {
+		return usagef("-from is required")
+	}
+	// Eerst de configuratie: community.time_zone bepaalt hoe de datums gelezen worden
+	cfg, err := opts.load("database.url", "tibber.token")
+	if err != nil {
+		return err
+	}
+	from, err := parseDate("from", *fromFlag)
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = parseDate("to", *toFlag); err != nil {
+			return err
+		}
+	}
+	if !to.After(from) {
+		return usagef("-to must be after -from")
+	}
+
+	var resolutions []string
+	switch strings.ToUpper(*resolution) {
+	case "HOURLY", "DAILY":
+		resolutions = []string{strings.ToUpper(*resolution)}
+	case "BOTH":
+		resolutions = []string{"DAILY", "HOURLY"}
+	default:
+		return usagef("invalid -resolution %q, expected HOURLY, DAILY or both", *resolution)
+	}
+
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	homes, err := selectHomes(ctx, services, *home)
+	if err != nil {
+		return err
+	}
+	return collector.Backfill(ctx, services, homes, resolutions, from, to)
+}
+
+func cmdMigrate(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("migrate", &opts)
+	reset := fs.Bool("reset", false, "Drop prices, consumption, production, homes and owners before migrating; their data is lost")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	if *reset {
+		if err := db.ResetSchema(dbConn); err != nil {
+			return fmt.Errorf("error resetting database schema: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "✅ Database schema reset, run homes sync and backfill to fetch the data again")
+		return nil
+	}
+	if err := db.InitSchema(dbConn); err != nil {
+		return fmt.Errorf("error migrating database schema: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "✅ Database schema up to date")
+	return nil
+}
+
+func cmdHomesSync(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("homes sync", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("database.url", "tibber.token")
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	homes, err := services.Homes.SyncHomes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, home := range homes {
+		fmt.Printf("%s\t%s\t%s\n", home.Id, home.AppNickname, home.Address.Address1)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %d homes synchronized\n", len(homes))
+	return nil
+}
+
+func cmdPricesFetch(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("prices fetch", &opts)
+	home := fs.String("home", "all", "Home ID, or all for every home with production")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("database.url", "tibber.token")
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	homes, err := selectHomes(ctx, services, *home)
+	if err != nil {
+		return err
+	}
+	return collector.LoadPricesFor(ctx, services, homes)
+}
+
+func cmdVerify(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("verify", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("tibber.token", "tibber.house_id")
+	if err != nil {
+		return err
+	}
+
+	// Zelf opbouwen: tibber.NewClient controleert de toegang al en logt alleen een waarschuwing
+	wsClient := &tibber.Client{
+		WebsocketClient: &tibber.WebsocketClient{
+			Config: &tibber.WebsocketConfig{Token: cfg.Tibber.Token, Id: cfg.Tibber.HouseID},
+		},
+		APIURL: cfg.Tibber.APIURL,
+	}
+	if err := wsClient.VerifyAccess(); err != nil {
+		return configErrorf("Tibber access failed: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Tibber access verified for house ID %s\n", cfg.Tibber.HouseID)
+	return nil
+}
+
+func cmdConfigCheck(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("config check", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load(collector.RunRequired...)
+	if err != nil {
+		return err
+	}
+	if _, err := collector.Schedules(cfg); err != nil {
+		return configError{err}
+	}
+
+	source := cfg.Path
+	if source == "" {
+		source = "environment (no config file)"
+	}
+	fmt.Fprintf(os.Stderr, "✅ Configuration from %s is valid\n", source)
+	return nil
+}
+
+func cmdAccountsList(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("accounts list", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load(accountsRequired...)
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	accounts, err := services.Accounts.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		checked := "-"
+		if a.LastChecked != nil {
+			checked = a.LastChecked.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.Name, a.Email, a.TokenHint, a.Status, checked, strings.Join(a.Homes, ","))
+	}
+	fmt.Fprintf(os.Stderr, "%d accounts\n", len(accounts))
+	return nil
+}
+
+func cmdAccountsAdd(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("accounts add", &opts)
+	name := fs.String("name", "", "Name of the member (default: the name of the Tibber account)")
+	email := fs.String("email", "", "E-mail address for notifications about the token")
+	live := fs.Bool("live", false, "The member agreed to live measurements of the Tibber Pulse")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load(accountsRequired...)
+	if err != nil {
+		return err
+	}
+	token, err := readToken()
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	// Toevoegen door de beheerder gebeurt met instemming van het lid voor de gemeenschap
+	account, err := services.Accounts.Add(ctx, service_db.NewAccount{
+		Name:     *name,
+		Email:    *email,
+		Token:    token,
+		Consents: map[string]bool{model.ConsentCommunity: true, model.ConsentLive: *live},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Account %d (%s) stored\n", account.ID, account.Name)
+
+	homes, err := services.Homes.SyncAccountHomes(ctx, account.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching homes of account %d: %w", account.ID, err)
+	}
+	for _, home := range homes {
+		fmt.Printf("%s\t%s\t%s\n", home.Id, home.AppNickname, home.Address.Address1)
+	}
+	return nil
+}
+
+func cmdAccountsToken(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("accounts token", &opts)
+	id := fs.Int("id", 0, "Account ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load(accountsRequired...)
+	if err != nil {
+		return err
+	}
+	token, err := readToken()
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	if err := services.Accounts.UpdateToken(ctx, *id, token); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Token of account %d replaced\n", *id)
+	return nil
+}
+
+func cmdAccountsRemove(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("accounts remove", &opts)
+	id := fs.Int("id", 0, "Account ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+
+	if err := services.Accounts.Remove(ctx, *id); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Account %d removed\n", *id)
+	return nil
+}
+
+func cmdAccountsCheck(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("accounts check", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load(accountsRequired...)
+	if err != nil {
+		return err
+	}
+	services, closeDB, err := openServices(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer closeDB()
+	return collector.CheckAccounts(ctx, services)
+}
+
+func cmdExport(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("export", &opts)
+	now := time.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	dataset := fs.String("dataset", "", "Dataset: "+strings.Join(service_db.ExportDatasets(), ", "))
+	home := fs.String("home", "all", "Home ID, or all for every home of the community")
+	fromFlag := fs.String("from", firstOfMonth.AddDate(0, -1, 0).Format("2006-01-02"), "First day (YYYY-MM-DD)")
+	toFlag := fs.String("to", firstOfMonth.Format("2006-01-02"), "Day after the last day (YYYY-MM-DD)")
+	resolution := fs.String("resolution", "DAILY", "HOURLY or DAILY")
+	formatFlag := fs.String("format", "", "csv, jsonl or parquet (default: from the output extension, else csv)")
+	output := fs.String("o", "", "Output file (default: stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *dataset == "" {
+		return usagef("-dataset is required")
+	}
+	// Exportperiodes zijn UTC-dagen, zoals in cmd/export en de webserver
+	from, err := time.Parse("2006-01-02", *fromFlag)
+	if err != nil {
+		return usagef("invalid -from %q, expected YYYY-MM-DD", *fromFlag)
+	}
+	to, err := time.Parse("2006-01-02", *toFlag)
+	if err != nil {
+		return usagef("invalid -to %q, expected YYYY-MM-DD", *toFlag)
+	}
+	name := *formatFlag
+	if name == "" {
+		name = filepath.Ext(*output)
+	}
+	format, err := export.ParseFormat(name)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	homeID := *home
+	if homeID == "all" {
+		homeID = ""
+	}
+	return writeExport(ctx, dbConn, service_db.ExportQuery{
+		Dataset:    *dataset,
+		HomeId:     homeID,
+		From:       from,
+		To:         to,
+		Resolution: *resolution,
+	}, format, *output)
+}
+
+// writeExport streams an export to a file, or to stdout when output is empty or "-"
+func writeExport(ctx context.Context, dbConn *sql.DB, q service_db.ExportQuery, format export.Format, output string) error {
+	out := os.Stdout
+	if output != "" && output != "-" {
+		var err error
+		if out, err = os.Create(output); err != nil {
+			return err
+		}
+	}
+	buffered := bufio.NewWriterSize(out, 1<<20)
+
+	svc := &service_db.ExportService{DB: dbConn}
+	count, err := svc.Export(ctx, q, format, buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ %d rows of %s exported as %s\n", count, q.Dataset, format)
+	return nil
+}
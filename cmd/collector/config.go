@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"ws/internal/config"
+	"ws/internal/localtime"
+
+	"github.com/joho/godotenv"
+)
+
+// Exit codes van de collector
+const (
+	exitOK     = 0
+	exitError  = 1 // De opdracht is mislukt
+	exitUsage  = 2 // Onbekende opdracht of ongeldige vlaggen
+	exitConfig = 3 // Configuratie ontbreekt of is ongeldig, of Tibber weigert de toegang
+)
+
+// usageError is returned for invalid arguments and exits with exitUsage
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// configError is returned for missing or invalid configuration and exits with exitConfig
+type configError struct{ err error }
+
+func (e configError) Error() string { return e.err.Error() }
+func (e configError) Unwrap() error { return e.err }
+
+func configErrorf(format string, args ...any) error {
+	return configError{fmt.Errorf(format, args...)}
+}
+
+// exitCode maps the error of a command to the exit code of the process
+func exitCode(err error) int {
+	var usage usageError
+	var config configError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &config):
+		return exitConfig
+	}
+	return exitError
+}
+
+// options are the flags that every command has
+type options struct {
+	configFile  string
+	databaseURL string
+	token       string
+	houseID     string
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "TOML config file (default: $CONFIG_FILE, else ./config.toml when present)")
+	fs.StringVar(&o.databaseURL, "database-url", "", "PostgreSQL URL (default: database.url)")
+	fs.StringVar(&o.token, "token", "", "Tibber API token (default: tibber.token)")
+	fs.StringVar(&o.houseID, "house-id", "", "Tibber home for live measurements (default: tibber.house_id)")
+}
+
+// load resolves the configuration with flags over environment over config file, and validates it
+// with the settings the command needs. A ./.env file is still read into the environment, without
+// overriding variables that are already set, so existing setups keep working.
+func (o *options) load(required ...string) (*config.Config, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, configErrorf("error loading .env: %w", err)
+	}
+
+	path := o.configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, configError{err}
+	}
+
+	cfg.ApplyOverride(func(c *config.Config) {
+		if o.databaseURL != "" {
+			c.Database.URL = o.databaseURL
+		}
+		if o.token != "" {
+			c.Tibber.Token = o.token
+		}
+		if o.houseID != "" {
+			c.Tibber.HouseID = o.houseID
+		}
+	})
+
+	if err := cfg.Validate(required...); err != nil {
+		return nil, configError{err}
+	}
+	if err := localtime.SetDefaultTimeZone(cfg.Community.TimeZone); err != nil {
+		return nil, configError{err}
+	}
+	return cfg, nil
+}
@@ -2,34 +2,72 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-
-	"ws/internal/collector"
-
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load .env file from root directory
-	if err := godotenv.Load("./.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+	// Ctrl-C en SIGTERM stoppen de opdracht netjes; run maakt lopende taken nog af
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := dispatch(ctx, os.Args[1:])
+	cancel()
+
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		var usage usageError
+		if errors.As(err, &usage) {
+			fmt.Fprintln(os.Stderr, "Run 'collector help' for usage.")
+		}
 	}
+	os.Exit(exitCode(err))
+}
 
-	// Create context that can be cancelled
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// dispatch runs the command in args. Without a command, or with only flags, the collector runs
+// as before the subcommands existed.
+func dispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+		return cmdRun(ctx, args)
+	}
+	if isHelp(args[0]) {
+		printUsage()
+		return nil
+	}
 
-	// Setup signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != cmd.name {
+			continue
+		}
+		return cmd.run(ctx, args[len(words):])
+	}
+	return usagef("unknown command %q", strings.Join(args[:min(len(args), 2)], " "))
+}
 
-	// Start real-time collector in a goroutine
-	go collector.RunRealTimeCollector(ctx)
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
 
-	// Wait for shutdown signal
-	<-sigChan
-	cancel()
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: collector <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.summary)
+		if cmd.args != "" {
+			fmt.Fprintf(os.Stderr, "  %-20s   %s\n", "", cmd.args)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Every command accepts -config, -database-url, -token and -house-id. Settings come from")
+	fmt.Fprintln(os.Stderr, "flags first, then the environment (and ./.env), then the TOML config file ($CONFIG_FILE")
+	fmt.Fprintln(os.Stderr, "or ./config.toml). See config.example.toml for all settings.")
+	fmt.Fprintln(os.Stderr, "'collector <command> -h' shows the flags of a command.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Exit codes: 0 success, 1 command failed, 2 invalid usage, 3 configuration missing or")
+	fmt.Fprintln(os.Stderr, "invalid, or Tibber refused access.")
 }
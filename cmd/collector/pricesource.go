@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"ws/internal/collector"
+	"ws/internal/pricesource"
+	"ws/internal/service_db"
+)
+
+func cmdPriceSourceShow(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("price-source show", &opts)
+	home := fs.String("home", "", "Home ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *home == "" {
+		return usagef("-home is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.PriceSourceService{DB: dbConn}
+	sourceConfig, err := svc.GetConfig(ctx, *home)
+	if err != nil {
+		return err
+	}
+	printPriceSource(sourceConfig)
+	return nil
+}
+
+func cmdPriceSourceSet(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("price-source set", &opts)
+	home := fs.String("home", "", "Home ID (required)")
+	source := fs.String("source", "", "Price source: "+pricesource.SourceTibber+" or "+pricesource.SourceEntsoe)
+	zone := fs.String("zone", "", "ENTSO-E bidding zone as EIC code or price area (NL, BE, DE); empty uses the price area of the home")
+	markup := fs.Float64("markup", 0, "Supplier markup in EUR/kWh excluding VAT")
+	energyTax := fs.Float64("energy-tax", 0, "Energy tax in EUR/kWh excluding VAT")
+	vat := fs.Float64("vat", 0, "VAT rate, for example 0.21")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *home == "" {
+		return usagef("-home is required")
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	*source = strings.ToUpper(*source)
+	if set["source"] && *source != pricesource.SourceTibber && *source != pricesource.SourceEntsoe {
+		return usagef("unknown -source %q", *source)
+	}
+	if code, ok := pricesource.BiddingZones[strings.ToUpper(*zone)]; ok {
+		*zone = code
+	}
+	if *markup < -1 || *markup > 1 {
+		return usagef("-markup must be in EUR/kWh, between -1 and 1")
+	}
+	if *energyTax < 0 || *energyTax > 1 {
+		return usagef("-energy-tax must be in EUR/kWh, between 0 and 1")
+	}
+	if *vat < 0 || *vat >= 1 {
+		return usagef("-vat must be a rate between 0 and 1")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	// Alleen de opgegeven instellingen veranderen
+	svc := &service_db.PriceSourceService{DB: dbConn}
+	sourceConfig, err := svc.GetConfig(ctx, *home)
+	if err != nil {
+		return err
+	}
+	if set["source"] {
+		sourceConfig.Source = *source
+	}
+	if set["zone"] {
+		sourceConfig.BiddingZone = *zone
+	}
+	if set["markup"] {
+		sourceConfig.Tariff.Markup = *markup
+	}
+	if set["energy-tax"] {
+		sourceConfig.Tariff.EnergyTax = *energyTax
+	}
+	if set["vat"] {
+		sourceConfig.Tariff.VatRate = *vat
+	}
+	if err := svc.SaveConfig(ctx, *sourceConfig); err != nil {
+		return err
+	}
+	printPriceSource(sourceConfig)
+	fmt.Fprintf(os.Stderr, "✅ Price source of home %s saved; `prices fetch -home %s` loads the prices now\n", *home, *home)
+	return nil
+}
+
+// printPriceSource prints the price source configuration of a home
+func printPriceSource(c *pricesource.MemberConfig) {
+	zone := c.BiddingZone
+	if zone == "" {
+		zone = "-"
+	}
+	fmt.Printf("home\t%s\nsource\t%s\nzone\t%s\nmarkup\t%.5f\nenergy-tax\t%.5f\nvat\t%.4f\n",
+		c.HomeId, c.Source, zone, c.Tariff.Markup, c.Tariff.EnergyTax, c.Tariff.VatRate)
+}
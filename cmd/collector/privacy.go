@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"ws/internal/collector"
+	"ws/internal/model"
+	"ws/internal/service_db"
+)
+
+// privacySubject returns the account or home of the -account and -home flags; exactly one is required
+func privacySubject(ctx context.Context, svc *service_db.PrivacyService, account int, home string) (*service_db.Subject, error) {
+	switch {
+	case account > 0 && home != "":
+		return nil, usagef("use -account or -home, not both")
+	case account > 0:
+		return svc.AccountSubject(ctx, account)
+	case home != "":
+		return &service_db.Subject{HomeIDs: []string{home}}, nil
+	}
+	return nil, usagef("-account or -home is required")
+}
+
+func cmdPrivacyExport(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("privacy export", &opts)
+	account := fs.Int("account", 0, "Account ID of the member")
+	home := fs.String("home", "", "Home ID, for a home without account")
+	output := fs.String("o", "", "Output ZIP file (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return usagef("-o is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.PrivacyService{DB: dbConn}
+	subject, err := privacySubject(ctx, svc, *account, *home)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	count, err := svc.Export(ctx, subject, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ %d rows of %d homes exported to %s\n", count, len(subject.HomeIDs), *output)
+	return nil
+}
+
+func cmdPrivacyErase(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("privacy erase", &opts)
+	account := fs.Int("account", 0, "Account ID of the member; erases the account and all its homes")
+	home := fs.String("home", "", "Home ID, to erase a single home")
+	pseudonymize := fs.Bool("pseudonymize", false, "Keep consumption, production and prices under a random home ID")
+	yes := fs.Bool("yes", false, "Confirm the erasure; it cannot be undone")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !*yes {
+		return usagef("erasing cannot be undone, add -yes to confirm")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.PrivacyService{DB: dbConn}
+	subject, err := privacySubject(ctx, svc, *account, *home)
+	if err != nil {
+		return err
+	}
+	erasure, err := svc.Erase(ctx, subject, *pseudonymize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Erasure %d: %d rows of %d homes (%s)\n", erasure.ID, erasure.Rows, erasure.Homes, strings.ToLower(erasure.Mode))
+	if *home != "" {
+		fmt.Fprintln(os.Stderr, "The home returns at the next start when tibber.token still gives access to it.")
+	}
+	return nil
+}
+
+func cmdPrivacyConsent(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("privacy consent", &opts)
+	categories := strings.Join(model.ConsentCategories, ", ")
+	account := fs.Int("account", 0, "Account ID of the member (required)")
+	grant := fs.String("grant", "", "Record consent for a category: "+categories)
+	withdraw := fs.String("withdraw", "", "Record that consent for a category is withdrawn: "+categories)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *account <= 0 {
+		return usagef("-account is required")
+	}
+	if *grant != "" && *withdraw != "" {
+		return usagef("use -grant or -withdraw, not both")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.PrivacyService{DB: dbConn}
+	category, granted := *grant, true
+	if *withdraw != "" {
+		category, granted = *withdraw, false
+	}
+	if category != "" {
+		if err := svc.SetConsent(ctx, *account, category, granted); err != nil {
+			return err
+		}
+		if category == model.ConsentCommunity && !granted {
+			fmt.Fprintln(os.Stderr, "Without community consent the member cannot take part; use 'privacy erase' to remove the data.")
+		}
+	}
+
+	consents, err := svc.Consents(ctx, *account)
+	if err != nil {
+		return err
+	}
+	for _, c := range consents {
+		fmt.Printf("%s\t%t\t%s\t%s\n", c.Category, c.Granted, c.Version, c.RecordedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func cmdPrivacyAccessLog(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("privacy access-log", &opts)
+	home := fs.String("home", "", "Home ID (required)")
+	days := fs.Int("days", 30, "Number of days to show")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *home == "" {
+		return usagef("-home is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.PrivacyService{DB: dbConn}
+	entries, err := svc.AccessLog(ctx, *home, time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		viewer := e.Viewer
+		if viewer == "" {
+			viewer = "-"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", e.AccessedAt.Local().Format("2006-01-02 15:04:05"), viewer, e.RemoteAddr, e.Resource)
+	}
+	fmt.Fprintf(os.Stderr, "%d views of home %s in the last %d days\n", len(entries), *home, *days)
+	return nil
+}
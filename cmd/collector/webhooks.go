@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"ws/internal/collector"
+	"ws/internal/model"
+	"ws/internal/service_db"
+	"ws/internal/webhook"
+)
+
+func cmdWebhooksAdd(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks add", &opts)
+	url := fs.String("url", "", "URL that receives the events (required)")
+	events := fs.String("events", "", "Comma-separated events: "+strings.Join(webhook.EventTypes, ", ")+" (required)")
+	account := fs.Int("account", 0, "Account ID of the member; without it the endpoint gets the events of every home")
+	description := fs.String("description", "", "Description of the endpoint")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *url == "" || *events == "" {
+		return usagef("-url and -events are required")
+	}
+	var types []string
+	for _, e := range strings.Split(*events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			types = append(types, e)
+		}
+	}
+	// De sleutel wordt versleuteld opgeslagen, net als de tokens van leden
+	cfg, err := opts.load(accountsRequired...)
+	if err != nil {
+		return err
+	}
+	box, err := cfg.Tibber.Box()
+	if err != nil {
+		return configError{err}
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn, Box: box}
+	endpoint, secret, err := svc.AddEndpoint(ctx, *account, *url, types, *description)
+	if err != nil {
+		return err
+	}
+	// Alleen de sleutel naar stdout, zodat hij in een script kan worden opgevangen
+	fmt.Println(secret)
+	fmt.Fprintf(os.Stderr, "✅ Endpoint %d for %s added; the secret is shown only once\n", endpoint.ID, endpoint.URL)
+	return nil
+}
+
+func cmdWebhooksList(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks list", &opts)
+	account := fs.Int("account", 0, "Only the endpoints of this account")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn}
+	endpoints, err := svc.Endpoints(ctx, *account)
+	if err != nil {
+		return err
+	}
+	for _, e := range endpoints {
+		account, status := "-", "active"
+		if e.AccountID != 0 {
+			account = fmt.Sprint(e.AccountID)
+		}
+		if !e.Active {
+			status = "paused"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.URL, strings.Join(e.Events, ","), account, status, e.Description)
+	}
+	fmt.Fprintf(os.Stderr, "%d endpoints\n", len(endpoints))
+	return nil
+}
+
+func cmdWebhooksRemove(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks remove", &opts)
+	id := fs.Int("id", 0, "Endpoint ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn}
+	if err := svc.RemoveEndpoint(ctx, *id, 0); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Endpoint %d removed with its deliveries\n", *id)
+	return nil
+}
+
+func cmdWebhooksTest(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks test", &opts)
+	id := fs.Int("id", 0, "Endpoint ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn}
+	if err := svc.Ping(ctx, *id, 0); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s queued for endpoint %d; the webhooks job delivers it within a minute\n", webhook.EventPing, *id)
+	return nil
+}
+
+func cmdWebhooksDeliveries(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks deliveries", &opts)
+	endpoint := fs.Int("endpoint", 0, "Only the deliveries to this endpoint")
+	status := fs.String("status", "", "Only deliveries with this status: "+strings.Join([]string{model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed}, ", "))
+	limit := fs.Int("limit", 50, "Number of deliveries, newest first")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	*status = strings.ToUpper(*status)
+	switch *status {
+	case "", model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed:
+	default:
+		return usagef("unknown -status %q", *status)
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn}
+	deliveries, err := svc.Deliveries(ctx, service_db.DeliveryFilter{EndpointID: *endpoint, Status: *status, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		last, response := "-", "-"
+		if d.LastAttemptAt != nil {
+			last = d.LastAttemptAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if d.ResponseStatus != 0 {
+			response = fmt.Sprint(d.ResponseStatus)
+		}
+		fmt.Printf("%d\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			d.ID, d.EndpointID, d.EventType, d.HomeID, d.Status, d.Attempts, last, response, d.LastError)
+	}
+	fmt.Fprintf(os.Stderr, "%d deliveries\n", len(deliveries))
+	return nil
+}
+
+func cmdWebhooksReplay(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("webhooks replay", &opts)
+	id := fs.Int64("id", 0, "Delivery ID (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usagef("-id is required")
+	}
+	cfg, err := opts.load("database.url")
+	if err != nil {
+		return err
+	}
+	dbConn, err := collector.Connect(cfg)
+	if err != nil {
+		return configError{err}
+	}
+	defer dbConn.Close()
+
+	svc := &service_db.WebhookService{DB: dbConn}
+	replay, err := svc.Replay(ctx, *id, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Delivery %d queued again as delivery %d\n", *id, replay)
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"ws/internal/config"
+	"ws/internal/db"
+	"ws/internal/export"
+	"ws/internal/service_db"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	// .env is optioneel, database.url kan ook uit de omgeving of het configuratiebestand komen
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "⚠️ Error: Could not load .env file: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	dataset := flag.String("dataset", "", "Dataset: "+strings.Join(service_db.ExportDatasets(), ", "))
+	home := flag.String("home", "all", "Home ID, or all for every home of the community")
+	fromFlag := flag.String("from", firstOfMonth.AddDate(0, -1, 0).Format("2006-01-02"), "First day (YYYY-MM-DD)")
+	toFlag := flag.String("to", firstOfMonth.Format("2006-01-02"), "Day after the last day (YYYY-MM-DD)")
+	resolution := flag.String("resolution", "DAILY", "HOURLY or DAILY")
+	formatFlag := flag.String("format", "", "csv, jsonl or parquet (default: from the output extension, else csv)")
+	output := flag.String("o", "", "Output file (default: stdout)")
+	configFlag := flag.String("config", "", "TOML config file (default: $CONFIG_FILE, else ./config.toml when present)")
+	flag.Parse()
+
+	if *dataset == "" {
+		fmt.Fprintln(os.Stderr, "-dataset is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	from, err := time.Parse("2006-01-02", *fromFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: invalid from date %q\n", *fromFlag)
+		os.Exit(2)
+	}
+	to, err := time.Parse("2006-01-02", *toFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: invalid to date %q\n", *toFlag)
+		os.Exit(2)
+	}
+
+	name := *formatFlag
+	if name == "" {
+		name = filepath.Ext(*output)
+	}
+	format, err := export.ParseFormat(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	path := *configFlag
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.Load(path)
+	if err == nil {
+		err = cfg.Validate("database.url")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	dbConfig, err := db.ParseURL(cfg.Database.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+	dbConn, err := db.NewConnection(dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer dbConn.Close()
+
+	// Ctrl-C breekt een lange export netjes af
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	out := os.Stdout
+	if *output != "" && *output != "-" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	buffered := bufio.NewWriterSize(out, 1<<20)
+
+	homeID := *home
+	if homeID == "all" {
+		homeID = ""
+	}
+	svc := &service_db.ExportService{DB: dbConn}
+	count, err := svc.Export(ctx, service_db.ExportQuery{
+		Dataset:    *dataset,
+		HomeId:     homeID,
+		From:       from,
+		To:         to,
+		Resolution: *resolution,
+	}, format, buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ %d rows of %s exported as %s\n", count, *dataset, format)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ws/internal/model"
+)
+
+// accessLogInterval is how often the same viewer is logged for the same data of a home; the
+// dashboard refreshes its partials, which would otherwise fill the log
+const accessLogInterval = 15 * time.Minute
+
+// logAccess wraps a handler for the data of a home so that the view is written to the access log.
+// The resource is the route name, followed by the type of a combined route.
+func (wd *WebDashboard) logAccess(resource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if homeID == "" {
+			homeID = wd.Config().Tibber.HouseID
+		}
+		if kind := chi.URLParam(r, "type"); kind != "" {
+			resource += "/" + kind
+		}
+		wd.recordAccess(r, homeID, resource)
+		next(w, r)
+	}
+}
+
+// recordAccess queues a view for the access log without waiting for the database
+func (wd *WebDashboard) recordAccess(r *http.Request, homeID, resource string) {
+	if wd.accessLog == nil || homeID == "" {
+		return
+	}
+	// Zonder poort, anders telt elke verbinding als een nieuwe kijker
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	entry := model.DataAccess{
+		AccessedAt: time.Now(),
+		RemoteAddr: addr,
+		HomeID:     homeID,
+		Resource:   resource,
+	}
+	if header := wd.Config().Web.ViewerHeader; header != "" {
+		entry.Viewer = r.Header.Get(header)
+	}
+	// Via de Home Assistant API is het token de kijker
+	if t := apiTokenFrom(r.Context()); t != nil {
+		entry.Viewer = fmt.Sprintf("api-token:%d", t.ID)
+	}
+
+	key := entry.Viewer + "|" + entry.RemoteAddr + "|" + homeID + "|" + resource
+	if last, ok := wd.accessSeen.Load(key); ok && entry.AccessedAt.Sub(last.(time.Time)) < accessLogInterval {
+		return
+	}
+	wd.accessSeen.Store(key, entry.AccessedAt)
+
+	select {
+	case wd.accessLog <- entry:
+	default:
+		log.Printf("⚠️ Access log queue full, view of home %s not logged", homeID)
+	}
+}
+
+// writeAccessLog stores the queued views until ctx is done
+func (wd *WebDashboard) writeAccessLog(ctx context.Context) {
+	cleanup := time.NewTicker(accessLogInterval)
+	defer cleanup.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-wd.accessLog:
+			entries := []model.DataAccess{entry}
+			// Wat er verder in de wachtrij staat in één keer meenemen
+		drain:
+			for len(entries) < 100 {
+				select {
+				case more := <-wd.accessLog:
+					entries = append(entries, more)
+				default:
+					break drain
+				}
+			}
+			writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := wd.PrivacySvc.LogAccess(writeCtx, entries); err != nil {
+				log.Printf("Error writing access log: %v", err)
+			}
+			cancel()
+		case now := <-cleanup.C:
+			wd.accessSeen.Range(func(key, value interface{}) bool {
+				if now.Sub(value.(time.Time)) >= accessLogInterval {
+					wd.accessSeen.Delete(key)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -1,18 +1,35 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/google/uuid"
+
+	"ws/internal/export"
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/planner"
+	"ws/internal/powerquality"
+	"ws/internal/scheduler"
+	"ws/internal/service_db"
+	"ws/internal/tariff"
 )
 
+// maxImportSize limits uploads of metering data; a year of quarter hours is a few MB
+const maxImportSize = 32 << 20
+
 // HTTP response helpers
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	log.Printf("Error response: %d - %s", code, message)
@@ -30,7 +47,7 @@ func respondWithJSON(w http.ResponseWriter, data interface{}) {
 func (wd *WebDashboard) handleHome() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"Title": wd.Title,
+			"Title": wd.Config().Web.Title,
 			"Homes": wd.Homes,
 		}
 
@@ -83,7 +100,8 @@ func (wd *WebDashboard) handlePricePartial() http.HandlerFunc {
 func (wd *WebDashboard) handleConsumptionPartial() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		homeID := chi.URLParam(r, "homeID")
-		if _, err := wd.findHomeByID(homeID); err != nil {
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
 			respondWithError(w, http.StatusNotFound, err.Error())
 			return
 		}
@@ -119,6 +137,9 @@ func (wd *WebDashboard) handleConsumptionPartial() http.HandlerFunc {
 				"TotalConsumption": totalConsumption,
 				"TotalCost":        totalCost,
 			}
+			if footprint := wd.recentFootprint(ctx, *selectedHome, 7); footprint != nil {
+				data["Carbon"] = footprint
+			}
 		}
 
 		if err := wd.Templates.ExecuteTemplate(w, "consumption.html", data); err != nil {
@@ -169,6 +190,9 @@ func (wd *WebDashboard) handleProductionPartial() http.HandlerFunc {
 				"TotalProduction": totalProduction,
 				"TotalProfit":     totalProfit,
 			}
+			if footprint := wd.recentFootprint(ctx, *selectedHome, 7); footprint != nil {
+				data["Carbon"] = footprint
+			}
 		}
 
 		if err := wd.Templates.ExecuteTemplate(w, "production.html", data); err != nil {
@@ -193,6 +217,416 @@ func (wd *WebDashboard) handleHomeDetailsPartial() http.HandlerFunc {
 	}
 }
 
+// handleTariffPartial toont de vergelijking van contracten voor een huis
+func (wd *WebDashboard) handleTariffPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Tariefvergelijking is niet beschikbaar zonder database",
+		}
+
+		if wd.TariffSvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			comparison, err := wd.compareTariffs(ctx, *selectedHome, queryInt(r, "days", 30))
+			if err != nil {
+				log.Printf("Error comparing tariffs for %s: %v", homeID, err)
+				data["Message"] = "Nog onvoldoende verbruiksgegevens voor een vergelijking"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":   true,
+					"HomeId":     homeID,
+					"Comparison": comparison,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "tariffs.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleNetMeteringPartial toont het jaaroverzicht van het einde van de salderingsregeling
+func (wd *WebDashboard) handleNetMeteringPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Salderingsberekening is niet beschikbaar zonder database",
+		}
+
+		if wd.TariffSvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			report, err := wd.netMeteringReport(ctx, *selectedHome, r)
+			if err != nil {
+				log.Printf("Error calculating net metering for %s: %v", homeID, err)
+				data["Message"] = "Nog onvoldoende verbruiksgegevens voor dit jaar"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":     true,
+					"HomeId":       homeID,
+					"Report":       report,
+					"PreviousYear": report.Year - 1,
+					"Fee":          queryFloat(r, "fee", tariff.DefaultFeedInFee),
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "netmetering.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleSolarPartial toont de verwachte en werkelijke zonneproductie
+func (wd *WebDashboard) handleSolarPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Zonnevoorspelling is niet beschikbaar zonder database",
+		}
+
+		switch {
+		case selectedHome.MeteringPointData.ProductionEan == "":
+			data["Message"] = "Dit huis heeft geen teruglevering"
+		case wd.SolarSvc != nil:
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			comparison, err := wd.compareSolar(ctx, *selectedHome)
+			if err != nil {
+				log.Printf("Error forecasting solar production for %s: %v", homeID, err)
+				data["Message"] = "Nog onvoldoende productiegegevens per uur voor een voorspelling"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":   true,
+					"HomeId":     homeID,
+					"Comparison": comparison,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "solar.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleUsagePartial toont de verbruiksvoorspelling en het sluipverbruik
+func (wd *WebDashboard) handleUsagePartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Verbruiksvoorspelling is niet beschikbaar zonder database",
+		}
+
+		if wd.UsageSvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			usage, err := wd.forecastUsage(ctx, *selectedHome, queryInt(r, "hours", 48))
+			if err != nil {
+				log.Printf("Error forecasting consumption for %s: %v", homeID, err)
+				data["Message"] = "Nog onvoldoende verbruiksgegevens per uur voor een voorspelling"
+			} else {
+				data = map[string]interface{}{
+					"IsActive": true,
+					"HomeId":   homeID,
+					"Usage":    usage,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "usage.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleAnomaliesPartial toont de openstaande afwijkingen van een huis
+func (wd *WebDashboard) handleAnomaliesPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Afwijkingsdetectie is niet beschikbaar zonder database",
+		}
+
+		if wd.AnomalySvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			days := queryInt(r, "days", 30)
+			events, err := wd.AnomalySvc.List(ctx, homeID, time.Now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Printf("Error loading anomalies for %s: %v", homeID, err)
+				data["Message"] = "Afwijkingen konden niet worden geladen"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":  true,
+					"HomeId":    homeID,
+					"Days":      days,
+					"Anomalies": events,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "anomalies.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handlePowerQualityPartial toont fasebalans, zekeringbelasting en overspanning van een huis
+func (wd *WebDashboard) handlePowerQualityPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Spanningskwaliteit is niet beschikbaar zonder database",
+		}
+
+		if wd.QualitySvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			overview, err := wd.powerQuality(ctx, *selectedHome, queryInt(r, "days", 30))
+			if err != nil {
+				log.Printf("Error loading power quality for %s: %v", homeID, err)
+				data["Message"] = "Spanningskwaliteit kon niet worden geladen"
+			} else if !overview.Last24h.HasPhaseData() && len(overview.Daily) == 0 {
+				data["Message"] = "De meter levert geen spanning of stroom per fase"
+			} else {
+				data = map[string]interface{}{
+					"IsActive": true,
+					"HomeId":   homeID,
+					"Quality":  overview,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "powerquality.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleCurtailmentPartial toont hoeveel opbrengst een huis misloopt door afschakelende omvormers
+func (wd *WebDashboard) handleCurtailmentPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Afschakeldetectie is niet beschikbaar zonder database",
+		}
+
+		if selectedHome.MeteringPointData.ProductionEan == "" {
+			data["Message"] = "Dit huis heeft geen zonnepanelen"
+		} else if wd.QualitySvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			overview, err := wd.curtailment(ctx, *selectedHome, queryInt(r, "days", 30))
+			if err != nil {
+				log.Printf("Error loading curtailment for %s: %v", homeID, err)
+				data["Message"] = "Afschakelgegevens konden niet worden geladen"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":    true,
+					"HomeId":      homeID,
+					"Curtailment": overview,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "curtailment.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleGasPartial toont het gasverbruik naast stroomverbruik en productie
+func (wd *WebDashboard) handleGasPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Gasverbruik is niet beschikbaar zonder database",
+		}
+
+		if wd.GasSvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			summary, err := wd.GasSvc.Summary(ctx, *selectedHome, queryInt(r, "days", 30))
+			if err != nil {
+				log.Printf("Error loading gas for %s: %v", homeID, err)
+				data["Message"] = "Gasverbruik kon niet worden geladen"
+			} else if summary == nil {
+				data["Message"] = "Er zijn nog geen gasmeterstanden voor dit huis"
+			} else {
+				data = map[string]interface{}{
+					"IsActive": true,
+					"HomeId":   homeID,
+					"Gas":      summary,
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "gas.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
+// handleImportPartial toont het uploadformulier voor meetgegevens van de netbeheerder en de eerdere imports
+func (wd *WebDashboard) handleImportPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		wd.renderImportPartial(w, r, homeID, nil, "")
+	}
+}
+
+// renderImportPartial toont het importformulier met de uitkomst van een upload
+func (wd *WebDashboard) renderImportPartial(w http.ResponseWriter, r *http.Request, homeID string, result *model.Import, importErr string) {
+	data := map[string]interface{}{
+		"IsActive": false,
+		"Message":  "Importeren van meetgegevens is niet beschikbaar zonder database",
+	}
+	if !wd.isAdmin(r) {
+		data["Message"] = "Alleen beheerders kunnen meetgegevens importeren"
+	}
+
+	if wd.ImportSvc != nil && wd.isAdmin(r) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		imports, err := wd.ImportSvc.List(ctx, homeID, 10)
+		if err != nil {
+			log.Printf("Error loading imports for %s: %v", homeID, err)
+		}
+		var profiles []string
+		for _, p := range wd.ImportSvc.Profiles {
+			profiles = append(profiles, p.Name)
+		}
+		data = map[string]interface{}{
+			"IsActive": true,
+			"HomeId":   homeID,
+			"Profiles": profiles,
+			"Imports":  imports,
+			"Result":   result,
+			"Error":    importErr,
+		}
+	}
+
+	if err := wd.Templates.ExecuteTemplate(w, "import.html", data); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+	}
+}
+
+// handleStatementPartial toont het maandoverzicht van een lid met kosten en CO2
+func (wd *WebDashboard) handleStatementPartial() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		data := map[string]interface{}{
+			"IsActive": false,
+			"Message":  "Het maandoverzicht is niet beschikbaar zonder database",
+		}
+
+		if wd.StatementSvc != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+
+			month, err := queryMonth(r, localtime.Location(selectedHome.TimeZone))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			statement, err := wd.StatementSvc.Monthly(ctx, *selectedHome, month, wd.AllHomes)
+			if err != nil {
+				log.Printf("Error building statement for %s: %v", homeID, err)
+				data["Message"] = "Het maandoverzicht kon niet worden opgesteld"
+			} else {
+				data = map[string]interface{}{
+					"IsActive":  true,
+					"HomeId":    homeID,
+					"Statement": statement,
+					"Previous":  statement.From.AddDate(0, -1, 0).Format("2006-01"),
+				}
+			}
+		}
+
+		if err := wd.Templates.ExecuteTemplate(w, "statement.html", data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rendering template")
+		}
+	}
+}
+
 // API Handlers
 
 // handlePriceData returns price data for the chart
@@ -333,53 +767,727 @@ func (wd *WebDashboard) handleProductionData() http.HandlerFunc {
 	}
 }
 
-// ServeLiveData serves live data via Server-Sent Events
-func (wd *WebDashboard) ServeLiveData(w http.ResponseWriter, r *http.Request) {
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// handleTariffData returns the contract comparison as JSON
+func (wd *WebDashboard) handleTariffData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
 
-	// Create a unique ID for this client
-	clientID := uuid.New().String()
+		if wd.TariffSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Tariff comparison requires a database")
+			return
+		}
 
-	// Create a channel for this client
-	clientChan := make(chan LiveData)
-	wd.liveDataChannels.Store(clientID, clientChan)
-	defer func() {
-		wd.liveDataChannels.Delete(clientID)
-	}()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
 
-	// Send initial empty state
-	fmt.Fprintf(w, "data: %s\n\n", "{}")
-	w.(http.Flusher).Flush()
+		comparison, err := wd.compareTariffs(ctx, *selectedHome, queryInt(r, "days", 30))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
 
-	// Get the client's context
-	ctx := r.Context()
+		respondWithJSON(w, comparison)
+	}
+}
 
-	// Start a goroutine to handle the client's connection
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case measurement := <-clientChan:
-				// Convert measurement to JSON
-				jsonData, err := json.Marshal(measurement)
-				if err != nil {
-					continue
-				}
+// handleNetMeteringData returns the yearly net metering phase-out report as JSON
+func (wd *WebDashboard) handleNetMeteringData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
 
-				// Send the data to the client
-				fmt.Fprintf(w, "data: %s\n\n", jsonData)
-				w.(http.Flusher).Flush()
+		if wd.TariffSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Net metering calculation requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		report, err := wd.netMeteringReport(ctx, *selectedHome, r)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJSON(w, report)
+	}
+}
+
+// handleForecastData returns published and forecast prices with the recent forecast accuracy
+func (wd *WebDashboard) handleForecastData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.ForecastSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Price forecast requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		prices, err := wd.ForecastSvc.Prices(ctx, homeID, queryInt(r, "hours", 72))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		accuracy, err := wd.ForecastSvc.Accuracy(ctx, homeID, 30)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, map[string]interface{}{
+			"prices":   prices,
+			"accuracy": accuracy,
+		})
+	}
+}
+
+// handleScheduleData returns the cheapest block of hours to run a flexible load,
+// using forecasts for the hours without published prices
+func (wd *WebDashboard) handleScheduleData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.ForecastSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Scheduling requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		prices, err := wd.ForecastSvc.Prices(ctx, homeID, queryInt(r, "horizon", 24))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		window, err := planner.CheapestWindow(prices, queryInt(r, "duration", 1))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJSON(w, window)
+	}
+}
+
+// handleSolarData returns actual and predicted hourly production from yesterday through tomorrow
+func (wd *WebDashboard) handleSolarData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if selectedHome.MeteringPointData.ProductionEan == "" {
+			respondWithError(w, http.StatusNotFound, "No production data available")
+			return
+		}
+
+		if wd.SolarSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Solar forecast requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		comparison, err := wd.compareSolar(ctx, *selectedHome)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJSON(w, comparison)
+	}
+}
+
+// handleUsageData returns the consumption forecast with the baseload of a home
+func (wd *WebDashboard) handleUsageData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.UsageSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Consumption forecast requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		usage, err := wd.forecastUsage(ctx, *selectedHome, queryInt(r, "hours", 48))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJSON(w, usage)
+	}
+}
+
+// handleBaseloadData returns the always-on consumption of a home and its yearly cost
+func (wd *WebDashboard) handleBaseloadData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.UsageSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Baseload requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		baseload, err := wd.UsageSvc.Baseload(ctx, homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJSON(w, baseload)
+	}
+}
+
+// handleAnomaliesData returns the open anomalies of a home
+func (wd *WebDashboard) handleAnomaliesData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.AnomalySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Anomaly detection requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		events, err := wd.AnomalySvc.List(ctx, homeID, time.Now().AddDate(0, 0, -queryInt(r, "days", 30)))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, events)
+	}
+}
+
+// handleAcknowledgeAnomaly marks an anomaly as seen and renders the remaining list
+func (wd *WebDashboard) handleAcknowledgeAnomaly() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.AnomalySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Anomaly detection requires a database")
+			return
+		}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid anomaly id")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		if err := wd.AnomalySvc.Acknowledge(ctx, homeID, id); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		wd.handleAnomaliesPartial().ServeHTTP(w, r)
+	}
+}
+
+// handleJobs returns the status of the scheduled jobs of the collector
+func (wd *WebDashboard) handleJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wd.SchedulerSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Job status requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		jobs, err := wd.SchedulerSvc.Jobs(ctx)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithJSON(w, jobs)
+	}
+}
+
+// handleRunJob asks the collector to run a scheduled job now; the collector picks it up within
+// scheduler.TriggerPollInterval
+func (wd *WebDashboard) handleRunJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wd.SchedulerSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Running jobs requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		name := chi.URLParam(r, "name")
+		if err := wd.SchedulerSvc.Trigger(ctx, name); err != nil {
+			if errors.Is(err, scheduler.ErrUnknownJob) {
+				respondWithError(w, http.StatusNotFound, err.Error())
+				return
 			}
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
-	}()
 
-	// Keep the connection alive
-	<-ctx.Done()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		respondWithJSON(w, map[string]interface{}{"job": name, "triggered": true})
+	}
+}
+
+// handlePowerQualityData returns the phase balance, fuse utilization and voltage events of a home
+func (wd *WebDashboard) handlePowerQualityData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.QualitySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Power quality requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		overview, err := wd.powerQuality(ctx, *selectedHome, queryInt(r, "days", 30))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, overview)
+	}
+}
+
+// handleCurtailmentData returns the curtailment episodes and monthly totals of a home
+func (wd *WebDashboard) handleCurtailmentData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.QualitySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Curtailment detection requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		overview, err := wd.curtailment(ctx, *selectedHome, queryInt(r, "days", 30))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, overview)
+	}
+}
+
+// handleGasData returns the daily gas use with costs, CO2 and degree days (?days=30)
+func (wd *WebDashboard) handleGasData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.GasSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Gas tracking requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		summary, err := wd.GasSvc.Summary(ctx, *selectedHome, queryInt(r, "days", 30))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if summary == nil {
+			respondWithError(w, http.StatusNotFound, "No gas readings for this home")
+			return
+		}
+
+		respondWithJSON(w, summary)
+	}
+}
+
+// requireImportAccess lets admins upload for every home, and requests with an API token only for
+// the homes of that token, as on /ha/v1
+func (wd *WebDashboard) requireImportAccess(next http.Handler) http.Handler {
+	admin := wd.requireAdmin(next)
+	token := wd.requireAPIToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if _, err := wd.tokenHome(ctx, r); err != nil {
+			respondWithHomeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			token.ServeHTTP(w, r)
+			return
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
+
+// handleImport accepts an upload with metering data of the grid operator (multipart: file, profile, ean).
+// htmx requests get the import partial back, other clients the import as JSON.
+func (wd *WebDashboard) handleImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.ImportSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Importing metering data requires a database")
+			return
+		}
+
+		htmx := r.Header.Get("HX-Request") == "true"
+		fail := func(code int, message string) {
+			if htmx {
+				wd.renderImportPartial(w, r, homeID, nil, message)
+				return
+			}
+			respondWithError(w, code, message)
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			fail(http.StatusBadRequest, "Geen bestand ontvangen of het bestand is te groot")
+			return
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(file)
+		if err != nil {
+			fail(http.StatusBadRequest, "Het bestand kon niet worden gelezen")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+		defer cancel()
+
+		filename := filepath.Base(header.Filename)
+		if len(filename) > 255 {
+			filename = filename[:255]
+		}
+		result, err := wd.ImportSvc.Import(ctx, *selectedHome, filename, content, r.FormValue("profile"), r.FormValue("ean"))
+		if errors.Is(err, service_db.ErrAlreadyImported) {
+			fail(http.StatusConflict, "Dit bestand is al eerder geïmporteerd")
+			return
+		}
+		if err != nil {
+			log.Printf("Import of %s for %s failed: %v", filename, homeID, err)
+			fail(http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if htmx {
+			wd.renderImportPartial(w, r, homeID, result, "")
+			return
+		}
+		respondWithJSON(w, result)
+	}
+}
+
+// handleExportData streams a dataset of a home, or of all homes with homeID "all", as CSV, JSON Lines
+// or Parquet (?dataset=consumption&format=parquet&resolution=HOURLY&from=2025-01-01&to=2025-02-01)
+func (wd *WebDashboard) handleExportData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		if homeID == "all" {
+			homeID = ""
+		} else if _, err := wd.findHomeByID(homeID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.ExportSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Exports require a database")
+			return
+		}
+
+		format, err := export.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		from, to, err := reportPeriod(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q := service_db.ExportQuery{
+			Dataset:    r.URL.Query().Get("dataset"),
+			HomeId:     homeID,
+			From:       from,
+			To:         to,
+			Resolution: r.URL.Query().Get("resolution"),
+		}
+
+		// Grote exports mogen langer duren dan de andere API-aanroepen
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+		defer cancel()
+
+		scope := homeID
+		if scope == "" {
+			scope = "alle"
+		}
+		name := fmt.Sprintf("%s_%s_%s_%s.%s", q.Dataset, scope, from.Format("20060102"), to.Format("20060102"), format)
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+		out := &countingWriter{w: w}
+		if _, err := wd.ExportSvc.Export(ctx, q, format, out); err != nil {
+			if out.n == 0 {
+				w.Header().Del("Content-Disposition")
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			// De status is al verstuurd; de client ziet een afgebroken bestand
+			log.Printf("Export %s for %s failed after %d bytes: %v", q.Dataset, scope, out.n, err)
+		}
+	}
+}
+
+// handleCurtailmentReport returns the monthly curtailment per postal area as CSV,
+// with the same period and digits parameters as the power quality report
+func (wd *WebDashboard) handleCurtailmentReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wd.QualitySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Curtailment detection requires a database")
+			return
+		}
+
+		from, to, err := reportPeriod(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		months, err := wd.QualitySvc.CurtailmentByArea(ctx, from, to, queryInt(r, "digits", powerquality.NeighbourhoodDigits))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := powerquality.WriteCurtailmentCSV(&buf, months); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		name := fmt.Sprintf("afschakelen_%s_%s.csv", from.Format("20060102"), to.Format("20060102"))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Write(buf.Bytes())
+	}
+}
+
+// handleStatementData returns the monthly statement of a home
+func (wd *WebDashboard) handleStatementData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.StatementSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Statements require a database")
+			return
+		}
+
+		month, err := queryMonth(r, localtime.Location(selectedHome.TimeZone))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		statement, err := wd.StatementSvc.Monthly(ctx, *selectedHome, month, wd.AllHomes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, statement)
+	}
+}
+
+// handleCarbonData returns the carbon account of a home over the last days (?days=30)
+func (wd *WebDashboard) handleCarbonData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID := chi.URLParam(r, "homeID")
+		selectedHome, err := wd.findHomeByID(homeID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		if wd.CarbonSvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Carbon accounting requires a database")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		to := time.Now()
+		from := to.AddDate(0, 0, -queryInt(r, "days", 30))
+		footprint, err := wd.CarbonSvc.Footprint(ctx, *selectedHome, from, to)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		community, err := wd.CarbonSvc.Community(ctx, wd.AllHomes, from, to)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"home":      footprint,
+			"community": community,
+		})
+	}
+}
+
+// handlePowerQualityReport returns the power quality of the community per postal area as CSV
+// for the grid operator. Use ?type=events for the individual overvoltage events, ?digits=6 for
+// street level and ?from=&to= (YYYY-MM-DD) for the period, by default the previous month.
+func (wd *WebDashboard) handlePowerQualityReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wd.QualitySvc == nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Power quality requires a database")
+			return
+		}
+
+		from, to, err := reportPeriod(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		digits := queryInt(r, "digits", powerquality.NeighbourhoodDigits)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		var buf bytes.Buffer
+		name := fmt.Sprintf("spanningskwaliteit_%s_%s", from.Format("20060102"), to.Format("20060102"))
+		if r.URL.Query().Get("type") == "events" {
+			areas, err := wd.QualitySvc.PostalAreas(ctx, digits)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			events, err := wd.QualitySvc.Events(ctx, "", from, to)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if err := powerquality.WriteEventsCSV(&buf, events, areas); err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			name += "_gebeurtenissen"
+		} else {
+			areas, err := wd.QualitySvc.AreaReport(ctx, from, to, digits)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if err := powerquality.WriteAreaCSV(&buf, from, to, areas); err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		w.Write(buf.Bytes())
+	}
 }
 
 // setupRoutes configures all HTTP routes
@@ -395,19 +1503,78 @@ func (wd *WebDashboard) setupRoutes() {
 	// Main routes
 	wd.Router.Get("/", wd.handleHome())
 
+	// Aanmelden van leden met hun Tibber-token (web.onboarding)
+	wd.Router.Route("/onboarding", func(r chi.Router) {
+		r.Get("/", wd.handleOnboarding())
+		r.Post("/", wd.handleOnboardingToken())
+		r.Post("/{id}", wd.handleOnboardingConfirm())
+	})
+
 	// Combineer gerelateerde routes in subrouters
+	// Openbare open data, alleen lezen (opendata.enabled)
+	wd.Router.Route("/open-data", func(r chi.Router) {
+		r.Get("/", wd.handleOpenDataIndex())
+		r.Get("/files/*", wd.handleOpenDataFiles())
+		r.Get("/{dataset}", wd.handleOpenData())
+	})
+
+	// Sensoren voor Home Assistant, alleen met een API-token van een lid
+	wd.Router.Route("/ha/v1", func(r chi.Router) {
+		r.Use(wd.requireAPIToken)
+		r.Get("/homes", wd.handleHAHomes())
+		r.Get("/homes/{homeID}/sensors", wd.logAccess("ha/sensors", wd.handleHASensors()))
+		r.Get("/homes/{homeID}/sensors/{sensor}", wd.logAccess("ha/sensors", wd.handleHASensor()))
+		r.Get("/homes/{homeID}/configuration.yaml", wd.handleHAConfiguration())
+	})
+
+	// API voor leden met hetzelfde API-token: webhooks van hun account
+	wd.Router.Route("/member/v1/webhooks", func(r chi.Router) {
+		r.Use(wd.requireAPIToken)
+		r.Get("/", wd.handleMemberWebhooks())
+		r.Post("/", wd.handleMemberWebhookAdd())
+		r.Delete("/{id}", wd.handleMemberWebhookRemove())
+		r.Post("/{id}/test", wd.handleMemberWebhookTest())
+		r.Get("/deliveries", wd.handleMemberWebhookDeliveries())
+		r.Post("/deliveries/{id}/replay", wd.handleMemberWebhookReplay())
+	})
+
+	// Live metingen per huis, met een API-token of voor de huizen van het dashboard
+	wd.Router.Route("/live/v1", func(r chi.Router) {
+		r.Get("/stream", wd.ServeLiveStream)
+		r.Get("/ws", wd.ServeLiveWebSocket)
+	})
+
+	// Beheer, alleen voor web.admin_users
+	wd.Router.Route("/admin", func(r chi.Router) {
+		r.Use(wd.requireAdmin)
+		r.Get("/jobs", wd.handleJobs())
+		r.Post("/jobs/{name}/run", wd.handleRunJob())
+		r.Group(func(r chi.Router) {
+			r.Use(wd.requireWebhooks)
+			r.Get("/webhooks", wd.handleWebhooksAdmin())
+			r.Post("/webhooks", wd.handleWebhooksAdminAdd())
+			r.Post("/webhooks/{id}/{action}", wd.handleWebhooksAdminAction())
+			r.Post("/webhooks/deliveries/{id}/replay", wd.handleWebhooksAdminReplay())
+		})
+	})
+
+	// Routes met gegevens van een huis staan in het inzagelog, zie logAccess
 	wd.Router.Route("/partials", func(r chi.Router) {
-		r.Get("/{type}/{homeID}", wd.handlePartial()) // Gecombineerde partial handler
+		r.Get("/{type}/{homeID}", wd.logAccess("partials", wd.handlePartial())) // Gecombineerde partial handler
 	})
 
 	// API endpoints
 	wd.Router.Route("/api", func(r chi.Router) {
-		r.Get("/{type}/{homeID}", wd.handleData()) // Gecombineerde data handler
+		r.Get("/{type}/{homeID}", wd.logAccess("api", wd.handleData())) // Gecombineerde data handler
+		r.Post("/anomalies/{homeID}/{id}/acknowledge", wd.handleAcknowledgeAnomaly())
+		r.With(wd.requireImportAccess).Post("/import/{homeID}", wd.logAccess("import", wd.handleImport()))
 	})
 
 	// Server-Sent Events
 	wd.Router.Get("/live-data", wd.ServeLiveData)
-	wd.Router.Get("/events/price/{homeID}", wd.ServePriceEvents)
+	wd.Router.Get("/reports/power-quality.csv", wd.handlePowerQualityReport())
+	wd.Router.Get("/reports/curtailment.csv", wd.handleCurtailmentReport())
+	wd.Router.Get("/events/price/{homeID}", wd.logAccess("events/price", wd.ServePriceEvents))
 }
 
 // Gecombineerde partial handler
@@ -424,6 +1591,26 @@ func (wd *WebDashboard) handlePartial() http.HandlerFunc {
 			wd.handleProductionPartial().ServeHTTP(w, r)
 		case "home-details":
 			wd.handleHomeDetailsPartial().ServeHTTP(w, r)
+		case "tariffs":
+			wd.handleTariffPartial().ServeHTTP(w, r)
+		case "netmetering":
+			wd.handleNetMeteringPartial().ServeHTTP(w, r)
+		case "solar":
+			wd.handleSolarPartial().ServeHTTP(w, r)
+		case "usage":
+			wd.handleUsagePartial().ServeHTTP(w, r)
+		case "anomalies":
+			wd.handleAnomaliesPartial().ServeHTTP(w, r)
+		case "powerquality":
+			wd.handlePowerQualityPartial().ServeHTTP(w, r)
+		case "curtailment":
+			wd.handleCurtailmentPartial().ServeHTTP(w, r)
+		case "statement":
+			wd.handleStatementPartial().ServeHTTP(w, r)
+		case "gas":
+			wd.handleGasPartial().ServeHTTP(w, r)
+		case "import":
+			wd.handleImportPartial().ServeHTTP(w, r)
 		default:
 			respondWithError(w, http.StatusNotFound, "Unknown partial type")
 		}
@@ -442,6 +1629,34 @@ func (wd *WebDashboard) handleData() http.HandlerFunc {
 			wd.handleConsumptionData().ServeHTTP(w, r)
 		case "production":
 			wd.handleProductionData().ServeHTTP(w, r)
+		case "tariffs":
+			wd.handleTariffData().ServeHTTP(w, r)
+		case "netmetering":
+			wd.handleNetMeteringData().ServeHTTP(w, r)
+		case "forecast":
+			wd.handleForecastData().ServeHTTP(w, r)
+		case "schedule":
+			wd.handleScheduleData().ServeHTTP(w, r)
+		case "solar":
+			wd.handleSolarData().ServeHTTP(w, r)
+		case "usage":
+			wd.handleUsageData().ServeHTTP(w, r)
+		case "baseload":
+			wd.handleBaseloadData().ServeHTTP(w, r)
+		case "anomalies":
+			wd.handleAnomaliesData().ServeHTTP(w, r)
+		case "powerquality":
+			wd.handlePowerQualityData().ServeHTTP(w, r)
+		case "curtailment":
+			wd.handleCurtailmentData().ServeHTTP(w, r)
+		case "statement":
+			wd.handleStatementData().ServeHTTP(w, r)
+		case "carbon":
+			wd.handleCarbonData().ServeHTTP(w, r)
+		case "gas":
+			wd.handleGasData().ServeHTTP(w, r)
+		case "export":
+			wd.handleExportData().ServeHTTP(w, r)
 		default:
 			respondWithError(w, http.StatusNotFound, "Unknown data type")
 		}
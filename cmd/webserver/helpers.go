@@ -1,9 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
 	"time"
+
+	"ws/internal/carbon"
+	"ws/internal/forecast"
+	"ws/internal/localtime"
 	"ws/internal/model"
+	"ws/internal/powerquality"
+	"ws/internal/tariff"
 )
 
 // sortConsumptionByDate sorteert consumptiegegevens op datum (nieuwste eerst)
@@ -110,3 +122,308 @@ func calculateEndTime(startTimeStr string) (string, error) {
 	// Bereken eindtijd (1 uur later) en formatteer als RFC3339
 	return parsedTime.Add(time.Hour).Format(time.RFC3339), nil
 }
+
+// tariffComparison is de vergelijking van contracten over een periode
+type tariffComparison struct {
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Days       int             `json:"days"`
+	ActualCost float64         `json:"actualCost"`
+	Results    []tariff.Result `json:"results"`
+}
+
+// compareTariffs berekent de contracten over de laatste hele dagen van een huis
+func (wd *WebDashboard) compareTariffs(ctx context.Context, home model.Home, days int) (*tariffComparison, error) {
+	loc := localtime.Location(home.TimeZone)
+	to := localtime.StartOfDay(time.Now(), loc)
+	from := to.AddDate(0, 0, -days)
+
+	// Huidige situatie: volledige saldering
+	results, err := wd.TariffSvc.Compare(ctx, home, wd.Contracts, from, to, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	actualCost, err := wd.TariffSvc.ActualCost(ctx, home.Id, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tariffComparison{
+		From:       from,
+		To:         to,
+		Days:       days,
+		ActualCost: actualCost,
+		Results:    results,
+	}, nil
+}
+
+// queryInt leest een positief geheel getal uit de query string met een standaardwaarde
+func queryInt(r *http.Request, key string, fallback int) int {
+	if v, err := strconv.Atoi(r.URL.Query().Get(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// netMeteringReport berekent het effect van het einde van de saldering voor een jaar van een huis
+func (wd *WebDashboard) netMeteringReport(ctx context.Context, home model.Home, r *http.Request) (*tariff.NetMeteringReport, error) {
+	contract, err := tariff.ContractByName(wd.Contracts, r.URL.Query().Get("contract"))
+	if err != nil {
+		return nil, err
+	}
+
+	year := queryInt(r, "year", time.Now().In(localtime.Location(home.TimeZone)).Year())
+	fee := queryFloat(r, "fee", tariff.DefaultFeedInFee)
+
+	return wd.TariffSvc.NetMetering(ctx, home, contract, year, tariff.NetMeteringScenarios(contract, fee))
+}
+
+// queryFloat leest een niet-negatief getal uit de query string met een standaardwaarde
+func queryFloat(r *http.Request, key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(r.URL.Query().Get(key), 64); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+// solarComparison is de werkelijke en voorspelde productie per uur van gisteren tot en met morgen
+type solarComparison struct {
+	Hours         []string   `json:"hours"`
+	Actual        []*float64 `json:"actual"` // nil voor uren zonder meting
+	Predicted     []float64  `json:"predicted"`
+	ClearSky      []float64  `json:"clearSky"`
+	TodayTotal    float64    `json:"todayTotal"`
+	TomorrowTotal float64    `json:"tomorrowTotal"`
+	Clearness     float64    `json:"clearness"`
+}
+
+// compareSolar voorspelt de productie van een huis en zet die naast de metingen
+func (wd *WebDashboard) compareSolar(ctx context.Context, home model.Home) (*solarComparison, error) {
+	m, err := wd.SolarSvc.Model(ctx, home)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := localtime.Location(home.TimeZone)
+	today := localtime.StartOfDay(time.Now(), loc)
+	yesterday := localtime.StartOfDay(today.Add(-time.Hour), loc)
+	tomorrow := localtime.NextDay(today, loc)
+	end := localtime.NextDay(tomorrow, loc)
+	hours := int(end.Sub(yesterday).Hours())
+
+	actual, err := wd.SolarSvc.HourlyProduction(ctx, home.Id, yesterday, end)
+	if err != nil {
+		return nil, err
+	}
+	measured := make(map[time.Time]float64, len(actual))
+	for _, p := range actual {
+		measured[p.Start.UTC()] = p.Value
+	}
+
+	predicted := m.Predict(yesterday, hours, wd.SolarSvc.Weather)
+	clearSky := m.ClearSky(yesterday, hours)
+
+	comparison := &solarComparison{Clearness: m.Clearness}
+	for i, p := range predicted {
+		comparison.Hours = append(comparison.Hours, p.Start.In(loc).Format(time.RFC3339))
+		comparison.Predicted = append(comparison.Predicted, p.Value)
+		comparison.ClearSky = append(comparison.ClearSky, clearSky[i].Value)
+		if v, ok := measured[p.Start]; ok {
+			v := v
+			comparison.Actual = append(comparison.Actual, &v)
+		} else {
+			comparison.Actual = append(comparison.Actual, nil)
+		}
+
+		switch {
+		case !p.Start.Before(tomorrow):
+			comparison.TomorrowTotal += p.Value
+		case !p.Start.Before(today):
+			comparison.TodayTotal += p.Value
+		}
+	}
+	comparison.TodayTotal = math.Round(comparison.TodayTotal*100) / 100
+	comparison.TomorrowTotal = math.Round(comparison.TomorrowTotal*100) / 100
+
+	return comparison, nil
+}
+
+// usageForecast is de verwachte stroomafname per uur met het sluipverbruik van een huis
+type usageForecast struct {
+	Hours     []string           `json:"hours"`
+	Predicted []float64          `json:"predicted"`
+	Total     float64            `json:"total"`
+	Baseload  *forecast.Baseload `json:"baseload,omitempty"`
+}
+
+// forecastUsage voorspelt het verbruik van een huis; het sluipverbruik is optioneel
+// omdat het pas na een paar nachten met real-time metingen bekend is
+func (wd *WebDashboard) forecastUsage(ctx context.Context, home model.Home, hours int) (*usageForecast, error) {
+	points, err := wd.UsageSvc.Forecast(ctx, home, hours)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := localtime.Location(home.TimeZone)
+	result := &usageForecast{}
+	for _, p := range points {
+		result.Hours = append(result.Hours, p.Start.In(loc).Format(time.RFC3339))
+		result.Predicted = append(result.Predicted, p.Value)
+	}
+	result.Total = forecast.Total(points)
+
+	if baseload, err := wd.UsageSvc.Baseload(ctx, home.Id); err == nil {
+		result.Baseload = baseload
+	}
+
+	return result, nil
+}
+
+// powerQualityOverview is de fase- en spanningskwaliteit van een huis: de afgelopen 24 uur
+// uit de real-time metingen en de vastgelegde dagen daarvoor
+type powerQualityOverview struct {
+	Last24h powerquality.Summary        `json:"last24h"`
+	Daily   []powerquality.Summary      `json:"daily"`
+	Events  []powerquality.VoltageEvent `json:"events"`
+	Limit   float64                     `json:"overvoltageLimit"`
+}
+
+// powerQuality verzamelt de spanningskwaliteit van een huis over de laatste dagen
+func (wd *WebDashboard) powerQuality(ctx context.Context, home model.Home, days int) (*powerQualityOverview, error) {
+	now := time.Now()
+	last24h, err := wd.QualitySvc.Analyze(ctx, home, now.Add(-24*time.Hour), now)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := localtime.Location(home.TimeZone)
+	to := localtime.Date(now, loc).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -days)
+	daily, err := wd.QualitySvc.Daily(ctx, home.Id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	events, err := wd.QualitySvc.Events(ctx, home.Id, now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gebeurtenissen van vandaag staan nog niet in de database
+	for _, e := range last24h.Events {
+		if e.Start.After(localtime.StartOfDay(now, loc)) {
+			events = append(events, e)
+		}
+	}
+	last24h.Events = nil
+
+	return &powerQualityOverview{
+		Last24h: last24h,
+		Daily:   daily,
+		Events:  events,
+		Limit:   powerquality.OvervoltageLimit,
+	}, nil
+}
+
+// curtailmentOverview is de door afschakelen gemiste opbrengst van een huis per maand
+// over het afgelopen jaar, met de episodes van de laatste dagen
+type curtailmentOverview struct {
+	Months       []powerquality.CurtailmentMonth   `json:"months"`
+	Episodes     []powerquality.CurtailmentEpisode `json:"episodes"`
+	TotalKWh     float64                           `json:"totalKWh"`
+	TotalRevenue float64                           `json:"totalRevenue"`
+}
+
+// curtailment verzamelt de afschakel-episodes van een huis
+func (wd *WebDashboard) curtailment(ctx context.Context, home model.Home, days int) (*curtailmentOverview, error) {
+	now := time.Now()
+	episodes, err := wd.QualitySvc.Curtailment(ctx, home.Id, now.AddDate(-1, 0, 0), now)
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &curtailmentOverview{
+		Months: powerquality.SummarizeCurtailment(episodes, func(string) string { return home.Id },
+			localtime.Location(home.TimeZone)),
+		Episodes: []powerquality.CurtailmentEpisode{},
+	}
+	since := now.AddDate(0, 0, -days)
+	for _, e := range episodes {
+		overview.TotalKWh += e.LostKWh
+		overview.TotalRevenue += e.LostRevenue
+		if e.Start.After(since) {
+			overview.Episodes = append(overview.Episodes, e)
+		}
+	}
+	overview.TotalKWh = math.Round(overview.TotalKWh*100) / 100
+	overview.TotalRevenue = math.Round(overview.TotalRevenue*100) / 100
+
+	return overview, nil
+}
+
+// reportPeriod leest de periode van een rapport uit ?from= en ?to= (YYYY-MM-DD),
+// standaard de vorige kalendermaand
+func reportPeriod(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -1, 0)
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from date %q", v)
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date %q", v)
+		}
+		to = t
+	}
+	if !to.After(from) {
+		return from, to, fmt.Errorf("period is empty")
+	}
+	return from, to, nil
+}
+
+// recentFootprint berekent de CO2 van de afgelopen dagen, of nil zonder database of intensiteiten
+func (wd *WebDashboard) recentFootprint(ctx context.Context, home model.Home, days int) *carbon.Footprint {
+	if wd.CarbonSvc == nil {
+		return nil
+	}
+	to := time.Now()
+	footprint, err := wd.CarbonSvc.Footprint(ctx, home, to.AddDate(0, 0, -days), to)
+	if err != nil {
+		log.Printf("Error computing carbon footprint for %s: %v", home.Id, err)
+		return nil
+	}
+	return &footprint
+}
+
+// queryMonth leest ?month=YYYY-MM, standaard de vorige maand
+func queryMonth(r *http.Request, loc *time.Location) (time.Time, error) {
+	v := r.URL.Query().Get("month")
+	if v == "" {
+		now := time.Now().In(loc)
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, loc), nil
+	}
+	month, err := time.ParseInLocation("2006-01", v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q", v)
+	}
+	return month, nil
+}
+
+// countingWriter telt de geschreven bytes, om te weten of een fout nog als HTTP-status kan worden gemeld
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
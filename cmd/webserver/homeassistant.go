@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ws/internal/homeassistant"
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/planner"
+	"ws/internal/service_db"
+	"ws/internal/tibber"
+)
+
+// Home Assistant leest de sensoren met een vast interval; live waarden ouder dan liveStale gelden
+// als onbekend
+const (
+	haScanInterval = 30 * time.Second
+	liveStale      = 5 * time.Minute
+)
+
+// apiTokenKey is the context key of the authenticated API token
+type apiTokenKey struct{}
+
+// apiTokenFrom returns the API token of a request to the Home Assistant API
+func apiTokenFrom(ctx context.Context) *model.APIToken {
+	t, _ := ctx.Value(apiTokenKey{}).(*model.APIToken)
+	return t
+}
+
+// requireAPIToken lets only requests with a valid API token through, as Authorization: Bearer
+func (wd *WebDashboard) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wd.APITokens == nil {
+			respondWithError(w, http.StatusNotFound, "The API requires a database")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ha"`)
+			respondWithError(w, http.StatusUnauthorized, "Missing API token")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		t, err := wd.APITokens.Authenticate(ctx, strings.TrimSpace(token))
+		if errors.Is(err, service_db.ErrInvalidAPIToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ha", error="invalid_token"`)
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, t)))
+	})
+}
+
+// tokenHome returns the home of the request if the token gives access to it; other homes are
+// not found, so that a token does not reveal which homes exist
+func (wd *WebDashboard) tokenHome(ctx context.Context, r *http.Request) (*service_db.TokenHome, error) {
+	homes, err := wd.APITokens.Homes(ctx, apiTokenFrom(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	homeID := chi.URLParam(r, "homeID")
+	for i := range homes {
+		if homes[i].ID == homeID {
+			return &homes[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// handleHAHomes lists the homes of the token with the URLs of their sensors
+func (wd *WebDashboard) handleHAHomes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		homes, err := wd.APITokens.Homes(ctx, apiTokenFrom(r.Context()))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		type home struct {
+			service_db.TokenHome
+			Sensors       string `json:"sensors"`
+			Configuration string `json:"configuration"`
+		}
+		result := make([]home, 0, len(homes))
+		for _, h := range homes {
+			base := "/ha/v1/homes/" + h.ID
+			result = append(result, home{TokenHome: h, Sensors: base + "/sensors", Configuration: base + "/configuration.yaml"})
+		}
+		respondWithJSON(w, result)
+	}
+}
+
+// handleHASensors returns all sensors of a home, by key, for one request of the RESTful integration
+func (wd *WebDashboard) handleHASensors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		home, err := wd.tokenHome(ctx, r)
+		if err != nil {
+			respondWithHomeError(w, err)
+			return
+		}
+		entities := wd.haEntities(ctx, r, home.ID, homeassistant.Sensors)
+		byKey := make(map[string]homeassistant.Entity, len(entities))
+		for i, s := range homeassistant.Sensors {
+			byKey[s.Key] = entities[i]
+		}
+		respondWithJSON(w, byKey)
+	}
+}
+
+// handleHASensor returns one sensor of a home, for the RESTful sensor platform
+func (wd *WebDashboard) handleHASensor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sensor, ok := homeassistant.Find(chi.URLParam(r, "sensor"))
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Unknown sensor")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		home, err := wd.tokenHome(ctx, r)
+		if err != nil {
+			respondWithHomeError(w, err)
+			return
+		}
+		respondWithJSON(w, wd.haEntities(ctx, r, home.ID, []homeassistant.Sensor{sensor})[0])
+	}
+}
+
+// handleHAConfiguration returns a configuration for Home Assistant with every sensor of a home
+func (wd *WebDashboard) handleHAConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		home, err := wd.tokenHome(ctx, r)
+		if err != nil {
+			respondWithHomeError(w, err)
+			return
+		}
+
+		name := home.Name
+		if name == "" {
+			name = "Tibber"
+		}
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		w.Write([]byte(homeassistant.Configuration(scheme+"://"+r.Host, home.ID, name, "ws_api_token", haScanInterval)))
+	}
+}
+
+// respondWithHomeError answers a failed tokenHome
+func respondWithHomeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Unknown home")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+// haEntities returns the entities of the given sensors of a home. A sensor whose source fails or
+// has no data gets no state; the others are still returned. The cheapest window takes the query
+// parameters duration (hours, default 3) and horizon (hours ahead, default 24).
+func (wd *WebDashboard) haEntities(ctx context.Context, r *http.Request, homeID string, sensors []homeassistant.Sensor) []homeassistant.Entity {
+	var (
+		measurement     *tibber.Measurement
+		measurementDone bool
+		prices          []model.Price
+		pricesDone      bool
+	)
+	latest := func() *tibber.Measurement {
+		if !measurementDone {
+			measurementDone = true
+			measurements, err := wd.RealTimeSvc.GetLatestMeasurements(ctx, homeID, 1)
+			if err != nil {
+				log.Printf("Error fetching live measurement of home %s for Home Assistant: %v", homeID, err)
+			} else if len(measurements) > 0 && time.Since(measurements[0].Timestamp) < liveStale {
+				measurement = &measurements[0]
+			}
+		}
+		return measurement
+	}
+	upcoming := func() []model.Price {
+		if !pricesDone {
+			pricesDone = true
+			var err error
+			if prices, err = wd.ForecastSvc.Prices(ctx, homeID, queryInt(r, "horizon", 24)); err != nil {
+				log.Printf("Error fetching prices of home %s for Home Assistant: %v", homeID, err)
+			}
+		}
+		return prices
+	}
+
+	entities := make([]homeassistant.Entity, 0, len(sensors))
+	for _, s := range sensors {
+		e := s.NewEntity(homeID)
+		switch s.Key {
+		case homeassistant.Power, homeassistant.PowerProduction, homeassistant.EnergyConsumption, homeassistant.EnergyProduction:
+			m := latest()
+			if m == nil {
+				break
+			}
+			e.LastUpdated = &m.Timestamp
+			e.Attributes["timestamp"] = m.Timestamp
+			switch s.Key {
+			case homeassistant.Power:
+				e.State = m.Power
+			case homeassistant.PowerProduction:
+				e.State = m.PowerProduction
+			case homeassistant.EnergyConsumption:
+				e.State = roundTo(m.AccumulatedConsumption, 3)
+				if m.LastMeterConsumption > 0 {
+					e.Attributes["meter_reading"] = m.LastMeterConsumption
+				}
+			case homeassistant.EnergyProduction:
+				e.State = roundTo(m.AccumulatedProduction, 3)
+				if m.LastMeterProduction > 0 {
+					e.Attributes["meter_reading"] = m.LastMeterProduction
+				}
+			}
+
+		case homeassistant.Price:
+			current := upcoming()
+			if len(current) == 0 {
+				break
+			}
+			price := current[0]
+			start, err := localtime.ParseTimestamp(price.StartTime)
+			if err != nil || time.Since(start) >= time.Hour || time.Now().Before(start) {
+				// Geen prijs voor het huidige uur
+				break
+			}
+			if price.Currency != "" {
+				e.UnitOfMeasurement = price.Currency + "/kWh"
+			}
+			e.State = roundTo(price.Total, 4)
+			e.LastUpdated = &start
+			e.Attributes["level"] = price.Level
+			e.Attributes["energy"] = roundTo(price.Energy, 4)
+			e.Attributes["tax"] = roundTo(price.Tax, 4)
+			e.Attributes["starts_at"] = price.StartTime
+			e.Attributes["ends_at"] = price.EndTime
+			e.Attributes["forecast"] = price.IsForecast
+
+		case homeassistant.CheapestWindow:
+			hours := queryInt(r, "duration", 3)
+			window, err := planner.CheapestWindow(upcoming(), hours)
+			if err != nil {
+				break
+			}
+			e.State = window.Start.Format(time.RFC3339)
+			e.Attributes["end"] = window.End.Format(time.RFC3339)
+			e.Attributes["hours"] = hours
+			e.Attributes["average_price"] = roundTo(window.AveragePrice, 4)
+			e.Attributes["forecasted_hours"] = window.Forecasted
+
+		case homeassistant.SelfSufficiency:
+			result, err := wd.CommunitySvc.LiveSelfSufficiency(ctx, time.Now().Add(-liveStale))
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("Error calculating live self-sufficiency for Home Assistant: %v", err)
+				}
+				break
+			}
+			e.State = result.Percent
+			e.LastUpdated = &result.To
+			e.Attributes["homes"] = result.Homes
+			e.Attributes["consumption_w"] = roundTo(result.Consumption, 0)
+			e.Attributes["production_w"] = roundTo(result.Production, 0)
+
+		case homeassistant.SelfSufficiencyYesterday:
+			loc := localtime.Location("")
+			today := localtime.StartOfDay(time.Now(), loc)
+			yesterday := today.AddDate(0, 0, -1)
+			result, err := wd.CommunitySvc.DailySelfSufficiency(ctx, yesterday, today)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("Error calculating self-sufficiency of yesterday for Home Assistant: %v", err)
+				}
+				break
+			}
+			e.State = result.Percent
+			e.Attributes["date"] = yesterday.Format("2006-01-02")
+			e.Attributes["homes"] = result.Homes
+			e.Attributes["consumption_kwh"] = result.Consumption
+			e.Attributes["production_kwh"] = result.Production
+		}
+		entities = append(entities, e)
+	}
+	return entities
+}
+
+// roundTo rounds a value to a number of decimals
+func roundTo(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
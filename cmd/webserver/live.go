@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"ws/internal/live"
+	"ws/internal/service_db"
+)
+
+const (
+	liveQueue        = 64              // Berichten die op een client mogen wachten voor hij wordt afgesloten
+	liveMaxClients   = 200             // Gelijktijdige live verbindingen
+	livePollInterval = 5 * time.Second // Hoe vaak huizen uit de database worden gevolgd
+	liveLoadLimit    = 1000            // Metingen per huis bij het vullen van de backlog
+	liveHeartbeat    = 15 * time.Second
+	liveWriteWait    = 10 * time.Second
+	liveMaxHomes     = 20 // Huizen per verbinding
+)
+
+// liveUpgrader accepts WebSocket connections from the dashboard itself, and from anywhere with an API
+// token. Browsers always send an Origin with a WebSocket, so a request without one needs a token.
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  512,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		if apiTokenFrom(r.Context()) != nil {
+			return true
+		}
+		return r.Header.Get("Origin") != "" && sameOrigin(r)
+	},
+}
+
+// sameOrigin reports whether a request comes from a page of the dashboard itself. A browser leaves
+// out the Origin of a same-origin EventSource, but then sends Sec-Fetch-Site.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") == "same-origin"
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// liveHomes returns the homes a live client may follow. With an API token in the Authorization
+// header these are the homes of the token; the returned request carries the token for the access
+// log. Without a token only pages of the dashboard itself may follow the homes of the installation.
+func (wd *WebDashboard) liveHomes(w http.ResponseWriter, r *http.Request) ([]string, *http.Request, bool) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if token == "" {
+		if !sameOrigin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="live"`)
+			respondWithError(w, http.StatusUnauthorized, "Missing API token")
+			return nil, nil, false
+		}
+		homes, err := wd.installationHomes(ctx)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return nil, nil, false
+		}
+		return homes, r, true
+	}
+
+	if wd.APITokens == nil {
+		respondWithError(w, http.StatusNotFound, "The API requires a database")
+		return nil, nil, false
+	}
+	t, err := wd.APITokens.Authenticate(ctx, token)
+	if errors.Is(err, service_db.ErrInvalidAPIToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="live", error="invalid_token"`)
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return nil, nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	tokenHomes, err := wd.APITokens.Homes(ctx, t)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	homes := make([]string, 0, len(tokenHomes))
+	for _, home := range tokenHomes {
+		homes = append(homes, home.ID)
+	}
+	return homes, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, t)), true
+}
+
+// installationHomes returns the homes of the installation: those of tibber.token with the Tibber
+// and P1 home, without the homes that members share with their own account
+func (wd *WebDashboard) installationHomes(ctx context.Context) ([]string, error) {
+	shared := make(map[string]bool)
+	if wd.Members != nil {
+		linked, err := wd.Members.LinkedHomes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range linked {
+			shared[h.HomeID] = true
+		}
+	}
+
+	var homes []string
+	for _, home := range wd.Homes {
+		homes = append(homes, home.Id)
+	}
+	cfg := wd.Config()
+	homes = append(homes, cfg.Tibber.HouseID, cfg.DSMR.HomeID)
+
+	var own []string
+	for _, homeID := range homes {
+		if homeID != "" && !shared[homeID] && !slices.Contains(own, homeID) {
+			own = append(own, homeID)
+		}
+	}
+	return own, nil
+}
+
+// liveSubscribe subscribes a live client to the homes and fields of its request:
+//   - homes: comma-separated home IDs, default the given homes or else every home the client may follow
+//   - fields: comma-separated fields or groups (phases, voltage, all), default live.DefaultFields
+//   - backlog: minutes of measurements to replay first, at most web.live_backlog
+//
+// A Last-Event-ID header, sent by a reconnecting EventSource, replays from that measurement instead.
+// The views are written to the access log under resource.
+func (wd *WebDashboard) liveSubscribe(w http.ResponseWriter, r *http.Request, resource string, defaultHomes []string) (*live.Subscription, *http.Request, bool) {
+	allowed, r, ok := wd.liveHomes(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	homes := defaultHomes
+	if param := r.URL.Query().Get("homes"); param != "" {
+		homes = nil
+		for _, homeID := range strings.Split(param, ",") {
+			if homeID = strings.TrimSpace(homeID); homeID != "" && !slices.Contains(homes, homeID) {
+				homes = append(homes, homeID)
+			}
+		}
+	}
+	if len(homes) == 0 {
+		homes = allowed
+	}
+	if len(homes) == 0 {
+		respondWithError(w, http.StatusNotFound, "No homes to follow")
+		return nil, nil, false
+	}
+	if len(homes) > liveMaxHomes {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d homes per connection", liveMaxHomes))
+		return nil, nil, false
+	}
+	for _, homeID := range homes {
+		// Een onbekend huis en een huis van een ander zijn niet te onderscheiden
+		if !slices.Contains(allowed, homeID) {
+			respondWithError(w, http.StatusNotFound, "Unknown home")
+			return nil, nil, false
+		}
+	}
+
+	fields, err := live.ParseFields(strings.Split(r.URL.Query().Get("fields"), ","))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	oldest := now.Add(-wd.Live.Backlog)
+	since := now
+	if minutes := queryInt(r, "backlog", 0); minutes > 0 {
+		since = now.Add(-time.Duration(minutes) * time.Minute)
+	}
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		since = time.UnixMilli(id)
+	}
+	if since.Before(oldest) {
+		since = oldest
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+	sub, err := wd.Live.Subscribe(ctx, homes, fields, since)
+	if errors.Is(err, live.ErrTooManyClients) {
+		w.Header().Set("Retry-After", "30")
+		respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	for _, homeID := range homes {
+		wd.recordAccess(r, homeID, resource)
+	}
+	return sub, r, true
+}
+
+// ServeLiveStream streams the live measurements of homes as Server-Sent Events: measurement events
+// with the timestamp in milliseconds as ID, a ready event after the replay, heartbeat events and an
+// evicted event when the client does not keep up
+func (wd *WebDashboard) ServeLiveStream(w http.ResponseWriter, r *http.Request) {
+	wd.serveLiveEvents(w, r, "live", nil, false)
+}
+
+// ServeLiveData streams the live measurements of one home for the dashboard, the home parameter or
+// else the Tibber or P1 home, as unnamed events with comment heartbeats
+func (wd *WebDashboard) ServeLiveData(w http.ResponseWriter, r *http.Request) {
+	homeID := r.URL.Query().Get("home")
+	if homeID == "" {
+		homeID = wd.Config().Tibber.HouseID
+	}
+	if homeID == "" {
+		homeID = wd.Config().DSMR.HomeID
+	}
+	if homeID == "" {
+		respondWithError(w, http.StatusNotFound, "No live home configured")
+		return
+	}
+	wd.serveLiveEvents(w, r, "live-data", []string{homeID}, true)
+}
+
+// serveLiveEvents writes a subscription as Server-Sent Events. All writes happen here, in the handler,
+// each with its own deadline because the server's WriteTimeout would otherwise end the stream.
+func (wd *WebDashboard) serveLiveEvents(w http.ResponseWriter, r *http.Request, resource string, homes []string, legacy bool) {
+	sub, r, ok := wd.liveSubscribe(w, r, resource, homes)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// Zonder token alleen voor het dashboard zelf; met token ook voor andere sites
+	if apiTokenFrom(r.Context()) != nil {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	writeRaw := func(b []byte) error {
+		_ = rc.SetWriteDeadline(time.Now().Add(liveWriteWait))
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	write := func(event string, id int64, data interface{}) error {
+		var buf bytes.Buffer
+		if id != 0 {
+			fmt.Fprintf(&buf, "id: %d\n", id)
+		}
+		if event != "" {
+			fmt.Fprintf(&buf, "event: %s\n", event)
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "data: %s\n\n", payload)
+		return writeRaw(buf.Bytes())
+	}
+	measurementEvent := "measurement"
+	if legacy {
+		measurementEvent = ""
+	}
+	send := func(msg live.Message) error {
+		return write(measurementEvent, msg.Measurement.Timestamp.UnixMilli(), msg.Values(sub.Fields))
+	}
+
+	// Een EventSource probeert het na een verbroken verbinding na vijf seconden opnieuw
+	if err := writeRaw([]byte("retry: 5000\n\n")); err != nil {
+		return
+	}
+	for _, msg := range sub.Replay {
+		if err := send(msg); err != nil {
+			return
+		}
+	}
+	if !legacy {
+		ready := map[string]interface{}{"homes": sub.Homes, "fields": sub.Fields, "replayed": len(sub.Replay)}
+		if err := write("ready", 0, ready); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil && !legacy {
+					_ = write("evicted", 0, map[string]string{"error": err.Error()})
+				}
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case now := <-heartbeat.C:
+			var err error
+			if legacy {
+				err = writeRaw([]byte(": heartbeat\n\n"))
+			} else {
+				err = write("heartbeat", 0, map[string]time.Time{"time": now})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeLiveWebSocket streams the live measurements of homes over a WebSocket as JSON messages with a
+// type: measurement, ready after the replay and heartbeat, next to ping frames. A client that does not
+// keep up is closed with status 1013 (try again later). Messages from the client are ignored.
+func (wd *WebDashboard) ServeLiveWebSocket(w http.ResponseWriter, r *http.Request) {
+	sub, r, ok := wd.liveSubscribe(w, r, "live", nil)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade heeft de client al geantwoord
+		return
+	}
+	defer conn.Close()
+
+	// De lezer houdt alleen pongs en het sluiten bij; zonder pong valt de verbinding weg
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * liveHeartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * liveHeartbeat))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(kind string, values map[string]interface{}) error {
+		values["type"] = kind
+		_ = conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+		return conn.WriteJSON(values)
+	}
+	closeWith := func(code int, text string) {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(liveWriteWait))
+	}
+
+	for _, msg := range sub.Replay {
+		if err := write("measurement", msg.Values(sub.Fields)); err != nil {
+			return
+		}
+	}
+	ready := map[string]interface{}{"homes": sub.Homes, "fields": sub.Fields, "replayed": len(sub.Replay)}
+	if err := write("ready", ready); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					closeWith(websocket.CloseTryAgainLater, err.Error())
+				} else {
+					closeWith(websocket.CloseNormalClosure, "")
+				}
+				return
+			}
+			if err := write("measurement", msg.Values(sub.Fields)); err != nil {
+				return
+			}
+		case now := <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait)); err != nil {
+				return
+			}
+			if err := write("heartbeat", map[string]interface{}{"time": now}); err != nil {
+				return
+			}
+		}
+	}
+}
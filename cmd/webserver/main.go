@@ -1,57 +1,81 @@
 package main
 
 import (
+	"database/sql"
 	"flag"
 	"fmt"
 	"os"
-	"strconv"
+
+	"ws/internal/config"
+	"ws/internal/db"
+	"ws/internal/localtime"
 
 	"github.com/joho/godotenv"
 )
 
+// webRequired are the settings the web server needs
+var webRequired = []string{"tibber.token"}
+
 func main() {
+	portFlag := flag.Int("port", 0, "HTTP server port (default: web.port)")
+	configFlag := flag.String("config", "", "TOML config file (default: $CONFIG_FILE, else ./config.toml when present)")
+	flag.Parse()
 
-	if err := godotenv.Load(); err != nil {
-		fmt.Errorf("⚠️ Error: Could not load .env file: %v\n", err)
+	// Een .env vult de omgeving aan, die gaat voor het configuratiebestand
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("⚠️ Error: Could not load .env file: %v\n", err)
 		os.Exit(1)
 	}
 
-	apiToken := os.Getenv("TIBBER_API_TOKEN")
-	if apiToken == "" {
-		fmt.Errorf("TIBBER_API_TOKEN environment variable is required")
+	path := *configFlag
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	title := os.Getenv("TITLE")
-	if title == "" {
-		title = "Default Title"
-	}
+	// De command-line flag gaat voor de omgeving en het configuratiebestand
+	cfg.ApplyOverride(func(c *config.Config) {
+		if *portFlag > 0 {
+			c.Web.Port = *portFlag
+		}
+	})
 
-	apiEndPoint := os.Getenv("TIBBER_API_ENDPOINT")
+	if err := cfg.Validate(webRequired...); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := localtime.SetDefaultTimeZone(cfg.Community.TimeZone); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		os.Exit(1)
+	}
 
- 	portFlag := flag.Int("port", 0, "HTTP server port")
-	flag.Parse()
+	// Database is optioneel; zonder database ontbreken de analyses op opgeslagen data
+	var dbConn *sql.DB
+	if cfg.Database.URL != "" {
+		dbConfig, err := db.ParseURL(cfg.Database.URL)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
 
-	var port int
-
-	// Eerst proberen we de command-line flag
-	if *portFlag > 0 {
-		port = *portFlag
-	} else {
-		// Dan kijken we naar de omgevingsvariabele
-		if portEnv := os.Getenv("PORT"); portEnv != "" {
-			if p, err := strconv.Atoi(portEnv); err == nil && p > 0 {
-				port = p
-			}
+		dbConn, err = db.NewConnection(dbConfig)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
 		}
+		defer dbConn.Close()
 	}
 
 	// Maak een nieuwe web dashboard
-	webDashboard, err := NewWebDashboard(title, port, apiEndPoint, apiToken)
+	webDashboard, err := NewWebDashboard(cfg, dbConn)
 	if err != nil {
 		fmt.Printf("❌ Error: %v\n", err)
 		os.Exit(1)
-	} 
+	}
 
 	// Start de web server
 	if err := webDashboard.Start(); err != nil {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"ws/internal/client"
+	"ws/internal/collector"
+	"ws/internal/model"
+	"ws/internal/scheduler"
+	"ws/internal/service_db"
+)
+
+// signupTTL is how long a member has to choose homes after entering the token
+const signupTTL = 30 * time.Minute
+
+// signup is an onboarding in progress, between checking the token and confirming the homes. The
+// token stays in memory until the member confirms; only then is it stored encrypted.
+type signup struct {
+	Name    string
+	Email   string
+	Token   string
+	Homes   []model.Home
+	Expires time.Time
+}
+
+// onboardingEnabled reports whether members can sign up; it follows web.onboarding without restart
+func (wd *WebDashboard) onboardingEnabled() bool {
+	return wd.Config().Web.Onboarding && wd.Accounts != nil
+}
+
+// renderOnboarding renders a step of the onboarding page
+func (wd *WebDashboard) renderOnboarding(w http.ResponseWriter, code int, data map[string]interface{}) {
+	data["Title"] = wd.Config().Web.Title
+	data["InviteRequired"] = wd.Config().Web.InviteCode != ""
+	data["ConsentVersion"] = model.ConsentVersion
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := wd.Templates.ExecuteTemplate(w, "onboarding.html", data); err != nil {
+		log.Printf("Error rendering onboarding: %v", err)
+	}
+}
+
+// handleOnboarding shows the form for the Tibber token
+func (wd *WebDashboard) handleOnboarding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wd.onboardingEnabled() {
+			respondWithError(w, http.StatusNotFound, "Onboarding is not enabled")
+			return
+		}
+		wd.renderOnboarding(w, http.StatusOK, map[string]interface{}{"Step": "token"})
+	}
+}
+
+// handleOnboardingToken checks the token of a member and shows the homes it gives access to
+func (wd *WebDashboard) handleOnboardingToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wd.onboardingEnabled() {
+			respondWithError(w, http.StatusNotFound, "Onboarding is not enabled")
+			return
+		}
+		wd.expireSignups()
+
+		s := &signup{
+			Name:  strings.TrimSpace(r.FormValue("name")),
+			Email: strings.TrimSpace(r.FormValue("email")),
+			Token: strings.TrimSpace(r.FormValue("token")),
+		}
+		data := map[string]interface{}{"Step": "token", "Name": s.Name, "Email": s.Email}
+		fail := func(code int, message string) {
+			data["Error"] = message
+			wd.renderOnboarding(w, code, data)
+		}
+
+		if code := wd.Config().Web.InviteCode; code != "" &&
+			subtle.ConstantTimeCompare([]byte(r.FormValue("invite")), []byte(code)) != 1 {
+			fail(http.StatusForbidden, "De uitnodigingscode klopt niet")
+			return
+		}
+		if s.Name == "" || !strings.Contains(s.Email, "@") {
+			fail(http.StatusBadRequest, "Vul uw naam en e-mailadres in")
+			return
+		}
+		if s.Token == "" {
+			fail(http.StatusBadRequest, "Vul uw Tibber-token in")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		homes, err := wd.Accounts.PreviewHomes(ctx, s.Token)
+		if errors.Is(err, client.ErrUnauthorized) {
+			fail(http.StatusBadRequest, "Tibber accepteert dit token niet. Controleer of u het volledige token heeft gekopieerd.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching homes for onboarding: %v", err)
+			fail(http.StatusBadGateway, "Tibber is nu niet bereikbaar, probeer het later opnieuw")
+			return
+		}
+		if len(homes) == 0 {
+			fail(http.StatusBadRequest, "Bij dit Tibber-account zijn geen huizen gevonden")
+			return
+		}
+
+		s.Homes = homes
+		s.Expires = time.Now().Add(signupTTL)
+		id := uuid.New().String()
+		wd.signups.Store(id, s)
+
+		wd.renderOnboarding(w, http.StatusOK, map[string]interface{}{
+			"Step":  "homes",
+			"ID":    id,
+			"Name":  s.Name,
+			"Homes": homes,
+		})
+	}
+}
+
+// handleOnboardingConfirm stores the account with the chosen homes and consents, and asks the
+// collector to fetch the history of the homes
+func (wd *WebDashboard) handleOnboardingConfirm() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wd.onboardingEnabled() {
+			respondWithError(w, http.StatusNotFound, "Onboarding is not enabled")
+			return
+		}
+		wd.expireSignups()
+
+		id := chi.URLParam(r, "id")
+		value, ok := wd.signups.Load(id)
+		if !ok {
+			wd.renderOnboarding(w, http.StatusGone, map[string]interface{}{
+				"Step":  "token",
+				"Error": "Uw aanmelding is verlopen, begin opnieuw",
+			})
+			return
+		}
+		s := value.(*signup)
+		if err := r.ParseForm(); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid form")
+			return
+		}
+
+		data := map[string]interface{}{"Step": "homes", "ID": id, "Name": s.Name, "Homes": s.Homes}
+		fail := func(message string) {
+			data["Error"] = message
+			wd.renderOnboarding(w, http.StatusBadRequest, data)
+		}
+
+		// Alleen huizen van dit token
+		offered := make(map[string]bool, len(s.Homes))
+		for _, home := range s.Homes {
+			offered[home.Id] = true
+		}
+		selected := []string{}
+		for _, homeId := range r.Form["home"] {
+			if offered[homeId] {
+				selected = append(selected, homeId)
+			}
+		}
+		if len(selected) == 0 {
+			fail("Kies minstens één huis om te delen")
+			return
+		}
+		if r.FormValue("consent_community") != "on" {
+			fail("Zonder toestemming voor het gebruik van uw gegevens kunt u niet meedoen")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		account, err := wd.Accounts.Add(ctx, service_db.NewAccount{
+			Name:  s.Name,
+			Email: s.Email,
+			Token: s.Token,
+			Homes: selected,
+			Consents: map[string]bool{
+				model.ConsentCommunity: true,
+				model.ConsentLive:      r.FormValue("consent_live") == "on",
+			},
+		})
+		if err != nil {
+			log.Printf("Error storing onboarding of %s: %v", s.Email, err)
+			fail("Uw aanmelding kon niet worden opgeslagen, probeer het later opnieuw")
+			return
+		}
+		wd.signups.Delete(id)
+		log.Printf("Member %s signed up as Tibber account %d with %d homes", s.Name, account.ID, len(selected))
+
+		// De collector haalt de historie ook zonder dit op, uiterlijk bij de volgende geplande run
+		if err := wd.SchedulerSvc.Trigger(ctx, collector.JobOnboarding); err != nil && !errors.Is(err, scheduler.ErrUnknownJob) {
+			log.Printf("Error triggering onboarding job: %v", err)
+		}
+
+		var homes []model.Home
+		for _, home := range s.Homes {
+			for _, homeId := range selected {
+				if home.Id == homeId {
+					homes = append(homes, home)
+				}
+			}
+		}
+		wd.renderOnboarding(w, http.StatusOK, map[string]interface{}{
+			"Step":    "done",
+			"Name":    account.Name,
+			"Homes":   homes,
+			"Live":    r.FormValue("consent_live") == "on",
+			"Account": account,
+		})
+	}
+}
+
+// expireSignups forgets signups that were not confirmed in time, with their tokens
+func (wd *WebDashboard) expireSignups() {
+	now := time.Now()
+	wd.signups.Range(func(key, value interface{}) bool {
+		if s, ok := value.(*signup); ok && now.After(s.Expires) {
+			wd.signups.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ws/internal/export"
+	"ws/internal/localtime"
+	"ws/internal/opendata"
+)
+
+// maxOpenDataDays limits the period of one request to the open data API
+const maxOpenDataDays = 92
+
+// openDataEnabled reports whether the open data API is public; it follows opendata.enabled without restart
+func (wd *WebDashboard) openDataEnabled() bool {
+	return wd.Config().OpenData.Enabled && wd.OpenDataSvc != nil
+}
+
+// handleOpenDataIndex describes the datasets, the privacy settings and the published days
+func (wd *WebDashboard) handleOpenDataIndex() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wd.openDataEnabled() {
+			respondWithError(w, http.StatusNotFound, "Open data is not enabled")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		published, err := wd.OpenDataSvc.Published(ctx)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		cfg := wd.Config().OpenData
+		type dataset struct {
+			opendata.Dataset
+			Published interface{} `json:"published,omitempty"`
+			URL       string      `json:"url"`
+		}
+		datasets := make([]dataset, 0, len(opendata.Datasets))
+		for _, d := range opendata.Datasets {
+			entry := dataset{Dataset: d, URL: "/open-data/" + d.Name}
+			if p, ok := published[d.Name]; ok {
+				entry.Published = map[string]interface{}{
+					"first": p.First.Format("2006-01-02"),
+					"last":  p.Last.Format("2006-01-02"),
+					"days":  p.Days,
+				}
+			}
+			datasets = append(datasets, entry)
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		respondWithJSON(w, map[string]interface{}{
+			"level":         cfg.Level,
+			"timeZone":      localtime.Location("").String(),
+			"minHomes":      cfg.MinHomes,
+			"epsilon":       cfg.Epsilon,
+			"maxKWhPerHour": cfg.MaxKWhPerHour,
+			"files":         cfg.Directory != "",
+			"datasets":      datasets,
+		})
+	}
+}
+
+// handleOpenData returns the published rows of a dataset for a period of days, as CSV, JSON Lines
+// or Parquet
+func (wd *WebDashboard) handleOpenData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wd.openDataEnabled() {
+			respondWithError(w, http.StatusNotFound, "Open data is not enabled")
+			return
+		}
+		d, ok := opendata.Find(chi.URLParam(r, "dataset"))
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Unknown dataset")
+			return
+		}
+
+		// Dagen in de tijdzone van de gemeenschap, standaard de afgelopen week
+		loc := localtime.Location("")
+		to := localtime.StartOfDay(time.Now(), loc)
+		from := to.AddDate(0, 0, -7)
+		var err error
+		if v := r.URL.Query().Get("from"); v != "" {
+			if from, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid from, expected YYYY-MM-DD")
+				return
+			}
+		}
+		if v := r.URL.Query().Get("to"); v != "" {
+			if to, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid to, expected YYYY-MM-DD")
+				return
+			}
+		}
+		if !to.After(from) || to.Sub(from) > maxOpenDataDays*24*time.Hour {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("to must be after from, at most %d days", maxOpenDataDays))
+			return
+		}
+		format, err := export.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		rows, err := wd.OpenDataSvc.Rows(ctx, d, from, to, r.URL.Query().Get("area"))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		name := fmt.Sprintf("%s_%s_%s.%s", d.Name, from.Format("2006-01-02"), to.Format("2006-01-02"), format)
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// Gepubliceerde dagen veranderen niet meer
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if err := opendata.Write(w, format, d, rows); err != nil {
+			log.Printf("Error writing open data %s: %v", d.Name, err)
+		}
+	}
+}
+
+// handleOpenDataFiles serves the daily files from opendata.directory
+func (wd *WebDashboard) handleOpenDataFiles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir := wd.Config().OpenData.Directory
+		if !wd.openDataEnabled() || dir == "" {
+			respondWithError(w, http.StatusNotFound, "Open data files are not published")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.StripPrefix("/open-data/files/", http.FileServer(http.Dir(dir))).ServeHTTP(w, r)
+	}
+}
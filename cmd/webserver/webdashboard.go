@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"html/template"
 	"log"
@@ -11,31 +12,31 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"ws/internal/client"
+	"ws/internal/collector"
+	"ws/internal/config"
+	"ws/internal/forecast"
+	"ws/internal/gas"
+	"ws/internal/live"
+	"ws/internal/localtime"
+	"ws/internal/meterdata"
 	"ws/internal/model"
 	"ws/internal/service"
+	"ws/internal/service_db"
+	"ws/internal/tariff"
 	"ws/internal/tibber"
 
 	"github.com/go-chi/chi/v5"
 )
 
-// LiveData represents the data structure for SSE updates
-type LiveData struct {
-	Timestamp              time.Time `json:"timestamp"`
-	Power                  float64   `json:"power"`
-	PowerProduction        float64   `json:"powerProduction"`
-	AccumulatedConsumption float64   `json:"accumulatedConsumption"`
-	AccumulatedProduction  float64   `json:"accumulatedProduction"`
-}
-
 // WebDashboard represents the web dashboard
 type WebDashboard struct {
 	Port   int
 	Router *chi.Mux
-	Title  string
 
 	// Services
 	Client         *client.TibberClient
@@ -44,6 +45,44 @@ type WebDashboard struct {
 	ProductionSvc  *service.ProductionService
 	PriceSvc       *service.PriceService
 
+	// Database-gebaseerde analyses (alleen beschikbaar met database.url)
+	DB           *sql.DB
+	TariffSvc    *service_db.TariffService
+	ForecastSvc  *service_db.ForecastService
+	SolarSvc     *service_db.SolarService
+	UsageSvc     *service_db.ConsumptionForecastService
+	AnomalySvc   *service_db.AnomalyService
+	QualitySvc   *service_db.PowerQualityService
+	CarbonSvc    *service_db.CarbonService
+	StatementSvc *service_db.StatementService
+	GasSvc       *service_db.GasService
+	ImportSvc    *service_db.ImportService
+	ExportSvc    *service_db.ExportService
+	SchedulerSvc *service_db.SchedulerService
+	Contracts    []tariff.Contract
+
+	// Inzagelog: wie bekeek de gegevens van welk huis; nil zonder database
+	PrivacySvc *service_db.PrivacyService
+	accessLog  chan model.DataAccess
+	accessSeen sync.Map // Laatste vastgelegde inzage per kijker, huis en onderdeel
+
+	// Gepubliceerde open data voor /open-data; nil zonder database
+	OpenDataSvc *service_db.OpenDataService
+
+	// Sensoren voor Home Assistant onder /ha/v1 met API-tokens van leden; nil zonder database
+	APITokens    *service_db.APITokenService
+	RealTimeSvc  *service_db.RealTimeService
+	CommunitySvc *service_db.CommunityService
+
+	// Webhooks van leden en de beheerder, met het afleverlog op /admin/webhooks; nil zonder database
+	Webhooks *service_db.WebhookService
+
+	// Tibber-accounts van leden; nil zonder database of tibber.accounts_key
+	Accounts *service_db.AccountService
+	signups  sync.Map // Aanmeldingen in behandeling, per ID een *signup
+	// Huizen die leden delen, ook zonder tibber.accounts_key; nil zonder database
+	Members *service_db.AccountService
+
 	// State
 	Homes    []model.Home
 	AllHomes []model.Home
@@ -57,24 +96,24 @@ type WebDashboard struct {
 	priceUpdateChannels sync.Map // Maps homeID+clientAddr to notification channel
 
 	// Nightly energy data refresh
-	energyRefreshTicker *time.Ticker
 	energyRefreshStopCh chan struct{}
 	energyRefreshWg     sync.WaitGroup
 
-	ApiEndPoint  string
-	ApiToken     string
 	TibberClient *tibber.Client
 	Wg           *sync.WaitGroup
 
-	// Live data
-	liveDataChannels sync.Map // Maps client ID to channel
-	ctx              context.Context
+	// Huidige configuratie; de titel volgt het configuratiebestand zonder herstart
+	config atomic.Pointer[config.Config]
+
+	// Live metingen per huis voor /live/v1 en /live-data
+	Live *live.Hub
 }
 
-// de constructor voor WebDashboard
-func NewWebDashboard(title string, port int, apiEndPoint, apiToken string) (*WebDashboard, error) {
+// de constructor voor WebDashboard; cfg moet gevalideerd zijn met webRequired
+func NewWebDashboard(cfg *config.Config, dbConn *sql.DB) (*WebDashboard, error) {
 	// Create GraphQL client for regular API calls
-	graphqlClient := client.NewClient(apiToken)
+	graphqlClient := client.NewClient(cfg.Tibber.Token)
+	graphqlClient.APIURL = cfg.Tibber.APIURL
 
 	// Create services
 	homeService := &service.HomeService{Client: graphqlClient}
@@ -83,12 +122,11 @@ func NewWebDashboard(title string, port int, apiEndPoint, apiToken string) (*Web
 	priceSvc := &service.PriceService{Client: graphqlClient}
 
 	// Create websocket client
-	wsClient := tibber.NewClient(apiToken, os.Getenv("TIBBER_HOUSE_ID"))
+	wsClient := tibber.NewClientWithEndpoints(cfg.Tibber.Token, cfg.Tibber.HouseID, cfg.Tibber.Endpoints())
 
 	wd := &WebDashboard{
 		// Server configuration
-		Port:   port,
-		Title:  title,
+		Port:   cfg.Web.Port,
 		Router: chi.NewRouter(),
 		// Services
 		Client:         graphqlClient,
@@ -98,8 +136,109 @@ func NewWebDashboard(title string, port int, apiEndPoint, apiToken string) (*Web
 		PriceSvc:       priceSvc,
 		TibberClient:   wsClient,
 		Wg:             &sync.WaitGroup{},
-		ApiEndPoint:    apiEndPoint,
-		ApiToken:       apiToken,
+		DB:             dbConn,
+		Contracts:      tariff.DefaultContracts(),
+	}
+	wd.config.Store(cfg)
+	wd.Live = &live.Hub{
+		Backlog:      cfg.Web.LiveBacklog,
+		Queue:        liveQueue,
+		MaxClients:   liveMaxClients,
+		PollInterval: livePollInterval,
+	}
+
+	if dbConn != nil {
+		wd.TariffSvc = &service_db.TariffService{DB: dbConn}
+		wd.ForecastSvc = &service_db.ForecastService{DB: dbConn}
+		wd.SolarSvc = &service_db.SolarService{DB: dbConn}
+
+		// Instralings- of bewolkingsverwachting voor de PV-voorspelling
+		if path := cfg.Files.SolarWeather; path != "" {
+			weather, err := forecast.LoadWeather(path)
+			if err != nil {
+				return nil, err
+			}
+			wd.SolarSvc.Weather = weather
+		}
+
+		// Temperatuurverwachting voor de verbruiksvoorspelling
+		wd.UsageSvc = &service_db.ConsumptionForecastService{DB: dbConn}
+		if path := cfg.Files.ConsumptionWeather; path != "" {
+			weather, err := forecast.LoadWeather(path)
+			if err != nil {
+				return nil, err
+			}
+			wd.UsageSvc.Weather = weather
+		}
+
+		// Meldingen worden door de collector verstuurd, het dashboard toont ze alleen
+		wd.AnomalySvc = &service_db.AnomalyService{DB: dbConn, Solar: wd.SolarSvc, Usage: wd.UsageSvc}
+		wd.QualitySvc = &service_db.PowerQualityService{DB: dbConn}
+		wd.CarbonSvc = &service_db.CarbonService{DB: dbConn}
+		wd.StatementSvc = &service_db.StatementService{DB: dbConn, Carbon: wd.CarbonSvc}
+
+		// Gas met hetzelfde weer als de verbruiksvoorspelling voor de graaddagen
+		wd.GasSvc = &service_db.GasService{DB: dbConn, Tariff: gas.DefaultTariff(), Weather: wd.UsageSvc.Weather}
+		if path := cfg.Files.GasTariff; path != "" {
+			gasTariff, err := gas.LoadTariff(path)
+			if err != nil {
+				return nil, err
+			}
+			wd.GasSvc.Tariff = gasTariff
+		}
+
+		wd.ExportSvc = &service_db.ExportService{DB: dbConn}
+		wd.SchedulerSvc = &service_db.SchedulerService{DB: dbConn}
+		wd.PrivacySvc = &service_db.PrivacyService{DB: dbConn}
+		wd.OpenDataSvc = &service_db.OpenDataService{DB: dbConn}
+		wd.APITokens = &service_db.APITokenService{DB: dbConn}
+		wd.RealTimeSvc = &service_db.RealTimeService{DB: dbConn}
+		// Huizen die de collector meet, zoals die van leden en een P1-lezer, komen uit de database
+		wd.Live.Load = func(ctx context.Context, homeID string, since time.Time) ([]tibber.Measurement, error) {
+			return wd.RealTimeSvc.MeasurementsSince(ctx, homeID, since, liveLoadLimit)
+		}
+		wd.CommunitySvc = &service_db.CommunityService{DB: dbConn}
+
+		// Tokens van leden en sleutels van webhooks staan versleuteld in de database
+		box, err := cfg.Tibber.Box()
+		if err != nil {
+			return nil, err
+		}
+		wd.Webhooks = &service_db.WebhookService{DB: dbConn, Box: box}
+		wd.Members = &service_db.AccountService{DB: dbConn}
+		wd.accessLog = make(chan model.DataAccess, 1000)
+		if cfg.Web.ViewerHeader == "" {
+			// Achter een proxy is het IP-adres dat van de proxy: alleen API-tokens zijn dan te herleiden
+			log.Printf("⚠️ web.viewer_header is not set: the access log only records the proxy address for dashboard views")
+		}
+
+		// Leden melden zich aan met hun eigen Tibber-token, dat versleuteld wordt opgeslagen
+		if box != nil {
+			wd.Accounts = &service_db.AccountService{
+				DB:   dbConn,
+				Box:  box,
+				Pool: client.NewPool(cfg.Tibber.APIURL, cfg.Tibber.RequestsPerMinute, collector.AccountBurst),
+			}
+		}
+
+		// Meetgegevens van de netbeheerder, met eigen kolomindelingen naast de ingebouwde
+		wd.ImportSvc = &service_db.ImportService{DB: dbConn, Profiles: meterdata.Profiles}
+		if path := cfg.Files.ImportProfiles; path != "" {
+			profiles, err := meterdata.LoadProfiles(path)
+			if err != nil {
+				return nil, err
+			}
+			wd.ImportSvc.Profiles = profiles
+		}
+	}
+
+	// Eigen contracten voor de tariefvergelijking
+	if path := cfg.Files.TariffContracts; path != "" {
+		contracts, err := tariff.LoadContracts(path)
+		if err != nil {
+			return nil, err
+		}
+		wd.Contracts = contracts
 	}
 
 	// Laad templates
@@ -173,7 +312,8 @@ func (wd *WebDashboard) loadTemplates() (*template.Template, error) {
 	}
 
 	// Begin met een lege template en voeg de layout toe
-	t, err := template.New("").Funcs(funcMap).ParseFiles(layoutPath)
+	t, err := template.New("").Funcs(funcMap).ParseFiles(layoutPath,
+		filepath.Join(templatesPath, "onboarding.html"), filepath.Join(templatesPath, "webhooks.html"))
 	if err != nil {
 		return nil, fmt.Errorf("error bij parsen van layout: %w", err)
 	}
@@ -189,6 +329,11 @@ func (wd *WebDashboard) loadTemplates() (*template.Template, error) {
 	return t, nil
 }
 
+// Config returns the current configuration
+func (wd *WebDashboard) Config() *config.Config {
+	return wd.config.Load()
+}
+
 // Start de web server
 func (wd *WebDashboard) Start() error {
 	// Haal homes op
@@ -246,6 +391,17 @@ func (wd *WebDashboard) Start() error {
 	// Start the Tibber websocket connection
 	wd.StartTibberWebsocket(ctx)
 
+	// Inzage in de gegevens van huizen vastleggen
+	if wd.PrivacySvc != nil {
+		go wd.writeAccessLog(ctx)
+	}
+
+	// Niet-structurele instellingen, zoals de titel, volgen het configuratiebestand
+	go config.Watch(ctx, wd.Config(), webRequired, func(old, new *config.Config) {
+		wd.config.Store(new)
+		log.Printf("Configuration reloaded")
+	})
+
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		return fmt.Errorf("server error: %w", err)
 	}
@@ -342,45 +498,23 @@ func (wd *WebDashboard) stopPriceRefresh() {
 
 // startEnergyRefresh start een nachtelijke energiedata verversing
 func (wd *WebDashboard) startEnergyRefresh() {
-	// Bereken tijd tot 2 uur 's nachts
-	now := time.Now()
-	nextRun := time.Date(now.Year(), now.Month(), now.Day(), 2, 0, 0, 0, now.Location())
-	if now.After(nextRun) {
-		nextRun = nextRun.Add(24 * time.Hour)
-	}
-
-	initialDelay := time.Until(nextRun)
-
-	// Start een timer voor de eerste run
-	initialTimer := time.NewTimer(initialDelay)
+	// Elke nacht om 2 uur lokale tijd; opnieuw berekend zodat zomer-/wintertijd geen verschuiving geeft
+	loc := localtime.Location("")
 	wd.energyRefreshStopCh = make(chan struct{})
 	wd.energyRefreshWg.Add(1)
 
 	go func() {
 		defer wd.energyRefreshWg.Done()
 
-		// Wacht op de eerste timer
-		select {
-		case <-initialTimer.C:
-			// Start de refresh en dan de periodieke timer
-			wd.refreshAllEnergyData()
-			wd.energyRefreshTicker = time.NewTicker(24 * time.Hour)
-
-		case <-wd.energyRefreshStopCh:
-			initialTimer.Stop()
-			return
-		}
-
-		// Begin de dagelijkse cyclus
 		for {
+			timer := time.NewTimer(time.Until(localtime.NextAt(time.Now(), 2, loc)))
+
 			select {
-			case <-wd.energyRefreshTicker.C:
+			case <-timer.C:
 				wd.refreshAllEnergyData()
 
 			case <-wd.energyRefreshStopCh:
-				if wd.energyRefreshTicker != nil {
-					wd.energyRefreshTicker.Stop()
-				}
+				timer.Stop()
 				return
 			}
 		}
@@ -418,7 +552,7 @@ func (wd *WebDashboard) refreshAllEnergyData() {
 
 // stopEnergyRefresh stopt de nachtelijke energiedata verversing
 func (wd *WebDashboard) stopEnergyRefresh() {
-	if wd.energyRefreshTicker != nil || wd.energyRefreshStopCh != nil {
+	if wd.energyRefreshStopCh != nil {
 		close(wd.energyRefreshStopCh)
 		wd.energyRefreshWg.Wait()
 		log.Println("Dagelijkse energiedata verversing gestopt")
@@ -437,28 +571,7 @@ func (wd *WebDashboard) StartTibberWebsocket(ctx context.Context) {
 			select {
 			case measurement := <-wd.TibberClient.WebsocketClient.Data:
 				if !measurement.Timestamp.Equal(lastMeasurement) {
-					// Convert measurement to LiveData
-					liveData := LiveData{
-						Timestamp:              measurement.Timestamp,
-						Power:                  measurement.Power,
-						PowerProduction:        measurement.PowerProduction,
-						AccumulatedConsumption: measurement.AccumulatedConsumption,
-						AccumulatedProduction:  measurement.AccumulatedProduction,
-					}
-
-					// Broadcast to all connected SSE clients
-					wd.liveDataChannels.Range(func(key, value interface{}) bool {
-						if ch, ok := value.(chan LiveData); ok {
-							select {
-							case ch <- liveData:
-							default:
-								// Channel is full or closed, remove it
-								wd.liveDataChannels.Delete(key)
-							}
-						}
-						return true
-					})
-
+					wd.Live.Publish(wd.Config().Tibber.HouseID, measurement)
 					lastMeasurement = measurement.Timestamp
 				}
 			case <-ctx.Done():
@@ -467,34 +580,3 @@ func (wd *WebDashboard) StartTibberWebsocket(ctx context.Context) {
 		}
 	}()
 }
-
-func (wd *WebDashboard) handleTibberWebsocket() {
-	for {
-		select {
-		case measurement := <-wd.TibberClient.WebsocketClient.Data:
-			// Convert measurement to LiveData
-			liveData := LiveData{
-				Timestamp:              measurement.Timestamp,
-				Power:                  measurement.Power,
-				PowerProduction:        measurement.PowerProduction,
-				AccumulatedConsumption: measurement.AccumulatedConsumption,
-				AccumulatedProduction:  measurement.AccumulatedProduction,
-			}
-
-			// Send to all connected clients
-			wd.liveDataChannels.Range(func(key, value interface{}) bool {
-				if ch, ok := value.(chan LiveData); ok {
-					select {
-					case ch <- liveData:
-					default:
-						// Channel is full or closed, remove it
-						wd.liveDataChannels.Delete(key)
-					}
-				}
-				return true
-			})
-		case <-wd.ctx.Done():
-			return
-		}
-	}
-}
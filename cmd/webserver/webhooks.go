@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ws/internal/collector"
+	"ws/internal/model"
+	"ws/internal/scheduler"
+	"ws/internal/service_db"
+	"ws/internal/webhook"
+)
+
+// deliveryStatuses are the statuses of the delivery log, for the filter
+var deliveryStatuses = []string{model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed}
+
+// isAdmin reports whether the proxy passed one of web.admin_users in web.viewer_header
+func (wd *WebDashboard) isAdmin(r *http.Request) bool {
+	cfg := wd.Config().Web
+	if cfg.ViewerHeader == "" {
+		return false
+	}
+	user := r.Header.Get(cfg.ViewerHeader)
+	for _, u := range cfg.AdminUsers {
+		if user != "" && user == u {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdmin lets only the users of web.admin_users through, as set by the proxy in
+// web.viewer_header. Forms are only accepted from the admin pages themselves.
+func (wd *WebDashboard) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := wd.Config().Web
+		if len(cfg.AdminUsers) == 0 || cfg.ViewerHeader == "" {
+			respondWithError(w, http.StatusNotFound, "The admin pages are not enabled")
+			return
+		}
+		if !wd.isAdmin(r) {
+			respondWithError(w, http.StatusForbidden, "Not an admin")
+			return
+		}
+		// Een formulier van een andere site mag niet met de sessie van de beheerder posten
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+				respondWithError(w, http.StatusForbidden, "Cross-origin request")
+				return
+			}
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					respondWithError(w, http.StatusForbidden, "Cross-origin request")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireWebhooks answers the webhook admin pages only when the webhooks are stored in a database
+func (wd *WebDashboard) requireWebhooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wd.Webhooks == nil {
+			respondWithError(w, http.StatusNotFound, "Webhooks require a database")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// triggerWebhooks asks the collector to deliver now instead of at the next minute
+func (wd *WebDashboard) triggerWebhooks(ctx context.Context) {
+	if err := wd.SchedulerSvc.Trigger(ctx, collector.JobWebhooks); err != nil && !errors.Is(err, scheduler.ErrUnknownJob) {
+		log.Printf("Error triggering webhooks job: %v", err)
+	}
+}
+
+// renderWebhooksAdmin renders the admin page with the endpoints and the delivery log. The status
+// and endpoint query parameters filter the log.
+func (wd *WebDashboard) renderWebhooksAdmin(w http.ResponseWriter, r *http.Request, code int, data map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+	defer cancel()
+
+	filter := service_db.DeliveryFilter{
+		EndpointID: queryInt(r, "endpoint", 0),
+		Status:     strings.ToUpper(r.URL.Query().Get("status")),
+		Limit:      queryInt(r, "limit", 100),
+	}
+	endpoints, err := wd.Webhooks.Endpoints(ctx, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	deliveries, err := wd.Webhooks.Deliveries(ctx, filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data["Title"] = wd.Config().Web.Title
+	data["Endpoints"] = endpoints
+	data["Deliveries"] = deliveries
+	data["Filter"] = filter
+	data["Statuses"] = deliveryStatuses
+	data["EventTypes"] = webhook.EventTypes
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := wd.Templates.ExecuteTemplate(w, "webhooks.html", data); err != nil {
+		log.Printf("Error rendering webhooks admin: %v", err)
+	}
+}
+
+// handleWebhooksAdmin shows the webhook endpoints and the delivery log
+func (wd *WebDashboard) handleWebhooksAdmin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wd.renderWebhooksAdmin(w, r, http.StatusOK, map[string]interface{}{})
+	}
+}
+
+// handleWebhooksAdminAdd registers an endpoint from the admin page and shows its secret once
+func (wd *WebDashboard) handleWebhooksAdminAdd() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid form")
+			return
+		}
+		account, _ := strconv.Atoi(r.FormValue("account"))
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		endpoint, secret, err := wd.Webhooks.AddEndpoint(ctx, account, r.FormValue("url"), r.Form["event"], strings.TrimSpace(r.FormValue("description")))
+		if err != nil {
+			wd.renderWebhooksAdmin(w, r, http.StatusBadRequest, map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		log.Printf("Webhook endpoint %d for %s added by %s", endpoint.ID, endpoint.URL, r.Header.Get(wd.Config().Web.ViewerHeader))
+		wd.renderWebhooksAdmin(w, r, http.StatusOK, map[string]interface{}{"Added": endpoint, "Secret": secret})
+	}
+}
+
+// handleWebhooksAdminAction runs an action on an endpoint from the admin page: test, pause,
+// resume or remove. It redirects back to the page.
+func (wd *WebDashboard) handleWebhooksAdminAction() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		switch action := chi.URLParam(r, "action"); action {
+		case "test":
+			if err = wd.Webhooks.Ping(ctx, id, 0); err == nil {
+				wd.triggerWebhooks(ctx)
+			}
+		case "pause", "resume":
+			err = wd.Webhooks.SetActive(ctx, id, action == "resume")
+		case "remove":
+			err = wd.Webhooks.RemoveEndpoint(ctx, id, 0)
+		default:
+			respondWithError(w, http.StatusNotFound, "Unknown action")
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	}
+}
+
+// handleWebhooksAdminReplay queues a delivery again and redirects back to the delivery log
+func (wd *WebDashboard) handleWebhooksAdminReplay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 8*time.Second)
+		defer cancel()
+		if _, err := wd.Webhooks.Replay(ctx, id, 0); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		wd.triggerWebhooks(ctx)
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+	}
+}
+
+// memberAccount returns the account of the API token of a member request. Webhooks belong to an
+// account, so a token for a single home cannot manage them.
+func memberAccount(w http.ResponseWriter, r *http.Request) (int, bool) {
+	t := apiTokenFrom(r.Context())
+	if t == nil || t.AccountID == 0 {
+		respondWithError(w, http.StatusForbidden, "Webhooks need an API token of a member account")
+		return 0, false
+	}
+	return t.AccountID, true
+}
+
+// respondWithWebhookError answers an error of the webhook service; an endpoint or delivery of
+// another account is not found
+func respondWithWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Unknown webhook endpoint or delivery")
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+// handleMemberWebhooks lists the webhook endpoints of the member
+func (wd *WebDashboard) handleMemberWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		endpoints, err := wd.Webhooks.Endpoints(ctx, account)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		if endpoints == nil {
+			endpoints = []model.WebhookEndpoint{}
+		}
+		respondWithJSON(w, endpoints)
+	}
+}
+
+// handleMemberWebhookAdd registers an endpoint of the member; the secret is only in this response
+func (wd *WebDashboard) handleMemberWebhookAdd() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		var req struct {
+			URL         string   `json:"url"`
+			Events      []string `json:"events"`
+			Description string   `json:"description"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		endpoint, secret, err := wd.Webhooks.AddEndpoint(ctx, account, req.URL, req.Events, strings.TrimSpace(req.Description))
+		if err != nil {
+			// Alleen invoerfouten komen hier voor de opslag; die zijn voor het lid
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		respondWithJSON(w, map[string]interface{}{"endpoint": endpoint, "secret": secret})
+	}
+}
+
+// handleMemberWebhookRemove removes an endpoint of the member
+func (wd *WebDashboard) handleMemberWebhookRemove() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := wd.Webhooks.RemoveEndpoint(ctx, id, account); err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleMemberWebhookTest queues a webhook.ping for an endpoint of the member
+func (wd *WebDashboard) handleMemberWebhookTest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := wd.Webhooks.Ping(ctx, id, account); err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		wd.triggerWebhooks(ctx)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// handleMemberWebhookDeliveries returns the delivery log of the endpoints of the member, filtered
+// with the query parameters endpoint, status and limit
+func (wd *WebDashboard) handleMemberWebhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		deliveries, err := wd.Webhooks.Deliveries(ctx, service_db.DeliveryFilter{
+			AccountID:  account,
+			EndpointID: queryInt(r, "endpoint", 0),
+			Status:     strings.ToUpper(r.URL.Query().Get("status")),
+			Limit:      min(queryInt(r, "limit", 100), 500),
+		})
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []model.WebhookDelivery{}
+		}
+		respondWithJSON(w, deliveries)
+	}
+}
+
+// handleMemberWebhookReplay queues a delivery of the member again
+func (wd *WebDashboard) handleMemberWebhookReplay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := memberAccount(w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		replay, err := wd.Webhooks.Replay(ctx, id, account)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		wd.triggerWebhooks(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		respondWithJSON(w, map[string]interface{}{"id": replay, "replayOf": id})
+	}
+}
@@ -0,0 +1,177 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Soorten afwijkingen
+const (
+	KindNoProduction     = "NO_PRODUCTION"
+	KindLowProduction    = "LOW_PRODUCTION"
+	KindNightConsumption = "NIGHT_CONSUMPTION"
+	KindBaseloadJump     = "BASELOAD_JUMP"
+	KindHighPower        = "HIGH_POWER"
+	KindProductionOutage = "PRODUCTION_OUTAGE"
+)
+
+// Ernst van een afwijking, oplopend
+const (
+	SeverityInfo     = "INFO"
+	SeverityWarning  = "WARNING"
+	SeverityCritical = "CRITICAL"
+)
+
+// Event is a detected deviation from the expected behaviour of a home
+type Event struct {
+	ID          int64     `json:"id,omitempty"`
+	HomeId      string    `json:"homeId"`
+	Kind        string    `json:"kind"`
+	Severity    string    `json:"severity"`
+	PeriodStart time.Time `json:"periodStart"` // Begin van de dag, nacht of meting waar het om gaat
+	DetectedAt  time.Time `json:"detectedAt"`
+	Value       float64   `json:"value"`
+	Expected    float64   `json:"expected"`
+	Message     string    `json:"message"`
+}
+
+// SeverityRank orders severities so they can be compared against a minimum
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityCritical:
+		return 3
+	case SeverityWarning:
+		return 2
+	case SeverityInfo:
+		return 1
+	}
+	return 0
+}
+
+// Drempels voor de dagelijkse controles
+const (
+	SunnyPeerRatio     = 0.5  // Mediaan van de buren ten opzichte van hun verwachting: vanaf hier was het een zonnige dag
+	NoProductionRatio  = 0.05 // Minder dan dit deel van de verwachting geldt als geen productie
+	LowProductionShare = 0.4  // Minder dan dit deel van wat de buren haalden geldt als lage productie
+	MinExpectedKWh     = 1.0  // Dagen met minder verwachte productie worden niet beoordeeld
+	MinPeers           = 3
+
+	NightMADFactor  = 4   // Aantal keer de mediane afwijking boven de mediaan van eerdere nachten
+	MinNightHistory = 7   // Minimaal aantal eerdere nachten
+	MinNightExcessW = 100 // Minimale overschrijding in W
+
+	BaseloadJumpFactor = 1.5 // Recent sluipverbruik ten opzichte van daarvoor
+	MinBaseloadJumpW   = 50
+)
+
+// CheckProduction compares the production of a home on a day with its own expectation and with
+// how the community peers did relative to theirs. Peers are the actual/expected ratios of other homes
+// on the same day; without enough peers the weather is unknown and only zero production is flagged.
+func CheckProduction(homeId string, day time.Time, actual, expected float64, peers []float64) *Event {
+	if expected < MinExpectedKWh {
+		return nil
+	}
+
+	ratio := actual / expected
+	event := &Event{
+		HomeId:      homeId,
+		PeriodStart: day,
+		Value:       round2(actual),
+		Expected:    round2(expected),
+	}
+
+	peerRatio := -1.0
+	if len(peers) >= MinPeers {
+		peerRatio = median(peers)
+	}
+
+	switch {
+	case ratio < NoProductionRatio && (peerRatio < 0 || peerRatio >= SunnyPeerRatio):
+		event.Kind = KindNoProduction
+		event.Severity = SeverityCritical
+		event.Message = fmt.Sprintf("Geen zonneproductie op %s (%.1f kWh) terwijl %.1f kWh verwacht werd; controleer de omvormer",
+			day.Format("2006-01-02"), actual, expected)
+	case peerRatio >= SunnyPeerRatio && ratio < peerRatio*LowProductionShare:
+		event.Kind = KindLowProduction
+		event.Severity = SeverityWarning
+		event.Message = fmt.Sprintf("Zonneproductie op %s was %.0f%% van de verwachting, andere leden haalden %.0f%%",
+			day.Format("2006-01-02"), ratio*100, peerRatio*100)
+	default:
+		return nil
+	}
+
+	return event
+}
+
+// CheckNightConsumption flags a night whose average power is far above earlier nights of the home
+func CheckNightConsumption(homeId string, night time.Time, averageW float64, history []float64) *Event {
+	if len(history) < MinNightHistory {
+		return nil
+	}
+
+	med := median(history)
+	deviations := make([]float64, len(history))
+	for i, v := range history {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad := median(deviations)
+
+	threshold := med + math.Max(NightMADFactor*mad, MinNightExcessW)
+	if averageW <= threshold {
+		return nil
+	}
+
+	severity := SeverityWarning
+	if averageW > 2*threshold {
+		severity = SeverityCritical
+	}
+
+	return &Event{
+		HomeId:      homeId,
+		Kind:        KindNightConsumption,
+		Severity:    severity,
+		PeriodStart: night,
+		Value:       math.Round(averageW),
+		Expected:    math.Round(med),
+		Message: fmt.Sprintf("Ongebruikelijk hoog nachtverbruik: gemiddeld %.0f W, normaal %.0f W. Staat er een kachel of apparaat aan?",
+			averageW, med),
+	}
+}
+
+// CheckBaseloadJump flags a sudden increase of the always-on consumption
+func CheckBaseloadJump(homeId string, day time.Time, recentW, previousW float64) *Event {
+	if previousW <= 0 || recentW < previousW*BaseloadJumpFactor || recentW-previousW < MinBaseloadJumpW {
+		return nil
+	}
+
+	return &Event{
+		HomeId:      homeId,
+		Kind:        KindBaseloadJump,
+		Severity:    SeverityWarning,
+		PeriodStart: day,
+		Value:       math.Round(recentW),
+		Expected:    math.Round(previousW),
+		Message: fmt.Sprintf("Sluipverbruik is gestegen van %.0f W naar %.0f W. Is er een nieuw apparaat dat altijd aan staat?",
+			previousW, recentW),
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,72 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckProduction(t *testing.T) {
+	day := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		actual, expected float64
+		peers            []float64
+		want             string
+	}{
+		{"nothing expected", 0, 0.5, nil, ""},
+		// Zonder genoeg buren is het weer onbekend: alleen geen productie valt op
+		{"no production without peers", 0.1, 10, []float64{0.9}, KindNoProduction},
+		{"low production without peers", 2, 10, nil, ""},
+		{"no production on a sunny day", 0.1, 10, []float64{0.8, 0.9, 1.0}, KindNoProduction},
+		// Bij de buren was het ook bewolkt
+		{"no production on a dark day", 0.1, 10, []float64{0.1, 0.2, 0.3}, ""},
+		{"low production on a sunny day", 3, 10, []float64{0.8, 0.9, 1.0}, KindLowProduction},
+		{"normal production", 8, 10, []float64{0.8, 0.9, 1.0}, ""},
+	}
+	for _, tt := range tests {
+		e := CheckProduction("home", day, tt.actual, tt.expected, tt.peers)
+		switch {
+		case tt.want == "" && e != nil:
+			t.Errorf("%s: unexpected %s", tt.name, e.Kind)
+		case tt.want != "" && (e == nil || e.Kind != tt.want):
+			t.Errorf("%s: got %+v, want %s", tt.name, e, tt.want)
+		}
+	}
+}
+
+func TestCheckNightConsumption(t *testing.T) {
+	night := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	history := []float64{100, 110, 90, 105, 95, 100, 120}
+
+	// Mediaan 100, afwijking 5: de drempel is 100 + max(4 × 5, 100) = 200 W
+	if e := CheckNightConsumption("home", night, 200, history); e != nil {
+		t.Errorf("event at the threshold: %+v", e)
+	}
+	if e := CheckNightConsumption("home", night, 250, history); e == nil || e.Severity != SeverityWarning || e.Expected != 100 {
+		t.Errorf("got %+v, want a warning", e)
+	}
+	if e := CheckNightConsumption("home", night, 450, history); e == nil || e.Severity != SeverityCritical {
+		t.Errorf("got %+v, want a critical event", e)
+	}
+	if e := CheckNightConsumption("home", night, 1000, history[:MinNightHistory-1]); e != nil {
+		t.Errorf("event with too little history: %+v", e)
+	}
+}
+
+func TestCheckBaseloadJump(t *testing.T) {
+	day := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		recent, previous float64
+		want             bool
+	}{
+		{160, 100, true},
+		{140, 100, false}, // Minder dan anderhalf keer
+		{60, 30, false},   // Minder dan 50 W erbij
+		{100, 0, false},
+	}
+	for _, tt := range tests {
+		if e := CheckBaseloadJump("home", day, tt.recent, tt.previous); (e != nil) != tt.want {
+			t.Errorf("%v after %v: got %+v, want event %v", tt.recent, tt.previous, e, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+// Profile returns the expected consumption and production power in W of a home at t. The expected
+// production comes from the PV forecast and is only set for hours the weather forecast calls sunny.
+type Profile func(t time.Time) (consumptionW, productionW float64)
+
+// Drempels voor de live controles
+const (
+	HighPowerFactor     = 3    // Vermogen ten opzichte van het profiel
+	MinHighPowerExcessW = 2000 // Minimale overschrijding in W
+	HighPowerDuration   = 30 * time.Minute
+
+	SunnyClearSkyShare = 0.6 // Voorspelde productie ten opzichte van een heldere hemel: vanaf hier is een uur zonnig
+	OutageMinSurplusW  = 500 // Verwachte productie min verwacht verbruik waarboven geen teruglevering opvalt
+	OutageDuration     = 2 * time.Hour
+
+	LiveRepeatInterval = 6 * time.Hour // Dezelfde soort afwijking wordt niet vaker gemeld
+)
+
+// LiveDetector watches the real-time measurements of one home for sustained deviations from its profile
+type LiveDetector struct {
+	HomeId  string
+	Profile Profile
+
+	highSince   time.Time
+	outageSince time.Time
+	reported    map[string]time.Time
+}
+
+// NewLiveDetector creates a detector for a home with its learned profile
+func NewLiveDetector(homeId string, profile Profile) *LiveDetector {
+	return &LiveDetector{
+		HomeId:   homeId,
+		Profile:  profile,
+		reported: make(map[string]time.Time),
+	}
+}
+
+// Observe processes a measurement and returns an event once a deviation has lasted long enough
+func (d *LiveDetector) Observe(m tibber.Measurement) *Event {
+	if d.Profile == nil {
+		return nil
+	}
+	t := m.Timestamp
+	expectedConsumption, expectedProduction := d.Profile(t)
+
+	// Aanhoudend hoog verbruik, bijvoorbeeld een kachel die aan is blijven staan
+	limit := math.Max(expectedConsumption*HighPowerFactor, expectedConsumption+MinHighPowerExcessW)
+	if m.Power > limit {
+		if d.highSince.IsZero() {
+			d.highSince = t
+		}
+		if t.Sub(d.highSince) >= HighPowerDuration {
+			event := d.event(KindHighPower, SeverityWarning, d.highSince, t, m.Power, expectedConsumption,
+				fmt.Sprintf("Verbruik is al %s hoger dan %.0f W (nu %.0f W, normaal %.0f W)",
+					t.Sub(d.highSince).Round(time.Minute), limit, m.Power, expectedConsumption))
+			if event != nil {
+				return event
+			}
+		}
+	} else {
+		d.highSince = time.Time{}
+	}
+
+	// PowerProduction is de teruglevering, niet de bruto productie: zolang het verbruik de opwek
+	// overtreft is die nul. Alleen als er bij het gewone verbruik een flink overschot verwacht wordt,
+	// wijst aanhoudend geen teruglevering op een omvormer die uit staat.
+	surplus := expectedProduction - expectedConsumption
+	if surplus >= OutageMinSurplusW && m.PowerProduction <= 0 {
+		if d.outageSince.IsZero() {
+			d.outageSince = t
+		}
+		if t.Sub(d.outageSince) >= OutageDuration {
+			return d.event(KindProductionOutage, SeverityCritical, d.outageSince, t, m.PowerProduction, expectedProduction,
+				fmt.Sprintf("Al %s geen teruglevering terwijl de zon schijnt en %.0f W productie verwacht wordt; controleer de omvormer",
+					t.Sub(d.outageSince).Round(time.Minute), expectedProduction))
+		}
+	} else {
+		d.outageSince = time.Time{}
+	}
+
+	return nil
+}
+
+func (d *LiveDetector) event(kind, severity string, since, now time.Time, value, expected float64, message string) *Event {
+	if last, ok := d.reported[kind]; ok && now.Sub(last) < LiveRepeatInterval {
+		return nil
+	}
+	d.reported[kind] = now
+
+	return &Event{
+		HomeId:      d.HomeId,
+		Kind:        kind,
+		Severity:    severity,
+		PeriodStart: since.Truncate(time.Minute),
+		DetectedAt:  now,
+		Value:       math.Round(value),
+		Expected:    math.Round(expected),
+		Message:     message,
+	}
+}
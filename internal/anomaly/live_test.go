@@ -0,0 +1,127 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+var liveStart = time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+// constant returns a profile with the same expectation at every moment
+func constant(consumptionW, productionW float64) Profile {
+	return func(time.Time) (float64, float64) {
+		return consumptionW, productionW
+	}
+}
+
+// feed passes a measurement per minute from liveStart and returns the events with the minute they came
+func feed(d *LiveDetector, minutes int, measure func(minute int) (power, production float64)) map[int]*Event {
+	events := make(map[int]*Event)
+	for i := 0; i <= minutes; i++ {
+		power, production := measure(i)
+		m := tibber.Measurement{Timestamp: liveStart.Add(time.Duration(i) * time.Minute), Power: power, PowerProduction: production}
+		if e := d.Observe(m); e != nil {
+			events[i] = e
+		}
+	}
+	return events
+}
+
+func TestLiveHighPower(t *testing.T) {
+	d := NewLiveDetector("home", constant(400, 0))
+	// De grens is het maximum van 3 × 400 en 400 + 2000 W
+	events := feed(d, 40, func(int) (float64, float64) { return 2500, 0 })
+	e, ok := events[30]
+	if len(events) != 1 || !ok {
+		t.Fatalf("events at minutes %v, want one at 30", keys(events))
+	}
+	if e.Kind != KindHighPower || e.Severity != SeverityWarning || !e.PeriodStart.Equal(liveStart) || e.Value != 2500 || e.Expected != 400 {
+		t.Errorf("event %+v", e)
+	}
+
+	// Net onder de grens, of een onderbreking, begint opnieuw
+	d = NewLiveDetector("home", constant(400, 0))
+	events = feed(d, 59, func(minute int) (float64, float64) {
+		if minute == 20 {
+			return 2400, 0
+		}
+		return 2500, 0
+	})
+	if _, ok := events[51]; len(events) != 1 || !ok {
+		t.Errorf("events at minutes %v, want one at 51", keys(events))
+	}
+}
+
+func TestLiveProductionOutage(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    Profile
+		production float64
+		want       bool
+	}{
+		// Zonnig met 2500 W verwacht en 400 W verbruik: een werkende installatie levert terug
+		{"no export with surplus", constant(400, 2500), 0, true},
+		{"export", constant(400, 2500), 1500, false},
+		// Het verbruik overtreft de verwachte productie: geen teruglevering is normaal
+		{"consumption above production", constant(2500, 2800), 0, false},
+		// Geen zonnig uur volgens het weerbericht
+		{"not sunny", constant(400, 0), 0, false},
+	}
+	for _, tt := range tests {
+		d := NewLiveDetector("home", tt.profile)
+		events := feed(d, 150, func(int) (float64, float64) { return 400, tt.production })
+		e, ok := events[120]
+		if !tt.want {
+			if len(events) != 0 {
+				t.Errorf("%s: events at minutes %v", tt.name, keys(events))
+			}
+			continue
+		}
+		if len(events) != 1 || !ok {
+			t.Errorf("%s: events at minutes %v, want one at 120", tt.name, keys(events))
+			continue
+		}
+		if e.Kind != KindProductionOutage || e.Severity != SeverityCritical || e.Expected != 2500 {
+			t.Errorf("%s: event %+v", tt.name, e)
+		}
+	}
+
+	// Teruglevering halverwege begint de telling opnieuw
+	d := NewLiveDetector("home", constant(400, 2500))
+	events := feed(d, 200, func(minute int) (float64, float64) {
+		if minute == 60 {
+			return 0, 800
+		}
+		return 400, 0
+	})
+	if _, ok := events[181]; len(events) != 1 || !ok {
+		t.Errorf("events at minutes %v, want one at 181", keys(events))
+	}
+}
+
+func TestLiveRepeatInterval(t *testing.T) {
+	d := NewLiveDetector("home", constant(400, 0))
+	// Zeven uur aanhoudend hoog verbruik: na 30 minuten en na zes uur opnieuw
+	events := feed(d, 7*60, func(int) (float64, float64) { return 3000, 0 })
+	if _, ok := events[30]; !ok || len(events) != 2 {
+		t.Fatalf("events at minutes %v, want 30 and 390", keys(events))
+	}
+	if _, ok := events[30+6*60]; !ok {
+		t.Errorf("events at minutes %v, want 30 and 390", keys(events))
+	}
+
+	// Zonder profiel wordt niets beoordeeld
+	if e := NewLiveDetector("home", nil).Observe(tibber.Measurement{Timestamp: liveStart, Power: 1e6}); e != nil {
+		t.Errorf("event without profile: %+v", e)
+	}
+}
+
+func keys(events map[int]*Event) []int {
+	var minutes []int
+	for m := range events {
+		minutes = append(minutes, m)
+	}
+	return minutes
+}
@@ -0,0 +1,114 @@
+package carbon
+
+import (
+	"math"
+	"time"
+)
+
+// Usage is the metered grid consumption and production of a home in an interval
+type Usage struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Consumption float64   `json:"consumption"` // kWh
+	Production  float64   `json:"production"`  // kWh
+}
+
+// Footprint is the carbon account of a home or of the community over a period.
+// Emissions are those of the grid electricity consumed; avoided emissions are those of the
+// grid electricity the production replaced, at the intensity of the same hour.
+type Footprint struct {
+	Consumption      float64 `json:"consumption"` // kWh
+	Production       float64 `json:"production"`  // kWh
+	EmissionsKg      float64 `json:"emissionsKg"`
+	AvoidedKg        float64 `json:"avoidedKg"`
+	NetKg            float64 `json:"netKg"`
+	AverageIntensity float64 `json:"averageIntensity"` // gCO2/kWh, gewogen naar verbruik
+	Coverage         float64 `json:"coverage"`         // Deel van het verbruik met bekende intensiteit
+	Homes            int     `json:"homes"`
+
+	covered float64 // kWh verbruik met bekende intensiteit
+}
+
+// Account computes the footprint of usage intervals in a zone. Intervals longer than an hour,
+// such as days, use the average intensity of the hours in the interval.
+func Account(usage []Usage, zone string, table *Table) Footprint {
+	f := Footprint{Homes: 1}
+	var grams float64
+
+	for _, u := range usage {
+		f.Consumption += u.Consumption
+		f.Production += u.Production
+
+		intensity, ok := intervalIntensity(table, zone, u.Start, u.End)
+		if !ok {
+			continue
+		}
+		grams += u.Consumption * intensity
+		f.covered += u.Consumption
+		f.AvoidedKg += u.Production * intensity / 1000
+	}
+
+	f.EmissionsKg = grams / 1000
+	if f.covered > 0 {
+		f.AverageIntensity = grams / f.covered
+	}
+	f.round()
+	return f
+}
+
+// Add combines footprints, for example of all homes in the community
+func (f Footprint) Add(other Footprint) Footprint {
+	sum := Footprint{
+		Consumption: f.Consumption + other.Consumption,
+		Production:  f.Production + other.Production,
+		EmissionsKg: f.EmissionsKg + other.EmissionsKg,
+		AvoidedKg:   f.AvoidedKg + other.AvoidedKg,
+		Homes:       f.Homes + other.Homes,
+		covered:     f.covered + other.covered,
+	}
+	if sum.covered > 0 {
+		sum.AverageIntensity = sum.EmissionsKg * 1000 / sum.covered
+	}
+	sum.round()
+	return sum
+}
+
+func (f *Footprint) round() {
+	f.NetKg = f.EmissionsKg - f.AvoidedKg
+	if f.Consumption > 0 {
+		f.Coverage = math.Round(f.covered/f.Consumption*1000) / 1000
+	}
+	f.Consumption = round2(f.Consumption)
+	f.Production = round2(f.Production)
+	f.EmissionsKg = round2(f.EmissionsKg)
+	f.AvoidedKg = round2(f.AvoidedKg)
+	f.NetKg = round2(f.NetKg)
+	f.AverageIntensity = math.Round(f.AverageIntensity)
+}
+
+// intervalIntensity is the average intensity of the hours in [start, end)
+func intervalIntensity(table *Table, zone string, start, end time.Time) (float64, bool) {
+	if table == nil {
+		return 0, false
+	}
+	if !end.After(start) {
+		end = start.Add(time.Hour)
+	}
+
+	var sum float64
+	n := 0
+	for t := start.Truncate(time.Hour); t.Before(end); t = t.Add(time.Hour) {
+		if v, ok := table.Lookup(zone, t); ok {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
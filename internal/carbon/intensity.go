@@ -0,0 +1,204 @@
+package carbon
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultZone is used for homes without a price area and for files without a zone column
+const DefaultZone = "NL"
+
+// Intensity is the carbon intensity of the grid mix in one hour of a bidding zone
+type Intensity struct {
+	Zone        string    `json:"zone"`
+	Start       time.Time `json:"start"`
+	GramsPerKWh float64   `json:"gramsPerKWh"` // gCO2-eq per kWh
+}
+
+// LoadFile reads hourly carbon intensities from a CSV or JSON file, chosen by the extension
+func LoadFile(path string) ([]Intensity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening carbon intensity file: %w", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParseJSON(f)
+	}
+	return ParseCSV(f)
+}
+
+// ParseCSV parses a CSV file with a time column (RFC3339), an intensity column in gCO2/kWh and
+// optionally a zone column. Column names are matched loosely so exports such as those of
+// Electricity Maps ("Datetime (UTC)", "Zone Id", "Carbon Intensity gCO₂eq/kWh (direct)") work as well.
+func ParseCSV(r io.Reader) ([]Intensity, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading carbon intensity header: %w", err)
+	}
+	timeCol, valueCol, zoneCol := -1, -1, -1
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case zoneCol < 0 && strings.Contains(name, "zone"):
+			zoneCol = i
+		case timeCol < 0 && (strings.Contains(name, "time") || name == "start"):
+			timeCol = i
+		case valueCol < 0 && strings.Contains(name, "intensity"):
+			valueCol = i
+		}
+	}
+	if timeCol < 0 || valueCol < 0 {
+		return nil, fmt.Errorf("carbon intensity file needs a time and an intensity column")
+	}
+
+	var intensities []Intensity
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading carbon intensity line %d: %w", line, err)
+		}
+
+		start, err := parseTime(record[timeCol])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[valueCol]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid intensity: %w", line, err)
+		}
+		zone := DefaultZone
+		if zoneCol >= 0 && strings.TrimSpace(record[zoneCol]) != "" {
+			zone = strings.TrimSpace(record[zoneCol])
+		}
+		intensities = append(intensities, Intensity{Zone: zone, Start: start, GramsPerKWh: value})
+	}
+
+	return intensities, nil
+}
+
+// jsonPoint is one hour in a JSON file
+type jsonPoint struct {
+	Zone            string   `json:"zone"`
+	Datetime        string   `json:"datetime"`
+	Start           string   `json:"start"`
+	CarbonIntensity *float64 `json:"carbonIntensity"`
+	GramsPerKWh     *float64 `json:"gramsPerKWh"`
+}
+
+// ParseJSON parses either a list of hours ({"zone", "datetime", "carbonIntensity"}) or a
+// history document per zone ({"zone": "NL", "history": [...]}) as returned by Electricity Maps
+func ParseJSON(r io.Reader) ([]Intensity, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading carbon intensity file: %w", err)
+	}
+
+	var points []jsonPoint
+	if err := json.Unmarshal(data, &points); err != nil {
+		var doc struct {
+			Zone    string      `json:"zone"`
+			History []jsonPoint `json:"history"`
+			Data    []jsonPoint `json:"data"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error parsing carbon intensity file: %w", err)
+		}
+		points = append(doc.History, doc.Data...)
+		for i := range points {
+			if points[i].Zone == "" {
+				points[i].Zone = doc.Zone
+			}
+		}
+	}
+
+	intensities := make([]Intensity, 0, len(points))
+	for i, p := range points {
+		value := p.CarbonIntensity
+		if value == nil {
+			value = p.GramsPerKWh
+		}
+		if value == nil {
+			continue // Uren zonder waarde worden overgeslagen
+		}
+		ts := p.Datetime
+		if ts == "" {
+			ts = p.Start
+		}
+		start, err := parseTime(ts)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		zone := p.Zone
+		if zone == "" {
+			zone = DefaultZone
+		}
+		intensities = append(intensities, Intensity{Zone: zone, Start: start, GramsPerKWh: *value})
+	}
+
+	return intensities, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Truncate(time.Hour), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// Table looks up the intensity of an hour in a zone
+type Table struct {
+	hours map[string]map[int64]float64
+}
+
+// NewTable indexes intensities by zone and hour
+func NewTable(intensities []Intensity) *Table {
+	t := &Table{hours: make(map[string]map[int64]float64)}
+	for _, i := range intensities {
+		zone, ok := t.hours[i.Zone]
+		if !ok {
+			zone = make(map[int64]float64)
+			t.hours[i.Zone] = zone
+		}
+		zone[i.Start.UTC().Truncate(time.Hour).Unix()] = i.GramsPerKWh
+	}
+	return t
+}
+
+// Lookup returns the intensity in gCO2/kWh of the hour t falls in
+func (t *Table) Lookup(zone string, at time.Time) (float64, bool) {
+	hours, ok := t.hours[zone]
+	if !ok {
+		return 0, false
+	}
+	v, ok := hours[at.UTC().Truncate(time.Hour).Unix()]
+	return v, ok
+}
+
+// Zones returns the zones in the table
+func (t *Table) Zones() []string {
+	zones := make([]string, 0, len(t.hours))
+	for zone := range t.hours {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"sync"
+)
+
+// Pool keeps one API client per Tibber account, each with its own rate limiter. Members of an
+// energy community each have their own account, and Tibber limits the requests per token.
+type Pool struct {
+	APIURL            string
+	RequestsPerMinute int
+	Burst             int
+
+	mu      sync.Mutex
+	clients map[string]*TibberClient
+}
+
+// NewPool creates a pool for the given API endpoint and limit per account
+func NewPool(apiURL string, requestsPerMinute, burst int) *Pool {
+	return &Pool{
+		APIURL:            apiURL,
+		RequestsPerMinute: requestsPerMinute,
+		Burst:             burst,
+		clients:           make(map[string]*TibberClient),
+	}
+}
+
+// Client returns the client of an account. A new client is made on first use and when the token
+// of the account changed; otherwise the existing client and its rate limiter are reused.
+func (p *Pool) Client(account, token string) *TibberClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[account]; ok && c.APIToken == token {
+		return c
+	}
+	c := NewClient(token)
+	if p.APIURL != "" {
+		c.APIURL = p.APIURL
+	}
+	c.Limiter = NewRateLimiter(p.RequestsPerMinute, p.Burst)
+	p.clients[account] = c
+	return c
+}
+
+// Remove forgets the client of an account, for example after its token was revoked
+func (p *Pool) Remove(account string) {
+	p.mu.Lock()
+	delete(p.clients, account)
+	p.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that spreads the requests of one Tibber account, so that a community
+// with many members does not get a token blocked by sending all requests at once
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Tijd per nieuw token
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimiter allows perMinute requests per minute on average, with bursts of up to burst requests
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute < 1 {
+		perMinute = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    burst,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(float64(l.burst), l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) * float64(l.interval))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
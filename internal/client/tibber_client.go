@@ -3,24 +3,33 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 )
 
+// ErrUnauthorized is returned when Tibber rejects the token, for example because the member revoked it
+var ErrUnauthorized = errors.New("Tibber rejected the API token")
+
 // TibberClient provides a simple client for the Tibber GraphQL API
 type TibberClient struct {
 	APIToken  string
 	APIURL    string
 	UserAgent string
+	// Limiter spreads the requests of this token, nil means no limit
+	Limiter *RateLimiter
 }
 
 // GraphQLResponse represents a response from the Tibber GraphQL API
 type GraphQLResponse struct {
 	Data   map[string]interface{} `json:"data"`
 	Errors []struct {
-		Message string `json:"message"`
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
 	} `json:"errors,omitempty"`
 }
 
@@ -46,6 +55,12 @@ func (c *TibberClient) QueryAPI(ctx context.Context, query string, variables map
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create and execute HTTP request
 	req, err := http.NewRequestWithContext(ctx, "POST", c.APIURL, strings.NewReader(string(jsonBody)))
 	if err != nil {
@@ -68,6 +83,9 @@ func (c *TibberClient) QueryAPI(ctx context.Context, query string, variables map
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: API returned status %d", ErrUnauthorized, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
@@ -79,6 +97,9 @@ func (c *TibberClient) QueryAPI(ctx context.Context, query string, variables map
 	}
 
 	if len(graphqlResp.Errors) > 0 {
+		if graphqlResp.Errors[0].Extensions.Code == "UNAUTHENTICATED" {
+			return nil, fmt.Errorf("%w: %s", ErrUnauthorized, graphqlResp.Errors[0].Message)
+		}
 		return nil, fmt.Errorf("GraphQL error: %s", graphqlResp.Errors[0].Message)
 	}
 
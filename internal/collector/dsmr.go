@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ws/internal/anomaly"
+	"ws/internal/config"
+	"ws/internal/dsmr"
+	"ws/internal/gas"
+	"ws/internal/model"
+	"ws/internal/service_db"
+)
+
+// dsmrStoreInterval limits how often P1 telegrams are stored; DSMR 5 meters send one every second
+const dsmrStoreInterval = 10 * time.Second
+
+// startDSMR reads the P1 port configured in dsmr.source for the home in dsmr.home_id, for members
+// without a Tibber Pulse. It does nothing when no source is configured.
+func startDSMR(ctx context.Context, cfg config.DSMR, homeService *service_db.HomeService, realTimeService *service_db.RealTimeService, gasService *service_db.GasService, anomalyService *service_db.AnomalyService) {
+	if cfg.Source == "" {
+		return
+	}
+	homeID := cfg.HomeID
+	if homeID == "" {
+		log.Printf("dsmr.source is set but dsmr.home_id is not, ignoring P1 reader")
+		return
+	}
+
+	source, err := dsmr.ParseSource(cfg.Source, cfg.Baud, cfg.ReplaySpeed)
+	if err != nil {
+		log.Printf("Error in dsmr.source: %v", err)
+		return
+	}
+
+	go func() {
+		log.Printf("Reading P1 telegrams for home %s from %s", homeID, source)
+		if err := collectDSMR(ctx, homeID, source, homeService, realTimeService, gasService, anomalyService); err != nil {
+			log.Printf("P1 reader for home %s stopped: %v", homeID, err)
+		}
+	}()
+}
+
+// collectDSMR stores the telegrams of a P1 source as real-time measurements of a home and
+// passes them through the live anomaly detector, like the measurements of a Tibber Pulse.
+// Gas meter readings are stored and rolled up into hourly and daily gas use.
+func collectDSMR(ctx context.Context, homeID string, source dsmr.Source, homeService *service_db.HomeService, realTimeService *service_db.RealTimeService, gasService *service_db.GasService, anomalyService *service_db.AnomalyService) error {
+	home := model.Home{Id: homeID}
+	if homes, err := homeService.GetHomes(ctx); err != nil {
+		log.Printf("Error fetching homes for P1 reader: %v", err)
+	} else {
+		for _, h := range homes {
+			if h.Id == homeID {
+				home = h
+			}
+		}
+	}
+
+	meter := dsmr.NewMeter(nil)
+	var detector *anomaly.LiveDetector
+	if profile, err := anomalyService.LiveProfile(ctx, home); err != nil {
+		log.Printf("No live anomaly detection for home %s: %v", homeID, err)
+	} else {
+		detector = anomaly.NewLiveDetector(homeID, profile)
+	}
+
+	var lastStored, lastGas time.Time
+	return dsmr.Run(ctx, source, func(telegram *dsmr.Telegram) {
+		// De gasmeter levert eens per 5 minuten (DSMR 5) of per uur een nieuwe stand
+		if telegram.Gas != nil && !telegram.Gas.Timestamp.Equal(lastGas) {
+			lastGas = telegram.Gas.Timestamp
+			reading := gas.Reading{Timestamp: telegram.Gas.Timestamp, M3: telegram.Gas.M3}
+			if stored, err := gasService.StoreReading(ctx, homeID, reading, service_db.GasSourceDSMR); err != nil {
+				log.Printf("Error storing gas reading for home %s: %v", homeID, err)
+			} else if stored {
+				if err := gasService.Rollup(ctx, home, reading.Timestamp.Add(-time.Hour), reading.Timestamp.Add(time.Second)); err != nil {
+					log.Printf("Error computing gas use for home %s: %v", homeID, err)
+				}
+			}
+		}
+
+		// Elk telegram telt mee voor de dagtotalen, maar niet elk telegram wordt opgeslagen
+		measurement := meter.Measurement(telegram)
+		if measurement.Timestamp.Sub(lastStored) < dsmrStoreInterval {
+			return
+		}
+		lastStored = measurement.Timestamp
+
+		if err := realTimeService.StoreMeasurement(ctx, homeID, measurement); err != nil {
+			log.Printf("Error storing P1 measurement for home %s: %v", homeID, err)
+		}
+		if detector != nil {
+			if event := detector.Observe(measurement); event != nil {
+				if _, err := anomalyService.Record(ctx, *event); err != nil {
+					log.Printf("Error recording anomaly for home %s: %v", homeID, err)
+				}
+			}
+		}
+	})
+}
@@ -2,142 +2,351 @@ package collector
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
+	"ws/internal/carbon"
 	"ws/internal/client"
-	"ws/internal/db"
+	"ws/internal/config"
+	"ws/internal/forecast"
+	"ws/internal/localtime"
 	"ws/internal/model"
+	"ws/internal/notify"
+	"ws/internal/pricesource"
 	"ws/internal/service_db"
+)
+
+// PriceForecastHours is how far ahead prices are forecast
+const PriceForecastHours = 72
 
-	"github.com/joho/godotenv"
+// Historische data die de nachtelijke inhaalslag ophaalt: zonder opgeslagen data het eerste venster,
+// anders alles sinds het laatste interval (met een marge voor correcties van Tibber), tot een maximum
+const (
+	HistoryDays        = 30
+	HistoryHours       = 24 * 30
+	HistoryMarginDays  = 2
+	HistoryMaxDays     = 365
+	HistoryMaxHours    = 24 * 92
+	historyMarginHours = 24 * HistoryMarginDays
 )
 
-// LoadPrices loads current and future price information
-func LoadPrices(ctx context.Context) {
-	log.Printf("Loading price data...")
+// AccountBurst is how many Tibber requests an account may send at once before the rate limit applies
+const AccountBurst = 5
 
-	// Laad .env bestand
-	if err := godotenv.Load("./.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
+// Services are the services shared by the real-time collection and the scheduled jobs
+type Services struct {
+	DB           *sql.DB
+	Homes        *service_db.HomeService
+	Prices       *service_db.PriceService
+	PriceSources *service_db.PriceSourceService
+	Forecast     *service_db.ForecastService
+	Consumption  *service_db.ConsumptionService
+	Production   *service_db.ProductionService
+	RealTime     *service_db.RealTimeService
+	Anomaly      *service_db.AnomalyService
+	PowerQuality *service_db.PowerQualityService
+	Gas          *service_db.GasService
+	Scheduler    *service_db.SchedulerService
+	Accounts     *service_db.AccountService
+	OpenData     *service_db.OpenDataService
+	Statements   *service_db.StatementService
+	Webhooks     *service_db.WebhookService
+	// Notifier delivers anomaly notifications; it follows the [notify] settings on reload
+	Notifier *notify.Switchable
 
-	// Haal database URL op
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
+	current atomic.Pointer[config.Config]
+}
+
+// NewServices creates the collector services on a database connection with the Tibber API of the
+// configuration. Homes of member accounts use the token of their account, the others tibber.token.
+func NewServices(dbConn *sql.DB, cfg *config.Config) (*Services, error) {
+	apiClient := client.NewClient(cfg.Tibber.Token)
+	apiClient.APIURL = cfg.Tibber.APIURL
+	apiClient.Limiter = client.NewRateLimiter(cfg.Tibber.RequestsPerMinute, AccountBurst)
+	notifier := notify.NewSwitchable(notify.FromConfig(cfg.Notify))
 
-	// Parse database URL en maak verbinding
-	dbConfig, err := db.ParseURL(dbURL)
+	// Tokens van leden en sleutels van webhooks staan versleuteld in de database
+	box, err := cfg.Tibber.Box()
 	if err != nil {
-		log.Fatalf("Error parsing database URL: %v", err)
+		return nil, err
+	}
+	accounts := &service_db.AccountService{
+		DB:       dbConn,
+		Box:      box,
+		Pool:     client.NewPool(cfg.Tibber.APIURL, cfg.Tibber.RequestsPerMinute, AccountBurst),
+		Default:  apiClient,
+		Notifier: notifier,
+	}
+	webhooks := &service_db.WebhookService{DB: dbConn, Box: box}
+	s := &Services{
+		DB:           dbConn,
+		Homes:        &service_db.HomeService{Client: apiClient, DB: dbConn, Accounts: accounts},
+		Prices:       &service_db.PriceService{Client: apiClient, DB: dbConn, Accounts: accounts},
+		PriceSources: &service_db.PriceSourceService{DB: dbConn},
+		Forecast:     newForecastService(dbConn, cfg.Files.PriceWeather),
+		Consumption:  &service_db.ConsumptionService{Client: apiClient, DB: dbConn, Accounts: accounts},
+		Production:   &service_db.ProductionService{Client: apiClient, DB: dbConn, Accounts: accounts},
+		RealTime:     &service_db.RealTimeService{DB: dbConn},
+		Anomaly:      newAnomalyService(dbConn, cfg.Files, notifier, webhooks),
+		PowerQuality: &service_db.PowerQualityService{DB: dbConn},
+		Gas:          &service_db.GasService{DB: dbConn},
+		Scheduler:    &service_db.SchedulerService{DB: dbConn},
+		Accounts:     accounts,
+		OpenData:     &service_db.OpenDataService{DB: dbConn},
+		Statements:   &service_db.StatementService{DB: dbConn},
+		Webhooks:     webhooks,
+		Notifier:     notifier,
 	}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+// Config returns the current configuration
+func (s *Services) Config() *config.Config {
+	return s.current.Load()
+}
+
+// SetConfig applies a reloaded configuration to the services. Settings that are read when a job
+// runs, such as the retention and the ENTSO-E token, follow automatically; the notifier is rebuilt.
+func (s *Services) SetConfig(cfg *config.Config) {
+	s.Notifier.Set(notify.FromConfig(cfg.Notify))
+	s.current.Store(cfg)
+}
 
-	dbConn, err := db.NewConnection(dbConfig)
+// LoadPrices loads today's and tomorrow's prices of every home and renews the price forecasts
+func LoadPrices(ctx context.Context, s *Services) error {
+	homes, err := s.Homes.GetHomesWithProductionCapability(ctx)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		return fmt.Errorf("error fetching homes: %w", err)
 	}
-	defer dbConn.Close()
+	return LoadPricesFor(ctx, s, homes)
+}
 
-	// Haal Tibber API token op
-	token := os.Getenv("TIBBER_API_TOKEN")
-	if token == "" {
-		log.Fatal("TIBBER_API_TOKEN environment variable is not set")
+// LoadPricesFor loads today's and tomorrow's prices of the given homes and renews their price forecasts
+func LoadPricesFor(ctx context.Context, s *Services, homes []model.Home) error {
+	var errs []error
+	for _, home := range homes {
+		if err := loadHomePrices(ctx, home, s.Prices, s.PriceSources, s.Config().Entsoe); err != nil {
+			errs = append(errs, fmt.Errorf("home %s: %w", home.Id, err))
+		}
+		forecastHomePrices(ctx, home, s.Forecast)
+		emitTomorrowPrices(ctx, s, home)
 	}
 
-	// Maak clients en services
-	apiClient := client.NewClient(token)
-	homeService := &service_db.HomeService{
-		Client: apiClient,
-		DB:     dbConn,
+	log.Printf("Loaded prices for %d homes", len(homes))
+	return errors.Join(errs...)
+}
+
+// LoadTomorrowPrices loads the prices of tomorrow for the homes that do not have them yet. The day-ahead
+// prices are published around 13:00, so this is polled in the afternoon until every home has them.
+func LoadTomorrowPrices(ctx context.Context, s *Services) error {
+	homes, err := s.Homes.GetHomesWithProductionCapability(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching homes: %w", err)
 	}
-	priceService := &service_db.PriceService{
-		Client: apiClient,
-		DB:     dbConn,
+
+	var errs []error
+	missing := 0
+	for _, home := range homes {
+		loc := localtime.Location(home.TimeZone)
+		from := localtime.NextDay(localtime.StartOfDay(time.Now(), loc), loc)
+		to := localtime.NextDay(from, loc)
+
+		if ok, err := s.Prices.HasPrices(ctx, home.Id, from, to); err != nil {
+			errs = append(errs, fmt.Errorf("home %s: %w", home.Id, err))
+			continue
+		} else if ok {
+			continue
+		}
+
+		if err := loadHomePrices(ctx, home, s.Prices, s.PriceSources, s.Config().Entsoe); err != nil {
+			errs = append(errs, fmt.Errorf("home %s: %w", home.Id, err))
+			continue
+		}
+		ok, err := s.Prices.HasPrices(ctx, home.Id, from, to)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("home %s: %w", home.Id, err))
+			continue
+		}
+		if !ok {
+			missing++
+			continue
+		}
+		log.Printf("Tomorrow's prices published for home %s", home.Id)
+		forecastHomePrices(ctx, home, s.Forecast)
+		emitTomorrowPrices(ctx, s, home)
 	}
 
-	// Get homes with production capability
-	homes, err := homeService.GetHomesWithProductionCapability(ctx)
+	if missing > 0 {
+		log.Printf("Tomorrow's prices not yet published for %d homes", missing)
+	}
+	return errors.Join(errs...)
+}
+
+// LoadConsumptionAndProduction catches up the daily and hourly consumption and production of every
+// home from Tibber and stores them. It fetches everything since the newest stored interval, so a
+// collector that was down for a while fills the gap.
+func LoadConsumptionAndProduction(ctx context.Context, s *Services) error {
+	homes, err := s.Homes.GetHomesWithProductionCapability(ctx)
 	if err != nil {
-		log.Printf("Error fetching homes: %v", err)
-		return
+		return fmt.Errorf("error fetching homes: %w", err)
 	}
 
-	// Load prices for each home
+	var errs []error
 	for _, home := range homes {
-		if _, err := priceService.GetPrices(ctx, home.Id); err != nil {
-			log.Printf("Error loading prices for home %s: %v", home.Id, err)
+		// Uurdata is nodig om kosten per uur opnieuw te berekenen (dynamische tarieven)
+		for _, resolution := range []string{"DAILY", "HOURLY"} {
+			latest, err := s.Consumption.LatestFrom(ctx, home.Id, resolution)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			last := historyEntries(latest, resolution, time.Now())
+			if _, err := s.Consumption.GetConsumption(ctx, home.Id, resolution, last); err != nil {
+				errs = append(errs, fmt.Errorf("consumption of home %s (%s): %w", home.Id, resolution, err))
+			}
+
+			if latest, err = s.Production.LatestFrom(ctx, home.Id, resolution); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			last = historyEntries(latest, resolution, time.Now())
+			if _, err := s.Production.GetProduction(ctx, home.Id, resolution, last); err != nil {
+				errs = append(errs, fmt.Errorf("production of home %s (%s): %w", home.Id, resolution, err))
+			}
 		}
+		emitSettlement(ctx, s, home, time.Now().AddDate(0, 0, -1))
 	}
 
-	log.Printf("Finished loading price data")
+	log.Printf("Loaded consumption and production for %d homes", len(homes))
+	return errors.Join(errs...)
 }
 
-// LoadConsumptionAndProduction loads historical consumption and production data
-func LoadConsumptionAndProduction(ctx context.Context) {
-	log.Printf("Loading consumption and production data...")
+// Backfill fetches and stores the consumption and production of the given homes in [from, to),
+// for example to fill a gap after an outage that is longer than the nightly catch-up reaches
+func Backfill(ctx context.Context, s *Services, homes []model.Home, resolutions []string, from, to time.Time) error {
+	var errs []error
+	for _, home := range homes {
+		for _, resolution := range resolutions {
+			consumption, err := s.Consumption.Backfill(ctx, home.Id, resolution, from, to)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("consumption of home %s (%s): %w", home.Id, resolution, err))
+			} else {
+				log.Printf("Stored %d %s consumption intervals for home %s", len(consumption.Consumption), resolution, home.Id)
+			}
 
-	// Laad .env bestand
-	if err := godotenv.Load("./.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+			production, err := s.Production.Backfill(ctx, home.Id, resolution, from, to)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("production of home %s (%s): %w", home.Id, resolution, err))
+			} else {
+				log.Printf("Stored %d %s production intervals for home %s", len(production.Production), resolution, home.Id)
+			}
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Haal database URL op
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
+// historyEntries returns how many of the last intervals to fetch when latest is the newest stored one
+func historyEntries(latest time.Time, resolution string, now time.Time) int {
+	if resolution == "HOURLY" {
+		if latest.IsZero() {
+			return HistoryHours
+		}
+		return min(max(int(now.Sub(latest).Hours())+historyMarginHours, historyMarginHours), HistoryMaxHours)
+	}
+	if latest.IsZero() {
+		return HistoryDays
 	}
+	return min(max(int(now.Sub(latest).Hours()/24)+HistoryMarginDays, HistoryMarginDays), HistoryMaxDays)
+}
 
-	// Parse database URL en maak verbinding
-	dbConfig, err := db.ParseURL(dbURL)
+// loadHomePrices loads today's and tomorrow's prices for a home from its configured price source.
+// Homes on Tibber use the subscription prices, other homes the ENTSO-E day-ahead prices plus their supplier tariff.
+func loadHomePrices(ctx context.Context, home model.Home, priceService *service_db.PriceService, priceSourceService *service_db.PriceSourceService, entsoe config.Entsoe) error {
+	sourceConfig, err := priceSourceService.GetConfig(ctx, home.Id)
 	if err != nil {
-		log.Fatalf("Error parsing database URL: %v", err)
+		return err
 	}
 
-	dbConn, err := db.NewConnection(dbConfig)
+	sources := pricesource.Sources{
+		Tibber:      priceService,
+		EntsoeToken: entsoe.Token,
+		EntsoeURL:   entsoe.URL,
+		EntsoeFile:  entsoe.File,
+	}
+	source, err := sources.For(*sourceConfig, home)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		return err
 	}
-	defer dbConn.Close()
 
-	// Haal Tibber API token op
-	token := os.Getenv("TIBBER_API_TOKEN")
-	if token == "" {
-		log.Fatal("TIBBER_API_TOKEN environment variable is not set")
+	loc := localtime.Location(home.TimeZone)
+	from := localtime.StartOfDay(time.Now(), loc)
+	to := localtime.NextDay(localtime.NextDay(from, loc), loc)
+
+	prices, err := source.Prices(ctx, home, from, to)
+	if err != nil {
+		return err
+	}
+
+	// Tibber-prijzen zijn door GetPrices al opgeslagen; opnieuw opslaan is dezelfde upsert
+	log.Printf("Loaded %d %s prices for home %s", len(prices), source.Name(), home.Id)
+	return priceSourceService.StorePrices(ctx, home.Id, source.Name(), prices)
+}
+
+// newForecastService creates the price forecaster, with weather features when files.price_weather is set
+func newForecastService(dbConn *sql.DB, path string) *service_db.ForecastService {
+	forecastService := &service_db.ForecastService{DB: dbConn}
+
+	if path != "" {
+		weather, err := forecast.LoadWeather(path)
+		if err != nil {
+			log.Printf("Error loading weather file, forecasting without weather: %v", err)
+		} else {
+			forecastService.Weather = weather
+		}
 	}
 
-	// Maak clients en services
-	apiClient := client.NewClient(token)
-	homeService := &service_db.HomeService{
-		Client: apiClient,
-		DB:     dbConn,
+	return forecastService
+}
+
+// importCarbonIntensity loads the grid carbon intensities from files.carbon_intensity when it is set
+func importCarbonIntensity(ctx context.Context, dbConn *sql.DB, path string) {
+	if path == "" {
+		return
 	}
 
-	// Get homes with production capability
-	homes, err := homeService.GetHomesWithProductionCapability(ctx)
+	intensities, err := carbon.LoadFile(path)
 	if err != nil {
-		log.Printf("Error fetching homes: %v", err)
+		log.Printf("Error loading carbon intensity file: %v", err)
 		return
 	}
 
-	// Load data for each home
-	for _, home := range homes {
-		// Load consumption data
-		variables := map[string]interface{}{
-			"homeId":     home.Id,
-			"resolution": "DAILY",
-			"last":       30,
-		}
-		if _, err := apiClient.QueryAPI(ctx, model.ConsumptionQuery, variables); err != nil {
-			log.Printf("Error loading consumption data for home %s: %v", home.Id, err)
-		}
+	n, err := (&service_db.CarbonService{DB: dbConn}).Import(ctx, intensities, filepath.Base(path))
+	if err != nil {
+		log.Printf("Error importing carbon intensities: %v", err)
+		return
+	}
+	log.Printf("Imported %d hourly carbon intensities from %s", n, path)
+}
 
-		// Load production data
-		if _, err := apiClient.QueryAPI(ctx, model.ProductionQuery, variables); err != nil {
-			log.Printf("Error loading production data for home %s: %v", home.Id, err)
-		}
+// forecastHomePrices scores earlier forecasts against the published prices and
+// forecasts the hours beyond the day-ahead horizon
+func forecastHomePrices(ctx context.Context, home model.Home, forecastService *service_db.ForecastService) {
+	if n, err := forecastService.UpdateErrors(ctx, home.Id); err != nil {
+		log.Printf("Error updating forecast errors for home %s: %v", home.Id, err)
+	} else if n > 0 {
+		log.Printf("Scored %d price forecasts for home %s", n, home.Id)
 	}
 
-	log.Printf("Finished loading consumption and production data")
+	prices, err := forecastService.Generate(ctx, home.Id, PriceForecastHours)
+	if err != nil {
+		log.Printf("Error forecasting prices for home %s: %v", home.Id, err)
+		return
+	}
+	log.Printf("Forecasted %d prices for home %s", len(prices), home.Id)
 }
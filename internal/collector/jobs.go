@@ -0,0 +1,390 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/config"
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/scheduler"
+	"ws/internal/service_db"
+)
+
+// Namen van de geplande taken, ook voor handmatig starten
+const (
+	JobPrices         = "prices"
+	JobTomorrowPrices = "tomorrow-prices"
+	JobHistory        = "history"
+	JobPowerQuality   = "power-quality"
+	JobBaseload       = "baseload"
+	JobRetention      = "retention"
+	JobAccounts       = "accounts"
+	JobOnboarding     = "onboarding"
+	JobOpenData       = "opendata"
+	JobWebhooks       = "webhooks"
+)
+
+// DefaultSchedules are the cron schedules of the jobs, in the default time zone so that they follow
+// daylight saving time. [schedules] in the configuration overrides them per job.
+var DefaultSchedules = map[string]string{
+	JobPrices:         "5 * * * *",
+	JobTomorrowPrices: "*/10 13-16 * * *",
+	JobHistory:        "0 3 * * *",
+	JobPowerQuality:   "10 0 * * *",
+	JobBaseload:       fmt.Sprintf("0 %d * * *", service_db.NightEndHour+1),
+	JobRetention:      "0 3 * * *",
+	JobAccounts:       "20 */6 * * *",
+	JobOnboarding:     "*/15 * * * *",
+	JobOpenData:       "20 * * * *",
+	JobWebhooks:       "* * * * *",
+}
+
+// Schedules returns the schedule of every job: the override from the configuration, else the default
+func Schedules(cfg *config.Config) (map[string]*scheduler.Schedule, error) {
+	for name := range cfg.Schedules {
+		if _, ok := DefaultSchedules[name]; !ok {
+			known := make([]string, 0, len(DefaultSchedules))
+			for job := range DefaultSchedules {
+				known = append(known, job)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("schedules.%s: unknown job, expected one of %s", name, strings.Join(known, ", "))
+		}
+	}
+
+	loc := localtime.Location("")
+	schedules := make(map[string]*scheduler.Schedule, len(DefaultSchedules))
+	for name, expr := range DefaultSchedules {
+		if override, ok := cfg.Schedules[name]; ok {
+			expr = override
+		}
+		schedule, err := scheduler.Parse(expr, loc)
+		if err != nil {
+			return nil, fmt.Errorf("schedules.%s: %w", name, err)
+		}
+		schedules[name] = schedule
+	}
+	return schedules, nil
+}
+
+// Jobs returns the scheduled jobs of the collector with the schedules of the current configuration
+func Jobs(s *Services) ([]scheduler.Job, error) {
+	schedules, err := Schedules(s.Config())
+	if err != nil {
+		return nil, err
+	}
+	return []scheduler.Job{
+		{
+			// Prijzen van vandaag en morgen elk uur verversen, en de prijsverwachting bijwerken
+			Name:       JobPrices,
+			Schedule:   schedules[JobPrices],
+			Jitter:     2 * time.Minute,
+			Timeout:    15 * time.Minute,
+			RunOnStart: true,
+			Run:        func(ctx context.Context) error { return LoadPrices(ctx, s) },
+		},
+		{
+			// De day-ahead prijzen komen rond 13:00; tot ze er zijn elke 10 minuten kijken
+			Name:     JobTomorrowPrices,
+			Schedule: schedules[JobTomorrowPrices],
+			Jitter:   time.Minute,
+			Timeout:  5 * time.Minute,
+			Run:      func(ctx context.Context) error { return LoadTomorrowPrices(ctx, s) },
+		},
+		{
+			// Nachtelijke inhaalslag van verbruik en productie, ook na een storing
+			Name:       JobHistory,
+			Schedule:   schedules[JobHistory],
+			Jitter:     10 * time.Minute,
+			Timeout:    time.Hour,
+			RunOnStart: true,
+			Run:        func(ctx context.Context) error { return LoadConsumptionAndProduction(ctx, s) },
+		},
+		{
+			// Gisteren vastleggen voordat de metingen worden opgeruimd
+			Name:     JobPowerQuality,
+			Schedule: schedules[JobPowerQuality],
+			Timeout:  30 * time.Minute,
+			Run:      func(ctx context.Context) error { return recordPowerQuality(ctx, s) },
+		},
+		{
+			// Sluipverbruik van de afgelopen nacht, daarna afwijkingen van gisteren
+			Name:     JobBaseload,
+			Schedule: schedules[JobBaseload],
+			Timeout:  30 * time.Minute,
+			Run:      func(ctx context.Context) error { return recordBaseloads(ctx, s) },
+		},
+		{
+			// Bewaartermijnen per categorie, zie [retention]
+			Name:     JobRetention,
+			Schedule: schedules[JobRetention],
+			Timeout:  15 * time.Minute,
+			Run:      func(ctx context.Context) error { return applyRetention(ctx, s) },
+		},
+		{
+			// Tokens van leden controleren en nieuwe huizen van hun accounts ophalen
+			Name:     JobAccounts,
+			Schedule: schedules[JobAccounts],
+			Jitter:   5 * time.Minute,
+			Timeout:  15 * time.Minute,
+			Run:      func(ctx context.Context) error { return CheckAccounts(ctx, s) },
+		},
+		{
+			// Historie van nieuw gedeelde huizen ophalen; de website start de taak na een aanmelding
+			Name:     JobOnboarding,
+			Schedule: schedules[JobOnboarding],
+			Timeout:  time.Hour,
+			Run:      func(ctx context.Context) error { return OnboardHomes(ctx, s) },
+		},
+		{
+			// Open data van afgeronde dagen publiceren, alleen met opendata.enabled
+			Name:     JobOpenData,
+			Schedule: schedules[JobOpenData],
+			Timeout:  30 * time.Minute,
+			Run:      func(ctx context.Context) error { return PublishOpenData(ctx, s) },
+		},
+		{
+			// Webhooks afleveren die aan de beurt zijn; mislukte pogingen wachten volgens webhook.Backoff
+			Name:       JobWebhooks,
+			Schedule:   schedules[JobWebhooks],
+			Timeout:    5 * time.Minute,
+			RunOnStart: true,
+			Run:        func(ctx context.Context) error { return DeliverWebhooks(ctx, s) },
+		},
+	}, nil
+}
+
+// NewScheduler creates the scheduler with the collector jobs, persisting their status in the database
+func NewScheduler(s *Services) (*scheduler.Scheduler, error) {
+	jobs, err := Jobs(s)
+	if err != nil {
+		return nil, err
+	}
+	sched := scheduler.New(s.Scheduler)
+	for _, job := range jobs {
+		if err := sched.Add(job); err != nil {
+			return nil, err
+		}
+	}
+	return sched, nil
+}
+
+// Reschedule applies the schedules of a reloaded configuration to a running scheduler
+func Reschedule(ctx context.Context, sched *scheduler.Scheduler, cfg *config.Config) error {
+	schedules, err := Schedules(cfg)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for name, schedule := range schedules {
+		if err := sched.Reschedule(ctx, name, schedule); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retentionTables are the tables per retention category, with the column that dates a row
+var retentionTables = map[string][][2]string{
+	"measurements": {{"real_time_measurements", "timestamp"}},
+	"consumption": {
+		{"consumption", "from_time"},
+		{"production", "from_time"},
+		{"gas_consumption", "from_time"},
+		{"gas_readings", "timestamp"},
+	},
+	"prices": {{"prices", "starts_at"}, {"price_forecasts", "starts_at"}},
+	"analyses": {
+		{"baseload_nights", "night"},
+		{"anomalies", "period_start"},
+		{"power_quality_daily", "day"},
+		{"voltage_events", "started_at"},
+		{"curtailment_episodes", "started_at"},
+	},
+	"access_log": {{"data_access_log", "accessed_at"}},
+	// Afleveringen verdwijnen met hun gebeurtenis
+	"webhooks": {{"webhook_events", "created_at"}},
+}
+
+// applyRetention removes the rows that are older than the retention of their category
+func applyRetention(ctx context.Context, s *Services) error {
+	categories := s.Config().Retention.Categories()
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		retention := categories[name]
+		if retention <= 0 {
+			continue
+		}
+		before := time.Now().Add(-retention)
+		for _, t := range retentionTables[name] {
+			result, err := s.DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s < $1`, t[0], t[1]), before)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error cleaning up %s: %w", t[0], err))
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				log.Printf("Cleaned up %d rows of %s older than %s", n, t[0], retention)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckAccounts verifies the tokens of the member accounts, notifying members whose token was revoked,
+// and then discovers the homes of the accounts that still work
+func CheckAccounts(ctx context.Context, s *Services) error {
+	if s.Accounts.Box == nil {
+		return nil
+	}
+	revoked, err := s.Accounts.Check(ctx)
+	errs := []error{err}
+	if revoked > 0 {
+		log.Printf("%d Tibber tokens were revoked", revoked)
+	}
+
+	ids, err := s.Accounts.Active(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	homes := 0
+	for _, id := range ids {
+		found, err := s.Homes.SyncAccountHomes(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("homes of account %d: %w", id, err))
+			continue
+		}
+		homes += len(found)
+	}
+	log.Printf("Checked %d Tibber accounts with %d homes", len(ids), homes)
+	return errors.Join(errs...)
+}
+
+// OnboardHomes stores the homes that members shared since the last run and fetches their history:
+// a year of daily and HistoryMaxHours of hourly consumption and production, and today's prices.
+// A home whose history fails is tried again on the next run.
+func OnboardHomes(ctx context.Context, s *Services) error {
+	if s.Accounts.Box == nil {
+		return nil
+	}
+	pending, err := s.Accounts.PendingHomes(ctx)
+	if err != nil {
+		return err
+	}
+	byAccount := make(map[int]map[string]bool)
+	for _, h := range pending {
+		if byAccount[h.AccountID] == nil {
+			byAccount[h.AccountID] = make(map[string]bool)
+		}
+		byAccount[h.AccountID][h.HomeID] = true
+	}
+
+	var errs []error
+	now := time.Now()
+	for accountId, ids := range byAccount {
+		stored, err := s.Homes.SyncAccountHomes(ctx, accountId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("homes of account %d: %w", accountId, err))
+			continue
+		}
+		var homes []model.Home
+		for _, home := range stored {
+			if ids[home.Id] {
+				homes = append(homes, home)
+			}
+		}
+
+		var done []string
+		for _, home := range homes {
+			list := []model.Home{home}
+			err := errors.Join(
+				Backfill(ctx, s, list, []string{"DAILY"}, now.AddDate(0, 0, -HistoryMaxDays), now),
+				Backfill(ctx, s, list, []string{"HOURLY"}, now.Add(-HistoryMaxHours*time.Hour), now),
+			)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("history of home %s: %w", home.Id, err))
+				continue
+			}
+			done = append(done, home.Id)
+		}
+		if err := LoadPricesFor(ctx, s, homes); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.Accounts.MarkOnboarded(ctx, done); err != nil {
+			errs = append(errs, err)
+		}
+		log.Printf("Onboarded %d of %d homes of Tibber account %d", len(done), len(ids), accountId)
+	}
+	return errors.Join(errs...)
+}
+
+// recordPowerQuality stores the phase balance, fuse utilization, overvoltage events and inverter
+// curtailment of every home for the previous day, shortly after midnight while all measurements
+// of that day are still available
+func recordPowerQuality(ctx context.Context, s *Services) error {
+	homes, err := s.Homes.GetHomes(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching homes for power quality: %w", err)
+	}
+
+	var errs []error
+	yesterday := time.Now().AddDate(0, 0, -1)
+	events := 0
+	for _, home := range homes {
+		summary, err := s.PowerQuality.Record(ctx, home, yesterday)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error recording power quality: %w", err))
+			continue
+		}
+		if summary != nil {
+			events += summary.EventCount
+		}
+	}
+	log.Printf("Recorded power quality for %d homes, %d overvoltage events", len(homes), events)
+
+	// Afschakelende omvormers: alle productiehuizen samen, zodat buren vergeleken kunnen worden
+	episodes, err := s.PowerQuality.DetectCurtailment(ctx, homes, yesterday)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error detecting curtailment: %w", err))
+	} else {
+		log.Printf("Detected %d curtailment episodes", episodes)
+	}
+	return errors.Join(errs...)
+}
+
+// recordBaseloads stores the nightly minimum power of every home and then checks the previous
+// day for anomalies
+func recordBaseloads(ctx context.Context, s *Services) error {
+	homes, err := s.Homes.GetHomes(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching homes for baseload: %w", err)
+	}
+
+	var errs []error
+	for _, home := range homes {
+		if err := s.Anomaly.Usage.RecordBaseload(ctx, home.Id, time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("error recording baseload: %w", err))
+		}
+	}
+	log.Printf("Recorded nightly baseload for %d homes", len(homes))
+
+	// Gisteren is de laatste volledige dag met uurdata
+	n, err := s.Anomaly.DetectDaily(ctx, homes, time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error detecting anomalies: %w", err))
+	} else {
+		log.Printf("Detected %d new anomalies", n)
+	}
+	return errors.Join(errs...)
+}
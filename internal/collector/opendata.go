@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ws/internal/config"
+	"ws/internal/export"
+	"ws/internal/localtime"
+	"ws/internal/opendata"
+)
+
+// OpenDataDays is how many days back the opendata job looks for days that are not published yet
+const OpenDataDays = 7
+
+// PublishOpenData publishes every complete day of the open data datasets that is not published
+// yet: the rows go to the database for the API and, with opendata.directory, to a file per day
+func PublishOpenData(ctx context.Context, s *Services) error {
+	cfg := s.Config().OpenData
+	if !cfg.Enabled {
+		return nil
+	}
+	loc := localtime.Location("")
+	today := localtime.StartOfDay(time.Now(), loc)
+
+	var errs []error
+	for _, d := range opendata.Datasets {
+		day := today.AddDate(0, 0, -OpenDataDays)
+		for ; day.Before(today); day = localtime.NextDay(day, loc) {
+			end := localtime.NextDay(day, loc)
+			if time.Now().Before(end.Add(d.Delay)) {
+				break
+			}
+			if err := publishOpenDataDay(ctx, s, cfg, d, day, end); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", d.Name, day.Format("2006-01-02"), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishOpenDataDay aggregates and publishes one day of a dataset, unless it is published already
+// or there is no data for it
+func publishOpenDataDay(ctx context.Context, s *Services, cfg config.OpenData, d opendata.Dataset, day, end time.Time) error {
+	published, err := s.OpenData.IsPublished(ctx, d.Name, day)
+	if err != nil || published {
+		return err
+	}
+	samples, err := s.OpenData.Samples(ctx, d, day, end, cfg.Level, cfg.Community, cfg.PostalDigits)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		// Misschien komt de data nog, de volgende run kijkt opnieuw
+		return nil
+	}
+
+	rows, suppressed := opendata.Aggregate(d, samples, opendata.Options{
+		MinHomes:      cfg.MinHomes,
+		Epsilon:       cfg.Epsilon,
+		MaxKWhPerHour: cfg.MaxKWhPerHour,
+	})
+	if err := s.OpenData.Publish(ctx, d, day, cfg.Level, rows, suppressed); err != nil {
+		return err
+	}
+	log.Printf("Published open data %s of %s: %d rows, %d suppressed", d.Name, day.Format("2006-01-02"), len(rows), suppressed)
+
+	if cfg.Directory == "" {
+		return nil
+	}
+	return writeOpenDataFile(cfg, d, day, rows)
+}
+
+// writeOpenDataFile writes the rows of a day to <directory>/<dataset>/<dataset>-<day>.<format>
+func writeOpenDataFile(cfg config.OpenData, d opendata.Dataset, day time.Time, rows []opendata.Row) error {
+	format, err := export.ParseFormat(cfg.Format)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cfg.Directory, d.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.%s", d.Name, day.Format("2006-01-02"), format))
+
+	// Eerst naar een tijdelijk bestand, zodat de webserver nooit een half bestand uitlevert
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := opendata.Write(tmp, format, d, rows); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -3,151 +3,239 @@ package collector
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
-	"os"
 	"time"
 
-	"ws/internal/client"
+	"ws/internal/anomaly"
+	"ws/internal/config"
 	"ws/internal/db"
+	"ws/internal/forecast"
 	"ws/internal/model"
+	"ws/internal/notify"
 	"ws/internal/service_db"
 	"ws/internal/tibber"
-
-	"github.com/joho/godotenv"
 )
 
-// RunRealTimeCollector is de real-time data collector
-func RunRealTimeCollector(ctx context.Context) {
-	log.Printf("Starting real-time collector...")
+// RunRequired are the settings that RunRealTimeCollector needs
+var RunRequired = []string{"database.url", "tibber.token", "tibber.house_id"}
 
-	// Laad .env bestand
-	if err := godotenv.Load("./.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+// Connect opens the database of the configuration
+func Connect(cfg *config.Config) (*sql.DB, error) {
+	if cfg.Database.URL == "" {
+		return nil, fmt.Errorf("database.url is not set")
 	}
-	log.Printf("Loaded .env file")
-
-	// Haal database URL op
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
+	dbConfig, err := db.ParseURL(cfg.Database.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing database URL: %w", err)
 	}
-	log.Printf("Found DATABASE_URL")
-
-	// Parse database URL en maak verbinding
-	dbConfig, err := db.ParseURL(dbURL)
+	dbConn, err := db.NewConnection(dbConfig)
 	if err != nil {
-		log.Fatalf("Error parsing database URL: %v", err)
+		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
+	return dbConn, nil
+}
 
-	dbConn, err := db.NewConnection(dbConfig)
+// RunRealTimeCollector is de real-time data collector. Het draait tot ctx klaar is en maakt dan
+// de lopende geplande taken af. De configuratie moet gevalideerd zijn met RunRequired; wijzigingen
+// in het configuratiebestand worden tijdens het draaien toegepast, zie config.Watch.
+func RunRealTimeCollector(ctx context.Context, cfg *config.Config) error {
+	log.Printf("Starting real-time collector...")
+
+	// Tibber token en huis ID zijn nodig voor de live metingen
+	if cfg.Tibber.Token == "" {
+		return fmt.Errorf("tibber.token is not set")
+	}
+	if cfg.Tibber.HouseID == "" {
+		return fmt.Errorf("tibber.house_id is not set")
+	}
+
+	dbConn, err := Connect(cfg)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		return err
 	}
 	defer dbConn.Close()
 	log.Printf("Connected to database")
 
-	// Initialiseer database schema
+	// Ontbrekende migraties uitvoeren; bestaande gegevens blijven staan
 	if err := db.InitSchema(dbConn); err != nil {
-		log.Fatalf("Error initializing database schema: %v", err)
+		return fmt.Errorf("error migrating database schema: %w", err)
 	}
-	log.Printf("Initialized database schema")
+	log.Printf("Database schema is up to date")
+	log.Printf("Found Tibber credentials for house ID: %s", cfg.Tibber.HouseID)
 
-	// Haal Tibber API token en huis ID op
-	token := os.Getenv("TIBBER_API_TOKEN")
-	if token == "" {
-		log.Fatal("TIBBER_API_TOKEN environment variable is not set")
+	// Maak Tibber client voor de live metingen
+	wsClient := tibber.NewClientWithEndpoints(cfg.Tibber.Token, cfg.Tibber.HouseID, cfg.Tibber.Endpoints())
+
+	// Verifieer toegang tot Tibber API
+	if err := wsClient.VerifyAccess(); err != nil {
+		return fmt.Errorf("error verifying Tibber access: %w", err)
 	}
 
-	houseID := os.Getenv("TIBBER_HOUSE_ID")
-	if houseID == "" {
-		log.Fatal("TIBBER_HOUSE_ID environment variable is not set")
+	// Maak services
+	services, err := NewServices(dbConn, cfg)
+	if err != nil {
+		return err
 	}
-	log.Printf("Found Tibber credentials for house ID: %s", houseID)
 
-	// Maak Tibber clients
-	wsClient := tibber.NewClient(token, houseID)
-	apiClient := client.NewClient(token)
+	// CO2-intensiteit van het net voor de uitstootberekening
+	importCarbonIntensity(ctx, dbConn, cfg.Files.CarbonIntensity)
 
-	// Verifieer toegang tot Tibber API
-	if err := wsClient.VerifyAccess(); err != nil {
-		log.Fatalf("Error verifying Tibber access: %v", err)
+	// Geplande taken: prijzen, historie, dagelijkse rollups en opruimen
+	sched, err := NewScheduler(services)
+	if err != nil {
+		return err
 	}
+	schedDone := make(chan struct{})
+	go func() {
+		sched.Run(ctx)
+		close(schedDone)
+	}()
 
-	// Maak services
-	homeService := &service_db.HomeService{
-		Client: apiClient,
-		DB:     dbConn,
+	// Schema's, bewaartermijn, meldingen en het ENTSO-E token volgen het configuratiebestand
+	go config.Watch(ctx, cfg, RunRequired, func(old, new *config.Config) {
+		if err := Reschedule(ctx, sched, new); err != nil {
+			log.Printf("⚠️ Error applying schedules: %v", err)
+		}
+		services.SetConfig(new)
+		log.Printf("Configuration reloaded")
+	})
+
+	// P1-poort voor een huis zonder Tibber Pulse
+	startDSMR(ctx, cfg.DSMR, services.Homes, services.RealTime, services.Gas, services.Anomaly)
+
+	// Huizen die al live gemeten worden; nieuwe huizen komen er elke 5 minuten bij
+	started := make(map[string]bool)
+	members := make(map[string]context.CancelFunc)
+	for {
+		// Huizen van leden met hun eigen token, alleen met toestemming voor live metingen
+		wait := 5 * time.Minute
+		accountHomes, err := startAccountHomes(ctx, cfg, services, members)
+		if err != nil {
+			log.Printf("Error starting live measurements of member homes: %v", err)
+			wait = 5 * time.Second
+		}
+
+		// Get homes with production capability
+		homes, err := services.Homes.GetHomesWithProductionCapability(ctx)
+		if err != nil {
+			log.Printf("Error fetching homes: %v", err)
+			wait = 5 * time.Second
+		}
+		if accountHomes == nil {
+			// Zonder de huizen van leden niets starten, anders meet tibber.token hun huis
+			homes = nil
+		}
+
+		// Start real-time collection for each new home
+		for _, home := range homes {
+			if started[home.Id] || accountHomes[home.Id] {
+				continue
+			}
+			started[home.Id] = true
+			go collectHome(ctx, wsClient, services, home)
+		}
+
+		select {
+		case <-ctx.Done():
+			// Lopende taken afmaken voordat de databaseverbinding sluit
+			<-schedDone
+			return nil
+		case <-time.After(wait):
+		}
 	}
-	priceService := &service_db.PriceService{
-		Client: apiClient,
-		DB:     dbConn,
+}
+
+// startAccountHomes starts the live measurements of the member homes that are not running yet, each
+// with the token of its account, and stops those whose member withdrew consent or left. It returns
+// all member homes, also those without live consent, so that they are not measured with
+// tibber.token; nil on an error.
+func startAccountHomes(ctx context.Context, cfg *config.Config, services *Services, running map[string]context.CancelFunc) (map[string]bool, error) {
+	linked := make(map[string]bool)
+	if services.Accounts.Box == nil {
+		return linked, nil
 	}
-	consumptionService := &service_db.ConsumptionService{
-		Client: apiClient,
-		DB:     dbConn,
+	all, err := services.Accounts.LinkedHomes(ctx)
+	if err != nil {
+		return nil, err
 	}
-	productionService := &service_db.ProductionService{
-		Client: apiClient,
-		DB:     dbConn,
+	for _, h := range all {
+		linked[h.HomeID] = true
 	}
-	realTimeService := &service_db.RealTimeService{
-		DB: dbConn,
+	live, err := services.Accounts.LiveHomes(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Start cleanup goroutine
-	go cleanupOldMeasurements(ctx, dbConn)
+	// Toestemming ingetrokken of account verwijderd: direct stoppen met meten
+	allowed := make(map[string]bool, len(live))
+	for _, h := range live {
+		allowed[h.HomeID] = true
+	}
+	for homeId, stop := range running {
+		if !allowed[homeId] {
+			log.Printf("Stopping live measurements for home %s", homeId)
+			stop()
+			delete(running, homeId)
+		}
+	}
 
-	// Start a goroutine for each home
+	homes, err := services.Homes.GetHomes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]model.Home, len(homes))
+	for _, home := range homes {
+		byId[home.Id] = home
+	}
+	for _, h := range live {
+		home, ok := byId[h.HomeID]
+		if running[h.HomeID] != nil || !ok {
+			continue
+		}
+		token, err := services.Accounts.Token(ctx, h.AccountID)
+		if err != nil {
+			log.Printf("No live measurements for home %s: %v", h.HomeID, err)
+			continue
+		}
+		homeCtx, stop := context.WithCancel(ctx)
+		running[h.HomeID] = stop
+		log.Printf("Starting live measurements for home %s of Tibber account %d", h.HomeID, h.AccountID)
+		go collectHome(homeCtx, tibber.NewClientWithEndpoints(token, h.HomeID, cfg.Tibber.Endpoints()), services, home)
+	}
+	return linked, nil
+}
+
+// collectHome stores the live measurements of a home and passes them through the live anomaly detector
+func collectHome(ctx context.Context, wsClient *tibber.Client, services *Services, home model.Home) {
+	// Verwacht vermogen om afwijkingen in de live metingen te herkennen
+	var detector *anomaly.LiveDetector
+	if profile, err := services.Anomaly.LiveProfile(ctx, home); err != nil {
+		log.Printf("No live anomaly detection for home %s: %v", home.Id, err)
+	} else {
+		detector = anomaly.NewLiveDetector(home.Id, profile)
+	}
+
+	// Start WebSocket subscription
+	wsClient.Wg.Add(1)
+	go wsClient.Subscribe(ctx)
+
+	// Process measurements
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			// Get homes with production capability
-			homes, err := homeService.GetHomesWithProductionCapability(ctx)
-			if err != nil {
-				log.Printf("Error fetching homes: %v", err)
-				time.Sleep(5 * time.Second)
-				continue
+		case measurement := <-wsClient.WebsocketClient.Data:
+			if err := services.RealTime.StoreMeasurement(ctx, home.Id, measurement); err != nil {
+				log.Printf("Error storing measurement for home %s: %v", home.Id, err)
 			}
-
-			// Start real-time collection for each home
-			for _, home := range homes {
-				home := home // Create new variable for goroutine
-				go func() {
-					// Load initial data
-					if _, err := priceService.GetPrices(ctx, home.Id); err != nil {
-						log.Printf("Error loading initial prices for home %s: %v", home.Id, err)
-					}
-
-					if _, err := consumptionService.GetConsumption(ctx, home.Id, "DAILY", 30); err != nil {
-						log.Printf("Error loading initial consumption data for home %s: %v", home.Id, err)
-					}
-
-					if _, err := productionService.GetProduction(ctx, home.Id, "DAILY", 30); err != nil {
-						log.Printf("Error loading initial production data for home %s: %v", home.Id, err)
+			if detector != nil {
+				if event := detector.Observe(measurement); event != nil {
+					if _, err := services.Anomaly.Record(ctx, *event); err != nil {
+						log.Printf("Error recording anomaly for home %s: %v", home.Id, err)
 					}
-
-					// Start WebSocket subscription
-					wsClient.Wg.Add(1)
-					go wsClient.Subscribe(ctx)
-
-					// Process measurements
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						case measurement := <-wsClient.WebsocketClient.Data:
-							if err := realTimeService.StoreMeasurement(ctx, home.Id, measurement); err != nil {
-								log.Printf("Error storing measurement for home %s: %v", home.Id, err)
-							}
-						}
-					}
-				}()
+				}
 			}
-
-			// Wait before next update
-			time.Sleep(5 * time.Minute)
 		}
 	}
 }
@@ -158,31 +246,31 @@ func collectRealTimeData(ctx context.Context, client *tibber.Client, db *sql.DB,
 	return nil
 }
 
-// cleanupOldMeasurements removes measurements older than 24 hours
-func cleanupOldMeasurements(ctx context.Context, dbConn *sql.DB) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			// Wait until 3 AM
-			now := time.Now()
-			next := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, now.Location())
-			if now.After(next) {
-				next = next.Add(24 * time.Hour)
-			}
-			time.Sleep(next.Sub(now))
-
-			// Delete measurements older than 24 hours
-			query := `
-				DELETE FROM real_time_measurements 
-				WHERE timestamp < NOW() - INTERVAL '24 hours'
-			`
-			if _, err := dbConn.ExecContext(ctx, query); err != nil {
-				log.Printf("Error cleaning up old measurements: %v", err)
-			} else {
-				log.Printf("Cleaned up measurements older than 24 hours")
-			}
+// newAnomalyService creates the anomaly detector with the consumption and solar models it compares against.
+// files.solar_weather and files.consumption_weather are used like in the dashboard.
+func newAnomalyService(dbConn *sql.DB, files config.Files, notifier notify.Notifier, webhooks *service_db.WebhookService) *service_db.AnomalyService {
+	anomalyService := &service_db.AnomalyService{
+		DB:       dbConn,
+		Notifier: notifier,
+		Webhooks: webhooks,
+		Solar:    &service_db.SolarService{DB: dbConn},
+		Usage:    &service_db.ConsumptionForecastService{DB: dbConn},
+	}
+
+	if path := files.SolarWeather; path != "" {
+		if weather, err := forecast.LoadWeather(path); err != nil {
+			log.Printf("Error loading solar weather file: %v", err)
+		} else {
+			anomalyService.Solar.Weather = weather
+		}
+	}
+	if path := files.ConsumptionWeather; path != "" {
+		if weather, err := forecast.LoadWeather(path); err != nil {
+			log.Printf("Error loading consumption weather file: %v", err)
+		} else {
+			anomalyService.Usage.Weather = weather
 		}
 	}
+
+	return anomalyService
 }
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/service_db"
+	"ws/internal/webhook"
+)
+
+// Afleveren van webhooks: per run worden batches geclaimd tot de wachtrij leeg is
+const (
+	webhookBatch   = 50
+	webhookWorkers = 4
+	// webhookLease keeps a claimed delivery from other collectors until its attempt is recorded
+	webhookLease = webhook.Timeout + time.Minute
+)
+
+// DeliverWebhooks sends the queued deliveries that are due. A failed attempt is tried again after
+// webhook.Backoff, up to webhook.MaxAttempts. Endpoints of members may only be on public addresses.
+func DeliverWebhooks(ctx context.Context, s *Services) error {
+	// Endpoints van leden alleen naar publieke adressen, die van de beheerder ook intern
+	clients := map[bool]*http.Client{true: webhook.Client(true), false: webhook.Client(false)}
+
+	// Endpoints van voor de versleuteling krijgen eerst een versleutelde sleutel
+	sealed, err := s.Webhooks.SealSecrets(ctx)
+	if err != nil {
+		return err
+	}
+	if sealed > 0 {
+		log.Printf("Sealed the secrets of %d webhook endpoints", sealed)
+	}
+
+	delivered, failed := 0, 0
+	for ctx.Err() == nil {
+		due, err := s.Webhooks.Claim(ctx, webhookBatch, webhookLease)
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			break
+		}
+
+		var mu sync.Mutex
+		var errs []error
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, webhookWorkers)
+		for _, d := range due {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(d service_db.DueDelivery) {
+				defer func() { <-sem; wg.Done() }()
+				var result webhook.Result
+				if key, err := s.Webhooks.OpenSecret(d); err != nil {
+					result.Err = err // Telt als poging, zodat de aflevering niet blijft hangen
+				} else {
+					result = webhook.Deliver(ctx, clients[d.AccountID != 0], d.URL, key, d.Event)
+				}
+				err := s.Webhooks.Record(ctx, d, result)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+				}
+				if result.Err != nil {
+					failed++
+					log.Printf("Webhook delivery %d (%s) to %s failed, attempt %d: %v", d.ID, d.Event.Type, d.URL, d.Attempts+1, result.Err)
+				} else {
+					delivered++
+				}
+			}(d)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+
+	if delivered > 0 || failed > 0 {
+		log.Printf("Delivered %d webhooks, %d attempts failed", delivered, failed)
+	}
+	return nil
+}
+
+// TomorrowPrices is the data of a prices.tomorrow event
+type TomorrowPrices struct {
+	Date     string        `json:"date"` // YYYY-MM-DD, lokale dag van het huis
+	Currency string        `json:"currency,omitempty"`
+	Min      float64       `json:"min"`
+	Max      float64       `json:"max"`
+	Average  float64       `json:"average"`
+	Prices   []model.Price `json:"prices"`
+}
+
+// emitTomorrowPrices queues a prices.tomorrow event for a home once tomorrow's prices are complete.
+// The event is keyed on the home and the day, so it is sent once however often prices are loaded.
+func emitTomorrowPrices(ctx context.Context, s *Services, home model.Home) {
+	loc := localtime.Location(home.TimeZone)
+	from := localtime.NextDay(localtime.StartOfDay(time.Now(), loc), loc)
+	to := localtime.NextDay(from, loc)
+
+	if ok, err := s.Prices.HasPrices(ctx, home.Id, from, to); err != nil || !ok {
+		return
+	}
+	prices, err := s.Prices.PricesBetween(ctx, home.Id, from, to)
+	if err != nil {
+		log.Printf("Error fetching tomorrow's prices of home %s for webhooks: %v", home.Id, err)
+		return
+	}
+
+	data := TomorrowPrices{Date: from.Format("2006-01-02"), Min: math.Inf(1), Max: math.Inf(-1), Prices: prices}
+	var sum float64
+	for _, p := range prices {
+		data.Currency = p.Currency
+		data.Min = math.Min(data.Min, p.Total)
+		data.Max = math.Max(data.Max, p.Total)
+		sum += p.Total
+	}
+	data.Average = math.Round(sum/float64(len(prices))*10000) / 10000
+
+	key := fmt.Sprintf("%s:%s:%s", webhook.EventTomorrowPrices, home.Id, data.Date)
+	if n, err := s.Webhooks.Emit(ctx, webhook.EventTomorrowPrices, key, home.Id, data); err != nil {
+		log.Printf("Error queueing webhooks for tomorrow's prices of home %s: %v", home.Id, err)
+	} else if n > 0 {
+		log.Printf("Queued %d webhooks for tomorrow's prices of home %s", n, home.Id)
+	}
+}
+
+// emitSettlement queues a settlement.completed event for the local day of t of a home once its
+// daily consumption is stored. Like the prices it is keyed on the home and the day.
+func emitSettlement(ctx context.Context, s *Services, home model.Home, t time.Time) {
+	settlement, err := s.Statements.Daily(ctx, home, t)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching settlement of home %s for webhooks: %v", home.Id, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s:%s:%s", webhook.EventSettlement, home.Id, settlement.Date)
+	if n, err := s.Webhooks.Emit(ctx, webhook.EventSettlement, key, home.Id, settlement); err != nil {
+		log.Printf("Error queueing webhooks for the settlement of home %s: %v", home.Id, err)
+	} else if n > 0 {
+		log.Printf("Queued %d webhooks for the settlement of home %s on %s", n, home.Id, settlement.Date)
+	}
+}
@@ -0,0 +1,463 @@
+// Package config is the typed configuration of the collector and the web server. Settings come
+// from a TOML file and can be overridden by environment variables (the variable names that were
+// used before the file existed), so an existing .env keeps working.
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ws/internal/secret"
+	"ws/internal/tibber"
+)
+
+// DefaultFile is read when no file is given and it exists in the working directory
+const DefaultFile = "config.toml"
+
+// Config is the complete configuration. Fields tagged structural need a restart to change;
+// the others are applied when the file is reloaded.
+type Config struct {
+	Database  Database          `toml:"database"`
+	Tibber    Tibber            `toml:"tibber"`
+	Entsoe    Entsoe            `toml:"entsoe"`
+	Schedules map[string]string `toml:"schedules"` // Cron-schema per taak van de collector
+	Retention Retention         `toml:"retention"`
+	Web       Web               `toml:"web"`
+	Notify    Notify            `toml:"notify"`
+	Community Community         `toml:"community"`
+	Files     Files             `toml:"files"`
+	DSMR      DSMR              `toml:"dsmr"`
+	OpenData  OpenData          `toml:"opendata"`
+
+	// Path is the file the configuration was read from, empty without a file
+	Path string `toml:"-"`
+	// Override is applied again after every reload, for command-line flags that win over the file
+	Override func(*Config) `toml:"-"`
+}
+
+// ApplyOverride sets the overrides of the command line and applies them
+func (c *Config) ApplyOverride(override func(*Config)) {
+	c.Override = override
+	override(c)
+}
+
+type Database struct {
+	URL string `toml:"url" env:"DATABASE_URL" structural:"true"`
+}
+
+type Tibber struct {
+	Token         string `toml:"token" env:"TIBBER_API_TOKEN" structural:"true"`
+	HouseID       string `toml:"house_id" env:"TIBBER_HOUSE_ID" structural:"true"`
+	APIURL        string `toml:"api_url" env:"TIBBER_API_ENDPOINT" structural:"true"`
+	WebsocketHost string `toml:"websocket_host" env:"TIBBER_WEBSOCKET_HOST" structural:"true"`
+	WebsocketPath string `toml:"websocket_path" env:"TIBBER_WEBSOCKET_PATH" structural:"true"`
+	// Sleutel waarmee de tokens van leden en de sleutels van webhooks versleuteld in de database staan
+	// (32 bytes, hex of base64)
+	AccountsKey string `toml:"accounts_key" env:"TIBBER_ACCOUNTS_KEY" structural:"true"`
+	// Maximaal aantal API-verzoeken per minuut per account, met kleine pieken
+	RequestsPerMinute int `toml:"requests_per_minute" env:"TIBBER_REQUESTS_PER_MINUTE" structural:"true"`
+}
+
+// Box returns the box for the secrets in the database, the tokens of members and the keys of
+// webhooks; nil without accounts_key
+func (t Tibber) Box() (*secret.Box, error) {
+	if t.AccountsKey == "" {
+		return nil, nil
+	}
+	key, err := secret.ParseKey(t.AccountsKey)
+	if err != nil {
+		return nil, fmt.Errorf("tibber.accounts_key: %w", err)
+	}
+	return secret.NewBox(key)
+}
+
+// Endpoints returns the Tibber endpoints for the live measurement client
+func (t Tibber) Endpoints() tibber.Endpoints {
+	return tibber.Endpoints{APIURL: t.APIURL, WebsocketHost: t.WebsocketHost, WebsocketPath: t.WebsocketPath}
+}
+
+type Entsoe struct {
+	Token string `toml:"token" env:"ENTSOE_API_TOKEN"`
+	URL   string `toml:"url" env:"ENTSOE_API_URL"`
+	// Opgeslagen A44-document dat in plaats van de API wordt gelezen, bijvoorbeeld een handmatige download
+	File string `toml:"file" env:"ENTSOE_FILE"`
+}
+
+// Retention is how long each category of data is kept; 0 keeps it forever
+type Retention struct {
+	// Hoe lang real-time metingen bewaard blijven
+	Measurements time.Duration `toml:"measurements" env:"RETENTION_MEASUREMENTS"`
+	// Verbruik en productie per uur en per dag, ook gas
+	Consumption time.Duration `toml:"consumption" env:"RETENTION_CONSUMPTION"`
+	// Prijzen en prijsverwachtingen
+	Prices time.Duration `toml:"prices" env:"RETENTION_PRICES"`
+	// Analyses per huis: sluipverbruik, afwijkingen, spanningskwaliteit en afschakelen
+	Analyses time.Duration `toml:"analyses" env:"RETENTION_ANALYSES"`
+	// Log van wie de gegevens van een huis in het dashboard bekeek
+	AccessLog time.Duration `toml:"access_log" env:"RETENTION_ACCESS_LOG"`
+	// Webhook-gebeurtenissen met hun afleveringen
+	Webhooks time.Duration `toml:"webhooks" env:"RETENTION_WEBHOOKS"`
+}
+
+// Categories returns the retention per category, keyed by the name in the configuration file
+func (r Retention) Categories() map[string]time.Duration {
+	return map[string]time.Duration{
+		"measurements": r.Measurements,
+		"consumption":  r.Consumption,
+		"prices":       r.Prices,
+		"analyses":     r.Analyses,
+		"access_log":   r.AccessLog,
+		"webhooks":     r.Webhooks,
+	}
+}
+
+type Web struct {
+	Port  int    `toml:"port" env:"PORT" structural:"true"`
+	Title string `toml:"title" env:"TITLE"`
+	// Header waarin de proxy voor het dashboard de ingelogde gebruiker zet. Nodig voor een bruikbaar
+	// inzagelog: zonder staat bij het dashboard alleen het adres van de proxy in het log
+	ViewerHeader string `toml:"viewer_header" env:"WEB_VIEWER_HEADER"`
+	// Aanmelden van leden via /onboarding; vraagt database.url en tibber.accounts_key
+	Onboarding bool `toml:"onboarding" env:"WEB_ONBOARDING"`
+	// Code die een lid bij het aanmelden moet invullen, leeg voor geen code
+	InviteCode string `toml:"invite_code" env:"WEB_INVITE_CODE"`
+	// Gebruikers uit viewer_header die het beheer op /admin mogen gebruiken, leeg zet het uit
+	AdminUsers []string `toml:"admin_users" env:"WEB_ADMIN_USERS"`
+	// Hoe lang live metingen per huis in het geheugen blijven om bij het verbinden opnieuw te sturen
+	LiveBacklog time.Duration `toml:"live_backlog" env:"WEB_LIVE_BACKLOG" structural:"true"`
+}
+
+type Notify struct {
+	SMTPAddr     string   `toml:"smtp_addr" env:"NOTIFY_SMTP_ADDR"`
+	SMTPUser     string   `toml:"smtp_user" env:"NOTIFY_SMTP_USER"`
+	SMTPPassword string   `toml:"smtp_password" env:"NOTIFY_SMTP_PASSWORD"`
+	EmailFrom    string   `toml:"email_from" env:"NOTIFY_EMAIL_FROM"`
+	EmailTo      []string `toml:"email_to" env:"NOTIFY_EMAIL_TO"`
+	WebhookURL   string   `toml:"webhook_url" env:"NOTIFY_WEBHOOK_URL"`
+}
+
+type Community struct {
+	// Tijdzone voor huizen zonder eigen tijdzone en voor de schema's van de collector
+	TimeZone string `toml:"time_zone" env:"TIME_ZONE" structural:"true"`
+}
+
+// Files are data files that are read once at startup
+type Files struct {
+	TariffContracts    string `toml:"tariff_contracts" env:"TARIFF_CONTRACTS_FILE" structural:"true"`
+	GasTariff          string `toml:"gas_tariff" env:"GAS_TARIFF_FILE" structural:"true"`
+	ImportProfiles     string `toml:"import_profiles" env:"IMPORT_PROFILES_FILE" structural:"true"`
+	CarbonIntensity    string `toml:"carbon_intensity" env:"CARBON_INTENSITY_FILE" structural:"true"`
+	PriceWeather       string `toml:"price_weather" env:"PRICE_WEATHER_FILE" structural:"true"`
+	SolarWeather       string `toml:"solar_weather" env:"SOLAR_WEATHER_FILE" structural:"true"`
+	ConsumptionWeather string `toml:"consumption_weather" env:"CONSUMPTION_WEATHER_FILE" structural:"true"`
+}
+
+type DSMR struct {
+	Source      string  `toml:"source" env:"DSMR_SOURCE" structural:"true"`
+	HomeID      string  `toml:"home_id" env:"DSMR_HOME_ID" structural:"true"`
+	Baud        int     `toml:"baud" env:"DSMR_BAUD" structural:"true"`
+	ReplaySpeed float64 `toml:"replay_speed" env:"DSMR_REPLAY_SPEED" structural:"true"`
+}
+
+// OpenData is the publication of aggregated data for research and partners, see internal/opendata
+type OpenData struct {
+	// Publiceren via de taak opendata en /open-data van de webserver
+	Enabled bool `toml:"enabled" env:"OPENDATA_ENABLED"`
+	// community voor de hele gemeenschap, postal_code per postcodegebied
+	Level string `toml:"level" env:"OPENDATA_LEVEL"`
+	// Naam van het gebied op het niveau community
+	Community string `toml:"community" env:"OPENDATA_COMMUNITY"`
+	// Aantal cijfers van de postcode op het niveau postal_code
+	PostalDigits int `toml:"postal_digits" env:"OPENDATA_POSTAL_DIGITS"`
+	// Gebieden met minder huizen worden weggelaten (k-anonimiteit)
+	MinHomes int `toml:"min_homes" env:"OPENDATA_MIN_HOMES"`
+	// Privacybudget per gebied en uur voor Laplace-ruis, 0 voor geen ruis
+	Epsilon float64 `toml:"epsilon" env:"OPENDATA_EPSILON"`
+	// Grootste bijdrage van één huis per uur; hogere waarden worden afgekapt
+	MaxKWhPerHour float64 `toml:"max_kwh_per_hour" env:"OPENDATA_MAX_KWH_PER_HOUR"`
+	// Map voor de dagelijkse bestanden, leeg voor alleen de API
+	Directory string `toml:"directory" env:"OPENDATA_DIRECTORY"`
+	// csv, jsonl of parquet
+	Format string `toml:"format" env:"OPENDATA_FORMAT"`
+}
+
+// Default returns the configuration without file or environment
+func Default() *Config {
+	return &Config{
+		Tibber: Tibber{
+			APIURL:            "https://api.tibber.com/v1-beta/gql",
+			WebsocketHost:     "websocket-api.tibber.com",
+			WebsocketPath:     "/v1-beta/gql/subscriptions",
+			RequestsPerMinute: 20,
+		},
+		Entsoe:    Entsoe{URL: "https://web-api.tp.entsoe.eu/api"},
+		Schedules: map[string]string{},
+		Retention: Retention{Measurements: 24 * time.Hour, AccessLog: 365 * 24 * time.Hour, Webhooks: 30 * 24 * time.Hour},
+		Web:       Web{Title: "Default Title", LiveBacklog: 15 * time.Minute},
+		Community: Community{TimeZone: "Europe/Amsterdam"},
+		DSMR:      DSMR{Baud: 115200, ReplaySpeed: 1},
+		OpenData: OpenData{
+			Level:         "community",
+			Community:     "community",
+			PostalDigits:  4,
+			MinHomes:      10,
+			MaxKWhPerHour: 10,
+			Format:        "csv",
+		},
+	}
+}
+
+// Load reads the configuration: defaults, then the file at path, then the environment. An empty
+// path reads DefaultFile when it exists. The result is not validated yet, see Validate.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			path = DefaultFile
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+		values, err := parseTOML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := decode(reflect.ValueOf(cfg).Elem(), "", values); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := unknownKeys(values); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cfg.Path = path
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode sets the fields of v from the parsed values below prefix and marks them as used
+func decode(v reflect.Value, prefix string, values map[string]value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fv := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := decode(fv, path, values); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Map {
+			// Alle sleutels van de tabel, zoals [schedules]
+			for key, val := range values {
+				if !strings.HasPrefix(key, path+".") || val.line < 0 {
+					continue
+				}
+				s, ok := val.v.(string)
+				if !ok {
+					return fmt.Errorf("line %d: %s must be a string", val.line, key)
+				}
+				fv.SetMapIndex(reflect.ValueOf(strings.TrimPrefix(key, path+".")), reflect.ValueOf(s))
+				values[key] = value{v: val.v, line: -1}
+			}
+			continue
+		}
+
+		val, ok := values[path]
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, val.v); err != nil {
+			return fmt.Errorf("line %d: %s %w", val.line, path, err)
+		}
+		values[path] = value{v: val.v, line: -1} // Gebruikt
+	}
+	return nil
+}
+
+// unknownKeys reports the settings in the file that the configuration does not have, usually typos
+func unknownKeys(values map[string]value) error {
+	var unknown []string
+	for key, val := range values {
+		if val.line >= 0 {
+			unknown = append(unknown, fmt.Sprintf("line %d: unknown setting %s", val.line, key))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("%s", strings.Join(unknown, "; "))
+}
+
+// setValue converts a parsed TOML value to the type of the field
+func setValue(fv reflect.Value, v any) error {
+	if fv.Type() == durationType {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a duration such as \"24h\"")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as \"24h\": %v", err)
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		fv.SetString(s)
+	case reflect.Int:
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("must be an integer")
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		switch n := v.(type) {
+		case float64:
+			fv.SetFloat(n)
+		case int64:
+			fv.SetFloat(float64(n))
+		default:
+			return fmt.Errorf("must be a number")
+		}
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("must be true or false")
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("must be an array of strings")
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("must be an array of strings")
+			}
+			list = append(list, s)
+		}
+		fv.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("has an unsupported type")
+	}
+	return nil
+}
+
+// applyEnv overrides fields with their environment variable when it is set
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			continue
+		}
+
+		var parsed any = raw
+		switch {
+		case field.Type == durationType:
+		case fv.Kind() == reflect.Int:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("environment variable %s must be an integer, got %q", name, raw)
+			}
+			parsed = n
+		case fv.Kind() == reflect.Float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("environment variable %s must be a number, got %q", name, raw)
+			}
+			parsed = f
+		case fv.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("environment variable %s must be true or false, got %q", name, raw)
+			}
+			parsed = b
+		case fv.Kind() == reflect.Slice:
+			var items []any
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			parsed = items
+		}
+		if err := setValue(fv, parsed); err != nil {
+			return fmt.Errorf("environment variable %s %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Structural returns the settings that differ between two configurations and need a restart
+func Structural(old, new *Config) []string {
+	var changed []string
+	compareStructural(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", &changed)
+	return changed
+}
+
+func compareStructural(a, b reflect.Value, prefix string, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			compareStructural(a.Field(i), b.Field(i), path, changed)
+			continue
+		}
+		if field.Tag.Get("structural") == "true" && !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			*changed = append(*changed, path)
+		}
+	}
+}
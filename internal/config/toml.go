@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the subset of TOML that the configuration uses: [tables] and [nested.tables],
+// key = value pairs with strings, integers, floats, booleans and arrays of those, and comments.
+// Every value is returned with the line it was defined on, keyed by its dotted path.
+func parseTOML(data string) (map[string]value, error) {
+	values := make(map[string]value)
+	tables := make(map[string]bool)
+	table := ""
+
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %q", lineNo, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if !validKey(name, true) {
+				return nil, fmt.Errorf("line %d: invalid table name %q", lineNo, name)
+			}
+			if tables[name] {
+				return nil, fmt.Errorf("line %d: table [%s] is defined twice", lineNo, name)
+			}
+			tables[name] = true
+			table = name
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if len(key) >= 2 && key[0] == '"' && key[len(key)-1] == '"' {
+			key = key[1 : len(key)-1] // Sleutels met een streepje of punt, zoals "tomorrow-prices"
+		} else if !validKey(key, false) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNo, key)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+
+		// Een array mag over meerdere regels lopen
+		if strings.HasPrefix(raw, "[") {
+			for !arrayClosed(raw) && i+1 < len(lines) {
+				i++
+				raw += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+		}
+
+		v, rest, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", lineNo, key, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: %s: unexpected %q after value", lineNo, key, strings.TrimSpace(rest))
+		}
+
+		path := key
+		if table != "" {
+			path = table + "." + key
+		}
+		if _, ok := values[path]; ok {
+			return nil, fmt.Errorf("line %d: %s is defined twice", lineNo, path)
+		}
+		values[path] = value{v: v, line: lineNo}
+	}
+	return values, nil
+}
+
+// value is a parsed TOML value: string, int64, float64, bool or []any
+type value struct {
+	v    any
+	line int
+}
+
+func validKey(key string, dotted bool) bool {
+	if key == "" {
+		return false
+	}
+	for _, part := range strings.Split(key, ".") {
+		if part == "" || (!dotted && part != key) {
+			return false
+		}
+		for _, r := range part {
+			if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// arrayClosed reports whether the brackets outside strings are balanced
+func arrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '[':
+			depth++
+		case quote == 0 && c == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// parseValue parses the value at the start of s and returns the rest
+func parseValue(s string) (any, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		return parseBasicString(s)
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '[':
+		return parseArray(s)
+	}
+
+	end := strings.IndexAny(s, ",]")
+	if end < 0 {
+		end = len(s)
+	}
+	token, rest := strings.TrimSpace(s[:end]), s[end:]
+	switch token {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	clean := strings.ReplaceAll(token, "_", "")
+	if n, err := strconv.ParseInt(clean, 10, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q (strings need quotes)", token)
+}
+
+func parseBasicString(s string) (any, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, "", fmt.Errorf("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case '"', '\\':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u':
+				if i+4 >= len(s) {
+					return nil, "", fmt.Errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return nil, "", fmt.Errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return nil, "", fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated string")
+}
+
+func parseArray(s string) (any, string, error) {
+	items := []any{}
+	rest := strings.TrimSpace(s[1:])
+	for {
+		if strings.HasPrefix(rest, "]") {
+			return items, rest[1:], nil
+		}
+		v, r, err := parseValue(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, v)
+		rest = strings.TrimSpace(r)
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = strings.TrimSpace(rest[1:])
+		case strings.HasPrefix(rest, "]"):
+		default:
+			return nil, "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
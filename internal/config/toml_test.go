@@ -0,0 +1,299 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parsed returns the values of parseTOML without their line numbers
+func parsed(t *testing.T, data string) map[string]any {
+	t.Helper()
+	values, err := parseTOML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]any, len(values))
+	for key, val := range values {
+		result[key] = val.v
+	}
+	return result
+}
+
+// writeConfig writes data to a config.toml in a temporary directory and returns its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseTOMLStrings(t *testing.T) {
+	got := parsed(t, `
+plain = "tekst"
+escapes = "a \"b\" \\ c\td\ne\r"
+unicode = "caf\u00e9 \u20ac"
+literal = 'C:\pad\zonder "escapes"'
+empty = ""
+`)
+	want := map[string]any{
+		"plain":   "tekst",
+		"escapes": "a \"b\" \\ c\td\ne\r",
+		"unicode": "café €",
+		"literal": `C:\pad\zonder "escapes"`,
+		"empty":   "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParseTOMLComments(t *testing.T) {
+	got := parsed(t, `# Kop van het bestand
+title = "Huis # 12" # uitleg
+quote = "een \" en # in de tekst" # nog een
+literal = '#hashtag'
+port = 8080   # poort
+list = [
+  "a", # eerste
+  "b#c",
+] # einde
+`)
+	want := map[string]any{
+		"title":   "Huis # 12",
+		"quote":   `een " en # in de tekst`,
+		"literal": "#hashtag",
+		"port":    int64(8080),
+		"list":    []any{"a", "b#c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParseTOMLTablesAndTypes(t *testing.T) {
+	values, err := parseTOML("top = 1\r\n\r\n[web]\r\nport = 80\r\n\r\n[schedules]\r\n\"tomorrow-prices\" = \"0 14 * * *\"\r\n\r\n[a.b]\r\nc = true\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := map[string]int{"top": 1, "web.port": 4, "schedules.tomorrow-prices": 7, "a.b.c": 10}
+	for key, line := range lines {
+		if values[key].line != line {
+			t.Errorf("%s on line %d, want %d", key, values[key].line, line)
+		}
+	}
+
+	got := parsed(t, `
+int = -1_000
+float = 2.5
+exp = 1e3
+yes = true
+no = false
+nested = [[1, 2], ["x"]]
+empty = []
+`)
+	want := map[string]any{
+		"int":    int64(-1000),
+		"float":  2.5,
+		"exp":    1000.0,
+		"yes":    true,
+		"no":     false,
+		"nested": []any{[]any{int64(1), int64(2)}, []any{"x"}},
+		"empty":  []any{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"a = \"open", "line 1: a: unterminated string"},
+		{"\na = \"\\q\"", "line 2: a: invalid escape \\q"},
+		{"a = \"\\u12\"", "line 1: a: invalid unicode escape"},
+		{"a = hallo", "line 1: a: invalid value \"hallo\" (strings need quotes)"},
+		{"a =", "line 1: a: missing value"},
+		{"a = 1 2", "line 1: a: invalid value"},
+		{"a = \"x\" \"y\"", "line 1: a: unexpected \"\\\"y\\\"\" after value"},
+		{"a = [1, 2", "line 1: a:"},
+		{"just a line", "line 1: expected key = value"},
+		{"a b = 1", "line 1: invalid key \"a b\""},
+		{"[web", "line 1: invalid table header"},
+		{"[[hooks]]", "line 1: invalid table header"},
+		{"[web..x]", "line 1: invalid table name"},
+		{"[web]\n[web]", "line 2: table [web] is defined twice"},
+		{"[web]\nport = 1\n\nport = 2", "line 4: web.port is defined twice"},
+	}
+	for _, tt := range tests {
+		_, err := parseTOML(tt.data)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.data)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: error %q, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestLoadTypeErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"[web]\ntitle = \"x\"\nport = \"80\"", "line 3: web.port must be an integer"},
+		{"[web]\nonboarding = \"yes\"", "line 2: web.onboarding must be true or false"},
+		{"\n[retention]\nmeasurements = 24", "line 3: retention.measurements must be a duration"},
+		{"[retention]\nprices = \"a week\"", "line 2: retention.prices must be a duration such as \"24h\": "},
+		{"[dsmr]\nreplay_speed = true", "line 2: dsmr.replay_speed must be a number"},
+		{"[notify]\nemail_to = [\"a@b\", 1]", "line 2: notify.email_to must be an array of strings"},
+		{"[schedules]\nprices = 5", "line 2: schedules.prices must be a string"},
+		{"[web]\ntitel = \"x\"\n\n[dsmr]\nbaud = 9600\nspeed = 2", "line 2: unknown setting web.titel; line 6: unknown setting dsmr.speed"},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, tt.data)
+		_, err := Load(path)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.data)
+			continue
+		}
+		if want := path + ": " + tt.want; !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: error %q, want %q", tt.data, err, want)
+		}
+	}
+
+	// Een float mag geen geheel getal worden, een geheel getal wel een float
+	path := writeConfig(t, "[dsmr]\nbaud = 9600.5")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "line 2: dsmr.baud must be an integer") {
+		t.Errorf("float for an integer: %v", err)
+	}
+	cfg, err := Load(writeConfig(t, "[dsmr]\nreplay_speed = 2"))
+	if err != nil || cfg.DSMR.ReplaySpeed != 2 {
+		t.Errorf("integer for a float: %v, %v", cfg, err)
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `
+[web]
+title = "Uit het bestand"
+port = 8080
+admin_users = ["ann"]
+
+[retention]
+prices = "720h"
+
+[dsmr]
+replay_speed = 2.0
+`)
+	t.Setenv("TITLE", "Uit de omgeving")
+	t.Setenv("PORT", "") // Leeg telt als niet gezet
+	t.Setenv("WEB_ADMIN_USERS", "bob, carol,")
+	t.Setenv("RETENTION_PRICES", "48h")
+	t.Setenv("DSMR_BAUD", "9600")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Path != path {
+		t.Errorf("path %q, want %q", cfg.Path, path)
+	}
+	// Omgeving boven bestand, bestand boven standaard
+	if cfg.Web.Title != "Uit de omgeving" || cfg.Web.Port != 8080 || cfg.DSMR.ReplaySpeed != 2 || cfg.DSMR.Baud != 9600 {
+		t.Errorf("title %q port %d replay %v baud %d", cfg.Web.Title, cfg.Web.Port, cfg.DSMR.ReplaySpeed, cfg.DSMR.Baud)
+	}
+	if !reflect.DeepEqual(cfg.Web.AdminUsers, []string{"bob", "carol"}) || cfg.Retention.Prices != 48*time.Hour {
+		t.Errorf("admin users %q, retention %s", cfg.Web.AdminUsers, cfg.Retention.Prices)
+	}
+	if cfg.Retention.Measurements != 24*time.Hour {
+		t.Errorf("default retention.measurements = %s", cfg.Retention.Measurements)
+	}
+
+	// De opdrachtregel wint van de omgeving
+	cfg.ApplyOverride(func(c *Config) { c.Web.Title = "Van de opdrachtregel" })
+	if cfg.Web.Title != "Van de opdrachtregel" {
+		t.Errorf("title %q after override", cfg.Web.Title)
+	}
+
+	t.Setenv("DSMR_BAUD", "snel")
+	if _, err := Load(path); err == nil || err.Error() != `environment variable DSMR_BAUD must be an integer, got "snel"` {
+		t.Errorf("invalid environment variable: %v", err)
+	}
+	t.Setenv("DSMR_BAUD", "")
+	t.Setenv("RETENTION_PRICES", "een maand")
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), "environment variable RETENTION_PRICES must be a duration") {
+		t.Errorf("invalid duration in the environment: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	const valid = `
+[community]
+time_zone = "UTC"
+
+[web]
+title = "Eerste"
+port = 8080
+`
+	path := writeConfig(t, valid)
+	current, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.ApplyOverride(func(c *Config) { c.Web.ViewerHeader = "X-User" })
+	if err := current.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var applied []*Config
+	apply := func(old, new *Config) {
+		if old != current {
+			t.Error("apply got another old configuration")
+		}
+		applied = append(applied, new)
+	}
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Een ongeldig bestand of een ongeldige waarde laat de huidige configuratie staan
+	for _, data := range []string{
+		strings.Replace(valid, `title = "Eerste"`, `title = Tweede`, 1),
+		strings.Replace(valid, `port = 8080`, `port = 70000`, 1),
+		valid + "\n[retention]\nprices = \"1h\"\n",
+	} {
+		write(data)
+		if next := reload(current, nil, apply); next != current {
+			t.Errorf("invalid configuration was applied:\n%s", data)
+		}
+	}
+	// Ook een instelling die verplicht is maar ontbreekt
+	write(valid)
+	if next := reload(current, []string{"database.url"}, apply); next != current {
+		t.Error("configuration without a required setting was applied")
+	}
+	if len(applied) != 0 {
+		t.Fatalf("apply was called %d times for invalid configurations", len(applied))
+	}
+
+	// Een geldige wijziging wordt toegepast; structurele instellingen houden hun oude waarde
+	write(strings.NewReplacer(`"Eerste"`, `"Tweede"`, "8080", "9090").Replace(valid))
+	next := reload(current, nil, apply)
+	if next == current || len(applied) != 1 || applied[0] != next {
+		t.Fatal("valid configuration was not applied")
+	}
+	if next.Web.Title != "Tweede" || next.Web.Port != 8080 || next.Web.ViewerHeader != "X-User" {
+		t.Errorf("title %q port %d viewer header %q", next.Web.Title, next.Web.Port, next.Web.ViewerHeader)
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/scheduler"
+	"ws/internal/secret"
+)
+
+// ValidationError lists every problem in a configuration, so they can be fixed in one go
+type ValidationError struct {
+	Path     string // Bestand van de configuratie, leeg zonder bestand
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	where := "configuration"
+	if e.Path != "" {
+		where = e.Path
+	}
+	return fmt.Sprintf("invalid %s:\n  - %s", where, strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate checks the configuration. Required lists the settings the program cannot run without,
+// as dotted names such as "database.url" or "tibber.token".
+func (c *Config) Validate(required ...string) error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, name := range required {
+		if v, env, ok := c.lookup(name); !ok {
+			add("%s: unknown setting", name)
+		} else if v.IsZero() {
+			if env != "" {
+				add("%s is required (or set %s)", name, env)
+			} else {
+				add("%s is required", name)
+			}
+		}
+	}
+
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			add("database.url must be a postgres:// URL")
+		}
+	}
+	checkURL := func(name, value string, schemes ...string) {
+		if value == "" {
+			add("%s is required", name)
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" {
+			add("%s: %q is not a valid URL", name, value)
+			return
+		}
+		for _, s := range schemes {
+			if u.Scheme == s {
+				return
+			}
+		}
+		add("%s: scheme must be %s", name, strings.Join(schemes, " or "))
+	}
+	checkURL("tibber.api_url", c.Tibber.APIURL, "https", "http")
+	checkURL("entsoe.url", c.Entsoe.URL, "https", "http")
+	if c.Tibber.WebsocketHost == "" || strings.Contains(c.Tibber.WebsocketHost, "/") {
+		add("tibber.websocket_host must be a host name such as websocket-api.tibber.com")
+	}
+	if !strings.HasPrefix(c.Tibber.WebsocketPath, "/") {
+		add("tibber.websocket_path must start with /")
+	}
+	if c.Tibber.AccountsKey != "" {
+		if _, err := secret.ParseKey(c.Tibber.AccountsKey); err != nil {
+			add("tibber.accounts_key: %v", err)
+		}
+	}
+	if c.Tibber.RequestsPerMinute < 1 {
+		add("tibber.requests_per_minute must be at least 1, got %d", c.Tibber.RequestsPerMinute)
+	}
+
+	loc, err := time.LoadLocation(c.Community.TimeZone)
+	if err != nil || c.Community.TimeZone == "" {
+		add("community.time_zone: unknown time zone %q", c.Community.TimeZone)
+		loc = time.UTC
+	}
+
+	names := make([]string, 0, len(c.Schedules))
+	for name := range c.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := scheduler.Parse(c.Schedules[name], loc); err != nil {
+			add("schedules.%s: %v", name, err)
+		}
+	}
+
+	if c.Retention.Measurements < time.Hour {
+		add("retention.measurements must be at least 1h, got %s", c.Retention.Measurements)
+	}
+	for _, name := range []string{"consumption", "prices", "analyses", "access_log", "webhooks"} {
+		if d := c.Retention.Categories()[name]; d != 0 && d < 24*time.Hour {
+			add("retention.%s must be 0 (keep) or at least 24h, got %s", name, d)
+		}
+	}
+
+	if c.Web.Port < 0 || c.Web.Port > 65535 {
+		add("web.port must be between 0 and 65535, got %d", c.Web.Port)
+	}
+	if c.Web.LiveBacklog < 0 || c.Web.LiveBacklog > 24*time.Hour {
+		add("web.live_backlog must be between 0 and 24h, got %s", c.Web.LiveBacklog)
+	}
+	if len(c.Web.AdminUsers) > 0 && c.Web.ViewerHeader == "" {
+		add("web.admin_users needs web.viewer_header to recognize the users")
+	}
+	if c.Web.Onboarding {
+		if c.Database.URL == "" {
+			add("web.onboarding needs database.url")
+		}
+		if c.Tibber.AccountsKey == "" {
+			add("web.onboarding needs tibber.accounts_key to store the tokens of members")
+		}
+	}
+
+	if c.Notify.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Notify.SMTPAddr); err != nil {
+			add("notify.smtp_addr must be host:port, got %q", c.Notify.SMTPAddr)
+		}
+		if !strings.Contains(c.Notify.EmailFrom, "@") {
+			add("notify.email_from must be an e-mail address when notify.smtp_addr is set")
+		}
+		if len(c.Notify.EmailTo) == 0 {
+			add("notify.email_to needs at least one address when notify.smtp_addr is set")
+		}
+	}
+	for _, to := range c.Notify.EmailTo {
+		if !strings.Contains(to, "@") {
+			add("notify.email_to: %q is not an e-mail address", to)
+		}
+	}
+	if c.Notify.WebhookURL != "" {
+		checkURL("notify.webhook_url", c.Notify.WebhookURL, "https", "http")
+	}
+
+	// Databestanden moeten bestaan, anders faalt pas een taak uren later
+	files := reflect.ValueOf(c.Files)
+	for i := 0; i < files.NumField(); i++ {
+		path := files.Field(i).String()
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			add("files.%s: %v", files.Type().Field(i).Tag.Get("toml"), err)
+		}
+	}
+
+	if c.DSMR.Source != "" && c.DSMR.HomeID == "" {
+		add("dsmr.home_id is required when dsmr.source is set")
+	}
+	if c.DSMR.Baud <= 0 {
+		add("dsmr.baud must be positive, got %d", c.DSMR.Baud)
+	}
+	if c.DSMR.ReplaySpeed < 0 {
+		add("dsmr.replay_speed must not be negative")
+	}
+
+	if o := c.OpenData; o.Enabled {
+		if c.Database.URL == "" {
+			add("opendata.enabled needs database.url")
+		}
+		if o.Level != "community" && o.Level != "postal_code" {
+			add("opendata.level must be community or postal_code, got %q", o.Level)
+		}
+		if o.Level == "community" && o.Community == "" {
+			add("opendata.community is required for level community")
+		}
+		if o.PostalDigits < 1 || o.PostalDigits > 6 {
+			add("opendata.postal_digits must be between 1 and 6, got %d", o.PostalDigits)
+		}
+		if o.MinHomes < 3 {
+			add("opendata.min_homes must be at least 3, got %d", o.MinHomes)
+		}
+		if o.Epsilon < 0 {
+			add("opendata.epsilon must not be negative")
+		}
+		if o.MaxKWhPerHour <= 0 {
+			add("opendata.max_kwh_per_hour must be positive")
+		}
+		switch o.Format {
+		case "csv", "jsonl", "parquet":
+		default:
+			add("opendata.format must be csv, jsonl or parquet, got %q", o.Format)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Path: c.Path, Problems: problems}
+	}
+	return nil
+}
+
+// lookup finds a setting by its dotted name and returns its value and environment variable
+func (c *Config) lookup(name string) (reflect.Value, string, bool) {
+	v := reflect.ValueOf(c).Elem()
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		t := v.Type()
+		found := false
+		for j := 0; j < t.NumField(); j++ {
+			if t.Field(j).Tag.Get("toml") != part {
+				continue
+			}
+			if i == len(parts)-1 {
+				return v.Field(j), t.Field(j).Tag.Get("env"), true
+			}
+			v = v.Field(j)
+			found = true
+			break
+		}
+		if !found || v.Kind() != reflect.Struct {
+			return reflect.Value{}, "", false
+		}
+	}
+	return reflect.Value{}, "", false
+}
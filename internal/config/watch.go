@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReloadInterval is how often the configuration file is checked for changes
+const ReloadInterval = 30 * time.Second
+
+// Watch reloads the configuration when its file changes or the process gets SIGHUP, until ctx is
+// done. A new configuration is only applied when it is valid; structural settings keep their old
+// value until a restart, the others are passed to apply together with the previous configuration.
+func Watch(ctx context.Context, current *Config, required []string, apply func(old, new *Config)) {
+	if current.Path == "" {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	modified := modTime(current.Path)
+	ticker := time.NewTicker(ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP received, reloading %s", current.Path)
+		case <-ticker.C:
+			m := modTime(current.Path)
+			if m.Equal(modified) {
+				continue
+			}
+			modified = m
+			log.Printf("%s changed, reloading", current.Path)
+		}
+
+		current = reload(current, required, apply)
+	}
+}
+
+// reload reads the file of current again and returns the configuration that applies from now on:
+// the new one when it is valid, otherwise current
+func reload(current *Config, required []string, apply func(old, new *Config)) *Config {
+	next, err := Load(current.Path)
+	if err == nil {
+		if current.Override != nil {
+			next.ApplyOverride(current.Override)
+		}
+		err = next.Validate(required...)
+	}
+	if err != nil {
+		log.Printf("⚠️ Keeping the current configuration: %v", err)
+		return current
+	}
+
+	if changed := Structural(current, next); len(changed) > 0 {
+		log.Printf("⚠️ Restart needed to apply %s", strings.Join(changed, ", "))
+		keepStructural(current, next)
+	}
+	apply(current, next)
+	return next
+}
+
+// keepStructural copies the structural settings of old into new, so that a reload never applies
+// only part of them
+func keepStructural(old, new *Config) {
+	copyStructural(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+}
+
+func copyStructural(from, to reflect.Value) {
+	t := from.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			copyStructural(from.Field(i), to.Field(i))
+		} else if field.Tag.Get("structural") == "true" {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -3,163 +3,597 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"strings"
 )
 
-// InitSchema initializes the database schema
+// schemaLock is the key of the advisory lock that lets one collector or webserver at a time migrate the schema
+const schemaLock = 7242001
+
+// migration is a numbered change of the schema. Statements must be idempotent (IF NOT EXISTS, OR
+// REPLACE): a database from before schema_migrations runs every migration once on existing tables.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are applied in order; a new change of the schema is a new migration at the end
+var migrations = []migration{
+	{1, "create tables", createTables},
+	{2, "upgrade consumption, production and prices of the first schema", append(append(
+		upgradeIntervalTable("consumption"), upgradeIntervalTable("production")...), upgradePrices...)},
+	// De kolommen van de view zijn veranderd, CREATE OR REPLACE kan dat niet
+	{3, "recreate netto_profit per resolution", []string{`DROP VIEW IF EXISTS netto_profit`, nettoProfitView}},
+	// Een versleutelde sleutel past niet in VARCHAR(100); de collector versleutelt de bestaande
+	{4, "room for sealed webhook secrets", []string{`ALTER TABLE webhook_endpoints ALTER COLUMN secret TYPE TEXT`}},
+}
+
+// resetTables are the tables with data from Tibber that ResetSchema drops; the collector fetches them again
+var resetTables = []string{"prices", "consumption", "production", "homes", "owners"}
+
+// InitSchema brings the database schema up to date. Only migrations that are not yet in
+// schema_migrations run, in one transaction, so existing data is kept.
 func InitSchema(db *sql.DB) error {
-	// Drop existing tables in reverse dependency order
-	dropQueries := []string{
-		`DROP TABLE IF EXISTS prices CASCADE;`,
-		`DROP TABLE IF EXISTS consumption CASCADE;`,
-		`DROP TABLE IF EXISTS production CASCADE;`,
-		`DROP TABLE IF EXISTS homes CASCADE;`,
-		`DROP TABLE IF EXISTS owners CASCADE;`,
-		`DROP VIEW IF EXISTS netto_profit CASCADE;`,
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting schema migration: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Execute drop queries
-	for _, query := range dropQueries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("error dropping tables: %w", err)
-		}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, schemaLock); err != nil {
+		return fmt.Errorf("error locking schema: %w", err)
+	}
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
 	}
 
-	// Create tables if they don't exist
-	createQueries := []string{
-		`CREATE TABLE IF NOT EXISTS owners (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			first_name VARCHAR(255),
-			middle_name VARCHAR(255),
-			last_name VARCHAR(255),
-			-- Address fields
-			address_1 VARCHAR(255),
-			address_2 VARCHAR(255),
-			address_3 VARCHAR(255),
-			city VARCHAR(100),
-			postal_code VARCHAR(20),
-			country VARCHAR(50),
-			latitude VARCHAR(20),
-			longitude VARCHAR(20),
-			-- Contact info
-			email VARCHAR(255) NOT NULL UNIQUE,
-			mobile VARCHAR(50),
-			-- Timestamps
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS homes (
-			id VARCHAR(50) PRIMARY KEY,
-			type VARCHAR(20),
-			size INTEGER,
-			app_nickname VARCHAR(100),
-			app_avatar VARCHAR(255),
-			main_fuse_size INTEGER,
-			number_of_residents INTEGER,
-			time_zone VARCHAR(50),
-			-- Address fields
-			address_1 VARCHAR(255),
-			address_2 VARCHAR(255),
-			postal_code VARCHAR(20),
-			city VARCHAR(100),
-			country VARCHAR(50),
-			latitude VARCHAR(20),
-			longitude VARCHAR(20),
-			-- Metering point data
-			consumption_ean VARCHAR(50),
-			grid_company VARCHAR(100),
-			grid_area_code VARCHAR(50),
-			price_area_code VARCHAR(50),
-			production_ean VARCHAR(50),
-			energy_tax_type VARCHAR(50),
-			vat_type VARCHAR(20),
-			estimated_annual_consumption DECIMAL(10,2),
-			-- Features
-			real_time_consumption_enabled BOOLEAN,
-			-- Owner reference
-			owner_id INTEGER REFERENCES owners(id),
-			-- Timestamps
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS consumption (
-			home_id VARCHAR(50),
-			from_date DATE,
-			to_time TIMESTAMP WITH TIME ZONE,
-			consumption DECIMAL(10,2),
-			cost DECIMAL(10,2),
-			currency TEXT,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (home_id, from_date),
-			FOREIGN KEY (home_id) REFERENCES homes(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS production (
-			home_id VARCHAR(50),
-			from_date DATE,
-			to_time TIMESTAMP WITH TIME ZONE,
-			production DECIMAL(10,2),
-			profit DECIMAL(10,2),
-			currency TEXT,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (home_id, from_date),
-			FOREIGN KEY (home_id) REFERENCES homes(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS prices (
-			home_id VARCHAR(50),
-			price_date DATE,
-			hour_of_day INTEGER,
-			total DECIMAL(10,4),
-			energy DECIMAL(10,4),
-			tax DECIMAL(10,4),
-			currency TEXT,
-			level TEXT,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (home_id, price_date, hour_of_day),
-			FOREIGN KEY (home_id) REFERENCES homes(id),
-			CHECK (hour_of_day >= 0 AND hour_of_day < 24)
-		)`,
-		`CREATE TABLE IF NOT EXISTS real_time_measurements (
-			id SERIAL PRIMARY KEY,
-			home_id VARCHAR(50) NOT NULL,
-			timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
-			power DECIMAL(10,2) NOT NULL,
-			power_production DECIMAL(10,2) NOT NULL,
-			min_power DECIMAL(10,2),
-			average_power DECIMAL(10,2),
-			max_power DECIMAL(10,2),
-			max_power_production DECIMAL(10,2),
-			accumulated_consumption DECIMAL(10,2) NOT NULL,
-			accumulated_production DECIMAL(10,2) NOT NULL,
-			last_meter_consumption DECIMAL(10,2),
-			last_meter_production DECIMAL(10,2),
-			current_l1 DECIMAL(10,2),
-			current_l2 DECIMAL(10,2),
-			current_l3 DECIMAL(10,2),
-			voltage_phase1 DECIMAL(10,2),
-			voltage_phase2 DECIMAL(10,2),
-			voltage_phase3 DECIMAL(10,2),
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (home_id) REFERENCES homes(id),
-			UNIQUE (home_id, timestamp)
-		)`,
-		`CREATE OR REPLACE VIEW netto_profit AS
-			SELECT 
-				p.home_id,
-				p.from_date,
-				c.cost,
-				p.profit,
-				-c.cost + p.profit as netto_profit
-			FROM production p
-			JOIN consumption c ON p.home_id = c.home_id AND p.from_date = c.from_date
-			ORDER BY p.home_id, p.from_date DESC`,
+	rows, err := tx.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading schema_migrations: %w", err)
 	}
 
-	// Execute create queries
-	for _, query := range createQueries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("error creating schema: %w", err)
+	var done []string
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		for _, query := range m.statements {
+			if _, err := tx.Exec(query); err != nil {
+				return fmt.Errorf("error in migration %d (%s): %w", m.version, m.description, err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, description) VALUES ($1, $2)`, m.version, m.description); err != nil {
+			return fmt.Errorf("error recording migration %d: %w", m.version, err)
 		}
+		done = append(done, fmt.Sprintf("%d (%s)", m.version, m.description))
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing schema migration: %w", err)
+	}
+	if len(done) > 0 {
+		log.Printf("Applied database migrations %s", strings.Join(done, ", "))
+	}
 	return nil
 }
+
+// ResetSchema drops the Tibber tables and the migration history and then creates the schema again.
+// Everything in those tables is lost; it is only used by collector migrate -reset.
+func ResetSchema(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting schema reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, schemaLock); err != nil {
+		return fmt.Errorf("error locking schema: %w", err)
+	}
+	queries := []string{`DROP VIEW IF EXISTS netto_profit CASCADE`}
+	for _, table := range resetTables {
+		queries = append(queries, fmt.Sprintf(`DROP TABLE IF EXISTS %s CASCADE`, table))
+	}
+	queries = append(queries, `DROP TABLE IF EXISTS schema_migrations`)
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("error dropping tables: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing schema reset: %w", err)
+	}
+	log.Printf("Dropped tables %s", strings.Join(resetTables, ", "))
+
+	return InitSchema(db)
+}
+
+// upgradeIntervalTable adds the columns and key of hourly and daily intervals to consumption or
+// production of the first schema, which had one row per local day. Days become intervals from
+// local midnight.
+func upgradeIntervalTable(table string) []string {
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS resolution VARCHAR(10) NOT NULL DEFAULT 'DAILY'`, table),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS from_time TIMESTAMP WITH TIME ZONE`, table),
+		fmt.Sprintf(`UPDATE %s SET from_time = from_date::timestamp AT TIME ZONE 'Europe/Amsterdam' WHERE from_time IS NULL`, table),
+		fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN from_time SET NOT NULL`, table),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'TIBBER'`, table),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS import_id INTEGER`, table),
+		fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s_pkey`, table, table),
+		fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (home_id, resolution, from_time)`, table),
+	}
+}
+
+// upgradePrices keys prices of the first schema, per local date and hour, on their UTC start
+var upgradePrices = []string{
+	`ALTER TABLE prices ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP WITH TIME ZONE`,
+	`UPDATE prices SET starts_at = (price_date + make_interval(hours => hour_of_day)) AT TIME ZONE 'Europe/Amsterdam'
+		WHERE starts_at IS NULL`,
+	`ALTER TABLE prices ALTER COLUMN starts_at SET NOT NULL`,
+	`ALTER TABLE prices ADD COLUMN IF NOT EXISTS source VARCHAR(20) DEFAULT 'TIBBER'`,
+	`ALTER TABLE prices DROP CONSTRAINT IF EXISTS prices_pkey`,
+	`ALTER TABLE prices ADD PRIMARY KEY (home_id, starts_at)`,
+}
+
+// createTables creates every table as it is now; existing tables are left as they are
+var createTables = []string{
+	`CREATE TABLE IF NOT EXISTS owners (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		first_name VARCHAR(255),
+		middle_name VARCHAR(255),
+		last_name VARCHAR(255),
+		-- Address fields
+		address_1 VARCHAR(255),
+		address_2 VARCHAR(255),
+		address_3 VARCHAR(255),
+		city VARCHAR(100),
+		postal_code VARCHAR(20),
+		country VARCHAR(50),
+		latitude VARCHAR(20),
+		longitude VARCHAR(20),
+		-- Contact info
+		email VARCHAR(255) NOT NULL UNIQUE,
+		mobile VARCHAR(50),
+		-- Timestamps
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS homes (
+		id VARCHAR(50) PRIMARY KEY,
+		type VARCHAR(20),
+		size INTEGER,
+		app_nickname VARCHAR(100),
+		app_avatar VARCHAR(255),
+		main_fuse_size INTEGER,
+		number_of_residents INTEGER,
+		time_zone VARCHAR(50),
+		-- Address fields
+		address_1 VARCHAR(255),
+		address_2 VARCHAR(255),
+		postal_code VARCHAR(20),
+		city VARCHAR(100),
+		country VARCHAR(50),
+		latitude VARCHAR(20),
+		longitude VARCHAR(20),
+		-- Metering point data
+		consumption_ean VARCHAR(50),
+		grid_company VARCHAR(100),
+		grid_area_code VARCHAR(50),
+		price_area_code VARCHAR(50),
+		production_ean VARCHAR(50),
+		energy_tax_type VARCHAR(50),
+		vat_type VARCHAR(20),
+		estimated_annual_consumption DECIMAL(10,2),
+		-- Features
+		real_time_consumption_enabled BOOLEAN,
+		-- Owner reference
+		owner_id INTEGER REFERENCES owners(id),
+		-- Timestamps
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS consumption (
+		home_id VARCHAR(50),
+		resolution VARCHAR(10) NOT NULL DEFAULT 'DAILY',
+		-- from_time is the UTC start of the interval, from_date the local day of the home
+		from_time TIMESTAMP WITH TIME ZONE NOT NULL,
+		from_date DATE,
+		to_time TIMESTAMP WITH TIME ZONE,
+		consumption DECIMAL(10,2),
+		cost DECIMAL(10,2),
+		currency TEXT,
+		-- TIBBER, of IMPORT met de import waar de regel uit komt
+		source VARCHAR(20) NOT NULL DEFAULT 'TIBBER',
+		import_id INTEGER,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (home_id, resolution, from_time),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS production (
+		home_id VARCHAR(50),
+		resolution VARCHAR(10) NOT NULL DEFAULT 'DAILY',
+		-- from_time is the UTC start of the interval, from_date the local day of the home
+		from_time TIMESTAMP WITH TIME ZONE NOT NULL,
+		from_date DATE,
+		to_time TIMESTAMP WITH TIME ZONE,
+		production DECIMAL(10,2),
+		profit DECIMAL(10,2),
+		currency TEXT,
+		-- TIBBER, of IMPORT met de import waar de regel uit komt
+		source VARCHAR(20) NOT NULL DEFAULT 'TIBBER',
+		import_id INTEGER,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (home_id, resolution, from_time),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS prices (
+		home_id VARCHAR(50),
+		-- starts_at is the UTC start of the hour; price_date and hour_of_day are
+		-- derived from it in the home's time zone, so a 25-hour day has two rows for hour 2
+		starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		price_date DATE,
+		hour_of_day INTEGER,
+		total DECIMAL(10,4),
+		energy DECIMAL(10,4),
+		tax DECIMAL(10,4),
+		currency TEXT,
+		level TEXT,
+		source VARCHAR(20) DEFAULT 'TIBBER',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (home_id, starts_at),
+		FOREIGN KEY (home_id) REFERENCES homes(id),
+		CHECK (hour_of_day >= 0 AND hour_of_day < 24)
+	)`,
+	`CREATE TABLE IF NOT EXISTS price_sources (
+		home_id VARCHAR(50) PRIMARY KEY,
+		source VARCHAR(20) NOT NULL DEFAULT 'TIBBER',
+		bidding_zone VARCHAR(20),
+		-- Supplier tariff in EUR/kWh excluding VAT
+		supplier_markup DECIMAL(10,5) DEFAULT 0,
+		energy_tax DECIMAL(10,5) DEFAULT 0,
+		vat_rate DECIMAL(5,4) DEFAULT 0.21,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS price_forecasts (
+		home_id VARCHAR(50) NOT NULL,
+		starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		horizon_hours INTEGER NOT NULL,
+		energy DECIMAL(10,4),
+		total DECIMAL(10,4),
+		-- Filled in once the published price for starts_at is known
+		actual_total DECIMAL(10,4),
+		error DECIMAL(10,4),
+		PRIMARY KEY (home_id, starts_at, issued_at),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS real_time_measurements (
+		id SERIAL PRIMARY KEY,
+		home_id VARCHAR(50) NOT NULL,
+		timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+		power DECIMAL(10,2) NOT NULL,
+		power_production DECIMAL(10,2) NOT NULL,
+		min_power DECIMAL(10,2),
+		average_power DECIMAL(10,2),
+		max_power DECIMAL(10,2),
+		max_power_production DECIMAL(10,2),
+		accumulated_consumption DECIMAL(10,2) NOT NULL,
+		accumulated_production DECIMAL(10,2) NOT NULL,
+		last_meter_consumption DECIMAL(10,2),
+		last_meter_production DECIMAL(10,2),
+		current_l1 DECIMAL(10,2),
+		current_l2 DECIMAL(10,2),
+		current_l3 DECIMAL(10,2),
+		voltage_phase1 DECIMAL(10,2),
+		voltage_phase2 DECIMAL(10,2),
+		voltage_phase3 DECIMAL(10,2),
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (home_id) REFERENCES homes(id),
+		UNIQUE (home_id, timestamp)
+	)`,
+	`CREATE TABLE IF NOT EXISTS baseload_nights (
+		home_id VARCHAR(50) NOT NULL,
+		-- Local date of the morning the night ends on
+		night DATE NOT NULL,
+		min_power DECIMAL(10,2) NOT NULL,
+		samples INTEGER NOT NULL,
+		PRIMARY KEY (home_id, night),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS anomalies (
+		id SERIAL PRIMARY KEY,
+		home_id VARCHAR(50) NOT NULL,
+		kind VARCHAR(30) NOT NULL,
+		severity VARCHAR(10) NOT NULL,
+		period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+		detected_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		value DECIMAL(10,2),
+		expected DECIMAL(10,2),
+		message TEXT NOT NULL,
+		acknowledged BOOLEAN NOT NULL DEFAULT FALSE,
+		FOREIGN KEY (home_id) REFERENCES homes(id),
+		-- Dezelfde afwijking voor dezelfde periode wordt maar één keer vastgelegd
+		UNIQUE (home_id, kind, period_start)
+	)`,
+	`CREATE TABLE IF NOT EXISTS power_quality_daily (
+		home_id VARCHAR(50) NOT NULL,
+		day DATE NOT NULL,
+		samples INTEGER NOT NULL,
+		fuse_size INTEGER,
+		mean_imbalance DECIMAL(6,1),
+		max_imbalance DECIMAL(6,1),
+		max_fuse_utilization DECIMAL(6,1),
+		p95_fuse_utilization DECIMAL(6,1),
+		high_fuse_minutes DECIMAL(8,1),
+		min_voltage DECIMAL(6,1),
+		max_voltage DECIMAL(6,1),
+		undervoltage_count INTEGER NOT NULL DEFAULT 0,
+		overvoltage_minutes DECIMAL(8,1),
+		overvoltage_events INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (home_id, day),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS voltage_events (
+		home_id VARCHAR(50) NOT NULL,
+		phase SMALLINT NOT NULL,
+		started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		ended_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		max_voltage DECIMAL(6,1) NOT NULL,
+		production DECIMAL(10,2),
+		PRIMARY KEY (home_id, phase, started_at),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS curtailment_episodes (
+		home_id VARCHAR(50) NOT NULL,
+		started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		ended_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		max_voltage DECIMAL(6,1) NOT NULL,
+		reference_power DECIMAL(10,2) NOT NULL,
+		lost_kwh DECIMAL(10,3) NOT NULL,
+		price DECIMAL(10,4),
+		lost_revenue DECIMAL(10,2),
+		-- Productie van de buren ten opzichte van het begin, NULL zonder buren
+		peer_ratio DECIMAL(6,2),
+		confidence VARCHAR(10) NOT NULL,
+		PRIMARY KEY (home_id, started_at),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS carbon_intensity (
+		-- Biedzone zoals de price_area_code van een huis, bijvoorbeeld NL
+		zone VARCHAR(20) NOT NULL,
+		starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		intensity DECIMAL(8,2) NOT NULL,
+		source VARCHAR(255),
+		PRIMARY KEY (zone, starts_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS gas_readings (
+		home_id VARCHAR(50) NOT NULL,
+		timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+		reading DECIMAL(12,3) NOT NULL, -- meterstand in m³
+		source VARCHAR(20) NOT NULL DEFAULT 'DSMR',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (home_id, timestamp),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS gas_consumption (
+		home_id VARCHAR(50) NOT NULL,
+		resolution VARCHAR(10) NOT NULL DEFAULT 'DAILY',
+		-- from_time is the UTC start of the interval, from_date the local day of the home
+		from_time TIMESTAMP WITH TIME ZONE NOT NULL,
+		from_date DATE,
+		to_time TIMESTAMP WITH TIME ZONE,
+		consumption DECIMAL(10,3) NOT NULL, -- m³
+		source VARCHAR(20) NOT NULL DEFAULT 'DSMR',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (home_id, resolution, from_time),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS imports (
+		id SERIAL PRIMARY KEY,
+		home_id VARCHAR(50) NOT NULL,
+		filename VARCHAR(255) NOT NULL,
+		profile VARCHAR(50) NOT NULL,
+		ean VARCHAR(50),
+		-- sha256 van het bestand, hetzelfde bestand wordt maar één keer ingelezen
+		checksum VARCHAR(64) NOT NULL,
+		rows INTEGER NOT NULL,
+		duplicates INTEGER NOT NULL DEFAULT 0,
+		stored INTEGER NOT NULL DEFAULT 0,
+		-- Intervallen die al van Tibber kwamen en intervallen van een eerdere import
+		skipped INTEGER NOT NULL DEFAULT 0,
+		replaced INTEGER NOT NULL DEFAULT 0,
+		from_time TIMESTAMP WITH TIME ZONE,
+		to_time TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (home_id, checksum),
+		FOREIGN KEY (home_id) REFERENCES homes(id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS scheduler_jobs (
+		name VARCHAR(50) PRIMARY KEY,
+		schedule VARCHAR(100) NOT NULL,
+		status VARCHAR(10) NOT NULL DEFAULT 'IDLE',
+		-- Run-lock: welke collector de taak uitvoert en sinds wanneer
+		running_by VARCHAR(100),
+		running_since TIMESTAMP WITH TIME ZONE,
+		last_start TIMESTAMP WITH TIME ZONE,
+		last_end TIMESTAMP WITH TIME ZONE,
+		last_duration DECIMAL(10,3),
+		last_error TEXT,
+		next_run TIMESTAMP WITH TIME ZONE,
+		runs INTEGER NOT NULL DEFAULT 0,
+		failures INTEGER NOT NULL DEFAULT 0,
+		-- Gezet om de taak handmatig te starten, de collector wist het bij het starten
+		triggered_at TIMESTAMP WITH TIME ZONE,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS tibber_accounts (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		-- Adres van het lid voor meldingen over het token
+		email VARCHAR(255),
+		-- Token versleuteld met AES-256-GCM (tibber.accounts_key), gebonden aan het account ID
+		token_encrypted TEXT NOT NULL,
+		token_hint VARCHAR(10),
+		status VARCHAR(10) NOT NULL DEFAULT 'ACTIVE',
+		last_checked TIMESTAMP WITH TIME ZONE,
+		last_error TEXT,
+		-- Nieuwe huizen van het account automatisch delen; uit bij aanmelding via de website,
+		-- waar het lid zelf de huizen kiest
+		share_new_homes BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS tibber_account_homes (
+		-- Gedeelde huizen van een account. Geen foreign key naar homes: die tabel wordt bij
+		-- collector migrate -reset opnieuw gevuld
+		home_id VARCHAR(50) PRIMARY KEY,
+		account_id INTEGER NOT NULL REFERENCES tibber_accounts(id) ON DELETE CASCADE,
+		discovered_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		-- Gezet als de historie van het huis is opgehaald, zie de taak onboarding
+		onboarded_at TIMESTAMP WITH TIME ZONE
+	)`,
+	`CREATE TABLE IF NOT EXISTS member_consents (
+		id SERIAL PRIMARY KEY,
+		account_id INTEGER NOT NULL REFERENCES tibber_accounts(id) ON DELETE CASCADE,
+		-- Categorie van gegevens, zie model.Consent*
+		category VARCHAR(50) NOT NULL,
+		granted BOOLEAN NOT NULL,
+		-- Versie van de toestemmingstekst waarmee het lid akkoord ging
+		version VARCHAR(20) NOT NULL,
+		-- Alleen toevoegen: de laatste rij per categorie geldt, eerdere blijven als bewijs
+		recorded_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS data_access_log (
+		id BIGSERIAL PRIMARY KEY,
+		accessed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		-- Gebruikersnaam van de proxy voor het dashboard (web.viewer_header), NULL als onbekend
+		viewer VARCHAR(255),
+		remote_addr VARCHAR(100),
+		-- Geen foreign key: het log blijft staan als het huis opnieuw wordt ingelezen
+		home_id VARCHAR(50) NOT NULL,
+		resource VARCHAR(100) NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS privacy_erasures (
+		id SERIAL PRIMARY KEY,
+		erased_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		-- DELETE of PSEUDONYMIZE; bewust zonder huis ID, naam of adres
+		mode VARCHAR(20) NOT NULL,
+		account_id INTEGER,
+		homes INTEGER NOT NULL,
+		rows BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS open_data (
+		-- Gepubliceerde open data, zie internal/opendata. Eenmaal gepubliceerd verandert een
+		-- rij niet meer, zodat de ruis niet door herhaald opvragen uit te middelen is
+		dataset VARCHAR(30) NOT NULL,
+		area VARCHAR(50) NOT NULL,
+		starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		-- homes of een metriek van de dataset
+		metric VARCHAR(30) NOT NULL,
+		value DECIMAL(14,3) NOT NULL,
+		PRIMARY KEY (dataset, area, starts_at, metric)
+	)`,
+	`CREATE TABLE IF NOT EXISTS open_data_days (
+		dataset VARCHAR(30) NOT NULL,
+		-- Lokale dag in community.time_zone
+		day DATE NOT NULL,
+		level VARCHAR(20) NOT NULL,
+		rows INTEGER NOT NULL,
+		-- Gebieden en uren met te weinig huizen
+		suppressed INTEGER NOT NULL,
+		published_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (dataset, day)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		id SERIAL PRIMARY KEY,
+		-- Lid van het token; NULL voor een token voor één huis van de installatie
+		account_id INTEGER REFERENCES tibber_accounts(id) ON DELETE CASCADE,
+		-- Beperkt het token tot één huis; NULL voor alle gedeelde huizen van het account
+		home_id VARCHAR(50),
+		name VARCHAR(100) NOT NULL,
+		-- SHA-256 van het token; het token zelf wordt niet bewaard
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		token_hint VARCHAR(10),
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		CHECK (account_id IS NOT NULL OR home_id IS NOT NULL)
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_endpoints (
+		id SERIAL PRIMARY KEY,
+		-- Lid van het endpoint, dat alleen gebeurtenissen van de gedeelde huizen krijgt; NULL voor
+		-- de beheerder, die alle huizen krijgt
+		account_id INTEGER REFERENCES tibber_accounts(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		-- Sleutel voor de HMAC-handtekening; de ontvanger heeft dezelfde nodig. Versleuteld met
+		-- tibber.accounts_key en gebonden aan het ID, zie secret.Box
+		secret TEXT NOT NULL,
+		-- Soorten gebeurtenissen, komma-gescheiden, zie webhook.EventTypes
+		events TEXT NOT NULL,
+		description VARCHAR(255),
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_events (
+		id BIGSERIAL PRIMARY KEY,
+		-- Maakt een gebeurtenis uniek, zodat een taak die opnieuw draait hem niet nog eens verstuurt
+		event_key VARCHAR(200) NOT NULL UNIQUE,
+		type VARCHAR(50) NOT NULL,
+		-- Geen foreign key naar homes: die tabel wordt bij collector migrate -reset opnieuw gevuld
+		home_id VARCHAR(50),
+		payload JSONB NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		endpoint_id INTEGER NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+		event_id BIGINT NOT NULL REFERENCES webhook_events(id) ON DELETE CASCADE,
+		-- PENDING, DELIVERED of FAILED
+		status VARCHAR(10) NOT NULL DEFAULT 'PENDING',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP WITH TIME ZONE,
+		last_attempt_at TIMESTAMP WITH TIME ZONE,
+		response_status INTEGER,
+		last_error TEXT,
+		-- Oorspronkelijke aflevering bij een replay uit het beheer
+		replay_of BIGINT,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP WITH TIME ZONE
+	)`,
+}
+
+const nettoProfitView = `CREATE VIEW netto_profit AS
+	SELECT 
+		p.home_id,
+		p.resolution,
+		p.from_time,
+		p.from_date,
+		c.cost,
+		p.profit,
+		-c.cost + p.profit as netto_profit
+	FROM production p
+	JOIN consumption c ON p.home_id = c.home_id
+		AND p.resolution = c.resolution
+		AND p.from_time = c.from_time
+	ORDER BY p.home_id, p.from_time DESC`
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.version, i+1)
+		}
+		if m.description == "" || len(m.statements) == 0 {
+			t.Errorf("migration %d has no description or statements", m.version)
+		}
+	}
+}
+
+func TestMigrationsKeepData(t *testing.T) {
+	// Elke migratie moet ook draaien op een database die de tabellen al heeft
+	allowed := []*regexp.Regexp{
+		regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS \w+ \(`),
+		regexp.MustCompile(`^ALTER TABLE \w+ ADD COLUMN IF NOT EXISTS `),
+		regexp.MustCompile(`^ALTER TABLE \w+ ALTER COLUMN \w+ SET NOT NULL$`),
+		regexp.MustCompile(`^ALTER TABLE \w+ ALTER COLUMN \w+ TYPE TEXT$`),
+		regexp.MustCompile(`^ALTER TABLE (\w+) DROP CONSTRAINT IF EXISTS \w+_pkey$`),
+		regexp.MustCompile(`^ALTER TABLE \w+ ADD PRIMARY KEY \(`),
+		regexp.MustCompile(`^UPDATE \w+ SET .* WHERE \w+ IS NULL$`),
+		regexp.MustCompile(`^DROP VIEW IF EXISTS \w+$`),
+		regexp.MustCompile(`^CREATE VIEW \w+ AS`),
+	}
+
+	for _, m := range migrations {
+		for _, query := range m.statements {
+			q := strings.Join(strings.Fields(query), " ")
+			ok := false
+			for _, re := range allowed {
+				ok = ok || re.MatchString(q)
+			}
+			if !ok || strings.Contains(q, "DROP TABLE") || strings.Contains(q, "DELETE FROM") || strings.Contains(q, "TRUNCATE") {
+				t.Errorf("migration %d: statement is not idempotent or drops data: %.80s", m.version, q)
+			}
+		}
+	}
+}
+
+func TestPrimaryKeyIsAddedBackAfterDrop(t *testing.T) {
+	for _, m := range migrations {
+		dropped := ""
+		for _, query := range m.statements {
+			if strings.Contains(query, "DROP CONSTRAINT IF EXISTS") {
+				dropped = strings.Fields(query)[2]
+				continue
+			}
+			if dropped != "" {
+				if !strings.HasPrefix(query, "ALTER TABLE "+dropped+" ADD PRIMARY KEY") {
+					t.Errorf("migration %d: primary key of %s is not added right after it is dropped", m.version, dropped)
+				}
+				dropped = ""
+			}
+		}
+		if dropped != "" {
+			t.Errorf("migration %d ends without the primary key of %s", m.version, dropped)
+		}
+	}
+}
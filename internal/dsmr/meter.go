@@ -0,0 +1,83 @@
+package dsmr
+
+import (
+	"math"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/tibber"
+)
+
+// Meter turns the successive telegrams of one smart meter into measurements in the shape Tibber Pulse
+// delivers them, including the totals since midnight that the P1 port does not report itself
+type Meter struct {
+	Location *time.Location // Bepaalt wanneer de dag begint, standaard Europe/Amsterdam
+
+	day                      string
+	startImport, startExport float64
+	minPower, maxPower       float64
+	maxProduction            float64
+	powerSum                 float64
+	samples                  int
+}
+
+// NewMeter creates a meter whose days start at midnight in loc
+func NewMeter(loc *time.Location) *Meter {
+	return &Meter{Location: loc}
+}
+
+// Measurement converts a telegram. Telegrams must be passed in order; the first telegram of a day
+// sets the meter readings the accumulated consumption and production count from.
+func (m *Meter) Measurement(t *Telegram) tibber.Measurement {
+	timestamp := t.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	loc := m.Location
+	if loc == nil {
+		loc = localtime.Location("")
+	}
+
+	power := t.PowerImport * 1000
+	production := t.PowerExport * 1000
+
+	if day := timestamp.In(loc).Format("2006-01-02"); day != m.day {
+		m.day = day
+		m.startImport = t.Import()
+		m.startExport = t.Export()
+		m.minPower = power
+		m.maxPower = power
+		m.maxProduction = production
+		m.powerSum = 0
+		m.samples = 0
+	}
+	m.minPower = math.Min(m.minPower, power)
+	m.maxPower = math.Max(m.maxPower, power)
+	m.maxProduction = math.Max(m.maxProduction, production)
+	m.powerSum += power
+	m.samples++
+
+	return tibber.Measurement{
+		Timestamp:              timestamp,
+		Power:                  power,
+		PowerProduction:        production,
+		MinPower:               m.minPower,
+		AveragePower:           math.Round(m.powerSum/float64(m.samples)*10) / 10,
+		MaxPower:               m.maxPower,
+		MaxPowerProduction:     m.maxProduction,
+		AccumulatedConsumption: round3(t.Import() - m.startImport),
+		AccumulatedProduction:  round3(t.Export() - m.startExport),
+		LastMeterConsumption:   t.Import(),
+		LastMeterProduction:    t.Export(),
+		CurrentL1:              t.CurrentL1,
+		CurrentL2:              t.CurrentL2,
+		CurrentL3:              t.CurrentL3,
+		VoltagePhase1:          t.VoltageL1,
+		VoltagePhase2:          t.VoltageL2,
+		VoltagePhase3:          t.VoltageL3,
+	}
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,79 @@
+package dsmr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeterDayRollover(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	// Opeenvolgende telegrammen rond middernacht; de dag begint op lokale tijd, niet in UTC
+	steps := []struct {
+		at             time.Time
+		importT2       float64 // kWh
+		exportT2       float64 // kWh
+		power          float64 // kW
+		accConsumption float64
+		accProduction  float64
+		minPower       float64 // W
+		maxPower       float64 // W
+	}{
+		{time.Date(2025, 1, 15, 23, 50, 0, 0, loc), 1000.000, 500.000, 0.5, 0, 0, 500, 500},
+		{time.Date(2025, 1, 15, 23, 59, 59, 0, loc), 1000.250, 500.010, 2.0, 0.25, 0.01, 500, 2000},
+		{time.Date(2025, 1, 16, 0, 0, 9, 0, loc), 1000.260, 500.010, 1.0, 0, 0, 1000, 1000},
+		{time.Date(2025, 1, 16, 0, 30, 0, 0, loc), 1000.760, 500.010, 0.2, 0.5, 0, 200, 1000},
+		// 01:00 lokaal is middernacht UTC en geen nieuwe dag
+		{time.Date(2025, 1, 16, 1, 0, 0, 0, loc), 1001.010, 500.110, 0.4, 0.75, 0.1, 200, 1000},
+	}
+
+	m := NewMeter(loc)
+	for i, s := range steps {
+		power := s.power
+		tg := &Telegram{Timestamp: s.at, ImportT1: 2000, ImportT2: s.importT2, ExportT2: s.exportT2, PowerImport: power}
+		got := m.Measurement(tg)
+
+		if got.AccumulatedConsumption != s.accConsumption || got.AccumulatedProduction != s.accProduction {
+			t.Errorf("step %d (%s): accumulated %v/%v, want %v/%v", i, s.at.Format(time.RFC3339),
+				got.AccumulatedConsumption, got.AccumulatedProduction, s.accConsumption, s.accProduction)
+		}
+		if got.MinPower != s.minPower || got.MaxPower != s.maxPower {
+			t.Errorf("step %d (%s): min/max power %v/%v, want %v/%v", i, s.at.Format(time.RFC3339),
+				got.MinPower, got.MaxPower, s.minPower, s.maxPower)
+		}
+		if got.LastMeterConsumption != tg.Import() || got.Power != power*1000 {
+			t.Errorf("step %d: meter %v power %v, want %v %v", i, got.LastMeterConsumption, got.Power, tg.Import(), power*1000)
+		}
+	}
+}
+
+func TestMeterFromTelegrams(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	tg, err := Parse(fixture(t, "dsmr50.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMeter(loc)
+	first := m.Measurement(tg)
+	if first.AccumulatedConsumption != 0 || first.LastMeterConsumption != 6.825 || first.Power != 244 {
+		t.Errorf("first measurement = %+v", first)
+	}
+	if first.VoltagePhase3 == nil || *first.VoltagePhase3 != 229 || first.CurrentL3 == nil || *first.CurrentL3 != 0.86 {
+		t.Errorf("phases = %v %v", first.VoltagePhase3, first.CurrentL3)
+	}
+
+	// Tien seconden later op dezelfde dag, 12 Wh verder
+	next := *tg
+	next.Timestamp = tg.Timestamp.Add(10 * time.Second)
+	next.ImportT2 += 0.012
+	if got := m.Measurement(&next).AccumulatedConsumption; got != 0.012 {
+		t.Errorf("accumulated consumption = %v, want 0.012", got)
+	}
+}
@@ -0,0 +1,209 @@
+package dsmr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxTelegramSize protects against a stream without '!', a DSMR 5 telegram is about 1 kB
+const MaxTelegramSize = 16 * 1024
+
+// ReconnectDelay is the wait before a lost serial or TCP connection is opened again
+const ReconnectDelay = 10 * time.Second
+
+// Reader reads telegrams from a P1 stream
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a reader on a serial port, socket or file
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next reads and parses the next telegram. Data before the first '/' (a telegram that was cut off
+// when the stream was opened) is skipped. Telegrams with a wrong checksum return an error wrapping
+// ErrChecksum; the reader can be used again after that.
+func (r *Reader) Next() (*Telegram, error) {
+	// Zoek het begin van een telegram
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '/' {
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('/')
+	for {
+		line, err := r.r.ReadBytes('\n')
+		buf.Write(line)
+		if bytes.HasPrefix(line, []byte("!")) {
+			break
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if buf.Len() > MaxTelegramSize {
+			return nil, fmt.Errorf("dsmr telegram larger than %d bytes", MaxTelegramSize)
+		}
+	}
+
+	return Parse(buf.Bytes())
+}
+
+// Source is where telegrams come from
+type Source struct {
+	Kind    string  // serial, tcp of file
+	Address string  // Apparaat, host:poort of bestandspad
+	Baud    int     // Alleen voor serial, standaard 115200 (DSMR 4 en 5)
+	Speed   float64 // Alleen voor file: afspeelsnelheid, 0 is zo snel mogelijk
+}
+
+// Soorten bronnen
+const (
+	SourceSerial = "serial"
+	SourceTCP    = "tcp"
+	SourceFile   = "file"
+)
+
+// ParseSource interprets a source such as "/dev/ttyUSB0", "tcp://192.168.1.20:8088" or
+// "file:///var/lib/p1/telegrams.txt". speed is the replay speed of files relative to the
+// timestamps in the telegrams. A paced replay behaves like a live meter and moves the timestamps
+// to the present; with speed 0 the file is replayed as fast as possible with its own timestamps.
+func ParseSource(source string, baud int, speed float64) (Source, error) {
+	switch {
+	case source == "":
+		return Source{}, fmt.Errorf("no dsmr source configured")
+	case strings.HasPrefix(source, "tcp://"):
+		return Source{Kind: SourceTCP, Address: strings.TrimPrefix(source, "tcp://")}, nil
+	case strings.HasPrefix(source, "file://"):
+		return Source{Kind: SourceFile, Address: strings.TrimPrefix(source, "file://"), Speed: speed}, nil
+	default:
+		if baud == 0 {
+			baud = 115200
+		}
+		return Source{Kind: SourceSerial, Address: strings.TrimPrefix(source, "serial://"), Baud: baud}, nil
+	}
+}
+
+func (s Source) String() string {
+	return s.Kind + "://" + s.Address
+}
+
+// open opens the stream of the source
+func (s Source) open(ctx context.Context) (io.ReadCloser, error) {
+	switch s.Kind {
+	case SourceTCP:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", s.Address)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to p1 socket %s: %w", s.Address, err)
+		}
+		return conn, nil
+	case SourceFile:
+		f, err := os.Open(s.Address)
+		if err != nil {
+			return nil, fmt.Errorf("error opening p1 replay file: %w", err)
+		}
+		return f, nil
+	default:
+		return openSerial(s.Address, s.Baud)
+	}
+}
+
+// Run reads telegrams from the source and passes them to handle until ctx is done. Serial ports and
+// sockets are reopened when the connection is lost; a replay file ends Run when it is exhausted.
+// Telegrams with a wrong checksum are logged and skipped.
+func Run(ctx context.Context, source Source, handle func(*Telegram)) error {
+	for {
+		err := source.read(ctx, handle)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if source.Kind == SourceFile {
+			return err
+		}
+		log.Printf("P1 source %s lost: %v, reconnecting in %s", source, err, ReconnectDelay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(ReconnectDelay):
+		}
+	}
+}
+
+// read handles the telegrams of one opened stream
+func (s Source) read(ctx context.Context, handle func(*Telegram)) error {
+	stream, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// Sluit de stroom bij annulering zodat een blokkerende read terugkeert
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-done:
+		}
+	}()
+
+	reader := NewReader(stream)
+	var previous time.Time
+	var shift time.Duration
+	for {
+		telegram, err := reader.Next()
+		if errors.Is(err, ErrChecksum) {
+			log.Printf("Skipping P1 telegram: %v", err)
+			continue
+		}
+		if err == io.EOF && s.Kind == SourceFile {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Afspelen in het tempo van de meter
+		if s.Kind == SourceFile && s.Speed > 0 && !previous.IsZero() && telegram.Timestamp.After(previous) {
+			wait := time.Duration(float64(telegram.Timestamp.Sub(previous)) / s.Speed)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+		}
+		if previous.IsZero() && !telegram.Timestamp.IsZero() && s.Kind == SourceFile && s.Speed > 0 {
+			shift = time.Since(telegram.Timestamp)
+		}
+		previous = telegram.Timestamp
+
+		if shift != 0 {
+			telegram.Timestamp = telegram.Timestamp.Add(shift)
+			if telegram.Gas != nil {
+				telegram.Gas.Timestamp = telegram.Gas.Timestamp.Add(shift)
+			}
+		}
+		handle(telegram)
+	}
+}
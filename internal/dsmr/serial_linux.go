@@ -0,0 +1,43 @@
+package dsmr
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openSerial opens the P1 port and sets it to 115200 8N1 (DSMR 4 and 5) or 9600 7E1 (DSMR 2.2 and 3),
+// in raw mode so the telegram reaches the reader unchanged
+func openSerial(device string, baud int) (io.ReadCloser, error) {
+	f, err := os.OpenFile(device, os.O_RDONLY|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening p1 port: %w", err)
+	}
+
+	var speed, frame uint32
+	switch baud {
+	case 115200:
+		speed, frame = syscall.B115200, syscall.CS8
+	case 9600:
+		speed, frame = syscall.B9600, syscall.CS7|syscall.PARENB
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unsupported p1 baud rate %d", baud)
+	}
+
+	termios := syscall.Termios{
+		Cflag:  speed | frame | syscall.CREAD | syscall.CLOCAL,
+		Ispeed: speed,
+		Ospeed: speed,
+	}
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("error configuring p1 port: %w", errno)
+	}
+
+	return f, nil
+}
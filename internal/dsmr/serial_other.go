@@ -0,0 +1,19 @@
+//go:build !linux
+
+package dsmr
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// openSerial opens the P1 port as a file. Outside Linux the port settings are not changed and must
+// be set beforehand, for example with stty.
+func openSerial(device string, baud int) (io.ReadCloser, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, fmt.Errorf("error opening p1 port: %w", err)
+	}
+	return f, nil
+}
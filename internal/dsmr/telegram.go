@@ -0,0 +1,277 @@
+package dsmr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ws/internal/localtime"
+)
+
+// OBIS-codes uit de DSMR P1 companion standard (4.x en 5.x)
+const (
+	obisVersion     = "1-3:0.2.8"
+	obisTimestamp   = "0-0:1.0.0"
+	obisEquipmentId = "0-0:96.1.1"
+	obisImportT1    = "1-0:1.8.1"
+	obisImportT2    = "1-0:1.8.2"
+	obisExportT1    = "1-0:2.8.1"
+	obisExportT2    = "1-0:2.8.2"
+	obisTariff      = "0-0:96.14.0"
+	obisPowerImport = "1-0:1.7.0"
+	obisPowerExport = "1-0:2.7.0"
+	obisVoltageL1   = "1-0:32.7.0"
+	obisVoltageL2   = "1-0:52.7.0"
+	obisVoltageL3   = "1-0:72.7.0"
+	obisCurrentL1   = "1-0:31.7.0"
+	obisCurrentL2   = "1-0:51.7.0"
+	obisCurrentL3   = "1-0:71.7.0"
+
+	// Gasmeter op een M-Bus kanaal (0-1 t/m 0-4): apparaattype 3 en de laatste meterstand.
+	// DSMR 2.2 en 3 geven de stand als 24.3.0 met de waarde op een vervolgregel.
+	obisDeviceType   = ":24.1.0"
+	obisGasReading   = ":24.2.1"
+	obisGasReadingV3 = ":24.3.0"
+	gasDeviceType    = 3
+)
+
+// ErrChecksum is returned for telegrams whose CRC does not match their contents
+var ErrChecksum = errors.New("dsmr telegram checksum mismatch")
+
+// Telegram is one parsed P1 telegram
+type Telegram struct {
+	Header      string    `json:"header"`  // Identificatie van de meter, zonder '/'
+	Version     string    `json:"version"` // DSMR versie, bijvoorbeeld "50"
+	Timestamp   time.Time `json:"timestamp"`
+	EquipmentId string    `json:"equipmentId"`
+	ImportT1    float64   `json:"importT1"` // kWh, laagtarief
+	ImportT2    float64   `json:"importT2"` // kWh, normaaltarief
+	ExportT1    float64   `json:"exportT1"` // kWh
+	ExportT2    float64   `json:"exportT2"` // kWh
+	Tariff      int       `json:"tariff"`
+	PowerImport float64   `json:"powerImport"` // kW
+	PowerExport float64   `json:"powerExport"` // kW
+	VoltageL1   *float64  `json:"voltageL1,omitempty"`
+	VoltageL2   *float64  `json:"voltageL2,omitempty"`
+	VoltageL3   *float64  `json:"voltageL3,omitempty"`
+	CurrentL1   *float64  `json:"currentL1,omitempty"`
+	CurrentL2   *float64  `json:"currentL2,omitempty"`
+	CurrentL3   *float64  `json:"currentL3,omitempty"`
+	Gas         *Gas      `json:"gas,omitempty"`
+
+	// Objects holds the raw values of every OBIS code, also those not mapped above
+	Objects map[string][]string `json:"-"`
+}
+
+// Gas is the last reading of the gas meter, which the meter only updates every 5 minutes (DSMR 5) or hour
+type Gas struct {
+	Timestamp time.Time `json:"timestamp"`
+	M3        float64   `json:"m3"`
+}
+
+// Import is the total meter reading of consumed electricity in kWh
+func (t *Telegram) Import() float64 {
+	return t.ImportT1 + t.ImportT2
+}
+
+// Export is the total meter reading of returned electricity in kWh
+func (t *Telegram) Export() float64 {
+	return t.ExportT1 + t.ExportT2
+}
+
+// Parse parses a complete telegram, from the '/' of the header up to and including the CRC line.
+// DSMR 4 and 5 telegrams end with a CRC16 after the '!', which is verified; older meters send no
+// CRC and are accepted as is.
+func Parse(raw []byte) (*Telegram, error) {
+	start := strings.IndexByte(string(raw), '/')
+	end := strings.LastIndexByte(string(raw), '!')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("incomplete dsmr telegram")
+	}
+
+	crc := strings.TrimSpace(string(raw[end+1:]))
+	if crc != "" {
+		want, err := strconv.ParseUint(crc, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dsmr checksum %q: %w", crc, err)
+		}
+		if got := CRC16(raw[start : end+1]); uint64(got) != want {
+			return nil, fmt.Errorf("%w: got %04X, telegram says %04X", ErrChecksum, got, want)
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(raw[start:end]), "\r\n", "\n"), "\n")
+	t := &Telegram{
+		Header:  strings.TrimPrefix(strings.TrimSpace(lines[0]), "/"),
+		Objects: make(map[string][]string),
+	}
+	var last string
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		open := strings.IndexByte(line, '(')
+		switch {
+		case open == 0 && last != "":
+			// Vervolgregel, zoals de gasmeterstand van DSMR 2.2
+			t.Objects[last] = append(t.Objects[last], values(line)...)
+		case open > 0:
+			last = line[:open]
+			t.Objects[last] = values(line[open:])
+		}
+	}
+
+	if err := t.decode(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// values splits "(a)(b)(c)" into its parts
+func values(s string) []string {
+	var parts []string
+	for len(s) > 0 && s[0] == '(' {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			break
+		}
+		parts = append(parts, s[1:end])
+		s = s[end+1:]
+	}
+	return parts
+}
+
+// decode maps the OBIS objects onto the telegram fields
+func (t *Telegram) decode() error {
+	var err error
+	first := func(code string) (string, bool) {
+		v, ok := t.Objects[code]
+		if !ok || len(v) == 0 {
+			return "", false
+		}
+		return v[len(v)-1], true
+	}
+	number := func(code string, dst *float64) {
+		if s, ok := first(code); ok && err == nil {
+			*dst, err = parseNumber(s)
+			if err != nil {
+				err = fmt.Errorf("dsmr object %s: %w", code, err)
+			}
+		}
+	}
+	optional := func(code string) *float64 {
+		var v float64
+		if _, ok := first(code); !ok {
+			return nil
+		}
+		number(code, &v)
+		return &v
+	}
+
+	t.Version, _ = first(obisVersion)
+	t.EquipmentId, _ = first(obisEquipmentId)
+	if s, ok := first(obisTimestamp); ok {
+		if t.Timestamp, err = parseTimestamp(s); err != nil {
+			return err
+		}
+	}
+	number(obisImportT1, &t.ImportT1)
+	number(obisImportT2, &t.ImportT2)
+	number(obisExportT1, &t.ExportT1)
+	number(obisExportT2, &t.ExportT2)
+	number(obisPowerImport, &t.PowerImport)
+	number(obisPowerExport, &t.PowerExport)
+	if s, ok := first(obisTariff); ok {
+		t.Tariff, _ = strconv.Atoi(s)
+	}
+	t.VoltageL1 = optional(obisVoltageL1)
+	t.VoltageL2 = optional(obisVoltageL2)
+	t.VoltageL3 = optional(obisVoltageL3)
+	t.CurrentL1 = optional(obisCurrentL1)
+	t.CurrentL2 = optional(obisCurrentL2)
+	t.CurrentL3 = optional(obisCurrentL3)
+	if err != nil {
+		return err
+	}
+
+	// Gas: het M-Bus kanaal met apparaattype 3 (DSMR 4 en 5 schrijven 003, DSMR 2.2 gewoon 3)
+	for channel := 1; channel <= 4; channel++ {
+		prefix := fmt.Sprintf("0-%d", channel)
+		device, ok := first(prefix + obisDeviceType)
+		if n, err := strconv.Atoi(device); !ok || err != nil || n != gasDeviceType {
+			continue
+		}
+		reading, ok := t.Objects[prefix+obisGasReading]
+		if !ok {
+			// (tijdstip)(08)(60)(1)(0-1:24.2.1)(m3) met de stand als laatste waarde
+			reading, ok = t.Objects[prefix+obisGasReadingV3]
+		}
+		if !ok || len(reading) < 2 {
+			continue
+		}
+		ts, err := parseTimestamp(reading[0])
+		if err != nil {
+			return fmt.Errorf("dsmr gas reading: %w", err)
+		}
+		m3, err := parseNumber(reading[len(reading)-1])
+		if err != nil {
+			return fmt.Errorf("dsmr gas reading: %w", err)
+		}
+		t.Gas = &Gas{Timestamp: ts, M3: m3}
+		break
+	}
+
+	return nil
+}
+
+// parseNumber parses a value such as "001234.567*kWh" or "230.1*V", ignoring the unit
+func parseNumber(s string) (float64, error) {
+	if i := strings.IndexByte(s, '*'); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseTimestamp parses "YYMMDDhhmmssX", local Dutch time where X is S (summer time) or W (winter time).
+// DSMR 2.2 leaves out the X; those timestamps are read in Europe/Amsterdam.
+func parseTimestamp(s string) (time.Time, error) {
+	if len(s) == 12 {
+		t, err := time.ParseInLocation("060102150405", s, localtime.Location(""))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q: %w", s, err)
+		}
+		return t, nil
+	}
+	if len(s) != 13 {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q", s)
+	}
+	offset := 1
+	switch s[12] {
+	case 'S':
+		offset = 2
+	case 'W':
+	default:
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q", s)
+	}
+	t, err := time.ParseInLocation("060102150405", s[:12], time.FixedZone("", offset*3600))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid dsmr timestamp %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// CRC16 is the checksum of DSMR 4 and 5 telegrams (CRC-16/ARC, polynomial 0xA001 reflected, no initial value)
+func CRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
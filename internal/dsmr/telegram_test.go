@@ -0,0 +1,228 @@
+package dsmr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Echte telegrammen van een DSMR 5 (Iskra), DSMR 4.2 (Kaifa) en DSMR 2.2 (Iskra) meter,
+// met CRLF regeleinden zoals ze van de P1 poort komen
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// withCRC replaces the checksum of a changed telegram by the correct one
+func withCRC(raw []byte) []byte {
+	end := bytes.LastIndexByte(raw, '!')
+	body := raw[:end+1]
+	return append(append([]byte{}, body...), fmt.Sprintf("%04X\r\n", CRC16(body))...)
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestCRC16(t *testing.T) {
+	// Controlewaarde van CRC-16/ARC
+	if got := CRC16([]byte("123456789")); got != 0xBB3D {
+		t.Errorf("CRC16 = %04X, want BB3D", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file               string
+		header, version    string
+		timestamp          string
+		importT1, importT2 float64
+		exportT1, exportT2 float64
+		tariff             int
+		powerImport        float64
+		voltage, current   [3]*float64
+		gasTimestamp       string
+		gasM3              float64
+	}{
+		{
+			file: "dsmr50.txt", header: `ISk5\2MT382-1000`, version: "50",
+			timestamp: "2017-01-02T18:20:02Z",
+			importT1:  4.426, importT2: 2.399, exportT1: 2.444, exportT2: 0,
+			tariff: 2, powerImport: 0.244,
+			voltage:      [3]*float64{float(230), float(230), float(229)},
+			current:      [3]*float64{float(0.48), float(0.44), float(0.86)},
+			gasTimestamp: "2017-01-02T15:10:05Z", gasM3: 0.107,
+		},
+		{
+			// Geen spanningen en een stroom van 0 A op L1
+			file: "dsmr42.txt", header: "KFM5KAIFA-METER", version: "42",
+			timestamp: "2016-11-13T19:57:57Z",
+			importT1:  1581.123, importT2: 1435.706,
+			tariff: 2, powerImport: 2.027,
+			current:      [3]*float64{float(0), float(6), float(2)},
+			gasTimestamp: "2016-11-29T19:00:00Z", gasM3: 981.443,
+		},
+		{
+			// Geen CRC, versie en tijdstip; de gasstand staat op een vervolgregel zonder S/W
+			file: "dsmr22.txt", header: `ISk5\2ME382-1004`,
+			importT1: 185, importT2: 84, exportT1: 13, exportT2: 19,
+			tariff: 1, powerImport: 0.98,
+			gasTimestamp: "2012-05-17T00:00:00Z", gasM3: 124.477,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			tg, err := Parse(fixture(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tg.Header != tt.header || tg.Version != tt.version {
+				t.Errorf("header %q version %q, want %q %q", tg.Header, tg.Version, tt.header, tt.version)
+			}
+			if got := formatTime(tg.Timestamp); got != tt.timestamp {
+				t.Errorf("timestamp = %s, want %s", got, tt.timestamp)
+			}
+			if tg.ImportT1 != tt.importT1 || tg.ImportT2 != tt.importT2 || tg.ExportT1 != tt.exportT1 || tg.ExportT2 != tt.exportT2 {
+				t.Errorf("readings = %v %v %v %v, want %v %v %v %v", tg.ImportT1, tg.ImportT2, tg.ExportT1, tg.ExportT2,
+					tt.importT1, tt.importT2, tt.exportT1, tt.exportT2)
+			}
+			if tg.Tariff != tt.tariff || tg.PowerImport != tt.powerImport {
+				t.Errorf("tariff %d power %v, want %d %v", tg.Tariff, tg.PowerImport, tt.tariff, tt.powerImport)
+			}
+			checkPhases(t, "voltage", [3]*float64{tg.VoltageL1, tg.VoltageL2, tg.VoltageL3}, tt.voltage)
+			checkPhases(t, "current", [3]*float64{tg.CurrentL1, tg.CurrentL2, tg.CurrentL3}, tt.current)
+
+			if tg.Gas == nil {
+				t.Fatal("no gas reading")
+			}
+			if got := formatTime(tg.Gas.Timestamp); got != tt.gasTimestamp || tg.Gas.M3 != tt.gasM3 {
+				t.Errorf("gas = %s %v, want %s %v", got, tg.Gas.M3, tt.gasTimestamp, tt.gasM3)
+			}
+		})
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func checkPhases(t *testing.T, name string, got, want [3]*float64) {
+	t.Helper()
+	for i := range got {
+		switch {
+		case got[i] == nil && want[i] == nil:
+		case got[i] == nil || want[i] == nil:
+			t.Errorf("%s L%d = %v, want %v", name, i+1, got[i], want[i])
+		case *got[i] != *want[i]:
+			t.Errorf("%s L%d = %v, want %v", name, i+1, *got[i], *want[i])
+		}
+	}
+}
+
+func TestParseCorruptedCRC(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		old, new string
+	}{
+		{"changed reading", "dsmr50.txt", "1-0:1.8.1(000004.426*kWh)", "1-0:1.8.1(000004.427*kWh)"},
+		{"changed checksum", "dsmr50.txt", "!6EEE", "!6EEF"},
+		{"dropped line", "dsmr42.txt", "1-0:22.7.0(00.000*kW)\r\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := fixture(t, tt.file)
+			if !bytes.Contains(raw, []byte(tt.old)) {
+				t.Fatalf("%s does not contain %q", tt.file, tt.old)
+			}
+			_, err := Parse(bytes.Replace(raw, []byte(tt.old), []byte(tt.new), 1))
+			if !errors.Is(err, ErrChecksum) {
+				t.Errorf("err = %v, want ErrChecksum", err)
+			}
+		})
+	}
+
+	if _, err := Parse(bytes.Replace(fixture(t, "dsmr50.txt"), []byte("!6EEE"), []byte("!XYZ"), 1)); err == nil || errors.Is(err, ErrChecksum) {
+		t.Errorf("err = %v, want an invalid checksum error", err)
+	}
+}
+
+func TestParseMissingPhase(t *testing.T) {
+	// Een driefasen meter waarvan L2 ontbreekt
+	raw := fixture(t, "dsmr50.txt")
+	for _, line := range []string{"1-0:52.7.0(0230.0*V)\r\n", "1-0:51.7.0(0.44*A)\r\n"} {
+		raw = bytes.Replace(raw, []byte(line), nil, 1)
+	}
+
+	tg, err := Parse(withCRC(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPhases(t, "voltage", [3]*float64{tg.VoltageL1, tg.VoltageL2, tg.VoltageL3}, [3]*float64{float(230), nil, float(229)})
+	checkPhases(t, "current", [3]*float64{tg.CurrentL1, tg.CurrentL2, tg.CurrentL3}, [3]*float64{float(0.48), nil, float(0.86)})
+}
+
+func TestParseNoGasMeter(t *testing.T) {
+	// Een kanaal met een ander apparaattype (warmte) is geen gasmeter
+	raw := bytes.Replace(fixture(t, "dsmr50.txt"), []byte("0-1:24.1.0(003)"), []byte("0-1:24.1.0(004)"), 1)
+	tg, err := Parse(withCRC(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg.Gas != nil {
+		t.Errorf("gas = %+v, want none", tg.Gas)
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	// Het eerste telegram is afgekapt omdat de stroom halverwege werd geopend
+	corrupt := bytes.Replace(fixture(t, "dsmr42.txt"), []byte("001581.123"), []byte("001581.124"), 1)
+	var stream bytes.Buffer
+	stream.WriteString("0(00.244*kW)\r\n1-0:2.7.0(00.000*kW)\r\n!6EEE\r\n")
+	stream.Write(fixture(t, "dsmr50.txt"))
+	stream.Write(corrupt)
+	stream.Write(fixture(t, "dsmr42.txt"))
+	stream.Write(fixture(t, "dsmr22.txt"))
+
+	r := NewReader(&stream)
+	want := []string{"50", "checksum", "42", ""}
+	for i, version := range want {
+		tg, err := r.Next()
+		if version == "checksum" {
+			if !errors.Is(err, ErrChecksum) {
+				t.Fatalf("telegram %d: err = %v, want ErrChecksum", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("telegram %d: %v", i, err)
+		}
+		if tg.Version != version {
+			t.Errorf("telegram %d: version %q, want %q", i, tg.Version, version)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("err = %v after the last telegram, want EOF", err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	raw := fixture(t, "dsmr50.txt")
+	r := NewReader(bytes.NewReader(raw[:len(raw)/2]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package export writes tabular data as CSV, JSON Lines or Parquet for analysis in spreadsheets
+// and notebooks. Writers stream: rows are written as they come, Parquet buffers one row group.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSONL   Format = "jsonl"
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormat accepts a format name or file extension
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "csv", "":
+		return FormatCSV, nil
+	case "jsonl", "ndjson", "json":
+		return FormatJSONL, nil
+	case "parquet":
+		return FormatParquet, nil
+	}
+	return "", fmt.Errorf("unknown export format %q (csv, jsonl or parquet)", s)
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Type is the type of a column
+type Type int
+
+const (
+	String Type = iota
+	Float
+	Int
+	Time // Tijdstip, in Parquet als microseconden sinds 1970 UTC
+	Date // Kalenderdag, als time.Time of "2006-01-02"
+)
+
+// Column is a named, typed column. Values may always be nil.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Writer writes rows with a value per column: string, float64, int64, time.Time or nil
+type Writer interface {
+	Write(row []any) error
+	// Close writes what is buffered and the end of the file, but does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a writer for the format
+func NewWriter(format Format, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// csvWriter writes a header and one line per row; times in RFC3339, decimals with a point
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, col := range columns {
+		c.record[i] = col.Name
+	}
+	if err := c.w.Write(c.record); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(row []any) error {
+	if len(row) != len(c.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(c.columns))
+	}
+	for i, v := range row {
+		s, err := formatValue(c.columns[i], v)
+		if err != nil {
+			return err
+		}
+		c.record[i] = s
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes one JSON object per line with the columns in order
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+func (j *jsonlWriter) Write(row []any) error {
+	if len(row) != len(j.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(j.columns))
+	}
+	j.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		name, _ := json.Marshal(j.columns[i].Name)
+		j.w.Write(name)
+		j.w.WriteByte(':')
+
+		var value []byte
+		var err error
+		switch j.columns[i].Type {
+		case Time, Date:
+			var s string
+			if s, err = formatValue(j.columns[i], v); err == nil && v != nil {
+				value, err = json.Marshal(s)
+			}
+		default:
+			value, err = json.Marshal(v)
+		}
+		if err != nil {
+			return fmt.Errorf("column %s: %w", j.columns[i].Name, err)
+		}
+		if value == nil {
+			value = []byte("null")
+		}
+		j.w.Write(value)
+	}
+	j.w.WriteByte('}')
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+// formatValue converts a value to text for CSV and to the time notation of JSON Lines
+func formatValue(col Column, v any) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case time.Time:
+		if col.Type == Date {
+			return x.Format("2006-01-02"), nil
+		}
+		return x.Format(time.RFC3339), nil
+	}
+	return "", fmt.Errorf("column %s: unsupported value %T", col.Name, v)
+}
@@ -0,0 +1,287 @@
+package export
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// RowGroupSize is the number of rows buffered before a Parquet row group is written
+const RowGroupSize = 50000
+
+// Parquet constanten uit parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetDate            = 6
+	parquetTimestampMicros = 10
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage     = 0
+	parquetUncompressed = 0
+)
+
+// parquetWriter writes an uncompressed Parquet file with optional columns and one PLAIN data page
+// per column per row group. Only the current row group is kept in memory.
+type parquetWriter struct {
+	w       io.Writer
+	columns []Column
+	offset  int64
+
+	// Huidige row group, per kolom de definitieniveaus en de waarden zonder nulls
+	rows   int
+	levels [][]byte
+	values [][]byte
+
+	rowGroups []parquetRowGroup
+	total     int64
+}
+
+type parquetRowGroup struct {
+	rows    int
+	size    int64
+	columns []parquetChunk
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:       w,
+		columns: columns,
+		levels:  make([][]byte, len(columns)),
+		values:  make([][]byte, len(columns)),
+	}
+	if err := p.write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func (p *parquetWriter) Write(row []any) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(p.columns))
+	}
+	for i, v := range row {
+		if v == nil {
+			p.levels[i] = append(p.levels[i], 0)
+			continue
+		}
+		encoded, err := appendPlain(p.values[i], p.columns[i], v)
+		if err != nil {
+			return err
+		}
+		p.values[i] = encoded
+		p.levels[i] = append(p.levels[i], 1)
+	}
+	p.rows++
+	if p.rows >= RowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// appendPlain appends a value in the PLAIN encoding of the physical type of the column
+func appendPlain(buf []byte, col Column, v any) ([]byte, error) {
+	switch col.Type {
+	case String:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("column %s: expected string, got %T", col.Name, v)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+		return append(buf, s...), nil
+	case Float:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("column %s: expected float64, got %T", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case Int:
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("column %s: expected int64, got %T", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint64(buf, uint64(n)), nil
+	case Time:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("column %s: expected time, got %T", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint64(buf, uint64(t.UnixMicro())), nil
+	case Date:
+		var t time.Time
+		switch x := v.(type) {
+		case time.Time:
+			t = time.Date(x.Year(), x.Month(), x.Day(), 0, 0, 0, 0, time.UTC)
+		case string:
+			var err error
+			if t, err = time.Parse("2006-01-02", x); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("column %s: expected date, got %T", col.Name, v)
+		}
+		return binary.LittleEndian.AppendUint32(buf, uint32(t.Unix()/86400)), nil
+	}
+	return nil, fmt.Errorf("column %s: unknown type", col.Name)
+}
+
+// physicalType returns the Parquet type and converted type (or -1) of a column
+func physicalType(t Type) (int32, int32) {
+	switch t {
+	case String:
+		return parquetByteArray, parquetUTF8
+	case Float:
+		return parquetDouble, -1
+	case Int:
+		return parquetInt64, -1
+	case Time:
+		return parquetInt64, parquetTimestampMicros
+	case Date:
+		return parquetInt32, parquetDate
+	}
+	return parquetBoolean, -1
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: p.rows}
+	for i := range p.columns {
+		levels := encodeLevels(p.levels[i])
+		data := make([]byte, 0, 4+len(levels)+len(p.values[i]))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(levels)))
+		data = append(data, levels...)
+		data = append(data, p.values[i]...)
+
+		var header thriftWriter
+		header.beginStruct(0)
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(len(header.buf) + len(data)), values: p.rows}
+		if err := p.write(header.buf); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.size
+
+		p.levels[i] = p.levels[i][:0]
+		p.values[i] = p.values[i][:0]
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// encodeLevels encodes definition levels (0 of 1) in the RLE/bit-packing hybrid as RLE runs
+func encodeLevels(levels []byte) []byte {
+	var out []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		out = append(out, levels[i])
+		i = j
+	}
+	return out
+}
+
+// Close writes the last row group and the file metadata
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.beginStruct(0)
+	meta.i32(1, 1) // version
+
+	meta.listHeader(2, thriftStruct, len(p.columns)+1)
+	meta.beginStruct(0)
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.endStruct()
+	for _, col := range p.columns {
+		typ, converted := physicalType(col.Type)
+		meta.beginStruct(0)
+		meta.i32(1, typ)
+		meta.i32(3, parquetOptional)
+		meta.string(4, col.Name)
+		if converted >= 0 {
+			meta.i32(6, converted)
+		}
+		meta.endStruct()
+	}
+
+	meta.i64(3, p.total)
+
+	meta.listHeader(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		meta.beginStruct(0)
+		meta.listHeader(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			typ, _ := physicalType(p.columns[i].Type)
+			meta.beginStruct(0)
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, typ)
+			meta.i32List(2, []int32{parquetPlain, parquetRLE})
+			meta.stringList(3, []string{p.columns[i].Name})
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, int64(chunk.values))
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, group.size)
+		meta.i64(3, int64(group.rows))
+		meta.endStruct()
+	}
+
+	meta.string(6, "ws export")
+	meta.endStruct()
+
+	footer := binary.LittleEndian.AppendUint32(meta.buf, uint32(len(meta.buf)))
+	return p.write(append(footer, "PAR1"...))
+}
@@ -0,0 +1,258 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+var parquetColumns = []Column{
+	{Name: "home_id", Type: String},
+	{Name: "total", Type: Float},
+	{Name: "hour", Type: Int},
+	{Name: "starts_at", Type: Time},
+	{Name: "date", Type: Date},
+}
+
+// parquetRow returns test row i. Every column has nulls in a pattern of its own, the first row is
+// all nulls and a run of nulls crosses the border of the first row group.
+func parquetRow(i int) []any {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute)
+	row := []any{fmt.Sprintf("home-%d", i%3), float64(i)/4 - 100, int64(i - 1000), start, start}
+	if i%11 == 0 {
+		row[0] = "" // Een lege tekst is geen null
+	}
+	if i%2 == 0 {
+		row[4] = start.Format("2006-01-02")
+	}
+	for c := range row {
+		if i == 0 || (i+c)%(c+3) == 0 || (i >= RowGroupSize-5 && i < RowGroupSize+5) {
+			row[c] = nil
+		}
+	}
+	return row
+}
+
+// parquetFile decodes the footer of a Parquet file
+func parquetFile(t *testing.T, data []byte) thriftFields {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("file does not start and end with PAR1")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-size : len(data)-8]
+	r := &thriftReader{buf: footer}
+	meta, err := r.readStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.pos != len(footer) {
+		t.Errorf("footer has %d bytes after the metadata", len(footer)-r.pos)
+	}
+	return meta
+}
+
+// readChunk decodes the single data page of a column chunk into values, nil for nulls
+func readChunk(t *testing.T, data []byte, col Column, offset, size int64) []any {
+	t.Helper()
+	r := &thriftReader{buf: data[offset : offset+size]}
+	header, err := r.readStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := r.buf[r.pos:]
+	if header[1] != int64(parquetDataPage) || header[2] != int64(len(page)) || header[3] != int64(len(page)) {
+		t.Fatalf("column %s: page header %v for %d bytes", col.Name, header, len(page))
+	}
+	dataPage := header[5].(thriftFields)
+	if dataPage[2] != int64(parquetPlain) || dataPage[3] != int64(parquetRLE) {
+		t.Fatalf("column %s: data page header %v", col.Name, dataPage)
+	}
+	count := int(dataPage[1].(int64))
+
+	// Definitieniveaus: lengte en RLE-runs van (aantal << 1, niveau)
+	length := int(binary.LittleEndian.Uint32(page))
+	levels := &thriftReader{buf: page[4 : 4+length]}
+	var defined []bool
+	for levels.pos < len(levels.buf) {
+		run, err := levels.uvarint()
+		if err != nil || run&1 != 0 {
+			t.Fatalf("column %s: invalid level run", col.Name)
+		}
+		level, _ := levels.byte()
+		for n := uint64(0); n < run>>1; n++ {
+			defined = append(defined, level == 1)
+		}
+	}
+	if len(defined) != count {
+		t.Fatalf("column %s: %d levels for %d values", col.Name, len(defined), count)
+	}
+
+	plain := page[4+length:]
+	values := make([]any, count)
+	for i, ok := range defined {
+		if !ok {
+			continue
+		}
+		switch col.Type {
+		case String:
+			n := binary.LittleEndian.Uint32(plain)
+			values[i] = string(plain[4 : 4+n])
+			plain = plain[4+n:]
+		case Float:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(plain))
+			plain = plain[8:]
+		case Int:
+			values[i] = int64(binary.LittleEndian.Uint64(plain))
+			plain = plain[8:]
+		case Time:
+			values[i] = time.UnixMicro(int64(binary.LittleEndian.Uint64(plain))).UTC()
+			plain = plain[8:]
+		case Date:
+			values[i] = time.Unix(int64(int32(binary.LittleEndian.Uint32(plain)))*86400, 0).UTC()
+			plain = plain[4:]
+		}
+	}
+	if len(plain) != 0 {
+		t.Errorf("column %s: %d bytes after the values", col.Name, len(plain))
+	}
+	return values
+}
+
+// expectedValue is what a written value reads back as
+func expectedValue(col Column, v any) any {
+	switch x := v.(type) {
+	case string:
+		if col.Type == Date {
+			d, _ := time.Parse("2006-01-02", x)
+			return d
+		}
+	case time.Time:
+		if col.Type == Date {
+			return time.Date(x.Year(), x.Month(), x.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return x.UTC()
+	}
+	return v
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	const rows = RowGroupSize + 1234
+
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatParquet, &buf, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		if err := w.Write(parquetRow(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	meta := parquetFile(t, data)
+
+	if meta[1] != int64(1) || meta[3] != int64(rows) || meta[6] != "ws export" {
+		t.Errorf("version %v, %v rows, created by %v", meta[1], meta[3], meta[6])
+	}
+
+	// Schema: de root met het aantal kolommen, dan per kolom type, optional, naam en converted type
+	schema := meta[2].([]any)
+	if len(schema) != len(parquetColumns)+1 {
+		t.Fatalf("schema has %d elements", len(schema))
+	}
+	if root := schema[0].(thriftFields); root[4] != "schema" || root[5] != int64(len(parquetColumns)) {
+		t.Errorf("root = %v", root)
+	}
+	for i, col := range parquetColumns {
+		typ, converted := physicalType(col.Type)
+		element := schema[i+1].(thriftFields)
+		if element[1] != int64(typ) || element[3] != int64(parquetOptional) || element[4] != col.Name {
+			t.Errorf("schema of %s = %v", col.Name, element)
+		}
+		if got, ok := element[6]; (converted >= 0) != ok || (ok && got != int64(converted)) {
+			t.Errorf("converted type of %s = %v, want %d", col.Name, got, converted)
+		}
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("got %d row groups, want 2", len(groups))
+	}
+	offset := int64(4)
+	first := 0
+	for g, wantRows := range []int{RowGroupSize, rows - RowGroupSize} {
+		group := groups[g].(thriftFields)
+		if group[3] != int64(wantRows) {
+			t.Errorf("row group %d has %v rows, want %d", g, group[3], wantRows)
+		}
+		chunks := group[1].([]any)
+		var groupSize int64
+		for c, col := range parquetColumns {
+			chunk := chunks[c].(thriftFields)
+			cm := chunk[3].(thriftFields)
+			size := cm[7].(int64)
+			// Kolommen staan aaneengesloten achter elkaar
+			if chunk[2] != offset || cm[9] != offset || cm[6] != size || cm[5] != int64(wantRows) {
+				t.Fatalf("row group %d column %s: chunk %v at %d", g, col.Name, cm, offset)
+			}
+			if path := cm[3].([]any); len(path) != 1 || path[0] != col.Name {
+				t.Errorf("path of %s = %v", col.Name, path)
+			}
+
+			values := readChunk(t, data, col, offset, size)
+			for i, got := range values {
+				want := expectedValue(col, parquetRow(first + i)[c])
+				if got != want {
+					t.Fatalf("row %d column %s = %#v, want %#v", first+i, col.Name, got, want)
+				}
+			}
+			offset += size
+			groupSize += size
+		}
+		if group[2] != groupSize {
+			t.Errorf("row group %d size %v, want %d", g, group[2], groupSize)
+		}
+		first += wantRows
+	}
+	if footerStart := int64(len(data)) - 8 - int64(binary.LittleEndian.Uint32(data[len(data)-8:])); offset != footerStart {
+		t.Errorf("column chunks end at %d, footer starts at %d", offset, footerStart)
+	}
+}
+
+func TestParquetWriteErrors(t *testing.T) {
+	w, err := NewWriter(FormatParquet, &bytes.Buffer{}, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]any{"a"}); err == nil {
+		t.Error("expected an error for a row with too few values")
+	}
+	if err := w.Write([]any{"a", "1.5", nil, nil, nil}); err == nil {
+		t.Error("expected an error for a string in a float column")
+	}
+	if err := w.Write([]any{nil, nil, nil, nil, "15-01-2025"}); err == nil {
+		t.Error("expected an error for an invalid date")
+	}
+}
+
+func TestParquetEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatParquet, &buf, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta := parquetFile(t, buf.Bytes())
+	if meta[3] != int64(0) || len(meta[4].([]any)) != 0 {
+		t.Errorf("empty file has %v rows in %v", meta[3], meta[4])
+	}
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"encoding/binary"
+)
+
+// Typen van het Thrift compact protocol, waarmee Parquet de metadata codeert
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol. Only the parts that the
+// Parquet metadata needs are supported.
+type thriftWriter struct {
+	buf   []byte
+	last  []int16 // Laatste veld-id per geneste struct
+	field int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.field; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(int64(id))
+	}
+	t.field = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.buf = binary.AppendUvarint(t.buf, uint64((v<<1)^(v>>63)))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftTrue)
+	} else {
+		t.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// beginStruct starts a struct field; id 0 starts a struct that is a list element
+func (t *thriftWriter) beginStruct(id int16) {
+	if id != 0 {
+		t.fieldHeader(id, thriftStruct)
+	}
+	t.last = append(t.last, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0) // stop
+	t.field = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) listHeader(id int16, elem byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xF0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+}
+
+func (t *thriftWriter) i32List(id int16, values []int32) {
+	t.listHeader(id, thriftI32, len(values))
+	for _, v := range values {
+		t.varint(int64(v))
+	}
+}
+
+func (t *thriftWriter) stringList(id int16, values []string) {
+	t.listHeader(id, thriftBinary, len(values))
+	for _, s := range values {
+		t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+		t.buf = append(t.buf, s...)
+	}
+}
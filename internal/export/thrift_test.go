@@ -0,0 +1,165 @@
+package export
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// thriftFields is a decoded struct: values are int64, bool, string, []any or thriftFields
+type thriftFields map[int16]any
+
+// thriftReader decodes the compact protocol that thriftWriter produces, to check the Parquet metadata
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, fmt.Errorf("thrift: unexpected end at %d", r.pos)
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("thrift: invalid varint at %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) value(typ byte) (any, error) {
+	switch typ {
+	case thriftTrue:
+		return true, nil
+	case thriftFalse:
+		return false, nil
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if r.pos+int(n) > len(r.buf) {
+			return nil, fmt.Errorf("thrift: string of %d bytes past the end", n)
+		}
+		s := string(r.buf[r.pos : r.pos+int(n)])
+		r.pos += int(n)
+		return s, nil
+	case thriftList:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]any, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := r.value(header & 0x0F)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("thrift: unsupported type %d at %d", typ, r.pos)
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	fields := make(thriftFields)
+	var id int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			long, err := r.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(long)
+		}
+		if fields[id], err = r.value(header & 0x0F); err != nil {
+			return nil, fmt.Errorf("field %d: %w", id, err)
+		}
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	long := make([]string, 20) // Langer dan 14 elementen: grootte na de lijstkop
+	want := make([]any, len(long))
+	for i := range long {
+		long[i] = fmt.Sprintf("kolom %d", i)
+		want[i] = long[i]
+	}
+
+	var w thriftWriter
+	w.beginStruct(0)
+	w.i32(1, -1)
+	w.i64(2, 1<<40)
+	w.bool(3, true)
+	w.bool(4, false)
+	w.beginStruct(5)
+	w.string(1, "genest")
+	w.i32(40, 7) // Sprong van meer dan 15: lange veldkop
+	w.endStruct()
+	w.i32List(6, []int32{0, 3, -5})
+	w.stringList(7, long)
+	w.listHeader(8, thriftStruct, 2)
+	w.beginStruct(0)
+	w.i32(1, 1)
+	w.endStruct()
+	w.beginStruct(0)
+	w.endStruct()
+	w.string(30, "na de lijst")
+	w.endStruct()
+
+	r := &thriftReader{buf: w.buf}
+	got, err := r.readStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.pos != len(w.buf) {
+		t.Errorf("read %d of %d bytes", r.pos, len(w.buf))
+	}
+
+	expected := thriftFields{
+		1:  int64(-1),
+		2:  int64(1 << 40),
+		3:  true,
+		4:  false,
+		5:  thriftFields{1: "genest", 40: int64(7)},
+		6:  []any{int64(0), int64(3), int64(-5)},
+		7:  want,
+		8:  []any{thriftFields{1: int64(1)}, thriftFields{}},
+		30: "na de lijst",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("decoded %v\nwant %v", got, expected)
+	}
+}
@@ -0,0 +1,55 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Instellingen van de sluipverbruikbepaling
+const (
+	BaseloadNights    = 14 // Aantal recente nachten waarover de mediaan wordt genomen
+	MinBaseloadNights = 3
+	HoursPerYear      = 8760
+)
+
+// Baseload is the always-on consumption of a home with its yearly cost
+type Baseload struct {
+	Watts       float64 `json:"watts"`
+	Nights      int     `json:"nights"`
+	YearlyKWh   float64 `json:"yearlyKwh"`
+	PricePerKWh float64 `json:"pricePerKwh"`
+	YearlyCost  float64 `json:"yearlyCost"`
+}
+
+// EstimateBaseload takes the median of the nightly minimum power in W of the most recent nights
+// (newest last); the median ignores nights where a device happened to be running or the meter was off
+func EstimateBaseload(nightlyMinima []float64, pricePerKWh float64) (*Baseload, error) {
+	var valid []float64
+	for _, v := range nightlyMinima {
+		if v > 0 {
+			valid = append(valid, v)
+		}
+	}
+	if len(valid) > BaseloadNights {
+		valid = valid[len(valid)-BaseloadNights:]
+	}
+	if len(valid) < MinBaseloadNights {
+		return nil, fmt.Errorf("need at least %d nights of measurements, got %d", MinBaseloadNights, len(valid))
+	}
+
+	sort.Float64s(valid)
+	median := valid[len(valid)/2]
+	if len(valid)%2 == 0 {
+		median = (valid[len(valid)/2-1] + valid[len(valid)/2]) / 2
+	}
+
+	yearly := median * HoursPerYear / 1000
+	return &Baseload{
+		Watts:       math.Round(median),
+		Nights:      len(valid),
+		YearlyKWh:   math.Round(yearly),
+		PricePerKWh: round4(pricePerKWh),
+		YearlyCost:  math.Round(yearly*pricePerKWh*100) / 100,
+	}, nil
+}
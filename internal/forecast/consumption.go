@@ -0,0 +1,150 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Instellingen van het verbruiksmodel
+const (
+	HeatingBase       = 15.5 // °C; onder deze buitentemperatuur stijgt het verbruik door verwarming
+	ConsumptionLevel  = 14   // Dagen waarover het recente niveau wordt bepaald
+	MinWeatherSamples = 48
+)
+
+// ConsumptionModel predicts hourly consumption of a home from a weekday/hour profile,
+// scaled to the recent level, plus a heating effect per degree below HeatingBase
+type ConsumptionModel struct {
+	Location *time.Location
+
+	profile     [7][24]float64
+	profileN    [7][24]int
+	hourProfile [24]float64
+	scale       float64 // Verhouding tussen recent verbruik en het profiel
+
+	// HeatingPerDegree is the extra kWh per hour per degree below HeatingBase,
+	// zero when no temperature data was available
+	HeatingPerDegree float64
+	degreeHours      [24]float64 // Gemiddeld aantal graden onder HeatingBase per uur in de historie
+}
+
+// FitConsumption fits a consumption model on hourly history
+func FitConsumption(history []Point, loc *time.Location, weather []Weather) (*ConsumptionModel, error) {
+	if len(history) < MinHistory {
+		return nil, fmt.Errorf("need at least %d hours of consumption history, got %d", MinHistory, len(history))
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	sorted := make([]Point, len(history))
+	copy(sorted, history)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	m := &ConsumptionModel{Location: loc, scale: 1}
+	index := indexWeather(weather)
+
+	// Verwarming: verbruik tegen graaduren, beide na aftrek van hun gemiddelde per uur van de dag,
+	// zodat het dagritme (koud 's nachts, weinig verbruik 's nachts) niet als temperatuureffect telt
+	var sumV, sumX [24]float64
+	var n [24]int
+	for _, p := range sorted {
+		w, ok := index[p.Start.UTC().Truncate(time.Hour)]
+		if !ok {
+			continue
+		}
+		h := p.Start.In(loc).Hour()
+		sumV[h] += p.Value
+		sumX[h] += math.Max(0, HeatingBase-w.Temperature)
+		n[h]++
+	}
+	var sxy, sxx float64
+	var samples int
+	for _, p := range sorted {
+		w, ok := index[p.Start.UTC().Truncate(time.Hour)]
+		if !ok {
+			continue
+		}
+		h := p.Start.In(loc).Hour()
+		x := math.Max(0, HeatingBase-w.Temperature) - sumX[h]/float64(n[h])
+		sxy += x * (p.Value - sumV[h]/float64(n[h]))
+		sxx += x * x
+		samples++
+	}
+	if samples >= MinWeatherSamples && sxx > 0 {
+		m.HeatingPerDegree = math.Max(0, sxy/sxx)
+		for h := 0; h < 24; h++ {
+			if n[h] > 0 {
+				m.degreeHours[h] = sumX[h] / float64(n[h])
+			}
+		}
+	}
+
+	// Profiel per weekdag en uur; de gemiddelde verwarming van de historie zit hierin
+	var hourSum [24]float64
+	var hourN [24]int
+	for _, p := range sorted {
+		local := p.Start.In(loc)
+		v := p.Value
+		m.profile[local.Weekday()][local.Hour()] += v
+		m.profileN[local.Weekday()][local.Hour()]++
+		hourSum[local.Hour()] += v
+		hourN[local.Hour()]++
+	}
+	for h := 0; h < 24; h++ {
+		if hourN[h] > 0 {
+			m.hourProfile[h] = hourSum[h] / float64(hourN[h])
+		}
+		for wd := 0; wd < 7; wd++ {
+			if m.profileN[wd][h] > 0 {
+				m.profile[wd][h] /= float64(m.profileN[wd][h])
+			}
+		}
+	}
+
+	// Niveau: verhouding tussen het verbruik van de laatste dagen en wat het profiel daar voorspelt
+	since := sorted[len(sorted)-1].Start.AddDate(0, 0, -ConsumptionLevel)
+	var actual, expected float64
+	for _, p := range sorted {
+		if p.Start.Before(since) {
+			continue
+		}
+		actual += p.Value
+		expected += m.shape(p.Start)
+	}
+	if expected > 0 {
+		m.scale = actual / expected
+	}
+
+	return m, nil
+}
+
+func (m *ConsumptionModel) shape(t time.Time) float64 {
+	local := t.In(m.Location)
+	if m.profileN[local.Weekday()][local.Hour()] >= 2 {
+		return m.profile[local.Weekday()][local.Hour()]
+	}
+	return m.hourProfile[local.Hour()]
+}
+
+// Predict returns the expected consumption in kWh per hour for [from, from+hours).
+// Hours with a temperature in the weather data are corrected for how much colder or
+// warmer they are than the same hour in the history.
+func (m *ConsumptionModel) Predict(from time.Time, hours int, weather []Weather) []Point {
+	index := indexWeather(weather)
+	from = from.UTC().Truncate(time.Hour)
+
+	points := make([]Point, 0, hours)
+	for i := 0; i < hours; i++ {
+		t := from.Add(time.Duration(i) * time.Hour)
+		value := m.shape(t) * m.scale
+		if w, ok := index[t]; ok && m.HeatingPerDegree > 0 {
+			h := t.In(m.Location).Hour()
+			value += m.HeatingPerDegree * (math.Max(0, HeatingBase-w.Temperature) - m.degreeHours[h])
+		}
+		points = append(points, Point{Start: t, Value: math.Round(math.Max(0, value)*1000) / 1000})
+	}
+	return points
+}
@@ -0,0 +1,130 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateBaseload(t *testing.T) {
+	tests := []struct {
+		name    string
+		minima  []float64
+		watts   float64
+		nights  int
+		yearly  float64
+		cost    float64
+		wantErr bool
+	}{
+		{
+			// Een nacht zonder meting telt niet mee, een nacht met de vaatwasser verschuift de mediaan niet
+			name: "odd number of nights", minima: []float64{0, 150, 90, 100, 400, 110},
+			watts: 110, nights: 5, yearly: 964, cost: 240.90,
+		},
+		{
+			name: "even number of nights", minima: []float64{90, 100, 120, 130},
+			watts: 110, nights: 4, yearly: 964, cost: 240.90,
+		},
+		{
+			// Alleen de laatste 14 nachten: de twee oude nachten van 1000 W vallen weg
+			name: "only recent nights", minima: append([]float64{1000, 1000}, repeat(80, 14)...),
+			watts: 80, nights: 14, yearly: 701, cost: 175.20,
+		},
+		{name: "too few nights", minima: []float64{100, 0, 120}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := EstimateBaseload(tt.minima, 0.25)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := Baseload{Watts: tt.watts, Nights: tt.nights, YearlyKWh: tt.yearly, PricePerKWh: 0.25, YearlyCost: tt.cost}
+		if *got != want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, want)
+		}
+	}
+}
+
+func repeat(v float64, n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
+
+// eveningPeak is a daily consumption profile: 0,5 kWh per hour and 1 kWh from 18:00 to 22:00
+func eveningPeak(t time.Time) float64 {
+	if h := t.Hour(); h >= 18 && h < 22 {
+		return 1
+	}
+	return 0.5
+}
+
+func TestConsumptionFollowsRecentLevel(t *testing.T) {
+	// Vier weken, waarvan de laatste twee met twee keer zoveel verbruik
+	start := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	history := hours(start, 28*24, func(t time.Time) float64 {
+		if t.Sub(start) >= 14*24*time.Hour {
+			return 2 * eveningPeak(t)
+		}
+		return eveningPeak(t)
+	})
+
+	m, err := FitConsumption(history, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HeatingPerDegree != 0 {
+		t.Errorf("heating %v without temperatures", m.HeatingPerDegree)
+	}
+	// Het profiel is het gemiddelde van beide periodes, het niveau schaalt naar de laatste twee weken
+	for _, p := range m.Predict(time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), 24, nil) {
+		if want := 2 * eveningPeak(p.Start); !near(p.Value, want, 0.005) {
+			t.Errorf("%s: %v, want %v", p.Start.Format("15:04"), p.Value, want)
+		}
+	}
+
+	if _, err := FitConsumption(history[:MinHistory-1], time.UTC, nil); err == nil {
+		t.Error("expected an error with too little history")
+	}
+}
+
+func TestConsumptionHeating(t *testing.T) {
+	// Per dag een andere temperatuur; onder 15,5 °C 0,1 kWh per uur per graad
+	start := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	temperature := func(t time.Time) float64 {
+		return 5 + 3*float64(int(t.Sub(start).Hours()/24)%5)
+	}
+	var weather []Weather
+	history := hours(start, 20*24, func(t time.Time) float64 {
+		weather = append(weather, Weather{Start: t, Temperature: temperature(t)})
+		degrees := HeatingBase - temperature(t)
+		if degrees < 0 {
+			degrees = 0
+		}
+		return eveningPeak(t) + 0.1*degrees
+	})
+
+	m, err := FitConsumption(history, time.UTC, weather)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(m.HeatingPerDegree, 0.1, 1e-9) {
+		t.Fatalf("heating %v per degree, want 0.1", m.HeatingPerDegree)
+	}
+
+	// Hetzelfde uur bij 0 en 10 °C scheelt 10 graden
+	at := time.Date(2025, 2, 3, 12, 0, 0, 0, time.UTC)
+	cold := m.Predict(at, 1, []Weather{{Start: at, Temperature: 0}})[0].Value
+	mild := m.Predict(at, 1, []Weather{{Start: at, Temperature: 10}})[0].Value
+	warm := m.Predict(at, 1, []Weather{{Start: at, Temperature: 25}})[0].Value
+	if !near(cold-mild, 1, 0.002) || warm > mild {
+		t.Errorf("0 °C %v, 10 °C %v, 25 °C %v", cold, mild, warm)
+	}
+}
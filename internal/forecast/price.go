@@ -0,0 +1,227 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Point is an hourly value, such as the energy part of a price
+type Point struct {
+	Start time.Time `json:"start"`
+	Value float64   `json:"value"`
+}
+
+// PriceModel predicts hourly prices from history: a recent level, a damped trend,
+// a weekday/hour profile and optionally a linear weather effect
+type PriceModel struct {
+	Location *time.Location
+
+	Level float64 // Gemiddelde van de laatste LevelDays dagen
+	Trend float64 // Verandering van het daggemiddelde per dag
+
+	profile     [7][24]float64 // Afwijking van het daggemiddelde per weekdag en uur
+	hourProfile [24]float64    // Terugval als een weekdag/uur te weinig waarnemingen heeft
+	profileN    [7][24]int
+
+	weather     *weatherEffect
+	lastHistory time.Time
+}
+
+// Instellingen van het model
+const (
+	LevelDays    = 7
+	TrendDays    = 14
+	TrendDamping = 0.5 // Deel van de trend dat per voorspelde dag wordt doorgetrokken
+	MinHistory   = 48  // Minimaal aantal uren historie
+)
+
+// FitPrices fits a price model on hourly history
+func FitPrices(history []Point, loc *time.Location, weather []Weather) (*PriceModel, error) {
+	if len(history) < MinHistory {
+		return nil, fmt.Errorf("need at least %d hours of price history, got %d", MinHistory, len(history))
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	sorted := make([]Point, len(history))
+	copy(sorted, history)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	m := &PriceModel{Location: loc, lastHistory: sorted[len(sorted)-1].Start}
+
+	// Daggemiddelden in lokale dagen
+	type day struct {
+		date  time.Time
+		sum   float64
+		count int
+	}
+	var days []*day
+	byDate := make(map[string]*day)
+	for _, p := range sorted {
+		local := p.Start.In(loc)
+		key := local.Format("2006-01-02")
+		d, ok := byDate[key]
+		if !ok {
+			d = &day{date: time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)}
+			byDate[key] = d
+			days = append(days, d)
+		}
+		d.sum += p.Value
+		d.count++
+	}
+	dayMean := func(t time.Time) float64 {
+		d := byDate[t.In(loc).Format("2006-01-02")]
+		return d.sum / float64(d.count)
+	}
+
+	// Niveau: gemiddelde van de laatste dagen
+	var levelSum float64
+	var levelN int
+	for _, d := range days[max(0, len(days)-LevelDays):] {
+		levelSum += d.sum
+		levelN += d.count
+	}
+	m.Level = levelSum / float64(levelN)
+
+	// Trend: lineaire regressie over de daggemiddelden van de laatste dagen
+	trendDays := days[max(0, len(days)-TrendDays):]
+	if len(trendDays) >= 3 {
+		var sx, sy, sxx, sxy float64
+		n := float64(len(trendDays))
+		for _, d := range trendDays {
+			x := d.date.Sub(trendDays[0].date).Hours() / 24
+			y := d.sum / float64(d.count)
+			sx += x
+			sy += y
+			sxx += x * x
+			sxy += x * y
+		}
+		if den := n*sxx - sx*sx; den != 0 {
+			m.Trend = (n*sxy - sx*sy) / den
+		}
+	}
+
+	// Profiel: afwijking van het daggemiddelde per weekdag en uur
+	var hourSum [24]float64
+	var hourN [24]int
+	for _, p := range sorted {
+		local := p.Start.In(loc)
+		dev := p.Value - dayMean(p.Start)
+		m.profile[local.Weekday()][local.Hour()] += dev
+		m.profileN[local.Weekday()][local.Hour()]++
+		hourSum[local.Hour()] += dev
+		hourN[local.Hour()]++
+	}
+	for h := 0; h < 24; h++ {
+		if hourN[h] > 0 {
+			m.hourProfile[h] = hourSum[h] / float64(hourN[h])
+		}
+		for wd := 0; wd < 7; wd++ {
+			if m.profileN[wd][h] > 0 {
+				m.profile[wd][h] /= float64(m.profileN[wd][h])
+			}
+		}
+	}
+
+	// Weer: wat het profiel niet verklaart proberen we met wind, zon en temperatuur te verklaren
+	if len(weather) > 0 {
+		index := indexWeather(weather)
+		var samples []weatherSample
+		for _, p := range sorted {
+			w, ok := index[p.Start.UTC().Truncate(time.Hour)]
+			if !ok {
+				continue
+			}
+			residual := p.Value - dayMean(p.Start) - m.shape(p.Start)
+			samples = append(samples, weatherSample{w: w, residual: residual})
+		}
+		m.weather = fitWeather(samples)
+	}
+
+	return m, nil
+}
+
+// shape returns the weekday/hour deviation from the daily mean
+func (m *PriceModel) shape(t time.Time) float64 {
+	local := t.In(m.Location)
+	if m.profileN[local.Weekday()][local.Hour()] >= 2 {
+		return m.profile[local.Weekday()][local.Hour()]
+	}
+	return m.hourProfile[local.Hour()]
+}
+
+// Predict returns hourly predictions for [from, from+hours)
+func (m *PriceModel) Predict(from time.Time, hours int, weather []Weather) []Point {
+	index := indexWeather(weather)
+	from = from.UTC().Truncate(time.Hour)
+
+	points := make([]Point, 0, hours)
+	for i := 0; i < hours; i++ {
+		t := from.Add(time.Duration(i) * time.Hour)
+
+		daysAhead := t.Sub(m.lastHistory).Hours() / 24
+		value := m.Level + m.Trend*TrendDamping*math.Max(daysAhead, 0) + m.shape(t)
+
+		if m.weather != nil {
+			if w, ok := index[t]; ok {
+				value += m.weather.apply(w)
+			}
+		}
+
+		points = append(points, Point{Start: t, Value: value})
+	}
+	return points
+}
+
+// Accuracy summarizes the error of forecasts against published prices
+type Accuracy struct {
+	Horizon string  `json:"horizon"` // "0-24", "24-48", "48-72"
+	Count   int     `json:"count"`
+	MAE     float64 `json:"mae"`
+	RMSE    float64 `json:"rmse"`
+	Bias    float64 `json:"bias"` // Positief: voorspelling te hoog
+}
+
+// Evaluation is a single forecast with its realized price
+type Evaluation struct {
+	HorizonHours int
+	Error        float64 // Voorspeld min werkelijk
+}
+
+// Summarize groups evaluations into 24-hour horizon buckets
+func Summarize(evaluations []Evaluation) []Accuracy {
+	buckets := make(map[int]*Accuracy)
+	var keys []int
+	for _, e := range evaluations {
+		bucket := e.HorizonHours / 24
+		a, ok := buckets[bucket]
+		if !ok {
+			a = &Accuracy{Horizon: fmt.Sprintf("%d-%d", bucket*24, (bucket+1)*24)}
+			buckets[bucket] = a
+			keys = append(keys, bucket)
+		}
+		a.Count++
+		a.MAE += math.Abs(e.Error)
+		a.RMSE += e.Error * e.Error
+		a.Bias += e.Error
+	}
+
+	sort.Ints(keys)
+	result := make([]Accuracy, 0, len(keys))
+	for _, k := range keys {
+		a := buckets[k]
+		n := float64(a.Count)
+		a.MAE = round4(a.MAE / n)
+		a.RMSE = round4(math.Sqrt(a.RMSE / n))
+		a.Bias = round4(a.Bias / n)
+		result = append(result, *a)
+	}
+	return result
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
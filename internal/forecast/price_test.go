@@ -0,0 +1,112 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// hours returns n hourly points from start with the value of f
+func hours(start time.Time, n int, f func(t time.Time) float64) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		t := start.Add(time.Duration(i) * time.Hour)
+		points[i] = Point{Start: t, Value: f(t)}
+	}
+	return points
+}
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestPriceProfileFallback(t *testing.T) {
+	// Negen dagen vanaf maandag 2 juni: maandag en dinsdag komen twee keer voor, de andere dagen één keer.
+	// Op maandag schuift 0,24 van middernacht naar 12:00, zodat het daggemiddelde gelijk blijft.
+	start := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	history := hours(start, 9*24, func(t time.Time) float64 {
+		v := 0.10 + 0.01*float64(t.Hour())
+		if t.Weekday() == time.Monday {
+			switch t.Hour() {
+			case 0:
+				v -= 0.24
+			case 12:
+				v += 0.24
+			}
+		}
+		return v
+	})
+
+	m, err := FitPrices(history, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(m.Level, 0.215, 1e-9) || !near(m.Trend, 0, 1e-9) {
+		t.Errorf("level %v trend %v, want 0.215 and 0", m.Level, m.Trend)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		// Maandag en dinsdag hebben twee waarnemingen per uur: hun eigen profiel
+		{"monday profile", time.Date(2025, 6, 16, 12, 0, 0, 0, time.UTC), 0.215 + 0.245},
+		{"tuesday profile", time.Date(2025, 6, 17, 12, 0, 0, 0, time.UTC), 0.215 + 0.005},
+		// Woensdag heeft één waarneming: het gemiddelde van 12:00 over alle dagen, (2 × 0,245 + 7 × 0,005) / 9
+		{"wednesday falls back to hour profile", time.Date(2025, 6, 11, 12, 0, 0, 0, time.UTC), 0.215 + 0.525/9},
+	}
+	for _, tt := range tests {
+		got := m.Predict(tt.at, 1, nil)
+		if len(got) != 1 || !got[0].Start.Equal(tt.at) || !near(got[0].Value, tt.want, 1e-9) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPriceTrendIsDamped(t *testing.T) {
+	// Elke dag 0,01 duurder, zonder dagprofiel
+	start := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	history := hours(start, 14*24, func(t time.Time) float64 {
+		return 0.20 + 0.01*math.Floor(t.Sub(start).Hours()/24)
+	})
+	m, err := FitPrices(history, time.UTC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(m.Trend, 0.01, 1e-9) {
+		t.Fatalf("trend %v, want 0.01", m.Trend)
+	}
+
+	// Niveau van de laatste 7 dagen (0,27 tot 0,33) plus de halve trend per dag na het laatste uur
+	last := history[len(history)-1].Start
+	got := m.Predict(last.Add(48*time.Hour), 1, nil)[0]
+	if want := 0.30 + 0.01*TrendDamping*2; !near(got.Value, want, 1e-9) {
+		t.Errorf("prediction two days ahead %v, want %v", got.Value, want)
+	}
+
+	if _, err := FitPrices(history[:MinHistory-1], time.UTC, nil); err == nil {
+		t.Error("expected an error with too little history")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	got := Summarize([]Evaluation{
+		{HorizonHours: 30, Error: 0.02},
+		{HorizonHours: 1, Error: 0.01},
+		{HorizonHours: 23, Error: -0.03},
+		{HorizonHours: 47, Error: -0.04},
+	})
+	want := []Accuracy{
+		{Horizon: "0-24", Count: 2, MAE: 0.02, RMSE: 0.0224, Bias: -0.01},
+		{Horizon: "24-48", Count: 2, MAE: 0.03, RMSE: 0.0316, Bias: -0.01},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
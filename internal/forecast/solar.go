@@ -0,0 +1,203 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Instellingen van het PV-model
+const (
+	MinSolarHours     = 24   // Minimaal aantal bruikbare uren productiehistorie
+	MinClearSkyKWh    = 0.05 // Uren met minder instraling (kWh/m²) tellen niet mee in de fit
+	CapacityQuantile  = 0.9  // Kwantiel van de verhouding productie/instraling dat als heldere hemel geldt
+	MinSamplesPerHour = 5
+)
+
+// SolarModel predicts hourly PV production of a home from the position of the sun.
+// Capacity is the production in kWh per kWh/m² of clear-sky irradiation, learned per local hour
+// so that the orientation of the panels (east/west) is part of the model.
+type SolarModel struct {
+	Latitude  float64
+	Longitude float64
+	Location  *time.Location
+
+	Capacity  [24]float64
+	Clearness float64 // Gemiddelde verhouding tussen werkelijke en heldere-hemelproductie
+}
+
+// ParseCoordinates parses the latitude and longitude strings of a model.Address
+func ParseCoordinates(latitude, longitude string) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latitude), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude %q", latitude)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(longitude), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude %q", longitude)
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("coordinates out of range: %f, %f", lat, lon)
+	}
+	return lat, lon, nil
+}
+
+// SolarElevation returns the elevation of the sun in degrees (NOAA approximation)
+func SolarElevation(t time.Time, latitude, longitude float64) float64 {
+	t = t.UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	gamma := 2 * math.Pi / 365 * (float64(t.YearDay()-1) + (hour-12)/24)
+
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+
+	trueSolarMinutes := hour*60 + eqTime + 4*longitude
+	hourAngle := (trueSolarMinutes/4 - 180) * math.Pi / 180
+
+	lat := latitude * math.Pi / 180
+	cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(hourAngle)
+	return 90 - math.Acos(math.Max(-1, math.Min(1, cosZenith)))*180/math.Pi
+}
+
+// ClearSkyIrradiance returns the global horizontal irradiance in W/m² under a clear sky (Haurwitz)
+func ClearSkyIrradiance(elevation float64) float64 {
+	if elevation <= 0 {
+		return 0
+	}
+	cosZenith := math.Sin(elevation * math.Pi / 180)
+	return 1098 * cosZenith * math.Exp(-0.057/cosZenith)
+}
+
+// ClearSkyEnergy returns the clear-sky irradiation in kWh/m² for the hour starting at t
+func ClearSkyEnergy(t time.Time, latitude, longitude float64) float64 {
+	const samples = 6
+	var sum float64
+	for i := 0; i < samples; i++ {
+		at := t.Add(time.Duration((float64(i)+0.5)/samples*60) * time.Minute)
+		sum += ClearSkyIrradiance(SolarElevation(at, latitude, longitude))
+	}
+	return sum / samples / 1000
+}
+
+// FitSolar learns a per-home PV model from hourly production history
+func FitSolar(history []Point, latitude, longitude float64, loc *time.Location) (*SolarModel, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	m := &SolarModel{Latitude: latitude, Longitude: longitude, Location: loc}
+
+	type sample struct {
+		hour       int
+		clearSky   float64
+		production float64
+	}
+	var samples []sample
+	var all []float64
+	var byHour [24][]float64
+	for _, p := range history {
+		cs := ClearSkyEnergy(p.Start, latitude, longitude)
+		if cs < MinClearSkyKWh || p.Value < 0 {
+			continue
+		}
+		hour := p.Start.In(loc).Hour()
+		ratio := p.Value / cs
+		samples = append(samples, sample{hour: hour, clearSky: cs, production: p.Value})
+		all = append(all, ratio)
+		byHour[hour] = append(byHour[hour], ratio)
+	}
+	if len(samples) < MinSolarHours {
+		return nil, fmt.Errorf("need at least %d daylight hours of production history, got %d", MinSolarHours, len(samples))
+	}
+
+	// Heldere uren bepalen de capaciteit: een hoog kwantiel van de verhouding per uur
+	global := quantile(all, CapacityQuantile)
+	if global <= 0 {
+		return nil, fmt.Errorf("no production in history")
+	}
+	for h := 0; h < 24; h++ {
+		m.Capacity[h] = global
+		if len(byHour[h]) >= MinSamplesPerHour {
+			if c := quantile(byHour[h], CapacityQuantile); c > 0 {
+				m.Capacity[h] = c
+			}
+		}
+	}
+
+	// Gemiddelde bewolking: hoeveel van de heldere-hemelproductie er werkelijk was
+	var actual, potential float64
+	for _, s := range samples {
+		actual += s.production
+		potential += m.Capacity[s.hour] * s.clearSky
+	}
+	m.Clearness = math.Min(actual/potential, 1)
+
+	return m, nil
+}
+
+// Predict returns the expected production in kWh per hour for [from, from+hours).
+// Hours with irradiance in the weather data use it directly, hours with only cloud cover
+// scale the clear sky (Kasten-Czeplak), other hours use the average clearness.
+func (m *SolarModel) Predict(from time.Time, hours int, weather []Weather) []Point {
+	index := indexWeather(weather)
+	from = from.UTC().Truncate(time.Hour)
+
+	points := make([]Point, 0, hours)
+	for i := 0; i < hours; i++ {
+		t := from.Add(time.Duration(i) * time.Hour)
+		capacity := m.Capacity[t.In(m.Location).Hour()]
+		clearSky := ClearSkyEnergy(t, m.Latitude, m.Longitude)
+
+		var value float64
+		w, ok := index[t]
+		switch {
+		case clearSky == 0:
+		case ok && w.HasIrradiance:
+			value = capacity * w.Irradiance / 1000
+		case ok && w.HasCloudCover:
+			cloud := math.Max(0, math.Min(1, w.CloudCover))
+			value = capacity * clearSky * (1 - 0.75*math.Pow(cloud, 3.4))
+		default:
+			value = capacity * clearSky * m.Clearness
+		}
+
+		points = append(points, Point{Start: t, Value: math.Round(value*1000) / 1000})
+	}
+	return points
+}
+
+// ClearSky returns the production under a clear sky for [from, from+hours)
+func (m *SolarModel) ClearSky(from time.Time, hours int) []Point {
+	from = from.UTC().Truncate(time.Hour)
+	points := make([]Point, 0, hours)
+	for i := 0; i < hours; i++ {
+		t := from.Add(time.Duration(i) * time.Hour)
+		value := m.Capacity[t.In(m.Location).Hour()] * ClearSkyEnergy(t, m.Latitude, m.Longitude)
+		points = append(points, Point{Start: t, Value: math.Round(value*1000) / 1000})
+	}
+	return points
+}
+
+// Total sums the values of points
+func Total(points []Point) float64 {
+	var total float64
+	for _, p := range points {
+		total += p.Value
+	}
+	return math.Round(total*100) / 100
+}
+
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
@@ -0,0 +1,112 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Coördinaten van de tests, ongeveer Utrecht
+const testLatitude, testLongitude = 52.0, 5.0
+
+func TestSolarElevation(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		// Zonnewende: 90 − 52 + 23,44 graden rond de zonnemiddag op 5° oost, 11:40 UTC
+		{"summer solstice noon", time.Date(2025, 6, 21, 11, 40, 0, 0, time.UTC), 61.44},
+		{"winter solstice noon", time.Date(2025, 12, 21, 11, 40, 0, 0, time.UTC), 14.56},
+		{"equinox noon", time.Date(2025, 3, 20, 11, 47, 0, 0, time.UTC), 38},
+	}
+	// De benadering van NOAA zit rond de equinox tot een halve graad naast de werkelijke declinatie
+	for _, tt := range tests {
+		if got := SolarElevation(tt.at, testLatitude, testLongitude); !near(got, tt.want, 0.6) {
+			t.Errorf("%s: elevation %.2f, want %.2f", tt.name, got, tt.want)
+		}
+	}
+	if got := SolarElevation(time.Date(2025, 6, 21, 23, 40, 0, 0, time.UTC), testLatitude, testLongitude); got >= 0 {
+		t.Errorf("sun above the horizon at midnight: %.2f", got)
+	}
+}
+
+func TestClearSky(t *testing.T) {
+	if got, want := ClearSkyIrradiance(90), 1098*math.Exp(-0.057); !near(got, want, 1e-9) {
+		t.Errorf("irradiance with the sun overhead %v, want %v", got, want)
+	}
+	if got := ClearSkyIrradiance(-5); got != 0 {
+		t.Errorf("irradiance below the horizon %v", got)
+	}
+
+	night := ClearSkyEnergy(time.Date(2025, 6, 21, 0, 0, 0, 0, time.UTC), testLatitude, testLongitude)
+	noon := ClearSkyEnergy(time.Date(2025, 6, 21, 11, 0, 0, 0, time.UTC), testLatitude, testLongitude)
+	winter := ClearSkyEnergy(time.Date(2025, 12, 21, 11, 0, 0, 0, time.UTC), testLatitude, testLongitude)
+	// Ongeveer 0,9 kWh/m² rond de middag in juni, 0,22 in december
+	if night != 0 || !near(noon, 0.90, 0.02) || !near(winter, 0.22, 0.02) {
+		t.Errorf("clear-sky energy: night %v, june noon %v, december noon %v", night, noon, winter)
+	}
+}
+
+func TestFitSolarClearSky(t *testing.T) {
+	// Zes dagen: vier helder met 0,8 kWh per kWh/m², twee half bewolkt
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	history := hours(start, 6*24, func(t time.Time) float64 {
+		share := 0.8
+		if t.Day() == 3 || t.Day() == 5 {
+			share = 0.4
+		}
+		return share * ClearSkyEnergy(t, testLatitude, testLongitude)
+	})
+
+	m, err := FitSolar(history, testLatitude, testLongitude, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// De heldere dagen bepalen de capaciteit, de bewolkte de gemiddelde helderheid; die weegt per uur
+	// naar de instraling, die per dag iets verschilt
+	if noon := m.Capacity[11]; !near(noon, 0.8, 1e-9) {
+		t.Errorf("capacity at noon %v, want 0.8", noon)
+	}
+	if want := (4*0.8 + 2*0.4) / (6 * 0.8); !near(m.Clearness, want, 0.001) {
+		t.Errorf("clearness %v, want %v", m.Clearness, want)
+	}
+
+	at := time.Date(2025, 6, 10, 11, 0, 0, 0, time.UTC)
+	clearSky := ClearSkyEnergy(at, testLatitude, testLongitude)
+	weather := []Weather{
+		{Start: at.Add(time.Hour), Irradiance: 500, HasIrradiance: true},
+		{Start: at.Add(2 * time.Hour), CloudCover: 1, HasCloudCover: true},
+	}
+	got := m.Predict(at, 3, weather)
+	want := []float64{
+		0.8 * clearSky * m.Clearness, // Zonder weer: gemiddelde helderheid
+		0.8 * 0.5,                    // Gemeten instraling
+		0.8 * ClearSkyEnergy(at.Add(2*time.Hour), testLatitude, testLongitude) * 0.25, // Geheel bewolkt
+	}
+	for i := range want {
+		if !near(got[i].Value, want[i], 0.001) {
+			t.Errorf("hour %d: %v, want %v", i, got[i].Value, want[i])
+		}
+	}
+	if cs := m.ClearSky(at, 1); !near(cs[0].Value, 0.8*clearSky, 0.001) {
+		t.Errorf("clear sky %v, want %v", cs[0].Value, 0.8*clearSky)
+	}
+	if night := m.Predict(time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC), 1, nil); night[0].Value != 0 {
+		t.Errorf("production at night %v", night[0].Value)
+	}
+}
+
+func TestFitSolarErrors(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	// Eén dag heeft te weinig uren daglicht
+	if _, err := FitSolar(hours(start, 24, func(time.Time) float64 { return 1 }), testLatitude, testLongitude, time.UTC); err == nil {
+		t.Error("expected an error with one day of history")
+	}
+	if _, err := FitSolar(hours(start, 5*24, func(time.Time) float64 { return 0 }), testLatitude, testLongitude, time.UTC); err == nil {
+		t.Error("expected an error without production")
+	}
+	if _, _, err := ParseCoordinates("52.1", "191"); err == nil {
+		t.Error("expected an error for a longitude out of range")
+	}
+}
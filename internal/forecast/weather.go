@@ -0,0 +1,179 @@
+package forecast
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Weather is an hourly weather observation or forecast
+type Weather struct {
+	Start       time.Time `json:"start"`
+	Temperature float64   `json:"temperature"` // °C
+	WindSpeed   float64   `json:"windSpeed"`   // m/s
+	Irradiance  float64   `json:"irradiance"`  // W/m², globale straling (uurgemiddelde)
+	CloudCover  float64   `json:"cloudCover"`  // Bewolkingsgraad 0-1
+
+	HasIrradiance bool `json:"-"`
+	HasCloudCover bool `json:"-"`
+}
+
+// LoadWeather reads an hourly weather file in CSV format with a time column in RFC3339
+// and any of the columns temperature, wind_speed, irradiance and cloud_cover
+func LoadWeather(path string) ([]Weather, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening weather file: %w", err)
+	}
+	defer f.Close()
+
+	return ParseWeather(f)
+}
+
+// ParseWeather parses weather rows in the format of LoadWeather
+func ParseWeather(r io.Reader) ([]Weather, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading weather header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["time"]; !ok {
+		return nil, fmt.Errorf("weather file is missing column %q", "time")
+	}
+	_, hasIrradiance := columns["irradiance"]
+	_, hasCloudCover := columns["cloud_cover"]
+
+	var weather []Weather
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading weather line %d: %w", line, err)
+		}
+
+		start, err := time.Parse(time.RFC3339, record[columns["time"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time: %w", line, err)
+		}
+		w := Weather{
+			Start:         start.UTC().Truncate(time.Hour),
+			HasIrradiance: hasIrradiance,
+			HasCloudCover: hasCloudCover,
+		}
+		for name, target := range map[string]*float64{
+			"temperature": &w.Temperature,
+			"wind_speed":  &w.WindSpeed,
+			"irradiance":  &w.Irradiance,
+			"cloud_cover": &w.CloudCover,
+		} {
+			i, ok := columns[name]
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line, name, err)
+			}
+			*target = v
+		}
+		weather = append(weather, w)
+	}
+
+	return weather, nil
+}
+
+func indexWeather(weather []Weather) map[time.Time]Weather {
+	index := make(map[time.Time]Weather, len(weather))
+	for _, w := range weather {
+		index[w.Start.UTC().Truncate(time.Hour)] = w
+	}
+	return index
+}
+
+type weatherSample struct {
+	w        Weather
+	residual float64
+}
+
+// weatherEffect is a linear model: intercept + temperature, wind and irradiance coefficients
+type weatherEffect struct {
+	coef [4]float64
+}
+
+func (e *weatherEffect) apply(w Weather) float64 {
+	x := weatherFeatures(w)
+	var v float64
+	for i := range x {
+		v += e.coef[i] * x[i]
+	}
+	return v
+}
+
+func weatherFeatures(w Weather) [4]float64 {
+	return [4]float64{1, w.Temperature, w.WindSpeed, w.Irradiance / 100}
+}
+
+// fitWeather solves the least squares normal equations; nil when there is too little data
+func fitWeather(samples []weatherSample) *weatherEffect {
+	if len(samples) < MinHistory {
+		return nil
+	}
+
+	var a [4][5]float64
+	for _, s := range samples {
+		x := weatherFeatures(s.w)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				a[i][j] += x[i] * x[j]
+			}
+			a[i][4] += x[i] * s.residual
+		}
+	}
+	// Kleine ridge-term zodat een constante variabele (geen wind in het bestand) het stelsel niet singulier maakt
+	for i := 1; i < 4; i++ {
+		a[i][i] += 1e-6 * float64(len(samples))
+	}
+
+	// Gauss-eliminatie met pivotering
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := 0; row < 4; row++ {
+			if row == col {
+				continue
+			}
+			f := a[row][col] / a[col][col]
+			for k := col; k < 5; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	e := &weatherEffect{}
+	for i := 0; i < 4; i++ {
+		e.coef[i] = a[i][4] / a[i][i]
+	}
+	return e
+}
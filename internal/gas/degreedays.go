@@ -0,0 +1,135 @@
+package gas
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/forecast"
+)
+
+// HeatingBase is the daily mean temperature below which a day counts degree days (Dutch convention)
+const HeatingBase = 18.0
+
+// MinNormalizationDays is the number of days with weather needed to separate heating from hot water and cooking
+const MinNormalizationDays = 14
+
+// NormalDegreeDays is the long-term average of weighted degree days per month in De Bilt, rounded.
+// A normal year has about 2,750 weighted degree days.
+var NormalDegreeDays = [12]float64{460, 405, 355, 210, 110, 45, 20, 20, 65, 210, 375, 460}
+
+// WeightFactor is the weight of a degree day in a month: in winter more heat is lost through
+// wind and the lack of sun than the temperature alone suggests
+func WeightFactor(month time.Month) float64 {
+	switch month {
+	case time.November, time.December, time.January, time.February:
+		return 1.1
+	case time.March, time.October:
+		return 1.0
+	default:
+		return 0.8
+	}
+}
+
+// DegreeDays is the weighted number of degree days of a day with the given mean temperature
+func DegreeDays(meanTemperature float64, day time.Time) float64 {
+	return math.Max(0, HeatingBase-meanTemperature) * WeightFactor(day.Month())
+}
+
+// DailyDegreeDays computes the weighted degree days per local day ("2006-01-02") from hourly weather.
+// Days with fewer than 20 hours of weather are left out.
+func DailyDegreeDays(weather []forecast.Weather, loc *time.Location) map[string]float64 {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	first := make(map[string]time.Time)
+	for _, w := range weather {
+		local := w.Start.In(loc)
+		day := local.Format("2006-01-02")
+		sums[day] += w.Temperature
+		counts[day]++
+		if _, ok := first[day]; !ok {
+			first[day] = local
+		}
+	}
+
+	degreeDays := make(map[string]float64, len(sums))
+	for day, sum := range sums {
+		if counts[day] < 20 {
+			continue
+		}
+		degreeDays[day] = round2(DegreeDays(sum/float64(counts[day]), first[day]))
+	}
+	return degreeDays
+}
+
+// Day is the gas use of a home on one local day
+type Day struct {
+	Date       string  `json:"date"` // YYYY-MM-DD
+	M3         float64 `json:"m3"`
+	KWh        float64 `json:"kWh"`
+	Cost       float64 `json:"cost"`
+	CO2Kg      float64 `json:"co2Kg"`
+	DegreeDays float64 `json:"degreeDays"`
+	HasWeather bool    `json:"hasWeather"`
+}
+
+// Normalization splits gas use into a part independent of the weather (hot water, cooking) and a
+// part per degree day (heating), and scales it to a year with normal weather
+type Normalization struct {
+	Days         int     `json:"days"`
+	Baseload     float64 `json:"baseload"`     // m³ per dag
+	PerDegreeDay float64 `json:"perDegreeDay"` // m³ per gewogen graaddag
+	NormalYear   float64 `json:"normalYear"`   // m³ in een jaar met normaal weer
+	R2           float64 `json:"r2"`
+}
+
+// Normalize fits m³ = baseload + perDegreeDay × degree days over the days with weather
+func Normalize(days []Day) (*Normalization, error) {
+	var xs, ys []float64
+	for _, d := range days {
+		if d.HasWeather {
+			xs = append(xs, d.DegreeDays)
+			ys = append(ys, d.M3)
+		}
+	}
+	if len(xs) < MinNormalizationDays {
+		return nil, fmt.Errorf("need %d days with weather to normalize gas use, have %d", MinNormalizationDays, len(xs))
+	}
+
+	n := float64(len(xs))
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i] / n
+		meanY += ys[i] / n
+	}
+	var sxx, sxy, syy float64
+	for i := range xs {
+		sxx += (xs[i] - meanX) * (xs[i] - meanX)
+		sxy += (xs[i] - meanX) * (ys[i] - meanY)
+		syy += (ys[i] - meanY) * (ys[i] - meanY)
+	}
+	if sxx == 0 {
+		return nil, fmt.Errorf("degree days do not vary enough to normalize gas use")
+	}
+
+	slope := sxy / sxx
+	if slope < 0 {
+		return nil, fmt.Errorf("gas use does not increase with degree days")
+	}
+	intercept := math.Max(0, meanY-slope*meanX)
+
+	result := &Normalization{
+		Days:         len(xs),
+		Baseload:     round3(intercept),
+		PerDegreeDay: round3(slope),
+	}
+	if syy > 0 {
+		result.R2 = round2(sxy * sxy / (sxx * syy))
+	}
+	var normal float64
+	for _, dd := range NormalDegreeDays {
+		normal += dd
+	}
+	result.NormalYear = math.Round(365*intercept + slope*normal)
+	return result, nil
+}
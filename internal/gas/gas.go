@@ -0,0 +1,136 @@
+package gas
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Omrekenfactoren voor Nederlands aardgas (Groningen-kwaliteit)
+const (
+	CalorificValue = 9.769 // kWh per m³ (bovenwaarde 35,17 MJ/m³)
+	KgCO2PerM3     = 1.78  // CO2-emissiefactor aardgas, kg per m³
+)
+
+// Tariff describes a gas contract. Amounts are in EUR excluding VAT. The supply price is either per m³
+// or, as some suppliers and markets use, per kWh, converted with the calorific value.
+type Tariff struct {
+	Name           string  `json:"name"`
+	PricePerM3     float64 `json:"pricePerM3,omitempty"`
+	PricePerKWh    float64 `json:"pricePerKWh,omitempty"`
+	CalorificValue float64 `json:"calorificValue,omitempty"` // kWh per m³, standaard CalorificValue
+	EnergyTax      float64 `json:"energyTax"`                // Energiebelasting per m³
+	DailyCharges   float64 `json:"dailyCharges"`             // Vaste leveringskosten per dag
+	GridFee        float64 `json:"gridFee"`                  // Netbeheerkosten per dag
+	VatRate        float64 `json:"vatRate"`
+}
+
+// DefaultTariff is a typical variable Dutch gas contract of 2025
+func DefaultTariff() Tariff {
+	return Tariff{
+		Name:         "Variabel 2025",
+		PricePerM3:   0.62,
+		EnergyTax:    0.57816,
+		DailyCharges: 0.22,
+		GridFee:      0.55,
+		VatRate:      0.21,
+	}
+}
+
+// LoadTariff reads a gas tariff from a JSON file
+func LoadTariff(path string) (Tariff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Tariff{}, fmt.Errorf("error reading gas tariff: %w", err)
+	}
+
+	var t Tariff
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Tariff{}, fmt.Errorf("error parsing gas tariff: %w", err)
+	}
+	if t.PricePerM3 == 0 && t.PricePerKWh == 0 {
+		return Tariff{}, fmt.Errorf("gas tariff %s needs a price per m³ or per kWh", t.Name)
+	}
+	return t, nil
+}
+
+// KWh converts m³ to kWh with the calorific value of the tariff
+func (t Tariff) KWh(m3 float64) float64 {
+	value := t.CalorificValue
+	if value == 0 {
+		value = CalorificValue
+	}
+	return m3 * value
+}
+
+// SupplyPrice is the price per m³ excluding taxes
+func (t Tariff) SupplyPrice() float64 {
+	if t.PricePerKWh > 0 {
+		return t.KWh(1) * t.PricePerKWh
+	}
+	return t.PricePerM3
+}
+
+// Cost is the cost including VAT of m3 used over days, with the fixed charges for those days
+func (t Tariff) Cost(m3, days float64) float64 {
+	variable := m3 * (t.SupplyPrice() + t.EnergyTax)
+	fixed := days * (t.DailyCharges + t.GridFee)
+	return round2((variable + fixed) * (1 + t.VatRate))
+}
+
+// CO2 is the emission in kg of burning m3 of natural gas
+func CO2(m3 float64) float64 {
+	return round2(m3 * KgCO2PerM3)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// Summary is the gas use of a home over a period
+type Summary struct {
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	Tariff       string         `json:"tariff"`
+	M3           float64        `json:"m3"`
+	KWh          float64        `json:"kWh"`
+	Cost         float64        `json:"cost"`
+	CO2Kg        float64        `json:"co2Kg"`
+	DegreeDays   float64        `json:"degreeDays"`
+	PerDegreeDay float64        `json:"perDegreeDay"` // m³ per graaddag over de periode, 0 zonder weer
+	Days         []Day          `json:"days"`
+	Normalized   *Normalization `json:"normalized,omitempty"`
+}
+
+// Summarize totals the days of a period
+func Summarize(from, to time.Time, tariff Tariff, days []Day) Summary {
+	s := Summary{From: from, To: to, Tariff: tariff.Name, Days: days}
+	var weatherM3 float64
+	for _, d := range days {
+		s.M3 += d.M3
+		s.Cost += d.Cost
+		if d.HasWeather {
+			s.DegreeDays += d.DegreeDays
+			weatherM3 += d.M3
+		}
+	}
+	if s.DegreeDays > 0 {
+		s.PerDegreeDay = round3(weatherM3 / s.DegreeDays)
+	}
+	s.M3 = round3(s.M3)
+	s.KWh = round2(tariff.KWh(s.M3))
+	s.Cost = round2(s.Cost)
+	s.CO2Kg = CO2(s.M3)
+	s.DegreeDays = round2(s.DegreeDays)
+
+	if n, err := Normalize(days); err == nil {
+		s.Normalized = n
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+package gas
+
+import (
+	"sort"
+	"time"
+
+	"ws/internal/localtime"
+)
+
+// Reading is a cumulative gas meter reading
+type Reading struct {
+	Timestamp time.Time `json:"timestamp"`
+	M3        float64   `json:"m3"`
+}
+
+// Interval is the gas use in [Start, End)
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	M3    float64   `json:"m3"`
+}
+
+// Intervals turns time-ordered meter readings into hourly and daily use from the day of from onwards.
+// The increase between two readings counts for the hour in which the later reading was taken; a
+// decrease (a replaced meter) is skipped.
+func Intervals(readings []Reading, from time.Time, loc *time.Location) (hours, days []Interval) {
+	hourly := make(map[time.Time]float64)
+	for i := 1; i < len(readings); i++ {
+		delta := readings[i].M3 - readings[i-1].M3
+		if delta < 0 {
+			continue
+		}
+		// Een meterstand van 11:00 hoort bij het uur 10:00-11:00
+		hour := readings[i].Timestamp.Add(-time.Nanosecond).Truncate(time.Hour)
+		if hour.Before(from.Truncate(time.Hour)) {
+			continue
+		}
+		hourly[hour] += delta
+	}
+
+	daily := make(map[time.Time]float64)
+	for hour, m3 := range hourly {
+		hours = append(hours, Interval{Start: hour, End: hour.Add(time.Hour), M3: round3(m3)})
+		daily[localtime.StartOfDay(hour, loc)] += m3
+	}
+	for day, m3 := range daily {
+		days = append(days, Interval{Start: day, End: localtime.NextDay(day, loc), M3: round3(m3)})
+	}
+
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Start.Before(hours[j].Start) })
+	sort.Slice(days, func(i, j int) bool { return days[i].Start.Before(days[j].Start) })
+	return hours, days
+}
@@ -0,0 +1,142 @@
+// Package homeassistant describes the sensors of a home in the shape Home Assistant expects, so that
+// its RESTful integration can read them and the energy dashboard can use them.
+package homeassistant
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sleutels van de sensoren; ze maken deel uit van de unique_id en mogen niet veranderen
+const (
+	Power                    = "power"
+	PowerProduction          = "power_production"
+	EnergyConsumption        = "energy_consumption"
+	EnergyProduction         = "energy_production"
+	Price                    = "price"
+	CheapestWindow           = "cheapest_window"
+	SelfSufficiency          = "self_sufficiency"
+	SelfSufficiencyYesterday = "self_sufficiency_yesterday"
+)
+
+// Sensor is the fixed description of a sensor
+type Sensor struct {
+	Key         string
+	Name        string
+	Unit        string
+	DeviceClass string
+	StateClass  string
+	Icon        string
+	// Attributes are the attribute names the generated configuration makes available
+	Attributes []string
+}
+
+// Sensors are the sensors of every home
+var Sensors = []Sensor{
+	{Key: Power, Name: "Vermogen", Unit: "W", DeviceClass: "power", StateClass: "measurement",
+		Attributes: []string{"timestamp"}},
+	{Key: PowerProduction, Name: "Vermogen teruglevering", Unit: "W", DeviceClass: "power", StateClass: "measurement",
+		Attributes: []string{"timestamp"}},
+	// Tibber telt per dag op en begint om middernacht opnieuw; total_increasing vangt dat op
+	{Key: EnergyConsumption, Name: "Afname vandaag", Unit: "kWh", DeviceClass: "energy", StateClass: "total_increasing",
+		Attributes: []string{"timestamp", "meter_reading"}},
+	{Key: EnergyProduction, Name: "Teruglevering vandaag", Unit: "kWh", DeviceClass: "energy", StateClass: "total_increasing",
+		Attributes: []string{"timestamp", "meter_reading"}},
+	// Home Assistant staat de klasse monetary alleen toe bij een totaal, dus een prijs heeft geen
+	// klasse; de munteenheid volgt uit de prijs
+	{Key: Price, Name: "Stroomprijs", Unit: "EUR/kWh", StateClass: "measurement", Icon: "mdi:currency-eur",
+		Attributes: []string{"level", "energy", "tax", "starts_at", "ends_at", "forecast"}},
+	{Key: CheapestWindow, Name: "Goedkoopste blok", DeviceClass: "timestamp", Icon: "mdi:clock-start",
+		Attributes: []string{"end", "hours", "average_price", "forecasted_hours"}},
+	{Key: SelfSufficiency, Name: "Zelfvoorziening gemeenschap", Unit: "%", StateClass: "measurement", Icon: "mdi:home-group",
+		Attributes: []string{"homes", "consumption_w", "production_w"}},
+	{Key: SelfSufficiencyYesterday, Name: "Zelfvoorziening gemeenschap gisteren", Unit: "%", StateClass: "measurement", Icon: "mdi:home-group",
+		Attributes: []string{"date", "homes", "consumption_kwh", "production_kwh"}},
+}
+
+// Find returns the sensor with the given key
+func Find(key string) (Sensor, bool) {
+	for _, s := range Sensors {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Sensor{}, false
+}
+
+// Entity is the state of a sensor of a home, with the fields of a Home Assistant entity. State is
+// nil when the value is unknown, for example without recent live measurements.
+type Entity struct {
+	UniqueID          string                 `json:"unique_id"`
+	Name              string                 `json:"name"`
+	State             interface{}            `json:"state"`
+	UnitOfMeasurement string                 `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string                 `json:"device_class,omitempty"`
+	StateClass        string                 `json:"state_class,omitempty"`
+	Icon              string                 `json:"icon,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes"`
+	LastUpdated       *time.Time             `json:"last_updated,omitempty"`
+}
+
+// UniqueID returns the stable ID of a sensor of a home
+func UniqueID(homeID, key string) string {
+	return "ws_" + strings.ReplaceAll(homeID, "-", "") + "_" + key
+}
+
+// NewEntity returns an entity for a sensor of a home without state
+func (s Sensor) NewEntity(homeID string) Entity {
+	return Entity{
+		UniqueID:          UniqueID(homeID, s.Key),
+		Name:              s.Name,
+		UnitOfMeasurement: s.Unit,
+		DeviceClass:       s.DeviceClass,
+		StateClass:        s.StateClass,
+		Icon:              s.Icon,
+		Attributes:        map[string]interface{}{},
+	}
+}
+
+// Configuration returns a configuration for the RESTful integration of Home Assistant that reads
+// all sensors of a home with one request. The token comes from secrets.yaml under secret.
+func Configuration(baseURL, homeID, name, secret string, scanInterval time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Sensoren van %s; zet in secrets.yaml: %s: \"Bearer <token>\"\n", name, secret)
+	b.WriteString("rest:\n")
+	fmt.Fprintf(&b, "  - resource: %s\n", yamlString(strings.TrimRight(baseURL, "/")+"/ha/v1/homes/"+homeID+"/sensors"))
+	b.WriteString("    headers:\n")
+	fmt.Fprintf(&b, "      Authorization: !secret %s\n", secret)
+	fmt.Fprintf(&b, "    scan_interval: %d\n", int(scanInterval.Seconds()))
+	b.WriteString("    sensor:\n")
+	for _, s := range Sensors {
+		fmt.Fprintf(&b, "      - name: %s\n", yamlString(name+" "+s.Name))
+		fmt.Fprintf(&b, "        unique_id: %s\n", UniqueID(homeID, s.Key))
+		fmt.Fprintf(&b, "        value_template: \"{{ value_json.%s.state }}\"\n", s.Key)
+		fmt.Fprintf(&b, "        availability: \"{{ value_json.%s.state is not none }}\"\n", s.Key)
+		if s.Unit != "" {
+			fmt.Fprintf(&b, "        unit_of_measurement: %s\n", yamlString(s.Unit))
+		}
+		if s.DeviceClass != "" {
+			fmt.Fprintf(&b, "        device_class: %s\n", s.DeviceClass)
+		}
+		if s.StateClass != "" {
+			fmt.Fprintf(&b, "        state_class: %s\n", s.StateClass)
+		}
+		if s.Icon != "" {
+			fmt.Fprintf(&b, "        icon: %s\n", yamlString(s.Icon))
+		}
+		if len(s.Attributes) > 0 {
+			fmt.Fprintf(&b, "        json_attributes_path: \"$.%s.attributes\"\n", s.Key)
+			b.WriteString("        json_attributes:\n")
+			for _, a := range s.Attributes {
+				fmt.Fprintf(&b, "          - %s\n", a)
+			}
+		}
+	}
+	return b.String()
+}
+
+// yamlString quotes a string for YAML
+func yamlString(s string) string {
+	return "\"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + "\""
+}
@@ -0,0 +1,375 @@
+// Package live distributes the live measurements of homes to streaming clients. A Hub keeps the
+// measurements of the last minutes per home for replay, sends new ones to the subscriptions of that
+// home and evicts a client that does not keep up, so that a slow connection never holds up the others.
+package live
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+var (
+	// ErrSlowClient closes a subscription whose queue is full
+	ErrSlowClient = errors.New("client does not keep up with the live measurements")
+	// ErrTooManyClients is returned by Subscribe when MaxClients are connected
+	ErrTooManyClients = errors.New("too many live clients")
+)
+
+// Fields are the fields of a measurement a client can subscribe to, with the JSON names of tibber.Measurement
+var Fields = []string{
+	"power", "powerProduction",
+	"minPower", "averagePower", "maxPower", "maxPowerProduction",
+	"accumulatedConsumption", "accumulatedProduction",
+	"lastMeterConsumption", "lastMeterProduction",
+	"currentL1", "currentL2", "currentL3",
+	"voltagePhase1", "voltagePhase2", "voltagePhase3",
+}
+
+// Groups are shorthands for several fields
+var Groups = map[string][]string{
+	"phases":  {"currentL1", "currentL2", "currentL3"},
+	"voltage": {"voltagePhase1", "voltagePhase2", "voltagePhase3"},
+	"all":     Fields,
+}
+
+// DefaultFields are sent when a client asks for no fields; they are the fields of the dashboard
+var DefaultFields = []string{"power", "powerProduction", "accumulatedConsumption", "accumulatedProduction"}
+
+// ParseFields expands the groups in a list of field names and checks the names. No names gives
+// DefaultFields.
+func ParseFields(names []string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if group, ok := Groups[name]; ok {
+			for _, f := range group {
+				add(f)
+			}
+			continue
+		}
+		if _, ok := fieldValue(tibber.Measurement{}, name); !ok {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s or a group (phases, voltage, all)", name, strings.Join(Fields, ", "))
+		}
+		add(name)
+	}
+	if len(fields) == 0 {
+		return DefaultFields, nil
+	}
+	return fields, nil
+}
+
+// fieldValue returns a field of a measurement; a phase or voltage the meter does not measure is nil
+func fieldValue(m tibber.Measurement, name string) (interface{}, bool) {
+	optional := func(v *float64) interface{} {
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	switch name {
+	case "power":
+		return m.Power, true
+	case "powerProduction":
+		return m.PowerProduction, true
+	case "minPower":
+		return m.MinPower, true
+	case "averagePower":
+		return m.AveragePower, true
+	case "maxPower":
+		return m.MaxPower, true
+	case "maxPowerProduction":
+		return m.MaxPowerProduction, true
+	case "accumulatedConsumption":
+		return m.AccumulatedConsumption, true
+	case "accumulatedProduction":
+		return m.AccumulatedProduction, true
+	case "lastMeterConsumption":
+		return m.LastMeterConsumption, true
+	case "lastMeterProduction":
+		return m.LastMeterProduction, true
+	case "currentL1":
+		return optional(m.CurrentL1), true
+	case "currentL2":
+		return optional(m.CurrentL2), true
+	case "currentL3":
+		return optional(m.CurrentL3), true
+	case "voltagePhase1":
+		return optional(m.VoltagePhase1), true
+	case "voltagePhase2":
+		return optional(m.VoltagePhase2), true
+	case "voltagePhase3":
+		return optional(m.VoltagePhase3), true
+	}
+	return nil, false
+}
+
+// Message is a measurement of a home
+type Message struct {
+	HomeID      string
+	Measurement tibber.Measurement
+}
+
+// Values returns the home, the timestamp and the given fields of a message, for encoding as JSON
+func (m Message) Values(fields []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(fields)+2)
+	values["homeId"] = m.HomeID
+	values["timestamp"] = m.Measurement.Timestamp
+	for _, f := range fields {
+		values[f], _ = fieldValue(m.Measurement, f)
+	}
+	return values
+}
+
+// Hub distributes the measurements per home. Measurements come in with Publish, for example from the
+// Tibber websocket, and with Load set also from the database for homes that have subscribers.
+type Hub struct {
+	// Backlog is how long measurements are kept per home for replay
+	Backlog time.Duration
+	// Queue is how many messages may wait for a client before it is evicted
+	Queue int
+	// MaxClients limits the number of subscriptions, 0 for no limit
+	MaxClients int
+	// Load returns the measurements of a home after a moment, oldest first. With Load set the hub
+	// fills the backlog of a home at its first subscription and polls homes with subscribers every
+	// PollInterval, for homes that the collector measures.
+	Load         func(ctx context.Context, homeID string, since time.Time) ([]tibber.Measurement, error)
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	homes   map[string]*home
+	clients int
+}
+
+// home is the state of one home: its backlog, subscriptions and poller
+type home struct {
+	backlog []tibber.Measurement
+	subs    map[*Subscription]struct{}
+	loaded  bool               // Backlog aangevuld uit Load
+	stop    context.CancelFunc // Stopt het pollen, nil zonder poller
+}
+
+// Subscription receives the measurements of some homes. Replay holds the backlog since the requested
+// moment, oldest first; send it before reading C. C is closed when the subscription ends, Err tells why.
+type Subscription struct {
+	Homes  []string
+	Fields []string
+	Replay []Message
+	C      <-chan Message
+
+	hub    *Hub
+	c      chan Message
+	err    error
+	closed bool
+}
+
+// Err returns why C was closed: ErrSlowClient after an eviction, nil otherwise
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s, nil)
+}
+
+// home returns the state of a home, creating it; h.mu must be held
+func (h *Hub) home(homeID string) *home {
+	if h.homes == nil {
+		h.homes = make(map[string]*home)
+	}
+	hm, ok := h.homes[homeID]
+	if !ok {
+		hm = &home{subs: make(map[*Subscription]struct{})}
+		h.homes[homeID] = hm
+	}
+	return hm
+}
+
+// Publish adds a measurement of a home and sends it to its subscriptions. A measurement that is not
+// newer than the last one of the home is ignored, so that a home can come from several sources.
+func (h *Hub) Publish(homeID string, m tibber.Measurement) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hm := h.home(homeID)
+	if n := len(hm.backlog); n > 0 && !m.Timestamp.After(hm.backlog[n-1].Timestamp) {
+		return
+	}
+	hm.backlog = append(hm.backlog, m)
+	h.trim(hm)
+
+	msg := Message{HomeID: homeID, Measurement: m}
+	for s := range hm.subs {
+		select {
+		case s.c <- msg:
+		default:
+			h.remove(s, ErrSlowClient)
+		}
+	}
+}
+
+// trim drops the measurements that are older than the backlog; h.mu must be held
+func (h *Hub) trim(hm *home) {
+	if len(hm.backlog) == 0 {
+		return
+	}
+	cutoff := hm.backlog[len(hm.backlog)-1].Timestamp.Add(-h.Backlog)
+	i := sort.Search(len(hm.backlog), func(i int) bool { return hm.backlog[i].Timestamp.After(cutoff) })
+	if i > 0 {
+		hm.backlog = append([]tibber.Measurement(nil), hm.backlog[i:]...)
+	}
+}
+
+// Subscribe subscribes to the measurements of homes. The measurements in the backlog after since are
+// in Replay; the backlog and the new measurements join without gaps or duplicates.
+func (h *Hub) Subscribe(ctx context.Context, homes, fields []string, since time.Time) (*Subscription, error) {
+	if len(homes) == 0 {
+		return nil, errors.New("subscribe to at least one home")
+	}
+	if h.Load != nil {
+		for _, homeID := range homes {
+			h.load(ctx, homeID)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxClients > 0 && h.clients >= h.MaxClients {
+		return nil, ErrTooManyClients
+	}
+
+	c := make(chan Message, max(h.Queue, 1))
+	s := &Subscription{Homes: homes, Fields: fields, C: c, hub: h, c: c}
+	for _, homeID := range homes {
+		hm := h.home(homeID)
+		hm.subs[s] = struct{}{}
+		for _, m := range hm.backlog {
+			if m.Timestamp.After(since) {
+				s.Replay = append(s.Replay, Message{HomeID: homeID, Measurement: m})
+			}
+		}
+		if h.Load != nil && h.PollInterval > 0 && hm.stop == nil {
+			pollCtx, cancel := context.WithCancel(context.Background())
+			hm.stop = cancel
+			go h.poll(pollCtx, homeID)
+		}
+	}
+	sort.SliceStable(s.Replay, func(i, j int) bool {
+		return s.Replay[i].Measurement.Timestamp.Before(s.Replay[j].Measurement.Timestamp)
+	})
+	h.clients++
+	return s, nil
+}
+
+// remove ends a subscription and stops the pollers of homes without subscriptions; h.mu must be held
+func (h *Hub) remove(s *Subscription, err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.c)
+	for _, homeID := range s.Homes {
+		hm := h.homes[homeID]
+		delete(hm.subs, s)
+		if len(hm.subs) == 0 && hm.stop != nil {
+			hm.stop()
+			hm.stop = nil
+			// Zonder poller veroudert de backlog; de volgende abonnee laadt hem opnieuw
+			hm.loaded = false
+		}
+	}
+	h.clients--
+}
+
+// load fills the backlog of a home from Load once, merged with what was published meanwhile
+func (h *Hub) load(ctx context.Context, homeID string) {
+	h.mu.Lock()
+	loaded := h.home(homeID).loaded
+	h.mu.Unlock()
+	if loaded {
+		return
+	}
+
+	measurements, err := h.Load(ctx, homeID, time.Now().Add(-h.Backlog))
+	if err != nil {
+		log.Printf("Error loading live backlog of home %s: %v", homeID, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hm := h.home(homeID)
+	if hm.loaded {
+		return
+	}
+	merged := append(measurements, hm.backlog...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	hm.backlog = merged[:0]
+	for _, m := range merged {
+		if n := len(hm.backlog); n == 0 || m.Timestamp.After(hm.backlog[n-1].Timestamp) {
+			hm.backlog = append(hm.backlog, m)
+		}
+	}
+	h.trim(hm)
+	hm.loaded = true
+}
+
+// poll publishes the new measurements of a home from Load until ctx is done. A home whose last
+// measurement is recent, because it is published directly, is not queried.
+func (h *Hub) poll(ctx context.Context, homeID string) {
+	ticker := time.NewTicker(h.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		since := time.Now().Add(-h.Backlog)
+		h.mu.Lock()
+		if hm := h.homes[homeID]; hm != nil && len(hm.backlog) > 0 {
+			since = hm.backlog[len(hm.backlog)-1].Timestamp
+		}
+		h.mu.Unlock()
+		if time.Since(since) < h.PollInterval {
+			continue
+		}
+
+		loadCtx, cancel := context.WithTimeout(ctx, h.PollInterval)
+		measurements, err := h.Load(loadCtx, homeID, since)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error polling live measurements of home %s: %v", homeID, err)
+			}
+			continue
+		}
+		for _, m := range measurements {
+			h.Publish(homeID, m)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+var start = time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+// at returns a measurement n seconds after start
+func at(n int) tibber.Measurement {
+	return tibber.Measurement{Timestamp: start.Add(time.Duration(n) * time.Second), Power: float64(n)}
+}
+
+// receive reads the messages that are waiting on a subscription, until C is empty or closed
+func receive(s *Subscription) (powers []float64, open bool) {
+	for {
+		select {
+		case msg, ok := <-s.C:
+			if !ok {
+				return powers, false
+			}
+			powers = append(powers, msg.Measurement.Power)
+		default:
+			return powers, true
+		}
+	}
+}
+
+func replayed(s *Subscription) []float64 {
+	var powers []float64
+	for _, msg := range s.Replay {
+		powers = append(powers, msg.Measurement.Power)
+	}
+	return powers
+}
+
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEviction(t *testing.T) {
+	h := &Hub{Backlog: time.Minute, Queue: 2, MaxClients: 2}
+	ctx := context.Background()
+	slow, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, start); !errors.Is(err, ErrTooManyClients) {
+		t.Fatalf("third client: %v, want ErrTooManyClients", err)
+	}
+
+	var got []float64
+	for i := 1; i <= 3; i++ {
+		h.Publish("a", at(i))
+		powers, _ := receive(fast)
+		got = append(got, powers...)
+	}
+
+	// De trage client krijgt wat in zijn wachtrij paste en wordt daarna gesloten
+	powers, open := receive(slow)
+	if open || !equal(powers, []float64{1, 2}) || !errors.Is(slow.Err(), ErrSlowClient) {
+		t.Errorf("slow client: %v, open %v, err %v", powers, open, slow.Err())
+	}
+	// De andere client merkt er niets van
+	if !equal(got, []float64{1, 2, 3}) || fast.Err() != nil {
+		t.Errorf("fast client: %v, err %v", got, fast.Err())
+	}
+
+	// De plaats van de trage client is weer vrij; Close na het uitzetten doet niets
+	slow.Close()
+	if _, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, start); err != nil {
+		t.Errorf("subscribe after eviction: %v", err)
+	}
+}
+
+func TestReplayAfterReconnect(t *testing.T) {
+	h := &Hub{Backlog: time.Minute, Queue: 10}
+	ctx := context.Background()
+	for i := 0; i < 90; i += 10 {
+		h.Publish("a", at(i))
+	}
+	// Een oudere of dubbele meting, bijvoorbeeld uit een tweede bron, wordt genegeerd
+	h.Publish("a", at(50))
+
+	// De backlog is de laatste minuut: 30 tot en met 80
+	first, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replayed(first); !equal(got, []float64{30, 40, 50, 60, 70, 80}) {
+		t.Errorf("replay %v", got)
+	}
+	h.Publish("a", at(90))
+	powers, _ := receive(first)
+	if !equal(powers, []float64{90}) {
+		t.Errorf("received %v", powers)
+	}
+
+	// De verbinding valt weg na 90; intussen komen 100 en 110 binnen
+	first.Close()
+	if _, open := receive(first); open || first.Err() != nil {
+		t.Errorf("closed subscription: open %v, err %v", open, first.Err())
+	}
+	h.Publish("a", at(100))
+	h.Publish("a", at(110))
+
+	// Met Last-Event-ID, de tijd van de laatste meting in milliseconden, alleen wat gemist is
+	lastEventID := at(90).Timestamp.UnixMilli()
+	second, err := h.Subscribe(ctx, []string{"a"}, DefaultFields, time.UnixMilli(lastEventID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if got := replayed(second); !equal(got, []float64{100, 110}) {
+		t.Errorf("replay after reconnect %v, want 100 and 110", got)
+	}
+	h.Publish("a", at(120))
+	if powers, _ := receive(second); !equal(powers, []float64{120}) {
+		t.Errorf("received after reconnect %v", powers)
+	}
+}
+
+func TestReplayOfSeveralHomes(t *testing.T) {
+	h := &Hub{Backlog: time.Minute, Queue: 10}
+	h.Publish("a", at(10))
+	h.Publish("b", at(5))
+	h.Publish("a", at(20))
+	h.Publish("b", at(15))
+
+	s, err := h.Subscribe(context.Background(), []string{"a", "b"}, DefaultFields, at(5).Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Op tijd door elkaar, zonder de meting op het moment zelf
+	if got := replayed(s); !equal(got, []float64{10, 15, 20}) {
+		t.Errorf("replay %v", got)
+	}
+}
+
+func TestCloseStopsPolling(t *testing.T) {
+	var mu sync.Mutex
+	var loads, polls int
+	h := &Hub{
+		Backlog:      time.Hour,
+		Queue:        10,
+		PollInterval: 10 * time.Millisecond,
+		Load: func(_ context.Context, homeID string, since time.Time) ([]tibber.Measurement, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			loads++
+			if loads == 1 {
+				// De backlog bij het eerste abonnement
+				return []tibber.Measurement{{Timestamp: time.Now().Add(-time.Minute), Power: 1}}, nil
+			}
+			polls++
+			return []tibber.Measurement{{Timestamp: time.Now(), Power: 2}}, nil
+		},
+	}
+
+	s, err := h.Subscribe(context.Background(), []string{"a"}, DefaultFields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replayed(s); !equal(got, []float64{1}) {
+		t.Errorf("replay from Load %v", got)
+	}
+	select {
+	case msg := <-s.C:
+		if msg.Measurement.Power != 2 {
+			t.Errorf("polled %v", msg.Measurement.Power)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no polled measurement")
+	}
+
+	s.Close()
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	after := polls
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if polls != after {
+		t.Errorf("still polling after close: %d polls, then %d", after, polls)
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields([]string{"power", " phases", "currentL1", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 4 || fields[0] != "power" || fields[3] != "currentL3" {
+		t.Errorf("fields %v", fields)
+	}
+	if fields, _ := ParseFields([]string{""}); len(fields) != len(DefaultFields) {
+		t.Errorf("no fields gives %v", fields)
+	}
+	if _, err := ParseFields([]string{"powr"}); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+
+	// Een fase die de meter niet meet is nil
+	values := Message{HomeID: "a", Measurement: at(1)}.Values([]string{"power", "currentL1"})
+	if values["homeId"] != "a" || values["power"] != 1.0 || values["currentL1"] != nil {
+		t.Errorf("values %v", values)
+	}
+}
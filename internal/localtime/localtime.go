@@ -0,0 +1,92 @@
+package localtime
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTimeZone is used when a home has no (valid) time zone. It is set once at startup
+// from the configuration, see SetDefaultTimeZone.
+var DefaultTimeZone = "Europe/Amsterdam"
+
+// SetDefaultTimeZone changes DefaultTimeZone; it must be called before anything else runs
+func SetDefaultTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("unknown time zone %q: %w", name, err)
+	}
+	DefaultTimeZone = name
+	return nil
+}
+
+// Location returns the location for a home's TimeZone field,
+// falling back to DefaultTimeZone when it is empty or unknown
+func Location(timeZone string) *time.Location {
+	if timeZone != "" {
+		if loc, err := time.LoadLocation(timeZone); err == nil {
+			return loc
+		}
+	}
+
+	loc, err := time.LoadLocation(DefaultTimeZone)
+	if err != nil {
+		// Zonder tzdata kunnen we alleen nog op UTC terugvallen
+		return time.UTC
+	}
+	return loc
+}
+
+// StartOfDay returns local midnight of the day t falls on in loc
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
+
+// NextDay returns local midnight of the day after t in loc.
+// On DST transition days this is 23 or 25 hours after StartOfDay.
+func NextDay(t time.Time, loc *time.Location) time.Time {
+	start := StartOfDay(t, loc)
+	return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+}
+
+// DayBounds returns the [start, end) interval of the local day t falls on
+func DayBounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	return StartOfDay(t, loc), NextDay(t, loc)
+}
+
+// Date returns the local calendar date of t as a UTC midnight value,
+// which is how DATE columns are written to and read from Postgres
+func Date(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// HoursInDay returns the number of hours in the local day t falls on (23, 24 or 25)
+func HoursInDay(t time.Time, loc *time.Location) int {
+	start, end := DayBounds(t, loc)
+	return int(end.Sub(start) / time.Hour)
+}
+
+// Hour returns the local hour of day of t in loc
+func Hour(t time.Time, loc *time.Location) int {
+	return t.In(loc).Hour()
+}
+
+// NextAt returns the first moment after now at which the local clock in loc shows hour:00.
+// Unlike adding 24 hours this keeps jobs at the same wall-clock time across DST changes.
+func NextAt(now time.Time, hour int, loc *time.Location) time.Time {
+	local := now.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+	if !next.After(now) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, hour, 0, 0, 0, loc)
+	}
+	return next
+}
+
+// ParseTimestamp parses a Tibber timestamp (RFC3339 with offset, optionally with milliseconds)
+func ParseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	return t, nil
+}
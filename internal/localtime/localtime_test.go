@@ -0,0 +1,127 @@
+package localtime
+
+import (
+	"testing"
+	"time"
+)
+
+func amsterdam(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+	return loc
+}
+
+func TestDayBoundsAndHoursInDay(t *testing.T) {
+	loc := amsterdam(t)
+
+	tests := []struct {
+		name       string
+		t          time.Time
+		start, end string
+		hours      int
+	}{
+		{"day before spring forward", time.Date(2025, 3, 29, 12, 0, 0, 0, loc), "2025-03-28T23:00:00Z", "2025-03-29T23:00:00Z", 24},
+		{"spring forward", time.Date(2025, 3, 30, 12, 0, 0, 0, loc), "2025-03-29T23:00:00Z", "2025-03-30T22:00:00Z", 23},
+		{"spring forward, just before midnight", time.Date(2025, 3, 30, 23, 59, 0, 0, loc), "2025-03-29T23:00:00Z", "2025-03-30T22:00:00Z", 23},
+		{"day after spring forward", time.Date(2025, 3, 31, 0, 0, 0, 0, loc), "2025-03-30T22:00:00Z", "2025-03-31T22:00:00Z", 24},
+		{"fall back", time.Date(2025, 10, 26, 12, 0, 0, 0, loc), "2025-10-25T22:00:00Z", "2025-10-26T23:00:00Z", 25},
+		{"fall back, second 02:30", time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), "2025-10-25T22:00:00Z", "2025-10-26T23:00:00Z", 25},
+		{"day after fall back", time.Date(2025, 10, 27, 8, 0, 0, 0, loc), "2025-10-26T23:00:00Z", "2025-10-27T23:00:00Z", 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := DayBounds(tt.t, loc)
+			if got := start.UTC().Format(time.RFC3339); got != tt.start {
+				t.Errorf("start = %s, want %s", got, tt.start)
+			}
+			if got := end.UTC().Format(time.RFC3339); got != tt.end {
+				t.Errorf("end = %s, want %s", got, tt.end)
+			}
+			if got := HoursInDay(tt.t, loc); got != tt.hours {
+				t.Errorf("HoursInDay = %d, want %d", got, tt.hours)
+			}
+		})
+	}
+}
+
+func TestStartOfDayAndNextDay(t *testing.T) {
+	loc := amsterdam(t)
+
+	tests := []struct {
+		name        string
+		t           string
+		start, next string
+	}{
+		{"before spring forward", "2025-03-30T00:59:00Z", "2025-03-29T23:00:00Z", "2025-03-30T22:00:00Z"},
+		{"after spring forward", "2025-03-30T01:00:00Z", "2025-03-29T23:00:00Z", "2025-03-30T22:00:00Z"},
+		{"last instant of spring forward day", "2025-03-30T21:59:59Z", "2025-03-29T23:00:00Z", "2025-03-30T22:00:00Z"},
+		{"first instant after spring forward day", "2025-03-30T22:00:00Z", "2025-03-30T22:00:00Z", "2025-03-31T22:00:00Z"},
+		{"first 02:30 of fall back", "2025-10-26T00:30:00Z", "2025-10-25T22:00:00Z", "2025-10-26T23:00:00Z"},
+		{"second 02:30 of fall back", "2025-10-26T01:30:00Z", "2025-10-25T22:00:00Z", "2025-10-26T23:00:00Z"},
+		{"last instant of fall back day", "2025-10-26T22:59:59Z", "2025-10-25T22:00:00Z", "2025-10-26T23:00:00Z"},
+		{"first instant after fall back day", "2025-10-26T23:00:00Z", "2025-10-26T23:00:00Z", "2025-10-27T23:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := StartOfDay(at, loc).UTC().Format(time.RFC3339); got != tt.start {
+				t.Errorf("StartOfDay = %s, want %s", got, tt.start)
+			}
+			if got := NextDay(at, loc).UTC().Format(time.RFC3339); got != tt.next {
+				t.Errorf("NextDay = %s, want %s", got, tt.next)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	loc := amsterdam(t)
+
+	tests := []struct {
+		in   string
+		utc  string
+		date string
+		hour int
+	}{
+		// Tibber stuurt de lokale tijd met de offset van dat moment
+		{"2025-03-30T01:00:00.000+01:00", "2025-03-30T00:00:00Z", "2025-03-30", 1},
+		{"2025-03-30T03:00:00.000+02:00", "2025-03-30T01:00:00Z", "2025-03-30", 3},
+		{"2025-03-30T23:00:00+02:00", "2025-03-30T21:00:00Z", "2025-03-30", 23},
+		{"2025-10-26T02:00:00.000+02:00", "2025-10-26T00:00:00Z", "2025-10-26", 2},
+		{"2025-10-26T02:00:00.000+01:00", "2025-10-26T01:00:00Z", "2025-10-26", 2},
+		{"2025-10-26T00:00:00Z", "2025-10-26T00:00:00Z", "2025-10-26", 2},
+		{"2025-10-25T23:30:00.123456Z", "2025-10-25T23:30:00Z", "2025-10-26", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTimestamp(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := got.UTC().Truncate(time.Second).Format(time.RFC3339); s != tt.utc {
+				t.Errorf("UTC = %s, want %s", s, tt.utc)
+			}
+			if s := Date(got, loc).Format("2006-01-02"); s != tt.date {
+				t.Errorf("Date = %s, want %s", s, tt.date)
+			}
+			if h := Hour(got, loc); h != tt.hour {
+				t.Errorf("Hour = %d, want %d", h, tt.hour)
+			}
+		})
+	}
+
+	for _, in := range []string{"", "2025-03-30", "2025-03-30 02:30:00", "2025-03-30T02:30:00"} {
+		if _, err := ParseTimestamp(in); err == nil {
+			t.Errorf("ParseTimestamp(%q) gave no error", in)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package meterdata
+
+import (
+	"sort"
+	"time"
+)
+
+// Aggregate rolls the intervals of one connection up into the resolutions of the consumption and
+// production tables: complete hours and complete local days. Intervals of a day or longer (a
+// daily export) only count for the days. Incomplete hours and days at the edges of the export
+// are left out, so they never overwrite a complete period.
+func Aggregate(intervals []Interval, loc *time.Location) (hours, days []Interval) {
+	hourly := make(map[int64]*Interval)
+	coverage := make(map[int64]time.Duration)
+	var daily []Interval
+	for _, iv := range intervals {
+		length := iv.End.Sub(iv.Start)
+		switch {
+		case length >= 23*time.Hour:
+			daily = append(daily, iv)
+		case length <= time.Hour:
+			start := iv.Start.Truncate(time.Hour)
+			h, ok := hourly[start.Unix()]
+			if !ok {
+				h = &Interval{EAN: iv.EAN, Start: start, End: start.Add(time.Hour)}
+				hourly[start.Unix()] = h
+			}
+			h.Import += iv.Import
+			h.Export += iv.Export
+			// Het uur is compleet als de intervallen samen een uur beslaan
+			coverage[start.Unix()] += length
+		}
+	}
+	for unix, h := range hourly {
+		if coverage[unix] == time.Hour {
+			hours = append(hours, *h)
+		}
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Start.Before(hours[j].Start) })
+
+	byDay := make(map[string]*Interval)
+	dayCoverage := make(map[string]time.Duration)
+	for _, h := range hours {
+		local := h.Start.In(loc)
+		key := local.Format("2006-01-02")
+		d, ok := byDay[key]
+		if !ok {
+			start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+			d = &Interval{EAN: h.EAN, Start: start, End: start.AddDate(0, 0, 1)}
+			byDay[key] = d
+		}
+		d.Import += h.Import
+		d.Export += h.Export
+		dayCoverage[key] += time.Hour
+	}
+	for key, d := range byDay {
+		// Een dag telt 23, 24 of 25 uur bij de overgang van zomer- en wintertijd
+		if dayCoverage[key] == d.End.Sub(d.Start) {
+			days = append(days, *d)
+		}
+	}
+	for _, d := range daily {
+		if _, ok := byDay[d.Start.In(loc).Format("2006-01-02")]; !ok {
+			days = append(days, d)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Start.Before(days[j].Start) })
+	return hours, days
+}
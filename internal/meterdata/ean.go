@@ -0,0 +1,44 @@
+package meterdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeEAN removes spaces and quotes that spreadsheets add around long numbers
+func NormalizeEAN(ean string) string {
+	ean = strings.TrimSpace(ean)
+	ean = strings.Trim(ean, "'\"")
+	return strings.ReplaceAll(ean, " ", "")
+}
+
+// ValidateEAN checks that an EAN code of a Dutch connection has 18 digits, starts with 871 and
+// has a valid GS1 check digit
+func ValidateEAN(ean string) error {
+	ean = NormalizeEAN(ean)
+	if len(ean) != 18 {
+		return fmt.Errorf("EAN %q does not have 18 digits", ean)
+	}
+	for _, c := range ean {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("EAN %q contains non-digits", ean)
+		}
+	}
+	if !strings.HasPrefix(ean, "871") {
+		return fmt.Errorf("EAN %q is not a Dutch connection", ean)
+	}
+
+	// GS1: vanaf rechts (zonder controlecijfer) afwisselend gewicht 3 en 1
+	sum := 0
+	for i := len(ean) - 2; i >= 0; i-- {
+		digit := int(ean[i] - '0')
+		if (len(ean)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	if check := (10 - sum%10) % 10; check != int(ean[len(ean)-1]-'0') {
+		return fmt.Errorf("EAN %q has an invalid check digit", ean)
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package meterdata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxHeaderRow is how far into a file the header is searched; portals put a title and the address above it
+const MaxHeaderRow = 10
+
+// Interval is the metered consumption and production of a connection in [Start, End), in kWh
+type Interval struct {
+	EAN    string    `json:"ean,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Import float64   `json:"import"`
+	Export float64   `json:"export"`
+}
+
+// File is a parsed metering data export
+type File struct {
+	Profile    string        `json:"profile"`
+	Rows       int           `json:"rows"`
+	Duplicates int           `json:"duplicates"` // Regels voor een interval dat al in het bestand stond
+	EANs       []string      `json:"eans"`
+	Resolution time.Duration `json:"resolution"`
+	Intervals  []Interval    `json:"intervals"`
+}
+
+// Parse reads a CSV or XLSX export. profile may be empty to recognize the profile from the header.
+// Times without an offset are local times in loc.
+func Parse(filename string, data []byte, profiles []Profile, profile string, loc *time.Location) (*File, error) {
+	var rows [][]string
+	var err error
+	xlsx := strings.EqualFold(filepath.Ext(filename), ".xlsx") || bytes.HasPrefix(data, []byte("PK\x03\x04"))
+	if xlsx {
+		rows, err = readXLSX(data)
+	} else {
+		rows, err = readCSV(data, profiles, profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p, headerRow, header, err := selectProfile(rows, profiles, profile)
+	if err != nil {
+		return nil, err
+	}
+	// Een werkblad slaat getallen altijd met een punt op, ook als het portaal een komma toont
+	return parseRows(p, rows[headerRow+1:], header, headerRow+2, p.DecimalComma && !xlsx, loc)
+}
+
+// readCSV reads all rows with the delimiter of the profile or, without one, the most common of ; , and tab in the first lines
+func readCSV(data []byte, profiles []Profile, profile string) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	delimiter := ';'
+	if p, err := FindProfile(profiles, profile); err == nil && p.Delimiter != "" {
+		delimiter = []rune(p.Delimiter)[0]
+	} else {
+		head := string(data[:min(len(data), 4096)])
+		best := 0
+		for _, d := range []rune{';', ',', '\t'} {
+			if n := strings.Count(head, string(d)); n > best {
+				best, delimiter = n, d
+			}
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var rows [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv: %w", err)
+		}
+		rows = append(rows, record)
+	}
+	return rows, nil
+}
+
+// selectProfile finds the header row and the profile that fits it
+func selectProfile(rows [][]string, profiles []Profile, name string) (Profile, int, map[string]int, error) {
+	for i := 0; i < len(rows) && i < MaxHeaderRow; i++ {
+		header := make(map[string]int)
+		for col, cell := range rows[i] {
+			header[normalizeColumn(cell)] = col
+		}
+
+		if name != "" {
+			p, err := FindProfile(profiles, name)
+			if err != nil {
+				return Profile{}, 0, nil, err
+			}
+			if p.matches(header) {
+				return p, i, header, nil
+			}
+			continue
+		}
+		for _, p := range profiles {
+			if p.matches(header) {
+				return p, i, header, nil
+			}
+		}
+	}
+
+	if name != "" {
+		return Profile{}, 0, nil, fmt.Errorf("file does not have the columns of profile %s", name)
+	}
+	return Profile{}, 0, nil, fmt.Errorf("no import profile matches the columns of this file")
+}
+
+// parseRows converts the data rows with the profile; first is the line number of the first row, for errors.
+// With decimalComma numbers are written as 1.234,5.
+func parseRows(p Profile, rows [][]string, header map[string]int, first int, decimalComma bool, loc *time.Location) (*File, error) {
+	cell := func(row []string, column string) string {
+		i, ok := header[normalizeColumn(column)]
+		if column == "" || !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	number := func(s string) (float64, error) {
+		s = strings.ReplaceAll(s, " ", "")
+		if decimalComma {
+			s = strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+		}
+		if s == "" || s == "-" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	factor := func(unit string) float64 {
+		if strings.EqualFold(strings.TrimSpace(unit), "Wh") {
+			return 0.001
+		}
+		return 1
+	}
+
+	var fixed time.Duration
+	if p.Interval != "" {
+		fixed, _ = time.ParseDuration(p.Interval)
+	}
+
+	file := &File{Profile: p.Name}
+	type key struct {
+		ean   string
+		start int64
+	}
+	intervals := make(map[key]*Interval)
+	seen := make(map[string]bool) // ean|start|richting, voor dubbele regels
+	eans := make(map[string]bool)
+	var previous time.Time
+
+	for n, row := range rows {
+		line := first + n
+		if len(row) == 0 || strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		file.Rows++
+
+		start, err := parseTime(cell(row, p.DateColumn), cell(row, p.StartColumn), loc)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", line, err)
+		}
+		// In het dubbele uur bij het ingaan van de wintertijd herhalen lokale tijden zich;
+		// parseTime kiest de eerste, dus de herhaling hoort een uur later
+		if later := start.Add(time.Hour); !previous.IsZero() && !start.After(previous) &&
+			later.After(previous) && wallClock(later).Equal(wallClock(start)) {
+			start = later
+		}
+		previous = start
+
+		var end time.Time
+		if p.EndColumn != "" {
+			end, err = parseTime(cell(row, p.DateColumn), cell(row, p.EndColumn), loc)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", line, err)
+			}
+			// De lengte volgt uit de lokale tijden, zodat 02:00-03:00 ook in het dubbele uur een uur is
+			length := wallClock(end).Sub(wallClock(start))
+			for length <= 0 {
+				length += 24 * time.Hour // Eindtijd 00:00 of 24:00 zonder datum
+			}
+			if length < 23*time.Hour {
+				end = start.Add(length)
+			} else if !end.After(start) {
+				end = end.AddDate(0, 0, 1)
+			}
+		} else if fixed > 0 {
+			end = start.Add(fixed)
+		}
+
+		ean := NormalizeEAN(cell(row, p.EANColumn))
+		if ean != "" {
+			eans[ean] = true
+		}
+
+		var imported, exported float64
+		var hasImport, hasExport bool
+		if p.ValueColumn != "" {
+			value, err := number(cell(row, p.ValueColumn))
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid value: %w", line, err)
+			}
+			unit := p.Unit
+			if u := cell(row, p.UnitColumn); u != "" {
+				unit = u
+			}
+			value *= factor(unit)
+			direction := cell(row, p.DirectionColumn)
+			switch {
+			case containsFold(p.ImportDirections, direction):
+				imported, hasImport = value, true
+			case containsFold(p.ExportDirections, direction):
+				exported, hasExport = value, true
+			default:
+				return nil, fmt.Errorf("row %d: unknown direction %q", line, direction)
+			}
+		} else {
+			for _, c := range p.ImportColumns {
+				if _, ok := header[normalizeColumn(c)]; !ok {
+					continue
+				}
+				v, err := number(cell(row, c))
+				if err != nil {
+					return nil, fmt.Errorf("row %d: invalid value in %s: %w", line, c, err)
+				}
+				imported += v * factor(p.Unit)
+				hasImport = true
+			}
+			for _, c := range p.ExportColumns {
+				if _, ok := header[normalizeColumn(c)]; !ok {
+					continue
+				}
+				v, err := number(cell(row, c))
+				if err != nil {
+					return nil, fmt.Errorf("row %d: invalid value in %s: %w", line, c, err)
+				}
+				exported += v * factor(p.Unit)
+				hasExport = true
+			}
+		}
+		if imported < 0 || exported < 0 {
+			return nil, fmt.Errorf("row %d: negative values are not supported", line)
+		}
+
+		k := key{ean, start.Unix()}
+		iv, ok := intervals[k]
+		if !ok {
+			iv = &Interval{EAN: ean, Start: start, End: end}
+			intervals[k] = iv
+		}
+		// Een latere regel voor hetzelfde interval vervangt de eerdere
+		for direction, has := range map[string]bool{"import": hasImport, "export": hasExport} {
+			id := fmt.Sprintf("%s|%d|%s", ean, start.Unix(), direction)
+			if has && seen[id] {
+				file.Duplicates++
+			}
+			if has {
+				seen[id] = true
+			}
+		}
+		if hasImport {
+			iv.Import = imported
+		}
+		if hasExport {
+			iv.Export = exported
+		}
+	}
+
+	if len(intervals) == 0 {
+		return nil, fmt.Errorf("file contains no metering data")
+	}
+
+	for _, iv := range intervals {
+		file.Intervals = append(file.Intervals, *iv)
+	}
+	sort.Slice(file.Intervals, func(i, j int) bool {
+		if !file.Intervals[i].Start.Equal(file.Intervals[j].Start) {
+			return file.Intervals[i].Start.Before(file.Intervals[j].Start)
+		}
+		return file.Intervals[i].EAN < file.Intervals[j].EAN
+	})
+	for ean := range eans {
+		file.EANs = append(file.EANs, ean)
+	}
+	sort.Strings(file.EANs)
+
+	file.Resolution = inferEnds(file.Intervals)
+	return file, nil
+}
+
+// inferEnds fills in missing ends with the smallest step between two starts and returns the resolution
+func inferEnds(intervals []Interval) time.Duration {
+	var step time.Duration
+	for i := 1; i < len(intervals); i++ {
+		if d := intervals[i].Start.Sub(intervals[i-1].Start); d > 0 && (step == 0 || d < step) {
+			step = d
+		}
+	}
+	if step == 0 {
+		step = time.Hour
+	}
+
+	resolution := time.Duration(math.MaxInt64)
+	for i := range intervals {
+		if intervals[i].End.IsZero() {
+			intervals[i].End = intervals[i].Start.Add(step)
+		}
+		if d := intervals[i].End.Sub(intervals[i].Start); d < resolution {
+			resolution = d
+		}
+	}
+	return resolution
+}
+
+// Tijdnotaties in de exports van de portalen
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02-01-2006 15:04:05",
+	"02-01-2006 15:04",
+	"2-1-2006 15:04",
+	"02/01/2006 15:04",
+	"2006-01-02",
+	"02-01-2006",
+}
+
+// parseTime parses a time, optionally with the date in a separate column. Excel serial dates,
+// "24:00" and times with an offset are supported.
+func parseTime(date, clock string, loc *time.Location) (time.Time, error) {
+	s := strings.TrimSpace(clock)
+	if date != "" {
+		d, err := parseTime("", date, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if serial, err := strconv.ParseFloat(s, 64); err == nil && serial < 1 {
+			// Excel slaat een tijd op als deel van een dag
+			return d.Add(time.Duration(math.Round(serial*24*60)) * time.Minute), nil
+		}
+		s = d.Format("2006-01-02") + " " + s
+	}
+
+	if serial, err := strconv.ParseFloat(s, 64); err == nil && serial > 1 {
+		return excelTime(serial, loc), nil
+	}
+
+	// 24:00 is middernacht aan het eind van de dag
+	nextDay := false
+	if strings.HasSuffix(s, " 24:00") || strings.HasSuffix(s, " 24:00:00") {
+		s = s[:strings.LastIndex(s, " ")] + " 00:00"
+		nextDay = true
+	}
+
+	for _, layout := range timeLayouts {
+		var t time.Time
+		var err error
+		if strings.Contains(layout, "Z07:00") {
+			t, err = time.Parse(layout, s)
+		} else {
+			t, err = time.ParseInLocation(layout, s, loc)
+		}
+		if err == nil {
+			if nextDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			// Bij een tijd die twee keer voorkomt de eerste nemen
+			if earlier := t.Add(-time.Hour); wallClock(earlier).Equal(wallClock(t)) {
+				t = earlier
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", strings.TrimSpace(date+" "+clock))
+}
+
+// wallClock returns the local date and time of t as if it were UTC, to compare and subtract wall clock times
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// excelTime converts an Excel serial date (days since 30-12-1899, local time) to a time
+func excelTime(serial float64, loc *time.Location) time.Time {
+	days := math.Floor(serial)
+	minutes := math.Round((serial - days) * 24 * 60)
+	return time.Date(1899, 12, 30+int(days), 0, int(minutes), 0, 0, loc)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, strings.TrimSpace(s)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package meterdata
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+const testEAN = "871000000000000013"
+
+func amsterdam(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+	return loc
+}
+
+// hour is an expected hour of consumption and production on 15 January 2025
+type hour struct {
+	start           int
+	import_, export float64
+}
+
+func TestParseFixtures(t *testing.T) {
+	loc := amsterdam(t)
+
+	tests := []struct {
+		file       string
+		profile    string
+		rows       int
+		intervals  int
+		resolution time.Duration
+		hours      []hour
+	}{
+		{
+			// Gedeelde teksten, een kop met opgemaakte runs, een titel boven de kop en datums als serienummer
+			file: "liander.xlsx", profile: "liander", rows: 3, intervals: 3, resolution: time.Hour,
+			hours: []hour{{0, 0.25, 0}, {1, 0.5, 0.125}, {2, 0.375, 0}},
+		},
+		{
+			// Inline teksten, een datumkolom als serienummer en de tijd als deel van een dag
+			file: "stedin.xlsx", profile: "stedin", rows: 8, intervals: 8, resolution: 15 * time.Minute,
+			hours: []hour{{0, 0.4, 0}, {1, 0.4, 0.05}},
+		},
+		{
+			// Decimale komma, Wh met duizendtallen en een ontbrekend kwartier om 01:30
+			file: "enexis.csv", profile: "enexis", rows: 22, intervals: 11, resolution: 15 * time.Minute,
+			hours: []hour{{0, 0.5, 0.04}, {2, 1.375, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := Parse(tt.file, data, Profiles, "", loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if file.Profile != tt.profile || file.Rows != tt.rows || len(file.Intervals) != tt.intervals || file.Resolution != tt.resolution {
+				t.Errorf("profile %s, %d rows, %d intervals of %v; want %s, %d, %d of %v", file.Profile, file.Rows,
+					len(file.Intervals), file.Resolution, tt.profile, tt.rows, tt.intervals, tt.resolution)
+			}
+			if len(file.EANs) != 1 || file.EANs[0] != testEAN || file.Duplicates != 0 {
+				t.Errorf("EANs %v with %d duplicates, want [%s] without", file.EANs, file.Duplicates, testEAN)
+			}
+			first := time.Date(2025, 1, 15, 0, 0, 0, 0, loc)
+			if got := file.Intervals[0]; !got.Start.Equal(first) || !got.End.Equal(first.Add(tt.resolution)) {
+				t.Errorf("first interval %s - %s, want %s - %s", got.Start, got.End, first, first.Add(tt.resolution))
+			}
+
+			// Een uur met een ontbrekend interval is niet compleet en telt niet mee
+			hours, days := Aggregate(file.Intervals, loc)
+			if len(hours) != len(tt.hours) {
+				t.Fatalf("got %d hours, want %d", len(hours), len(tt.hours))
+			}
+			for i, want := range tt.hours {
+				got := hours[i]
+				start := first.Add(time.Duration(want.start) * time.Hour)
+				if !got.Start.Equal(start) || !got.End.Equal(start.Add(time.Hour)) || got.EAN != testEAN {
+					t.Errorf("hour %d: %s %s - %s, want %s - %s", i, got.EAN, got.Start, got.End, start, start.Add(time.Hour))
+				}
+				if math.Abs(got.Import-want.import_) > 1e-9 || math.Abs(got.Export-want.export) > 1e-9 {
+					t.Errorf("hour %d: import %v export %v, want %v %v", i, got.Import, got.Export, want.import_, want.export)
+				}
+			}
+			if len(days) != 0 {
+				t.Errorf("got %d days from an incomplete day", len(days))
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	loc := amsterdam(t)
+
+	tests := []struct {
+		date, clock string
+		want        string
+	}{
+		{"", "45672", "2025-01-15T00:00:00+01:00"},
+		{"", "45672.5", "2025-01-15T12:00:00+01:00"},
+		{"45672", "0.75", "2025-01-15T18:00:00+01:00"},
+		{"15-01-2025", "08:15", "2025-01-15T08:15:00+01:00"},
+		{"", "15-01-2025 24:00", "2025-01-16T00:00:00+01:00"},
+		{"", "2025-07-01T10:00:00Z", "2025-07-01T12:00:00+02:00"},
+		// In het dubbele uur van de wintertijd de eerste 02:30
+		{"", "2025-10-26 02:30", "2025-10-26T02:30:00+02:00"},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.date, tt.clock, loc)
+		if err != nil {
+			t.Errorf("parseTime(%q, %q): %v", tt.date, tt.clock, err)
+			continue
+		}
+		if s := got.In(loc).Format(time.RFC3339); s != tt.want {
+			t.Errorf("parseTime(%q, %q) = %s, want %s", tt.date, tt.clock, s, tt.want)
+		}
+	}
+
+	if _, err := parseTime("", "gisteren", loc); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
+
+func TestValidateEAN(t *testing.T) {
+	if err := ValidateEAN(" '871000000000000013' "); err != nil {
+		t.Errorf("valid EAN: %v", err)
+	}
+	for _, ean := range []string{"871000000000000014", "87100000000000001", "541000000000000013", "87100000000000001X"} {
+		if err := ValidateEAN(ean); err == nil {
+			t.Errorf("ValidateEAN(%q) accepted an invalid EAN", ean)
+		}
+	}
+}
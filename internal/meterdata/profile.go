@@ -0,0 +1,165 @@
+package meterdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Profile maps the columns of a metering data export onto consumption and production.
+// Exports are either wide (a column per register, such as normal and low tariff) or long
+// (one value column with a direction per row).
+type Profile struct {
+	Name         string `json:"name"`
+	Delimiter    string `json:"delimiter,omitempty"`    // Leeg: automatisch herkennen
+	DecimalComma bool   `json:"decimalComma,omitempty"` // Alleen voor CSV, een werkblad gebruikt altijd een punt
+
+	StartColumn string `json:"startColumn"`          // Begin van het interval, of de tijd als DateColumn gezet is
+	DateColumn  string `json:"dateColumn,omitempty"` // Datum in een aparte kolom
+	EndColumn   string `json:"endColumn,omitempty"`
+	EANColumn   string `json:"eanColumn,omitempty"`
+	Interval    string `json:"interval,omitempty"` // Lengte van een interval zonder EndColumn, bijvoorbeeld "15m"
+
+	// Breed formaat: kolommen die worden opgeteld
+	ImportColumns []string `json:"importColumns,omitempty"`
+	ExportColumns []string `json:"exportColumns,omitempty"`
+
+	// Lang formaat
+	DirectionColumn  string   `json:"directionColumn,omitempty"`
+	ValueColumn      string   `json:"valueColumn,omitempty"`
+	ImportDirections []string `json:"importDirections,omitempty"`
+	ExportDirections []string `json:"exportDirections,omitempty"`
+	UnitColumn       string   `json:"unitColumn,omitempty"`
+
+	Unit string `json:"unit,omitempty"` // kWh (standaard) of Wh
+}
+
+// Profiles are the built-in profiles for the exports of the Dutch grid operators and a generic format.
+// Column names follow the portal exports; a different layout can be added with LoadProfiles.
+var Profiles = []Profile{
+	{
+		Name:          "liander",
+		DecimalComma:  true,
+		StartColumn:   "Van",
+		EndColumn:     "Tot",
+		EANColumn:     "EAN",
+		ImportColumns: []string{"Levering normaal (kWh)", "Levering dal (kWh)"},
+		ExportColumns: []string{"Teruglevering normaal (kWh)", "Teruglevering dal (kWh)"},
+	},
+	{
+		Name:          "stedin",
+		DecimalComma:  true,
+		DateColumn:    "Datum",
+		StartColumn:   "Tijd",
+		EANColumn:     "EAN code",
+		Interval:      "15m",
+		ImportColumns: []string{"Afname (kWh)"},
+		ExportColumns: []string{"Invoeding (kWh)"},
+	},
+	{
+		Name:             "enexis",
+		DecimalComma:     true,
+		StartColumn:      "Datum/tijd van",
+		EndColumn:        "Datum/tijd tot",
+		EANColumn:        "EAN",
+		DirectionColumn:  "Richting",
+		ValueColumn:      "Waarde",
+		UnitColumn:       "Eenheid",
+		ImportDirections: []string{"Levering", "Afname"},
+		ExportDirections: []string{"Teruglevering", "Invoeding"},
+	},
+	{
+		Name:          "standaard",
+		StartColumn:   "start",
+		EndColumn:     "end",
+		EANColumn:     "ean",
+		ImportColumns: []string{"import"},
+		ExportColumns: []string{"export"},
+	},
+}
+
+// LoadProfiles reads extra profiles from a JSON file; they take precedence over built-in profiles with the same name
+func LoadProfiles(path string) ([]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading import profiles: %w", err)
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("error parsing import profiles: %w", err)
+	}
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, builtin := range Profiles {
+		if _, err := FindProfile(profiles, builtin.Name); err != nil {
+			profiles = append(profiles, builtin)
+		}
+	}
+	return profiles, nil
+}
+
+// FindProfile returns the profile with the given name
+func FindProfile(profiles []Profile, name string) (Profile, error) {
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown import profile %q", name)
+}
+
+// Validate checks that the profile names the columns it needs
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("import profile without name")
+	}
+	if p.StartColumn == "" {
+		return fmt.Errorf("import profile %s: no start column", p.Name)
+	}
+	long := p.DirectionColumn != "" || p.ValueColumn != ""
+	if long && (p.DirectionColumn == "" || p.ValueColumn == "") {
+		return fmt.Errorf("import profile %s: long format needs a direction and a value column", p.Name)
+	}
+	if !long && len(p.ImportColumns) == 0 && len(p.ExportColumns) == 0 {
+		return fmt.Errorf("import profile %s: no consumption or production columns", p.Name)
+	}
+	if p.Interval != "" {
+		if _, err := time.ParseDuration(p.Interval); err != nil {
+			return fmt.Errorf("import profile %s: invalid interval: %w", p.Name, err)
+		}
+	}
+	if p.Unit != "" && !strings.EqualFold(p.Unit, "kWh") && !strings.EqualFold(p.Unit, "Wh") {
+		return fmt.Errorf("import profile %s: unknown unit %q", p.Name, p.Unit)
+	}
+	return nil
+}
+
+// matches tells whether a header row has the columns of the profile. The EAN and unit columns are
+// optional, and of the register columns one is enough (an export without production).
+func (p Profile) matches(header map[string]int) bool {
+	for _, c := range []string{p.StartColumn, p.DateColumn, p.EndColumn, p.DirectionColumn, p.ValueColumn} {
+		if _, ok := header[normalizeColumn(c)]; c != "" && !ok {
+			return false
+		}
+	}
+	if p.ValueColumn != "" {
+		return true
+	}
+	for _, c := range append(append([]string{}, p.ImportColumns...), p.ExportColumns...) {
+		if _, ok := header[normalizeColumn(c)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+}
@@ -0,0 +1,172 @@
+package meterdata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxXLSXPart limits the size of a decompressed part of a workbook
+const maxXLSXPart = 256 << 20
+
+// readXLSX reads the cells of the first sheet of a workbook as text. Only the parts needed
+// for metering data are read: the sheet, the shared strings and the workbook relations.
+func readXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening xlsx: %w", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	sheet, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []xlsxString `xml:"si"`
+		}
+		if err := decodeXLSXPart(f, &sst); err != nil {
+			return nil, err
+		}
+		for _, si := range sst.Items {
+			shared = append(shared, si.text())
+		}
+	}
+
+	f, ok := files[sheet]
+	if !ok {
+		return nil, fmt.Errorf("xlsx: sheet %s not found", sheet)
+	}
+	var ws struct {
+		Rows []struct {
+			Index int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string     `xml:"r,attr"`
+				Type   string     `xml:"t,attr"`
+				Value  string     `xml:"v"`
+				Inline xlsxString `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeXLSXPart(f, &ws); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, r := range ws.Rows {
+		// Lege rijen staan niet in het bestand; het rijnummer houdt de koptekst op zijn plaats
+		for r.Index > len(rows)+1 {
+			rows = append(rows, nil)
+		}
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(c.Value)
+				if err != nil || n < 0 || n >= len(shared) {
+					return nil, fmt.Errorf("xlsx: invalid shared string in %s", c.Ref)
+				}
+				row[col] = shared[n]
+			case "inlineStr":
+				row[col] = c.Inline.text()
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// xlsxString is a shared or inline string, either plain or made of formatted runs
+type xlsxString struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"`
+}
+
+func (s xlsxString) text() string {
+	return s.Text + strings.Join(s.Runs, "")
+}
+
+// firstSheet returns the path in the archive of the first sheet of the workbook
+func firstSheet(files map[string]*zip.File) (string, error) {
+	workbook, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", fmt.Errorf("xlsx: no workbook")
+	}
+	var wb struct {
+		Sheets []struct {
+			Id string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeXLSXPart(workbook, &wb); err != nil {
+		return "", err
+	}
+	if len(wb.Sheets) == 0 {
+		return "", fmt.Errorf("xlsx: workbook has no sheets")
+	}
+
+	if rels, ok := files["xl/_rels/workbook.xml.rels"]; ok {
+		var r struct {
+			Relationships []struct {
+				Id     string `xml:"Id,attr"`
+				Target string `xml:"Target,attr"`
+			} `xml:"Relationship"`
+		}
+		if err := decodeXLSXPart(rels, &r); err != nil {
+			return "", err
+		}
+		for _, rel := range r.Relationships {
+			if rel.Id == wb.Sheets[0].Id {
+				if strings.HasPrefix(rel.Target, "/") {
+					return strings.TrimPrefix(rel.Target, "/"), nil
+				}
+				return path.Join("xl", rel.Target), nil
+			}
+		}
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+func decodeXLSXPart(f *zip.File, v any) error {
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("xlsx: error opening %s: %w", f.Name, err)
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(io.LimitReader(r, maxXLSXPart)).Decode(v); err != nil {
+		return fmt.Errorf("xlsx: error reading %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// columnIndex converts a cell reference such as "AB12" to a zero-based column index
+func columnIndex(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// Statussen van een Tibber account
+const (
+	AccountActive  = "ACTIVE"
+	AccountRevoked = "REVOKED" // Tibber weigert het token; het lid moet een nieuw token geven
+	AccountError   = "ERROR"   // De laatste controle mislukte om een andere reden
+)
+
+// Account is the Tibber account of a member. The token itself never leaves the account service;
+// TokenHint shows its last characters to recognize it.
+type Account struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Email       string     `json:"email"`
+	TokenHint   string     `json:"tokenHint"`
+	Status      string     `json:"status"`
+	LastChecked *time.Time `json:"lastChecked,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Homes       []string   `json:"homes"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// Categorieën van gegevens waarvoor een lid toestemming geeft
+const (
+	// ConsentCommunity covers prices, consumption and production per hour and day in the
+	// analyses of the community; it is required to take part
+	ConsentCommunity = "community"
+	// ConsentLive covers the live measurements of the Tibber Pulse (power, phases, voltage)
+	ConsentLive = "live"
+)
+
+// ConsentCategories are the categories a member can give or withdraw consent for
+var ConsentCategories = []string{ConsentCommunity, ConsentLive}
+
+// ConsentVersion identifies the consent text members agree to; raise it when the text changes
+const ConsentVersion = "2026-10"
+
+// Consent is the latest consent of a member for a category of data
+type Consent struct {
+	AccountID  int       `json:"accountId"`
+	Category   string    `json:"category"`
+	Granted    bool      `json:"granted"`
+	Version    string    `json:"version"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// AccountHome is a shared home with the account whose token is used for it
+type AccountHome struct {
+	HomeID    string `json:"homeId"`
+	AccountID int    `json:"accountId"`
+}
+
+// APIToken is a token for the Home Assistant API. It belongs to the account of a member and gives
+// access to the shared homes of that account, or to one home; a token for one home without account
+// is for the homes of the installation. The token itself is only shown when it is created.
+type APIToken struct {
+	ID         int        `json:"id"`
+	AccountID  int        `json:"accountId,omitempty"` // 0 zonder account
+	HomeID     string     `json:"homeId,omitempty"`    // Leeg: alle gedeelde huizen van het account
+	Name       string     `json:"name"`
+	TokenHint  string     `json:"tokenHint"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
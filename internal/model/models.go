@@ -109,6 +109,9 @@ type Price struct {
 	EndTime   string  `json:"endsAt,omitempty"`
 	Currency  string  `json:"currency"`
 	Level     string  `json:"level,omitempty"` // VERY_CHEAP, CHEAP, NORMAL, EXPENSIVE, VERY_EXPENSIVE
+
+	// IsForecast marks a predicted price; published day-ahead prices leave it false
+	IsForecast bool `json:"isForecast,omitempty"`
 }
 
 // PriceInfo represents price information for different time periods
@@ -179,6 +182,31 @@ func (p *Production) ToSummary() ProductionSummary {
 	}
 }
 
+// GasConsumption represents gas consumption for a specific time period
+type GasConsumption struct {
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Consumption float64 `json:"consumption"` // m³
+	Source      string  `json:"source"`      // DSMR, IMPORT
+}
+
+// Import represents an uploaded file with metering data of a grid operator
+type Import struct {
+	Id         int    `json:"id"`
+	HomeId     string `json:"homeId"`
+	Filename   string `json:"filename"`
+	Profile    string `json:"profile"`
+	EAN        string `json:"ean,omitempty"`
+	Rows       int    `json:"rows"`
+	Duplicates int    `json:"duplicates"` // Dubbele regels in het bestand
+	Stored     int    `json:"stored"`     // Nieuwe uren en dagen
+	Skipped    int    `json:"skipped"`    // Al aanwezig van Tibber
+	Replaced   int    `json:"replaced"`   // Vervangen uit een eerdere import
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+}
+
 // Measurement represents live power measurement data from Tibber
 type Measurement struct {
 	Timestamp              time.Time `json:"timestamp"`
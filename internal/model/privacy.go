@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Manieren om de gegevens van een vertrokken lid te wissen
+const (
+	// ErasureDelete removes every row of the homes
+	ErasureDelete = "DELETE"
+	// ErasurePseudonymize keeps consumption, production and prices under a random home ID for the
+	// community statistics, and removes everything that identifies the member
+	ErasurePseudonymize = "PSEUDONYMIZE"
+)
+
+// DataAccess is a view of the data of a home in the dashboard
+type DataAccess struct {
+	AccessedAt time.Time `json:"accessedAt"`
+	// Viewer is the user name set by the proxy in front of the dashboard, empty when unknown
+	Viewer     string `json:"viewer,omitempty"`
+	RemoteAddr string `json:"remoteAddr"`
+	HomeID     string `json:"homeId"`
+	Resource   string `json:"resource"`
+}
+
+// Erasure is the record of an erasure; it holds no personal data
+type Erasure struct {
+	ID        int       `json:"id"`
+	ErasedAt  time.Time `json:"erasedAt"`
+	Mode      string    `json:"mode"`
+	AccountID int       `json:"accountId,omitempty"`
+	Homes     int       `json:"homes"`
+	Rows      int64     `json:"rows"`
+}
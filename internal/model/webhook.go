@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Statussen van een webhook-aflevering
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED" // Opgegeven na webhook.MaxAttempts pogingen
+)
+
+// WebhookEndpoint is a URL that receives events. An endpoint of a member receives the events of the
+// homes its account shares; an endpoint without account, of the operator, those of every home.
+type WebhookEndpoint struct {
+	ID          int       `json:"id"`
+	AccountID   int       `json:"accountId,omitempty"` // 0 voor de beheerder
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is the delivery of an event to an endpoint, with the outcome of the last attempt
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	EndpointID     int        `json:"endpointId"`
+	URL            string     `json:"url"`
+	EventID        int64      `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	HomeID         string     `json:"homeId,omitempty"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt,omitempty"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	ReplayOf       int64      `json:"replayOf,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"ws/internal/config"
+)
+
+// Message is a notification to a member or operator
+type Message struct {
+	HomeId   string    `json:"homeId"`
+	Kind     string    `json:"kind"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title"`
+	Body     string    `json:"body"`
+	Time     time.Time `json:"time"`
+	// To are extra e-mail addresses for this message, such as the member it is about
+	To []string `json:"to,omitempty"`
+}
+
+// Notifier delivers messages over a channel such as e-mail or a webhook
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to the log
+type LogNotifier struct{}
+
+// Notify logs the message
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	log.Printf("[%s] %s (%s): %s", msg.Severity, msg.Title, msg.HomeId, msg.Body)
+	return nil
+}
+
+// SMTPNotifier sends messages by e-mail
+type SMTPNotifier struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Notify sends the message as a plain text e-mail
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	host := n.Addr
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	to := append(append([]string{}, n.To...), msg.To...)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", n.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: [%s] %s\r\n", msg.Severity, msg.Title)
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n\r\nHuis: %s\r\nTijd: %s\r\n", msg.Body, msg.HomeId, msg.Time.Format(time.RFC3339))
+
+	if err := smtp.SendMail(n.Addr, auth, n.From, to, []byte(body.String())); err != nil {
+		return fmt.Errorf("error sending e-mail: %w", err)
+	}
+	return nil
+}
+
+// WebhookNotifier posts messages as JSON to a URL
+type WebhookNotifier struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Notify posts the message
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Multi sends every message to all notifiers, keeping the first error
+type Multi []Notifier
+
+// Notify delivers the message on every channel
+func (m Multi) Notify(ctx context.Context, msg Message) error {
+	var first error
+	for _, n := range m {
+		if err := n.Notify(ctx, msg); err != nil {
+			log.Printf("Error sending notification: %v", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Switchable forwards messages to a notifier that can be replaced while running, so that a
+// reloaded configuration takes effect without recreating the services that hold it
+type Switchable struct {
+	mu       sync.RWMutex
+	notifier Notifier
+}
+
+// NewSwitchable returns a Switchable that forwards to n
+func NewSwitchable(n Notifier) *Switchable {
+	return &Switchable{notifier: n}
+}
+
+// Set replaces the notifier
+func (s *Switchable) Set(n Notifier) {
+	s.mu.Lock()
+	s.notifier = n
+	s.mu.Unlock()
+}
+
+// Notify delivers the message with the current notifier
+func (s *Switchable) Notify(ctx context.Context, msg Message) error {
+	s.mu.RLock()
+	n := s.notifier
+	s.mu.RUnlock()
+	return n.Notify(ctx, msg)
+}
+
+// FromConfig builds the notification channels from the [notify] settings. Messages always go to
+// the log; smtp_addr with email_to adds e-mail and webhook_url adds a webhook.
+func FromConfig(cfg config.Notify) Notifier {
+	notifiers := Multi{LogNotifier{}}
+
+	if cfg.SMTPAddr != "" {
+		if len(cfg.EmailTo) > 0 {
+			notifiers = append(notifiers, &SMTPNotifier{
+				Addr:     cfg.SMTPAddr,
+				Username: cfg.SMTPUser,
+				Password: cfg.SMTPPassword,
+				From:     cfg.EmailFrom,
+				To:       cfg.EmailTo,
+			})
+		} else {
+			log.Printf("notify.smtp_addr is set without notify.email_to, e-mail notifications disabled")
+		}
+	}
+
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, &WebhookNotifier{URL: cfg.WebhookURL})
+	}
+
+	return notifiers
+}
@@ -0,0 +1,235 @@
+// Package opendata aggregates the data of the homes to areas for publication as open data. Areas
+// with fewer homes than a threshold are suppressed (k-anonymity), and optional Laplace noise makes
+// the published sums differentially private per area and hour.
+package opendata
+
+import (
+	"io"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/export"
+)
+
+// Niveaus waarop huizen worden samengevoegd
+const (
+	LevelCommunity  = "community"   // De hele gemeenschap als één gebied
+	LevelPostalCode = "postal_code" // Per postcodegebied, de eerste cijfers van de postcode
+)
+
+// HomesMetric is the number of homes in an area, published next to the metrics of a dataset
+const HomesMetric = "homes"
+
+// Metric is a published value: the sum over the homes of an area
+type Metric struct {
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+	// Scale is the largest value of one home per hour relative to opendata.max_kwh_per_hour; values
+	// are clipped to it so that the noise covers every home
+	Scale float64 `json:"-"`
+}
+
+// Dataset is a published dataset with a row per area and hour
+type Dataset struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Metrics     []Metric `json:"metrics"`
+	// Delay is how long after the end of a day its data is complete
+	Delay time.Duration `json:"-"`
+}
+
+// Datasets are the published datasets
+var Datasets = []Dataset{
+	{
+		Name:        "energy",
+		Description: "Verbruik en productie per uur, opgeteld over de huizen van het gebied",
+		Metrics: []Metric{
+			{Name: "consumption_kwh", Unit: "kWh", Scale: 1},
+			{Name: "production_kwh", Unit: "kWh", Scale: 1},
+		},
+		// Uurdata van Tibber komt met de nachtelijke inhaalslag binnen
+		Delay: 6 * time.Hour,
+	},
+	{
+		Name:        "live",
+		Description: "Gemiddeld vermogen per uur uit de live metingen, opgeteld over de huizen van het gebied",
+		Metrics: []Metric{
+			{Name: "power_w", Unit: "W", Scale: 1000},
+			{Name: "power_production_w", Unit: "W", Scale: 1000},
+		},
+		Delay: time.Hour,
+	},
+}
+
+// Find returns the dataset with the given name
+func Find(name string) (Dataset, bool) {
+	for _, d := range Datasets {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Dataset{}, false
+}
+
+// Area returns the area of a home for a level, empty when the home cannot be placed
+func Area(level, community, postalCode string, digits int) string {
+	if level == LevelCommunity {
+		return community
+	}
+	code := strings.ReplaceAll(postalCode, " ", "")
+	if len(code) < digits {
+		return ""
+	}
+	for _, r := range code[:digits] {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+	return code[:digits]
+}
+
+// Sample is the value of one home in one hour, with a value per metric of the dataset
+type Sample struct {
+	Area   string
+	HomeID string
+	Start  time.Time
+	Values []float64
+}
+
+// Row is a published row: the number of homes and the sum per metric of an area in an hour
+type Row struct {
+	Area   string    `json:"area"`
+	Start  time.Time `json:"start"`
+	Homes  float64   `json:"homes"`
+	Values []float64 `json:"values"`
+}
+
+// Options are the privacy settings of an aggregation
+type Options struct {
+	MinHomes      int     // Gebieden met minder huizen worden niet gepubliceerd
+	Epsilon       float64 // Privacybudget per gebied en uur, 0 voor geen ruis
+	MaxKWhPerHour float64 // Grootste bijdrage van één huis per uur, bepaalt de hoeveelheid ruis
+	Rand          *rand.Rand
+}
+
+// Aggregate sums the samples per area and hour. Groups with fewer than MinHomes homes are left
+// out; their number is returned as suppressed. With an Epsilon the budget is split evenly over
+// the number of homes and the metrics, and Laplace noise is added to each. Values are clipped to
+// the largest contribution of a home, and the results rounded and never negative.
+func Aggregate(d Dataset, samples []Sample, opts Options) (rows []Row, suppressed int) {
+	type key struct {
+		area  string
+		start int64
+	}
+	homes := make(map[key]map[string]bool)
+	sums := make(map[key][]float64)
+	for _, s := range samples {
+		if s.Area == "" || len(s.Values) != len(d.Metrics) {
+			continue
+		}
+		k := key{s.Area, s.Start.Unix()}
+		if homes[k] == nil {
+			homes[k] = make(map[string]bool)
+			sums[k] = make([]float64, len(d.Metrics))
+		}
+		homes[k][s.HomeID] = true
+		for i, v := range s.Values {
+			sums[k][i] += clip(v, d.Metrics[i].Scale*opts.MaxKWhPerHour)
+		}
+	}
+
+	// Op volgorde van uur en gebied, zodat dezelfde Rand dezelfde ruis geeft
+	keys := make([]key, 0, len(homes))
+	for k := range homes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].start != keys[j].start {
+			return keys[i].start < keys[j].start
+		}
+		return keys[i].area < keys[j].area
+	})
+
+	// Eén epsilon per gebied en uur, verdeeld over het aantal huizen en elke metriek
+	share := opts.Epsilon / float64(len(d.Metrics)+1)
+	for _, k := range keys {
+		set := homes[k]
+		if len(set) < opts.MinHomes {
+			suppressed++
+			continue
+		}
+		row := Row{Area: k.area, Start: time.Unix(k.start, 0).UTC(), Homes: float64(len(set))}
+		if opts.Epsilon > 0 {
+			row.Homes += laplace(opts.Rand, 1/share)
+		}
+		row.Homes = math.Max(0, math.Round(row.Homes))
+		for i, sum := range sums[k] {
+			if opts.Epsilon > 0 {
+				sum += laplace(opts.Rand, d.Metrics[i].Scale*opts.MaxKWhPerHour/share)
+			}
+			row.Values = append(row.Values, math.Max(0, math.Round(sum*1000)/1000))
+		}
+		rows = append(rows, row)
+	}
+	return rows, suppressed
+}
+
+// clip limits a value to [0, max]; production and consumption are never negative
+func clip(v, max float64) float64 {
+	if math.IsNaN(v) || v < 0 {
+		return 0
+	}
+	return math.Min(v, max)
+}
+
+// laplace draws from the Laplace distribution around 0 with the given scale
+func laplace(r *rand.Rand, scale float64) float64 {
+	for {
+		var u float64
+		if r != nil {
+			u = r.Float64() - 0.5
+		} else {
+			u = rand.Float64() - 0.5
+		}
+		if x := 1 - 2*math.Abs(u); x > 0 {
+			if u < 0 {
+				return scale * math.Log(x)
+			}
+			return -scale * math.Log(x)
+		}
+	}
+}
+
+// Columns returns the export columns of a dataset: area, start, homes and the metrics
+func (d Dataset) Columns() []export.Column {
+	columns := []export.Column{
+		{Name: "area", Type: export.String},
+		{Name: "start", Type: export.Time},
+		{Name: HomesMetric, Type: export.Int},
+	}
+	for _, m := range d.Metrics {
+		columns = append(columns, export.Column{Name: m.Name, Type: export.Float})
+	}
+	return columns
+}
+
+// Write writes rows of a dataset in an export format
+func Write(w io.Writer, format export.Format, d Dataset, rows []Row) error {
+	writer, err := export.NewWriter(format, w, d.Columns())
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		values := []any{r.Area, r.Start, int64(r.Homes)}
+		for _, v := range r.Values {
+			values = append(values, v)
+		}
+		if err := writer.Write(values); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
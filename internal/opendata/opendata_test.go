@@ -0,0 +1,125 @@
+package opendata
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+var hour = time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+// samples returns one sample per home in an area for the energy dataset
+func samples(area string, start time.Time, values ...[2]float64) []Sample {
+	var list []Sample
+	for i, v := range values {
+		list = append(list, Sample{Area: area, HomeID: area + "-" + string(rune('a'+i)), Start: start, Values: []float64{v[0], v[1]}})
+	}
+	return list
+}
+
+func TestAggregateSuppression(t *testing.T) {
+	energy, _ := Find("energy")
+	var list []Sample
+	list = append(list, samples("3511", hour, [2]float64{1, 0}, [2]float64{2, 0.5}, [2]float64{0.5, 1})...)
+	list = append(list, samples("3512", hour, [2]float64{1, 0}, [2]float64{1, 0})...)
+	list = append(list, samples("3511", hour.Add(time.Hour), [2]float64{1, 0})...)
+	// Zonder gebied of met het verkeerde aantal waarden telt een meting niet mee
+	list = append(list, Sample{HomeID: "x", Start: hour, Values: []float64{1, 1}}, Sample{Area: "3511", HomeID: "y", Start: hour, Values: []float64{1}})
+
+	rows, suppressed := Aggregate(energy, list, Options{MinHomes: 3, MaxKWhPerHour: 10})
+	if suppressed != 2 {
+		t.Errorf("suppressed %d, want 2 (3512 and the second hour of 3511)", suppressed)
+	}
+	if len(rows) != 1 || rows[0].Area != "3511" || !rows[0].Start.Equal(hour) {
+		t.Fatalf("rows %+v, want only 3511 at 10:00", rows)
+	}
+
+	// Zonder ruis zijn het exacte sommen; gemiddelden volgen uit het aantal huizen
+	if r := rows[0]; r.Homes != 3 || r.Values[0] != 3.5 || r.Values[1] != 1.5 {
+		t.Errorf("row %+v, want 3 homes, 3.5 and 1.5 kWh", r)
+	}
+	if mean := rows[0].Values[0] / rows[0].Homes; math.Abs(mean-3.5/3) > 1e-12 {
+		t.Errorf("mean consumption %v", mean)
+	}
+}
+
+func TestAggregateClipsAndCountsHomesOnce(t *testing.T) {
+	energy, _ := Find("energy")
+	list := samples("community", hour, [2]float64{25, -1}, [2]float64{math.NaN(), 2}, [2]float64{1, 0})
+	// Hetzelfde huis twee keer in een uur telt één keer als huis, de waarden tellen op
+	list = append(list, Sample{Area: "community", HomeID: "community-c", Start: hour, Values: []float64{1, 0}})
+
+	rows, _ := Aggregate(energy, list, Options{MinHomes: 3, MaxKWhPerHour: 10})
+	if len(rows) != 1 {
+		t.Fatalf("rows %+v", rows)
+	}
+	if r := rows[0]; r.Homes != 3 || r.Values[0] != 12 || r.Values[1] != 2 {
+		t.Errorf("row %+v, want 3 homes, 10 + 0 + 1 + 1 and 0 + 2 + 0 + 0 kWh", r)
+	}
+}
+
+func TestAggregateNoise(t *testing.T) {
+	energy, _ := Find("energy")
+	var list []Sample
+	for h := 0; h < 200; h++ {
+		list = append(list, samples("community", hour.Add(time.Duration(h)*time.Hour),
+			[2]float64{5, 1}, [2]float64{5, 1}, [2]float64{5, 1}, [2]float64{5, 1}, [2]float64{5, 1})...)
+	}
+	opts := Options{MinHomes: 3, Epsilon: 1, MaxKWhPerHour: 2, Rand: rand.New(rand.NewPCG(1, 2))}
+	rows, _ := Aggregate(energy, list, opts)
+	if len(rows) != 200 {
+		t.Fatalf("%d rows, want 200", len(rows))
+	}
+
+	// Het budget gaat over het aantal huizen en twee metrieken: schaal 1 / (1/3) voor de huizen,
+	// 2 / (1/3) voor de waarden. Met dit zaad blijft de ruis binnen 12 keer de schaal.
+	exact := [3]float64{5, 10, 5} // Huizen en de geclipte sommen 5 × 2 en 5 × 1
+	scales := [3]float64{3, 6, 6}
+	var noisy bool
+	var mean [3]float64
+	for _, r := range rows {
+		got := [3]float64{r.Homes, r.Values[0], r.Values[1]}
+		for i := range got {
+			if got[i] < 0 || math.Abs(got[i]-exact[i]) > 12*scales[i] {
+				t.Errorf("%s: value %d is %v, exact %v", r.Start.Format("01-02 15:04"), i, got[i], exact[i])
+			}
+			noisy = noisy || got[i] != exact[i]
+			mean[i] += got[i] / float64(len(rows))
+		}
+	}
+	if !noisy {
+		t.Error("no noise with epsilon 1")
+	}
+	// Gemiddeld over veel uren komt de ruis rond nul uit, op het afronden op nul na
+	for i := range mean {
+		if math.Abs(mean[i]-exact[i]) > scales[i] {
+			t.Errorf("mean of value %d is %v, exact %v", i, mean[i], exact[i])
+		}
+	}
+
+	// Hetzelfde zaad geeft dezelfde uitkomst
+	opts.Rand = rand.New(rand.NewPCG(1, 2))
+	again, _ := Aggregate(energy, list, opts)
+	for i := range rows {
+		if rows[i].Homes != again[i].Homes || rows[i].Values[0] != again[i].Values[0] {
+			t.Fatalf("row %d differs with the same seed", i)
+		}
+	}
+}
+
+func TestArea(t *testing.T) {
+	tests := []struct {
+		level, postalCode, want string
+	}{
+		{LevelCommunity, "", "Utrecht"},
+		{LevelPostalCode, "3511 AB", "3511"},
+		{LevelPostalCode, "35", ""},
+		{LevelPostalCode, "AB12", ""},
+	}
+	for _, tt := range tests {
+		if got := Area(tt.level, "Utrecht", tt.postalCode, 4); got != tt.want {
+			t.Errorf("Area(%s, %q) = %q, want %q", tt.level, tt.postalCode, got, tt.want)
+		}
+	}
+}
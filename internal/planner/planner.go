@@ -0,0 +1,73 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// Window is a block of consecutive hours to run a flexible load in
+type Window struct {
+	Start        time.Time     `json:"start"`
+	End          time.Time     `json:"end"`
+	AveragePrice float64       `json:"averagePrice"`
+	Forecasted   int           `json:"forecasted"` // Aantal uren in het venster dat op een voorspelling berust
+	Prices       []model.Price `json:"prices"`
+}
+
+// CheapestWindow finds the consecutive block of hours with the lowest average total price.
+// Prices must be hourly and sorted; a gap in the prices breaks a block.
+func CheapestWindow(prices []model.Price, hours int) (*Window, error) {
+	if hours <= 0 {
+		return nil, fmt.Errorf("window must be at least one hour")
+	}
+
+	starts := make([]time.Time, len(prices))
+	for i, p := range prices {
+		t, err := localtime.ParseTimestamp(p.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		starts[i] = t
+	}
+
+	var best *Window
+	bestAvg := math.Inf(1)
+	for i := 0; i+hours <= len(prices); i++ {
+		var sum float64
+		var forecasted int
+		contiguous := true
+		for j := i; j < i+hours; j++ {
+			if j > i && !starts[j].Equal(starts[j-1].Add(time.Hour)) {
+				contiguous = false
+				break
+			}
+			sum += prices[j].Total
+			if prices[j].IsForecast {
+				forecasted++
+			}
+		}
+		if !contiguous {
+			continue
+		}
+
+		if avg := sum / float64(hours); avg < bestAvg {
+			bestAvg = avg
+			best = &Window{
+				Start:        starts[i],
+				End:          starts[i+hours-1].Add(time.Hour),
+				AveragePrice: math.Round(avg*10000) / 10000,
+				Forecasted:   forecasted,
+				Prices:       prices[i : i+hours],
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no %d consecutive hours with prices available", hours)
+	}
+	return best, nil
+}
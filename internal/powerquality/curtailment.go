@@ -0,0 +1,270 @@
+package powerquality
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+// Drempels voor het herkennen van afschakelende omvormers
+const (
+	CurtailmentVoltage     = 250.0 // Spanning vanaf waar afschakelen door de omvormer aannemelijk is
+	MinCurtailmentW        = 200.0 // Productie voor de daling, daaronder is een daling niet te onderscheiden
+	CurtailmentDropRatio   = 0.1   // Productie onder dit deel van de referentie geldt als uitgeschakeld
+	CurtailmentRecovery    = 0.5   // Productie boven dit deel van de referentie beëindigt de episode
+	PeerProducingRatio     = 0.5   // Buren houden minstens dit deel van hun productie vast
+	MinCurtailmentPeers    = 2
+	CurtailmentReference   = 10 * time.Minute // Venster voor de productie voor de daling
+	CurtailmentVoltageSpan = 2 * time.Minute  // Venster waarin de spanning hoog moet zijn geweest
+	MaxCurtailment         = 2 * time.Hour
+	PeerSampleAge          = 2 * time.Minute // Oudere metingen van een buur tellen niet
+)
+
+// Betrouwbaarheid van een episode
+const (
+	ConfidenceHigh   = "HIGH"   // Buren bleven produceren
+	ConfidenceMedium = "MEDIUM" // Geen buren om mee te vergelijken
+)
+
+// CurtailmentEpisode is a period in which the inverter of a home likely switched off because of high grid voltage
+type CurtailmentEpisode struct {
+	HomeId      string    `json:"homeId"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	MaxVoltage  float64   `json:"maxVoltage"`
+	ReferenceW  float64   `json:"referenceW"` // Productie voor de daling
+	LostKWh     float64   `json:"lostKWh"`
+	Price       float64   `json:"price"` // Gemiddelde prijs per kWh over de episode
+	LostRevenue float64   `json:"lostRevenue"`
+	PeerRatio   float64   `json:"peerRatio"` // Productie van de buren ten opzichte van voor de daling, -1 als onbekend
+	Confidence  string    `json:"confidence"`
+}
+
+// Duration is how long the inverter was off
+func (e CurtailmentEpisode) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// PeerOutput tells how the production of other homes at t compares to their production at from
+type PeerOutput func(from, t time.Time) (ratio float64, ok bool)
+
+// NewPeerOutput builds a PeerOutput from the time-ordered measurements of the other homes
+func NewPeerOutput(series map[string][]tibber.Measurement) PeerOutput {
+	return func(from, t time.Time) (float64, bool) {
+		var ratios []float64
+		for _, ms := range series {
+			before, ok := productionAt(ms, from)
+			if !ok || before < MinCurtailmentW {
+				continue
+			}
+			now, ok := productionAt(ms, t)
+			if !ok {
+				continue
+			}
+			ratios = append(ratios, now/before)
+		}
+		if len(ratios) < MinCurtailmentPeers {
+			return 0, false
+		}
+		sort.Float64s(ratios)
+		return ratios[len(ratios)/2], true
+	}
+}
+
+// productionAt returns the last production measured at or before t
+func productionAt(ms []tibber.Measurement, t time.Time) (float64, bool) {
+	i := sort.Search(len(ms), func(i int) bool { return ms[i].Timestamp.After(t) }) - 1
+	if i < 0 || t.Sub(ms[i].Timestamp) > PeerSampleAge {
+		return 0, false
+	}
+	return ms[i].PowerProduction, true
+}
+
+// DetectCurtailment finds episodes in the time-ordered measurements of a home where production dropped
+// to almost nothing while the voltage was close to the limit. With peers, episodes in which the other
+// homes lost production as well (a cloud) are discarded. price returns the price per kWh at a moment
+// and may be nil.
+func DetectCurtailment(homeId string, ms []tibber.Measurement, peers PeerOutput, price func(t time.Time) float64) []CurtailmentEpisode {
+	var episodes []CurtailmentEpisode
+
+	for i := 1; i < len(ms); i++ {
+		m := ms[i]
+		reference := referenceProduction(ms, i)
+		if reference < MinCurtailmentW || m.PowerProduction > reference*CurtailmentDropRatio {
+			continue
+		}
+		maxVoltage := recentMaxVoltage(ms, i)
+		if maxVoltage < CurtailmentVoltage {
+			continue
+		}
+
+		// Episode loopt tot de productie herstelt, de metingen stoppen of het te lang duurt
+		episode := CurtailmentEpisode{
+			HomeId:     homeId,
+			Start:      m.Timestamp,
+			MaxVoltage: maxVoltage,
+			ReferenceW: reference,
+			PeerRatio:  -1,
+		}
+		before := ms[i-1].Timestamp // Buren worden vergeleken met hun productie vlak voor de daling
+		var lostWh, priceWh, peerSum float64
+		peerCount := 0
+		j := i
+		for ; j < len(ms); j++ {
+			cur := ms[j]
+			if cur.PowerProduction >= reference*CurtailmentRecovery || cur.Timestamp.Sub(episode.Start) > MaxCurtailment {
+				break
+			}
+			if j > i && cur.Timestamp.Sub(ms[j-1].Timestamp) > MaxSampleInterval {
+				break
+			}
+			episode.MaxVoltage = math.Max(episode.MaxVoltage, maxPhaseVoltage(cur))
+
+			// Verwachte productie volgt de buren als die er zijn
+			expected := reference
+			if peers != nil {
+				if ratio, ok := peers(before, cur.Timestamp); ok {
+					expected = reference * math.Min(ratio, 1.5)
+					peerSum += ratio
+					peerCount++
+				}
+			}
+
+			if j+1 < len(ms) {
+				step := ms[j+1].Timestamp.Sub(cur.Timestamp)
+				if step > MaxSampleInterval {
+					step = 0
+				}
+				lost := math.Max(expected-cur.PowerProduction, 0) * step.Hours()
+				lostWh += lost
+				if price != nil {
+					priceWh += lost * price(cur.Timestamp)
+				}
+			}
+		}
+		if j < len(ms) {
+			episode.End = ms[j].Timestamp
+		} else {
+			episode.End = ms[len(ms)-1].Timestamp
+		}
+		i = j
+
+		episode.Confidence = ConfidenceMedium
+		if peerCount > 0 {
+			episode.PeerRatio = round2(peerSum / float64(peerCount))
+			if episode.PeerRatio < PeerProducingRatio {
+				continue // Buren zakten ook in: bewolking, geen afschakeling
+			}
+			episode.Confidence = ConfidenceHigh
+		}
+
+		if lostWh <= 0 {
+			continue
+		}
+		episode.LostKWh = math.Round(lostWh) / 1000
+		if lostWh > 0 && price != nil {
+			episode.Price = round4(priceWh / lostWh)
+		}
+		episode.LostRevenue = round2(episode.LostKWh * episode.Price)
+		episode.ReferenceW = math.Round(episode.ReferenceW)
+		episodes = append(episodes, episode)
+	}
+
+	return episodes
+}
+
+// referenceProduction is the average production in the window before measurement i
+func referenceProduction(ms []tibber.Measurement, i int) float64 {
+	var sum float64
+	n := 0
+	for k := i - 1; k >= 0 && ms[i].Timestamp.Sub(ms[k].Timestamp) <= CurtailmentReference; k-- {
+		sum += ms[k].PowerProduction
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// recentMaxVoltage is the highest phase voltage shortly before and at measurement i
+func recentMaxVoltage(ms []tibber.Measurement, i int) float64 {
+	var max float64
+	for k := i; k >= 0 && ms[i].Timestamp.Sub(ms[k].Timestamp) <= CurtailmentVoltageSpan; k-- {
+		max = math.Max(max, maxPhaseVoltage(ms[k]))
+	}
+	return max
+}
+
+func maxPhaseVoltage(m tibber.Measurement) float64 {
+	var max float64
+	for _, v := range []*float64{m.VoltagePhase1, m.VoltagePhase2, m.VoltagePhase3} {
+		if v != nil {
+			max = math.Max(max, *v)
+		}
+	}
+	return max
+}
+
+// CurtailmentMonth is the curtailment of a home or postal area in one month
+type CurtailmentMonth struct {
+	Month       string  `json:"month"` // YYYY-MM
+	Key         string  `json:"key"`   // Home ID of postcodegebied
+	Homes       int     `json:"homes"`
+	Episodes    int     `json:"episodes"`
+	Minutes     float64 `json:"minutes"`
+	LostKWh     float64 `json:"lostKWh"`
+	LostRevenue float64 `json:"lostRevenue"`
+}
+
+// SummarizeCurtailment totals episodes per month and key. keyOf maps a home to the key to group on,
+// such as the home itself or its postal area; loc determines the month an episode falls in.
+func SummarizeCurtailment(episodes []CurtailmentEpisode, keyOf func(homeId string) string, loc *time.Location) []CurtailmentMonth {
+	type group struct {
+		month CurtailmentMonth
+		homes map[string]bool
+	}
+	groups := make(map[string]*group)
+
+	for _, e := range episodes {
+		key := keyOf(e.HomeId)
+		month := e.Start.In(loc).Format("2006-01")
+		g, ok := groups[month+"|"+key]
+		if !ok {
+			g = &group{month: CurtailmentMonth{Month: month, Key: key}, homes: make(map[string]bool)}
+			groups[month+"|"+key] = g
+		}
+		g.homes[e.HomeId] = true
+		g.month.Episodes++
+		g.month.Minutes += e.Duration().Minutes()
+		g.month.LostKWh += e.LostKWh
+		g.month.LostRevenue += e.LostRevenue
+	}
+
+	months := make([]CurtailmentMonth, 0, len(groups))
+	for _, g := range groups {
+		m := g.month
+		m.Homes = len(g.homes)
+		m.Minutes = round1(m.Minutes)
+		m.LostKWh = round2(m.LostKWh)
+		m.LostRevenue = round2(m.LostRevenue)
+		months = append(months, m)
+	}
+	sort.Slice(months, func(i, j int) bool {
+		if months[i].Month != months[j].Month {
+			return months[i].Month > months[j].Month
+		}
+		return months[i].LostKWh > months[j].LostKWh
+	})
+	return months
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
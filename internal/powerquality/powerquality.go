@@ -0,0 +1,263 @@
+package powerquality
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"ws/internal/tibber"
+)
+
+// Spanningsgrenzen volgens NEN-EN 50160 (230 V ± 10%)
+const (
+	NominalVoltage    = 230.0
+	OvervoltageLimit  = 253.0 // Boven deze spanning schakelen omvormers af
+	UndervoltageLimit = 207.0
+)
+
+// Drempels voor onbalans, zekeringbelasting en spanningsgebeurtenissen
+const (
+	MinImbalanceCurrent = 2.0  // Onder deze gemiddelde stroom in A zegt onbalans weinig
+	HighImbalance       = 50.0 // Onbalans in % waarboven een fase veel zwaarder belast is dan de rest
+	HighFuseUtilization = 80.0 // Belasting van de hoofdzekering in %
+	EventGap            = 5 * time.Minute
+	MaxSampleInterval   = 5 * time.Minute // Langere gaten tellen niet mee als duur
+)
+
+// VoltageEvent is a period in which the voltage of one phase stayed above the overvoltage limit
+type VoltageEvent struct {
+	HomeId     string    `json:"homeId"`
+	Phase      int       `json:"phase"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	MaxVoltage float64   `json:"maxVoltage"`
+	Production float64   `json:"production"` // Hoogste teruglevering in W tijdens de gebeurtenis
+}
+
+// Duration is how long the event lasted
+func (e VoltageEvent) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// Phase holds the voltage and current statistics of one phase
+type Phase struct {
+	Samples     int     `json:"samples"`
+	MinVoltage  float64 `json:"minVoltage"`
+	MaxVoltage  float64 `json:"maxVoltage"`
+	MeanVoltage float64 `json:"meanVoltage"`
+	MaxCurrent  float64 `json:"maxCurrent"`
+	MeanCurrent float64 `json:"meanCurrent"`
+}
+
+// Summary is the power quality of a home over a period
+type Summary struct {
+	HomeId   string    `json:"homeId"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Samples  int       `json:"samples"`
+	Phases   [3]Phase  `json:"phases"`
+	FuseSize int       `json:"fuseSize"` // Hoofdzekering in A, 0 als onbekend
+
+	MeanImbalance float64 `json:"meanImbalance"` // %
+	MaxImbalance  float64 `json:"maxImbalance"`  // %
+
+	MaxFuseUtilization float64 `json:"maxFuseUtilization"` // % van de hoofdzekering
+	P95FuseUtilization float64 `json:"p95FuseUtilization"`
+	HighFuseMinutes    float64 `json:"highFuseMinutes"`
+
+	MinVoltage         float64        `json:"minVoltage"`
+	MaxVoltage         float64        `json:"maxVoltage"`
+	UndervoltageCount  int            `json:"undervoltageCount"`
+	OvervoltageMinutes float64        `json:"overvoltageMinutes"`
+	EventCount         int            `json:"eventCount"`
+	Events             []VoltageEvent `json:"events,omitempty"`
+}
+
+// HasPhaseData reports whether the meter delivered any voltage or current readings.
+// Stored daily summaries have no per-phase detail, so the totals are checked as well.
+func (s Summary) HasPhaseData() bool {
+	for _, p := range s.Phases {
+		if p.Samples > 0 || p.MaxCurrent > 0 {
+			return true
+		}
+	}
+	return s.MaxVoltage > 0 || s.MaxFuseUtilization > 0 || s.MaxImbalance > 0
+}
+
+// Imbalance returns the largest deviation of a phase current from the mean, in percent of the mean.
+// Only phases that report a current are used; with less than two phases or a low load there is no imbalance.
+func Imbalance(currents []float64) (float64, bool) {
+	if len(currents) < 2 {
+		return 0, false
+	}
+
+	var sum float64
+	for _, c := range currents {
+		sum += c
+	}
+	mean := sum / float64(len(currents))
+	if mean < MinImbalanceCurrent {
+		return 0, false
+	}
+
+	var deviation float64
+	for _, c := range currents {
+		deviation = math.Max(deviation, math.Abs(c-mean))
+	}
+	return deviation / mean * 100, true
+}
+
+// Analyze computes the power quality of a home from its real-time measurements, which must be in time order
+func Analyze(homeId string, fuseSize int, measurements []tibber.Measurement) Summary {
+	summary := Summary{HomeId: homeId, FuseSize: fuseSize}
+	if len(measurements) == 0 {
+		return summary
+	}
+	summary.From = measurements[0].Timestamp
+	summary.To = measurements[len(measurements)-1].Timestamp
+	summary.Samples = len(measurements)
+
+	var (
+		voltageSums, currentSums [3]float64
+		currentCounts            [3]int
+		imbalanceSum             float64
+		imbalanceCount           int
+		utilizations             []float64
+		open                     [3]*VoltageEvent
+	)
+	summary.MinVoltage = math.Inf(1)
+	for i := range summary.Phases {
+		summary.Phases[i].MinVoltage = math.Inf(1)
+	}
+
+	for i, m := range measurements {
+		// Tijd tot de volgende meting telt als duur van deze meting
+		var step time.Duration
+		if i+1 < len(measurements) {
+			step = measurements[i+1].Timestamp.Sub(m.Timestamp)
+			if step > MaxSampleInterval {
+				step = 0
+			}
+		}
+
+		voltages := [3]*float64{m.VoltagePhase1, m.VoltagePhase2, m.VoltagePhase3}
+		currents := [3]*float64{m.CurrentL1, m.CurrentL2, m.CurrentL3}
+
+		overvoltage := false
+		var present []float64
+		maxCurrent := 0.0
+		for p := 0; p < 3; p++ {
+			phase := &summary.Phases[p]
+
+			if v := voltages[p]; v != nil && *v > 0 {
+				phase.Samples++
+				phase.MinVoltage = math.Min(phase.MinVoltage, *v)
+				phase.MaxVoltage = math.Max(phase.MaxVoltage, *v)
+				voltageSums[p] += *v
+				summary.MinVoltage = math.Min(summary.MinVoltage, *v)
+				summary.MaxVoltage = math.Max(summary.MaxVoltage, *v)
+				if *v < UndervoltageLimit {
+					summary.UndervoltageCount++
+				}
+
+				if *v > OvervoltageLimit {
+					overvoltage = true
+					if e := open[p]; e != nil && m.Timestamp.Sub(e.End) <= EventGap {
+						e.End = m.Timestamp.Add(step)
+						e.MaxVoltage = math.Max(e.MaxVoltage, *v)
+						e.Production = math.Max(e.Production, m.PowerProduction)
+					} else {
+						if e != nil {
+							summary.Events = append(summary.Events, *e)
+						}
+						open[p] = &VoltageEvent{
+							HomeId:     homeId,
+							Phase:      p + 1,
+							Start:      m.Timestamp,
+							End:        m.Timestamp.Add(step),
+							MaxVoltage: *v,
+							Production: m.PowerProduction,
+						}
+					}
+				}
+			}
+
+			if c := currents[p]; c != nil {
+				present = append(present, math.Abs(*c))
+				phase.MaxCurrent = math.Max(phase.MaxCurrent, math.Abs(*c))
+				currentSums[p] += math.Abs(*c)
+				currentCounts[p]++
+				maxCurrent = math.Max(maxCurrent, math.Abs(*c))
+			}
+		}
+
+		if overvoltage {
+			summary.OvervoltageMinutes += step.Minutes()
+		}
+
+		if imbalance, ok := Imbalance(present); ok {
+			imbalanceSum += imbalance
+			imbalanceCount++
+			summary.MaxImbalance = math.Max(summary.MaxImbalance, imbalance)
+		}
+
+		if fuseSize > 0 && len(present) > 0 {
+			utilization := maxCurrent / float64(fuseSize) * 100
+			utilizations = append(utilizations, utilization)
+			summary.MaxFuseUtilization = math.Max(summary.MaxFuseUtilization, utilization)
+			if utilization >= HighFuseUtilization {
+				summary.HighFuseMinutes += step.Minutes()
+			}
+		}
+	}
+
+	for p := range open {
+		if open[p] != nil {
+			summary.Events = append(summary.Events, *open[p])
+		}
+	}
+	sort.Slice(summary.Events, func(i, j int) bool {
+		return summary.Events[i].Start.Before(summary.Events[j].Start)
+	})
+	summary.EventCount = len(summary.Events)
+
+	for p := range summary.Phases {
+		phase := &summary.Phases[p]
+		if phase.Samples > 0 {
+			phase.MeanVoltage = round1(voltageSums[p] / float64(phase.Samples))
+		} else {
+			phase.MinVoltage = 0
+		}
+		if currentCounts[p] > 0 {
+			phase.MeanCurrent = round1(currentSums[p] / float64(currentCounts[p]))
+		}
+	}
+	if math.IsInf(summary.MinVoltage, 1) {
+		summary.MinVoltage = 0
+	}
+
+	if imbalanceCount > 0 {
+		summary.MeanImbalance = round1(imbalanceSum / float64(imbalanceCount))
+	}
+	summary.MaxImbalance = round1(summary.MaxImbalance)
+	summary.MaxFuseUtilization = round1(summary.MaxFuseUtilization)
+	summary.P95FuseUtilization = round1(percentile(utilizations, 0.95))
+	summary.HighFuseMinutes = round1(summary.HighFuseMinutes)
+	summary.OvervoltageMinutes = round1(summary.OvervoltageMinutes)
+
+	return summary
+}
+
+func percentile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
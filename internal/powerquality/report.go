@@ -0,0 +1,221 @@
+package powerquality
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Niveaus van aggregatie op postcode
+const (
+	NeighbourhoodDigits = 4 // 1234: wijk
+	StreetDigits        = 6 // 1234AB: straat of deel van een straat
+)
+
+// PostalArea normalizes a Dutch postal code and shortens it to the given aggregation level
+func PostalArea(postalCode string, digits int) string {
+	code := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(postalCode), " ", ""))
+	if code == "" {
+		return "ONBEKEND"
+	}
+	if digits > 0 && len(code) > digits {
+		code = code[:digits]
+	}
+	return code
+}
+
+// Area is the power quality of all homes in one postal area over a period
+type Area struct {
+	Area                 string  `json:"area"`
+	Homes                int     `json:"homes"`
+	HomesWithPhaseData   int     `json:"homesWithPhaseData"`
+	HomesWithOvervoltage int     `json:"homesWithOvervoltage"`
+	Events               int     `json:"events"`
+	OvervoltageMinutes   float64 `json:"overvoltageMinutes"`
+	MaxVoltage           float64 `json:"maxVoltage"`
+	MeanImbalance        float64 `json:"meanImbalance"`
+	HomesHighImbalance   int     `json:"homesHighImbalance"`
+	MaxFuseUtilization   float64 `json:"maxFuseUtilization"`
+	HomesHighFuse        int     `json:"homesHighFuse"`
+}
+
+// Aggregate combines summaries per postal area. areaOf maps a home to its area;
+// a home may have several summaries (one per day), it is counted once per area.
+func Aggregate(summaries []Summary, areaOf map[string]string) []Area {
+	type state struct {
+		area                          Area
+		homes, phaseData, overvoltage map[string]bool
+		highImbalance, highFuse       map[string]bool
+		imbalanceSum                  float64
+		imbalanceCount                int
+	}
+	states := make(map[string]*state)
+
+	for _, s := range summaries {
+		name := areaOf[s.HomeId]
+		if name == "" {
+			name = "ONBEKEND"
+		}
+		st, ok := states[name]
+		if !ok {
+			st = &state{
+				area:          Area{Area: name},
+				homes:         make(map[string]bool),
+				phaseData:     make(map[string]bool),
+				overvoltage:   make(map[string]bool),
+				highImbalance: make(map[string]bool),
+				highFuse:      make(map[string]bool),
+			}
+			states[name] = st
+		}
+
+		st.homes[s.HomeId] = true
+		if !s.HasPhaseData() {
+			continue
+		}
+		st.phaseData[s.HomeId] = true
+
+		if s.EventCount > 0 {
+			st.overvoltage[s.HomeId] = true
+		}
+		st.area.Events += s.EventCount
+		st.area.OvervoltageMinutes += s.OvervoltageMinutes
+		st.area.MaxVoltage = math.Max(st.area.MaxVoltage, s.MaxVoltage)
+
+		if s.MeanImbalance > 0 {
+			st.imbalanceSum += s.MeanImbalance
+			st.imbalanceCount++
+		}
+		if s.MeanImbalance >= HighImbalance {
+			st.highImbalance[s.HomeId] = true
+		}
+
+		st.area.MaxFuseUtilization = math.Max(st.area.MaxFuseUtilization, s.MaxFuseUtilization)
+		if s.MaxFuseUtilization >= HighFuseUtilization {
+			st.highFuse[s.HomeId] = true
+		}
+	}
+
+	areas := make([]Area, 0, len(states))
+	for _, st := range states {
+		a := st.area
+		a.Homes = len(st.homes)
+		a.HomesWithPhaseData = len(st.phaseData)
+		a.HomesWithOvervoltage = len(st.overvoltage)
+		a.HomesHighImbalance = len(st.highImbalance)
+		a.HomesHighFuse = len(st.highFuse)
+		a.OvervoltageMinutes = round1(a.OvervoltageMinutes)
+		if st.imbalanceCount > 0 {
+			a.MeanImbalance = round1(st.imbalanceSum / float64(st.imbalanceCount))
+		}
+		areas = append(areas, a)
+	}
+
+	// Gebieden met de meeste overspanning eerst
+	sort.Slice(areas, func(i, j int) bool {
+		if areas[i].OvervoltageMinutes != areas[j].OvervoltageMinutes {
+			return areas[i].OvervoltageMinutes > areas[j].OvervoltageMinutes
+		}
+		return areas[i].Area < areas[j].Area
+	})
+	return areas
+}
+
+// WriteAreaCSV writes the area report for the grid operator. It contains no home ids.
+func WriteAreaCSV(w io.Writer, from, to time.Time, areas []Area) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+
+	header := []string{
+		"periode_van", "periode_tot", "postcodegebied", "aantal_woningen", "woningen_met_fasedata",
+		"woningen_met_overspanning", "overspanning_gebeurtenissen", "overspanning_minuten", "max_spanning_v",
+		"gem_onbalans_pct", "woningen_hoge_onbalans", "max_zekeringbelasting_pct", "woningen_hoge_zekeringbelasting",
+	}
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("error writing report header: %w", err)
+	}
+
+	for _, a := range areas {
+		record := []string{
+			from.Format("2006-01-02"),
+			to.Format("2006-01-02"),
+			a.Area,
+			fmt.Sprint(a.Homes),
+			fmt.Sprint(a.HomesWithPhaseData),
+			fmt.Sprint(a.HomesWithOvervoltage),
+			fmt.Sprint(a.Events),
+			fmt.Sprintf("%.1f", a.OvervoltageMinutes),
+			fmt.Sprintf("%.1f", a.MaxVoltage),
+			fmt.Sprintf("%.1f", a.MeanImbalance),
+			fmt.Sprint(a.HomesHighImbalance),
+			fmt.Sprintf("%.1f", a.MaxFuseUtilization),
+			fmt.Sprint(a.HomesHighFuse),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("error writing report: %w", err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteEventsCSV writes the individual overvoltage events with their postal area instead of the home
+func WriteEventsCSV(w io.Writer, events []VoltageEvent, areaOf map[string]string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+
+	if err := cw.Write([]string{"postcodegebied", "fase", "begin", "einde", "duur_minuten", "max_spanning_v", "teruglevering_w"}); err != nil {
+		return fmt.Errorf("error writing events header: %w", err)
+	}
+
+	for _, e := range events {
+		record := []string{
+			areaOf[e.HomeId],
+			fmt.Sprintf("L%d", e.Phase),
+			e.Start.Format(time.RFC3339),
+			e.End.Format(time.RFC3339),
+			fmt.Sprintf("%.1f", e.Duration().Minutes()),
+			fmt.Sprintf("%.1f", e.MaxVoltage),
+			fmt.Sprintf("%.0f", e.Production),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("error writing events: %w", err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCurtailmentCSV writes the monthly curtailment per postal area
+func WriteCurtailmentCSV(w io.Writer, months []CurtailmentMonth) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+
+	if err := cw.Write([]string{"maand", "postcodegebied", "woningen", "episodes", "minuten", "verloren_kwh", "verloren_euro"}); err != nil {
+		return fmt.Errorf("error writing curtailment header: %w", err)
+	}
+
+	for _, m := range months {
+		record := []string{
+			m.Month,
+			m.Key,
+			fmt.Sprint(m.Homes),
+			fmt.Sprint(m.Episodes),
+			fmt.Sprintf("%.1f", m.Minutes),
+			fmt.Sprintf("%.2f", m.LostKWh),
+			fmt.Sprintf("%.2f", m.LostRevenue),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("error writing curtailment: %w", err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
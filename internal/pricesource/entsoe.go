@@ -0,0 +1,321 @@
+package pricesource
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// DefaultEntsoeURL is the ENTSO-E Transparency Platform REST endpoint
+const DefaultEntsoeURL = "https://web-api.tp.entsoe.eu/api"
+
+// BiddingZones maps Tibber price area codes to ENTSO-E EIC codes
+var BiddingZones = map[string]string{
+	"NL":    "10YNL----------L",
+	"BE":    "10YBE----------2",
+	"DE":    "10Y1001A1001A82H",
+	"DE-LU": "10Y1001A1001A82H",
+}
+
+// DayAheadPrice is a single wholesale price point from a Publication_MarketDocument
+type DayAheadPrice struct {
+	Start      time.Time
+	Resolution time.Duration
+	Amount     float64 // Per MWh
+	Currency   string
+}
+
+// publicationDocument mirrors the parts of Publication_MarketDocument that we use.
+// Element names are matched without namespace, so all document versions work.
+type publicationDocument struct {
+	XMLName    xml.Name `xml:"Publication_MarketDocument"`
+	TimeSeries []struct {
+		InDomain string `xml:"in_Domain.mRID"`
+		Currency string `xml:"currency_Unit.name"`
+		Unit     string `xml:"price_Measure_Unit.name"`
+		Curve    string `xml:"curveType"`
+		Period   []struct {
+			TimeInterval struct {
+				Start string `xml:"start"`
+				End   string `xml:"end"`
+			} `xml:"timeInterval"`
+			Resolution string `xml:"resolution"`
+			Points     []struct {
+				Position int     `xml:"position"`
+				Amount   float64 `xml:"price.amount"`
+			} `xml:"Point"`
+		} `xml:"Period"`
+	} `xml:"TimeSeries"`
+}
+
+// acknowledgementDocument is returned by ENTSO-E instead of prices when a request fails
+type acknowledgementDocument struct {
+	XMLName xml.Name `xml:"Acknowledgement_MarketDocument"`
+	Reason  []struct {
+		Code string `xml:"code"`
+		Text string `xml:"text"`
+	} `xml:"Reason"`
+}
+
+// ParsePublicationDocument parses an ENTSO-E day-ahead (A44) Publication_MarketDocument.
+// If biddingZone is not empty only time series for that in_Domain are returned.
+func ParsePublicationDocument(r io.Reader, biddingZone string) ([]DayAheadPrice, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading document: %w", err)
+	}
+
+	var doc publicationDocument
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		var ack acknowledgementDocument
+		if ackErr := xml.Unmarshal(body, &ack); ackErr == nil && len(ack.Reason) > 0 {
+			return nil, fmt.Errorf("ENTSO-E returned %s: %s", ack.Reason[0].Code, ack.Reason[0].Text)
+		}
+		return nil, fmt.Errorf("error parsing publication document: %w", err)
+	}
+
+	var prices []DayAheadPrice
+	for _, ts := range doc.TimeSeries {
+		if biddingZone != "" && strings.TrimSpace(ts.InDomain) != biddingZone {
+			continue
+		}
+		if unit := strings.ToUpper(strings.TrimSpace(ts.Unit)); unit != "" && unit != "MWH" {
+			return nil, fmt.Errorf("unsupported price unit %q", ts.Unit)
+		}
+
+		for _, period := range ts.Period {
+			start, err := parseEntsoeTime(period.TimeInterval.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseEntsoeTime(period.TimeInterval.End)
+			if err != nil {
+				return nil, err
+			}
+			resolution, err := parseResolution(period.Resolution)
+			if err != nil {
+				return nil, err
+			}
+
+			// Posities zijn 1-based; bij curveType A03 ontbreken posities met dezelfde prijs als de vorige
+			amounts := make(map[int]float64, len(period.Points))
+			for _, p := range period.Points {
+				amounts[p.Position] = p.Amount
+			}
+
+			slots := int(end.Sub(start) / resolution)
+			var last float64
+			var seen bool
+			for pos := 1; pos <= slots; pos++ {
+				amount, ok := amounts[pos]
+				if !ok {
+					if !seen {
+						continue
+					}
+					amount = last
+				}
+				last, seen = amount, true
+
+				prices = append(prices, DayAheadPrice{
+					Start:      start.Add(time.Duration(pos-1) * resolution),
+					Resolution: resolution,
+					Amount:     amount,
+					Currency:   strings.TrimSpace(ts.Currency),
+				})
+			}
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Start.Before(prices[j].Start) })
+	return prices, nil
+}
+
+// HourlyAverages aggregates sub-hourly (PT15M/PT30M) prices into hourly averages
+func HourlyAverages(prices []DayAheadPrice) []DayAheadPrice {
+	type bucket struct {
+		sum      float64
+		count    int
+		currency string
+	}
+
+	buckets := make(map[time.Time]*bucket)
+	var hours []time.Time
+	for _, p := range prices {
+		hour := p.Start.UTC().Truncate(time.Hour)
+		b, ok := buckets[hour]
+		if !ok {
+			b = &bucket{currency: p.Currency}
+			buckets[hour] = b
+			hours = append(hours, hour)
+		}
+		b.sum += p.Amount
+		b.count++
+	}
+
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+	hourly := make([]DayAheadPrice, 0, len(hours))
+	for _, hour := range hours {
+		b := buckets[hour]
+		hourly = append(hourly, DayAheadPrice{
+			Start:      hour,
+			Resolution: time.Hour,
+			Amount:     b.sum / float64(b.count),
+			Currency:   b.currency,
+		})
+	}
+	return hourly
+}
+
+// EntsoeSource fetches day-ahead prices for a bidding zone and applies a supplier tariff
+type EntsoeSource struct {
+	Token       string
+	URL         string
+	BiddingZone string
+	Tariff      SupplierTariff
+	HTTPClient  *http.Client
+}
+
+// Name returns the name of the source
+func (s *EntsoeSource) Name() string {
+	return SourceEntsoe
+}
+
+// Prices fetches the day-ahead prices in [from, to) and converts them to all-in prices
+func (s *EntsoeSource) Prices(ctx context.Context, home model.Home, from, to time.Time) ([]model.Price, error) {
+	body, err := s.fetch(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	points, err := ParsePublicationDocument(body, s.BiddingZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return toModelPrices(points, s.Tariff, localtime.Location(home.TimeZone), from, to), nil
+}
+
+// fetch requests the A44 document for the bidding zone
+func (s *EntsoeSource) fetch(ctx context.Context, from, to time.Time) (io.ReadCloser, error) {
+	if s.Token == "" {
+		return nil, fmt.Errorf("ENTSO-E security token is not configured")
+	}
+	if s.BiddingZone == "" {
+		return nil, fmt.Errorf("no bidding zone configured")
+	}
+
+	endpoint := s.URL
+	if endpoint == "" {
+		endpoint = DefaultEntsoeURL
+	}
+
+	params := url.Values{}
+	params.Set("securityToken", s.Token)
+	params.Set("documentType", "A44")
+	params.Set("in_Domain", s.BiddingZone)
+	params.Set("out_Domain", s.BiddingZone)
+	params.Set("periodStart", from.UTC().Format("200601021504"))
+	params.Set("periodEnd", to.UTC().Format("200601021504"))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ENTSO-E request failed: %w", err)
+	}
+
+	// Fouten komen als Acknowledgement_MarketDocument terug; die parseren we verderop
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		resp.Body.Close()
+		return nil, fmt.Errorf("ENTSO-E returned status %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// FileSource reads a stored Publication_MarketDocument, e.g. a manual download or fixture
+type FileSource struct {
+	Path        string
+	BiddingZone string
+	Tariff      SupplierTariff
+}
+
+// Name returns the name of the source
+func (s *FileSource) Name() string {
+	return SourceEntsoe
+}
+
+// Prices parses the stored document and returns the all-in prices in [from, to)
+func (s *FileSource) Prices(ctx context.Context, home model.Home, from, to time.Time) ([]model.Price, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", s.Path, err)
+	}
+	defer f.Close()
+
+	points, err := ParsePublicationDocument(f, s.BiddingZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return toModelPrices(points, s.Tariff, localtime.Location(home.TimeZone), from, to), nil
+}
+
+// toModelPrices converts wholesale points into hourly all-in prices in the home's time zone
+func toModelPrices(points []DayAheadPrice, tariff SupplierTariff, loc *time.Location, from, to time.Time) []model.Price {
+	prices := make([]model.Price, 0, len(points))
+	for _, p := range HourlyAverages(points) {
+		if p.Start.Before(from) || !p.Start.Before(to) {
+			continue
+		}
+		prices = append(prices, tariff.AllIn(p.Start.In(loc), p.Amount, p.Currency))
+	}
+
+	AssignLevels(prices)
+	return prices
+}
+
+// parseEntsoeTime parses the minute precision timestamps used by ENTSO-E (2025-03-29T23:00Z)
+func parseEntsoeTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02T15:04Z07:00", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid ENTSO-E time %q", s)
+}
+
+// parseResolution parses the ISO 8601 durations used for periods (PT15M, PT30M, PT60M)
+func parseResolution(s string) (time.Duration, error) {
+	switch strings.TrimSpace(s) {
+	case "PT15M":
+		return 15 * time.Minute, nil
+	case "PT30M":
+		return 30 * time.Minute, nil
+	case "PT60M", "PT1H":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported resolution %q", s)
+	}
+}
@@ -0,0 +1,158 @@
+package pricesource
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+var testHome = model.Home{Id: "home-1", TimeZone: "Europe/Amsterdam"}
+
+// fixturePrices reads a fixture through FileSource for the local day of date, without supplier tariff
+// so that the totals are the wholesale prices in EUR/kWh
+func fixturePrices(t *testing.T, name, date string) []model.Price {
+	t.Helper()
+	loc := localtime.Location(testHome.TimeZone)
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := localtime.DayBounds(day, loc)
+
+	source := &FileSource{Path: "testdata/" + name, BiddingZone: BiddingZones["NL"]}
+	prices, err := source.Prices(context.Background(), testHome, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prices
+}
+
+// checkTotals compares the totals of prices with the expected wholesale prices in EUR/MWh
+func checkTotals(t *testing.T, prices []model.Price, perMWh []float64) {
+	t.Helper()
+	if len(prices) != len(perMWh) {
+		t.Fatalf("got %d prices, want %d", len(prices), len(perMWh))
+	}
+	for i, p := range prices {
+		if want := round4(perMWh[i] / 1000); math.Abs(p.Total-want) > 1e-9 {
+			t.Errorf("price %d (%s) = %v, want %v", i, p.StartTime, p.Total, want)
+		}
+	}
+}
+
+func TestFileSourceHourly(t *testing.T) {
+	prices := fixturePrices(t, "pt60m.xml", "2025-01-15")
+
+	// De BE-reeks in hetzelfde document telt niet mee
+	want := make([]float64, 24)
+	for h := range want {
+		want[h] = 50 + float64(h)
+	}
+	checkTotals(t, prices, want)
+
+	if got := prices[0].StartTime; got != "2025-01-15T00:00:00+01:00" {
+		t.Errorf("first start = %s, want local midnight", got)
+	}
+	if got := prices[23].EndTime; got != "2025-01-16T00:00:00+01:00" {
+		t.Errorf("last end = %s, want next local midnight", got)
+	}
+	for _, p := range prices {
+		if p.Currency != "EUR" || p.Level == "" {
+			t.Errorf("price %s has currency %q and level %q", p.StartTime, p.Currency, p.Level)
+		}
+	}
+	if prices[0].Level != "CHEAP" || prices[23].Level != "EXPENSIVE" {
+		t.Errorf("levels = %s .. %s, want CHEAP .. EXPENSIVE", prices[0].Level, prices[23].Level)
+	}
+}
+
+func TestFileSourceQuarterHoursAreAveraged(t *testing.T) {
+	f, err := os.Open("testdata/pt15m.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	points, err := ParsePublicationDocument(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 96 || points[0].Resolution != 15*time.Minute {
+		t.Fatalf("got %d points of %v, want 96 of 15m", len(points), points[0].Resolution)
+	}
+
+	prices := fixturePrices(t, "pt15m.xml", "2025-10-15")
+	want := make([]float64, 24)
+	for h := range want {
+		want[h] = 55 + float64(h) // Gemiddelde van 40, 50, 60 en 70 plus het uur
+	}
+	checkTotals(t, prices, want)
+}
+
+func TestFileSourceFillsOmittedPositions(t *testing.T) {
+	prices := fixturePrices(t, "a03.xml", "2025-06-10")
+
+	// Bij curveType A03 houdt een ontbrekende positie de prijs van de vorige, ook aan het eind
+	want := make([]float64, 24)
+	for p := 1; p <= 24; p++ {
+		switch {
+		case p >= 3 && p <= 5:
+			want[p-1] = 20
+		case p >= 22:
+			want[p-1] = 210
+		default:
+			want[p-1] = 10 * float64(p)
+		}
+	}
+	checkTotals(t, prices, want)
+}
+
+func TestFileSourceDSTDay(t *testing.T) {
+	prices := fixturePrices(t, "dst.xml", "2025-03-30")
+
+	want := make([]float64, 23)
+	for h := range want {
+		want[h] = 100 + float64(h)
+	}
+	checkTotals(t, prices, want)
+
+	// Na 01:00 lokale tijd komt 03:00 zomertijd
+	if prices[1].StartTime != "2025-03-30T01:00:00+01:00" || prices[2].StartTime != "2025-03-30T03:00:00+02:00" {
+		t.Errorf("starts around the transition = %s, %s", prices[1].StartTime, prices[2].StartTime)
+	}
+}
+
+func TestFileSourceRange(t *testing.T) {
+	loc := localtime.Location(testHome.TimeZone)
+	from := time.Date(2025, 1, 15, 6, 0, 0, 0, loc)
+	source := &FileSource{Path: "testdata/pt60m.xml", BiddingZone: BiddingZones["NL"]}
+
+	prices, err := source.Prices(context.Background(), testHome, from, from.Add(6*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTotals(t, prices, []float64{56, 57, 58, 59, 60, 61})
+}
+
+func TestFileSourceAcknowledgement(t *testing.T) {
+	source := &FileSource{Path: "testdata/acknowledgement.xml", BiddingZone: BiddingZones["NL"]}
+	_, err := source.Prices(context.Background(), testHome, time.Time{}, time.Now())
+	if err == nil {
+		t.Fatal("expected an error for an acknowledgement document")
+	}
+	if !strings.Contains(err.Error(), "999") || !strings.Contains(err.Error(), "No matching data found") {
+		t.Errorf("error = %v, want the reason of the acknowledgement", err)
+	}
+}
+
+func TestFileSourceMissingFile(t *testing.T) {
+	source := &FileSource{Path: "testdata/missing.xml"}
+	if _, err := source.Prices(context.Background(), testHome, time.Time{}, time.Now()); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
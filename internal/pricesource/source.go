@@ -0,0 +1,136 @@
+package pricesource
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/model"
+)
+
+// Namen van de ondersteunde prijsbronnen
+const (
+	SourceTibber = "TIBBER"
+	SourceEntsoe = "ENTSOE"
+)
+
+// Source delivers hourly all-in prices for a home in the interval [from, to)
+type Source interface {
+	Name() string
+	Prices(ctx context.Context, home model.Home, from, to time.Time) ([]model.Price, error)
+}
+
+// Sources builds the price source of a home from its MemberConfig
+type Sources struct {
+	// Tibber haalt de prijzen van het abonnement op, bijvoorbeeld service_db.PriceService
+	Tibber TibberPrices
+	// Token en URL van het ENTSO-E Transparency Platform
+	EntsoeToken string
+	EntsoeURL   string
+	// EntsoeFile is een opgeslagen Publication_MarketDocument dat in plaats van de API wordt gelezen
+	EntsoeFile string
+}
+
+// For returns the price source of a home. An ENTSO-E home without bidding zone uses the zone of its
+// price area.
+func (s Sources) For(cfg MemberConfig, home model.Home) (Source, error) {
+	switch cfg.Source {
+	case "", SourceTibber:
+		if s.Tibber == nil {
+			return nil, fmt.Errorf("no Tibber price service for home %s", home.Id)
+		}
+		return &TibberSource{PriceService: s.Tibber}, nil
+
+	case SourceEntsoe:
+		biddingZone := cfg.BiddingZone
+		if biddingZone == "" {
+			biddingZone = BiddingZones[home.MeteringPointData.PriceAreaCode]
+		}
+		if biddingZone == "" {
+			return nil, fmt.Errorf("no bidding zone for home %s with price area %q", home.Id, home.MeteringPointData.PriceAreaCode)
+		}
+		if s.EntsoeFile != "" {
+			return &FileSource{Path: s.EntsoeFile, BiddingZone: biddingZone, Tariff: cfg.Tariff}, nil
+		}
+		return &EntsoeSource{Token: s.EntsoeToken, URL: s.EntsoeURL, BiddingZone: biddingZone, Tariff: cfg.Tariff}, nil
+	}
+	return nil, fmt.Errorf("unknown price source %q", cfg.Source)
+}
+
+// SupplierTariff describes how a supplier turns a wholesale price into a consumer price.
+// All amounts are in EUR per kWh excluding VAT.
+type SupplierTariff struct {
+	Markup    float64 `json:"markup"`    // Inkoopvergoeding / opslag van de leverancier
+	EnergyTax float64 `json:"energyTax"` // Energiebelasting
+	VatRate   float64 `json:"vatRate"`   // BTW, bijvoorbeeld 0.21
+}
+
+// MemberConfig is the price source configuration of a single home
+type MemberConfig struct {
+	HomeId      string         `json:"homeId"`
+	Source      string         `json:"source"`
+	BiddingZone string         `json:"biddingZone,omitempty"`
+	Tariff      SupplierTariff `json:"tariff"`
+}
+
+// DefaultTariff returns the Dutch 2025 energy tax and VAT without supplier markup
+func DefaultTariff() SupplierTariff {
+	return SupplierTariff{
+		EnergyTax: 0.10154,
+		VatRate:   0.21,
+	}
+}
+
+// AllIn converts a wholesale price in EUR/MWh into a price comparable to Tibber's
+// total/energy/tax: energy is spot plus markup incl. VAT, tax is energy tax incl. VAT
+func (t SupplierTariff) AllIn(start time.Time, amountPerMWh float64, currency string) model.Price {
+	vat := 1 + t.VatRate
+	energy := round4((amountPerMWh/1000 + t.Markup) * vat)
+	tax := round4(t.EnergyTax * vat)
+
+	return model.Price{
+		Total:     round4(energy + tax),
+		Energy:    energy,
+		Tax:       tax,
+		StartTime: start.Format(time.RFC3339),
+		EndTime:   start.Add(time.Hour).Format(time.RFC3339),
+		Currency:  currency,
+	}
+}
+
+// AssignLevels sets the Tibber-style price level of each price relative to the average of the set
+func AssignLevels(prices []model.Price) {
+	if len(prices) == 0 {
+		return
+	}
+
+	var sum float64
+	for _, p := range prices {
+		sum += p.Total
+	}
+	avg := sum / float64(len(prices))
+	if avg <= 0 {
+		return
+	}
+
+	for i := range prices {
+		ratio := prices[i].Total / avg
+		switch {
+		case ratio <= 0.6:
+			prices[i].Level = "VERY_CHEAP"
+		case ratio <= 0.9:
+			prices[i].Level = "CHEAP"
+		case ratio < 1.15:
+			prices[i].Level = "NORMAL"
+		case ratio < 1.4:
+			prices[i].Level = "EXPENSIVE"
+		default:
+			prices[i].Level = "VERY_EXPENSIVE"
+		}
+	}
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
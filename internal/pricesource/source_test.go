@@ -0,0 +1,138 @@
+package pricesource
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+func TestMemberConfigAllIn(t *testing.T) {
+	home := testHome
+	home.MeteringPointData.PriceAreaCode = "NL"
+	cfg := MemberConfig{
+		HomeId: home.Id,
+		Source: SourceEntsoe,
+		Tariff: SupplierTariff{Markup: 0.02, EnergyTax: 0.10154, VatRate: 0.21},
+	}
+
+	source, err := Sources{EntsoeFile: "testdata/pt60m.xml"}.For(cfg, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := source.(*FileSource); !ok || fs.BiddingZone != BiddingZones["NL"] {
+		t.Fatalf("source = %#v, want a FileSource for the NL bidding zone", source)
+	}
+
+	loc := localtime.Location(home.TimeZone)
+	from, to := localtime.DayBounds(time.Date(2025, 1, 15, 12, 0, 0, 0, loc), loc)
+	prices, err := source.Prices(context.Background(), home, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 24 {
+		t.Fatalf("got %d prices, want 24", len(prices))
+	}
+
+	tests := []struct {
+		hour               int
+		energy, tax, total float64
+	}{
+		// (50 EUR/MWh + 0,02 opslag) * 1,21 en 0,10154 energiebelasting * 1,21
+		{0, 0.0847, 0.1229, 0.2076},
+		// (73 EUR/MWh + 0,02 opslag) * 1,21
+		{23, 0.1125, 0.1229, 0.2354},
+	}
+	for _, tt := range tests {
+		p := prices[tt.hour]
+		if !near(p.Energy, tt.energy) || !near(p.Tax, tt.tax) || !near(p.Total, tt.total) {
+			t.Errorf("hour %d: energy %v, tax %v, total %v; want %v, %v, %v",
+				tt.hour, p.Energy, p.Tax, p.Total, tt.energy, tt.tax, tt.total)
+		}
+	}
+}
+
+func TestSupplierTariffAllIn(t *testing.T) {
+	start := time.Date(2025, 1, 15, 0, 0, 0, 0, localtime.Location("Europe/Amsterdam"))
+
+	tests := []struct {
+		name               string
+		tariff             SupplierTariff
+		perMWh             float64
+		energy, tax, total float64
+	}{
+		{"default tariff", DefaultTariff(), 100, 0.121, 0.1229, 0.2439},
+		{"no VAT", SupplierTariff{Markup: 0.015, EnergyTax: 0.1}, 100, 0.115, 0.1, 0.215},
+		{"negative price", SupplierTariff{Markup: 0.02, EnergyTax: 0.10154, VatRate: 0.21}, -50, -0.0363, 0.1229, 0.0866},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.tariff.AllIn(start, tt.perMWh, "EUR")
+			if !near(p.Energy, tt.energy) || !near(p.Tax, tt.tax) || !near(p.Total, tt.total) {
+				t.Errorf("energy %v, tax %v, total %v; want %v, %v, %v", p.Energy, p.Tax, p.Total, tt.energy, tt.tax, tt.total)
+			}
+			if p.StartTime != "2025-01-15T00:00:00+01:00" || p.EndTime != "2025-01-15T01:00:00+01:00" {
+				t.Errorf("interval = %s - %s", p.StartTime, p.EndTime)
+			}
+		})
+	}
+}
+
+// stubTibber returns fixed Tibber prices
+type stubTibber struct{ prices []model.Price }
+
+func (s stubTibber) GetPrices(ctx context.Context, homeId string) (*model.Home, error) {
+	return &model.Home{Id: homeId, CurrentSubscription: &model.Subscription{
+		PriceInfo: model.PriceInfo{Today: s.prices},
+	}}, nil
+}
+
+func TestSourcesFor(t *testing.T) {
+	home := testHome
+	tibber := stubTibber{prices: []model.Price{
+		{StartTime: "2025-01-14T23:00:00.000+01:00", Total: 0.2},
+		{StartTime: "2025-01-15T00:00:00.000+01:00", Total: 0.3},
+		{StartTime: "2025-01-15T23:00:00.000+01:00", Total: 0.4},
+		{StartTime: "2025-01-16T00:00:00.000+01:00", Total: 0.5},
+	}}
+	sources := Sources{Tibber: tibber, EntsoeToken: "token"}
+
+	source, err := sources.For(MemberConfig{HomeId: home.Id}, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Name() != SourceTibber {
+		t.Fatalf("source = %s, want %s for a home without configuration", source.Name(), SourceTibber)
+	}
+	loc := localtime.Location(home.TimeZone)
+	from, to := localtime.DayBounds(time.Date(2025, 1, 15, 12, 0, 0, 0, loc), loc)
+	prices, err := source.Prices(context.Background(), home, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 2 || prices[0].Total != 0.3 || prices[1].Total != 0.4 {
+		t.Errorf("prices = %v, want those of 15 January", prices)
+	}
+
+	source, err = sources.For(MemberConfig{Source: SourceEntsoe, BiddingZone: BiddingZones["BE"]}, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es, ok := source.(*EntsoeSource); !ok || es.BiddingZone != BiddingZones["BE"] || es.Token != "token" {
+		t.Errorf("source = %#v, want the ENTSO-E API for BE", source)
+	}
+
+	if _, err := sources.For(MemberConfig{Source: SourceEntsoe}, home); err == nil {
+		t.Error("expected an error for an ENTSO-E home without bidding zone or price area")
+	}
+	if _, err := sources.For(MemberConfig{Source: "NORDPOOL"}, home); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
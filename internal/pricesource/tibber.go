@@ -0,0 +1,55 @@
+package pricesource
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// TibberPrices fetches the price info of a home from Tibber, like service.PriceService and
+// service_db.PriceService; the latter also stores the prices
+type TibberPrices interface {
+	GetPrices(ctx context.Context, homeId string) (*model.Home, error)
+}
+
+// TibberSource reads prices from the home's Tibber subscription (priceInfo)
+type TibberSource struct {
+	PriceService TibberPrices
+}
+
+// Name returns the name of the source
+func (s *TibberSource) Name() string {
+	return SourceTibber
+}
+
+// Prices returns today's and tomorrow's Tibber prices that fall in [from, to)
+func (s *TibberSource) Prices(ctx context.Context, home model.Home, from, to time.Time) ([]model.Price, error) {
+	homeWithPrices, err := s.PriceService.GetPrices(ctx, home.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if homeWithPrices.CurrentSubscription == nil {
+		return nil, fmt.Errorf("home %s has no Tibber subscription", home.Id)
+	}
+
+	priceInfo := homeWithPrices.CurrentSubscription.PriceInfo
+	all := append(priceInfo.Today, priceInfo.Tomorrow...)
+
+	prices := make([]model.Price, 0, len(all))
+	for _, price := range all {
+		startTime, err := localtime.ParseTimestamp(price.StartTime)
+		if err != nil {
+			continue
+		}
+		if startTime.Before(from) || !startTime.Before(to) {
+			continue
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,206 @@
+// Package scheduler runs the periodic jobs of the collector on cron schedules, with jitter,
+// overlap prevention, manual triggers and persisted status.
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the five standard fields
+// (minute hour day-of-month month day-of-week), evaluated in Location
+type Schedule struct {
+	Expr     string
+	Location *time.Location
+
+	minute, hour, dom, month, dow uint64 // bit n staat voor waarde n
+	domStar, dowStar, hourStar    bool
+}
+
+// Afkortingen zoals in crontab
+var cronAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// Parse parses a cron expression such as "*/15 13-16 * * *" or "@daily". Fields support
+// *, lists (1,15), ranges (1-5) and steps (*/10, 0-30/5); day of week 0 and 7 are Sunday.
+func Parse(expr string, loc *time.Location) (*Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	spec := strings.TrimSpace(expr)
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	s := &Schedule{Expr: expr, Location: loc}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron expression %q: minute: %w", expr, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron expression %q: hour: %w", expr, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of month: %w", expr, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron expression %q: month: %w", expr, err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of week: %w", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is ook zondag
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	s.hourStar = strings.HasPrefix(fields[1], "*")
+	return s, nil
+}
+
+// MustParse is Parse for schedules that are fixed in the code
+func MustParse(expr string, loc *time.Location) *Schedule {
+	s, err := Parse(expr, loc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rangePart)
+			}
+			lo = v
+			if strings.Contains(part, "/") {
+				hi = max // 5/15 betekent vanaf 5 elke 15
+			} else {
+				hi = v
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+// dayMatches applies the cron rule that a restricted day of month and day of week are alternatives
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t that matches the schedule, or the zero time when there
+// is none within five years (such as 30 February). Local times that do not exist because of
+// daylight saving time run at the first minute after the change. The hour that repeats when
+// daylight saving time ends only runs again for schedules with * in the hour field; others run once.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := s.Location
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !has(s.hour, t.Hour()) || s.repeated(t) {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			} else if first := next.Add(-time.Hour); first.Hour() == next.Hour() && first.After(t) {
+				next = first // Een uur dat twee keer voorkomt begint bij de eerste keer
+			}
+			if s.skipped(t, next) {
+				return next
+			}
+			t = next
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			next := t.Add(time.Minute)
+			if s.skipped(t, next) {
+				return next
+			}
+			t = next
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// repeated reports whether t lies in the second run of a local hour after the change back to
+// standard time, which only schedules with * in the hour field use
+func (s *Schedule) repeated(t time.Time) bool {
+	return !s.hourStar && t.Add(-time.Hour).Hour() == t.Hour()
+}
+
+// skipped reports whether the step from one time to the next on the same day jumps over local
+// hours of the schedule because of the change to daylight saving time
+func (s *Schedule) skipped(from, to time.Time) bool {
+	if from.YearDay() != to.YearDay() {
+		return false
+	}
+	for h := from.Hour() + 1; h < to.Hour(); h++ {
+		if has(s.hour, h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) String() string {
+	return s.Expr
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func amsterdam(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+	return loc
+}
+
+// runs returns the scheduled times in [from, to)
+func runs(s *Schedule, from, to time.Time) []time.Time {
+	var times []time.Time
+	for t := s.Next(from.Add(-time.Minute)); !t.IsZero() && t.Before(to); t = s.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2025, 6, 2, 10, 7, 30, 0, time.UTC) // Maandag
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2025, 6, 2, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 6, 2, 11, 0, 0, 0, time.UTC)},
+		{"30 13-16 * * *", time.Date(2025, 6, 2, 13, 30, 0, 0, time.UTC)},
+		{"0 7 * * 7", time.Date(2025, 6, 8, 7, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		// Dag van de maand en dag van de week zijn alternatieven: de 15e of een vrijdag
+		{"0 0 15 * 5", time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr, time.UTC)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: next %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@yearly"} {
+		if _, err := Parse(expr, time.UTC); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestNextSpringForward(t *testing.T) {
+	loc := amsterdam(t)
+	// Op 30 maart 2025 gaat de klok om 02:00 naar 03:00
+	from := time.Date(2025, 3, 29, 0, 0, 0, 0, loc)
+	to := time.Date(2025, 4, 1, 0, 0, 0, 0, loc)
+
+	got := runs(MustParse("30 2 * * *", loc), from, to)
+	want := []time.Time{
+		time.Date(2025, 3, 29, 1, 30, 0, 0, time.UTC), // 02:30 CET
+		time.Date(2025, 3, 30, 1, 0, 0, 0, time.UTC),  // 03:00 CEST, de eerste minuut na de wissel
+		time.Date(2025, 3, 31, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+	}
+	if len(got) != len(want) {
+		t.Fatalf("runs %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("run %d at %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Een taak die elk kwartier draait, gaat van 01:45 door naar 03:00
+	got = runs(MustParse("*/15 * * * *", loc), time.Date(2025, 3, 30, 1, 30, 0, 0, loc), time.Date(2025, 3, 30, 3, 30, 0, 0, loc))
+	if len(got) != 4 || got[2].Format("15:04") != "03:00" || got[3].Format("15:04") != "03:15" {
+		t.Errorf("quarter-hourly runs %v", got)
+	}
+}
+
+func TestNextFallBack(t *testing.T) {
+	loc := amsterdam(t)
+	// Op 26 oktober 2025 gaat de klok om 03:00 terug naar 02:00; 02:30 komt twee keer voor
+	from := time.Date(2025, 10, 26, 0, 0, 0, 0, loc)
+	to := time.Date(2025, 10, 26, 6, 0, 0, 0, loc)
+
+	got := runs(MustParse("30 2 * * *", loc), from, to)
+	if len(got) != 1 || !got[0].Equal(time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC)) {
+		t.Errorf("daily runs %v, want once at 02:30 CEST", got)
+	}
+
+	// Met * in het uurveld draait de taak in beide uren
+	got = runs(MustParse("30 * * * *", loc), from, to)
+	if len(got) != 7 {
+		t.Errorf("hourly runs %v, want 7 in 7 hours", got)
+	}
+}
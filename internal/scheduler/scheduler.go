@@ -0,0 +1,362 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TriggerPollInterval is how often the store is asked for manually triggered jobs
+const TriggerPollInterval = 15 * time.Second
+
+// DefaultTimeout limits a run when a job has no timeout of its own
+const DefaultTimeout = time.Hour
+
+// Job statussen
+const (
+	StatusIdle    = "IDLE"
+	StatusRunning = "RUNNING"
+	StatusOK      = "OK"
+	StatusFailed  = "FAILED"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrRunning    = errors.New("job is already running")
+)
+
+// Job is a periodic task of the collector
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	// Jitter delays every scheduled run by a random duration up to Jitter, so that several
+	// collectors (or several jobs on the same minute) do not hit the Tibber API at once
+	Jitter  time.Duration
+	Timeout time.Duration
+	// RunOnStart runs the job once when the scheduler starts, before the first scheduled run
+	RunOnStart bool
+	Run        func(ctx context.Context) error
+}
+
+// Status is the state of a job as kept in memory and persisted in the store
+type Status struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Status       string     `json:"status"`
+	RunningBy    string     `json:"runningBy,omitempty"`
+	LastStart    *time.Time `json:"lastStart,omitempty"`
+	LastEnd      *time.Time `json:"lastEnd,omitempty"`
+	LastDuration float64    `json:"lastDurationSeconds"`
+	LastError    string     `json:"lastError,omitempty"`
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+}
+
+// Store persists job status and hands out run locks and manual triggers, so that status
+// survives restarts and a job never runs twice at the same time, also not across collectors
+type Store interface {
+	// Register adds or updates the job with its schedule and next run
+	Register(ctx context.Context, name, schedule string, next time.Time) error
+	// Acquire marks the job as running by owner; it returns false when another run holds
+	// the job and has not been running longer than stale
+	Acquire(ctx context.Context, name, owner string, stale time.Duration) (bool, error)
+	// Finish stores the result of a run by owner and releases the job
+	Finish(ctx context.Context, owner string, status Status) error
+	// Triggered returns and clears the jobs that were triggered manually
+	Triggered(ctx context.Context) ([]string, error)
+}
+
+type entry struct {
+	job    Job
+	status Status
+	next   time.Time
+}
+
+// Scheduler runs jobs on their schedules. Without a Store, status is only kept in memory and
+// overlap is only prevented within this process.
+type Scheduler struct {
+	Store Store
+	Owner string // Naam van deze collector in de run-locks, standaard hostnaam:pid
+
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	running sync.WaitGroup
+	rand    *rand.Rand
+}
+
+// New creates a scheduler that persists status in store, which may be nil
+func New(store Store) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		Store: store,
+		Owner: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		jobs:  make(map[string]*entry),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers a job; jobs must be added before Run
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return fmt.Errorf("job %q needs a name, schedule and run function", job.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("job %q is already registered", job.Name)
+	}
+	s.jobs[job.Name] = &entry{
+		job:    job,
+		status: Status{Name: job.Name, Schedule: job.Schedule.Expr, Status: StatusIdle},
+	}
+	return nil
+}
+
+// Reschedule changes the schedule of a job, also while the scheduler runs. A running job
+// finishes first; the new schedule applies from its next run.
+func (s *Scheduler) Reschedule(ctx context.Context, name string, schedule *Schedule) error {
+	s.mu.Lock()
+	e, ok := s.jobs[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	if e.job.Schedule.Expr == schedule.Expr && e.job.Schedule.Location == schedule.Location {
+		s.mu.Unlock()
+		return nil
+	}
+	e.job.Schedule = schedule
+	e.status.Schedule = schedule.Expr
+	e.next = s.nextRun(e, time.Now())
+	e.status.NextRun = timePtr(e.next)
+	next := e.next
+	s.mu.Unlock()
+
+	log.Printf("🕒 Job %s rescheduled to %q", name, schedule.Expr)
+	if s.Store != nil {
+		return s.Store.Register(ctx, name, schedule.Expr, next)
+	}
+	return nil
+}
+
+// Jobs returns the status of all jobs, sorted by name
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Status, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		list = append(list, e.status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// nextRun returns the next scheduled time of a job after now, including jitter
+func (s *Scheduler) nextRun(e *entry, now time.Time) time.Time {
+	next := e.job.Schedule.Next(now)
+	if next.IsZero() || e.job.Jitter <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(s.rand.Int63n(int64(e.job.Jitter))))
+}
+
+// Run runs the scheduled jobs until ctx is done and then waits for running jobs to finish
+func (s *Scheduler) Run(ctx context.Context) {
+	now := time.Now()
+	s.mu.Lock()
+	var startup []string
+	for name, e := range s.jobs {
+		e.next = s.nextRun(e, now)
+		e.status.NextRun = timePtr(e.next)
+		if s.Store != nil {
+			if err := s.Store.Register(ctx, name, e.job.Schedule.Expr, e.next); err != nil {
+				log.Printf("⚠️ Error registering job %s: %v", name, err)
+			}
+		}
+		if e.job.RunOnStart {
+			startup = append(startup, name)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(startup)
+	for _, name := range startup {
+		if err := s.start(ctx, name, "startup"); err != nil {
+			log.Printf("⚠️ Job %s not started: %v", name, err)
+		}
+	}
+
+	for {
+		wait := TriggerPollInterval
+		s.mu.Lock()
+		for _, e := range s.jobs {
+			if !e.next.IsZero() {
+				if d := time.Until(e.next); d < wait {
+					wait = d
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.running.Wait()
+			return
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		now := time.Now()
+		var due []string
+		s.mu.Lock()
+		for name, e := range s.jobs {
+			if e.next.IsZero() || e.next.After(now) {
+				continue
+			}
+			due = append(due, name)
+			e.next = s.nextRun(e, now)
+			e.status.NextRun = timePtr(e.next)
+		}
+		s.mu.Unlock()
+
+		sort.Strings(due)
+		for _, name := range due {
+			if err := s.start(ctx, name, "schedule"); err != nil {
+				log.Printf("⏭️ Skipping scheduled run of %s: %v", name, err)
+			}
+		}
+
+		if s.Store != nil {
+			triggered, err := s.Store.Triggered(ctx)
+			if err != nil {
+				log.Printf("⚠️ Error reading job triggers: %v", err)
+			}
+			for _, name := range triggered {
+				if err := s.start(ctx, name, "manual"); err != nil {
+					log.Printf("⏭️ Skipping manual run of %s: %v", name, err)
+				}
+			}
+		}
+	}
+}
+
+// Trigger starts a job now, outside its schedule. It returns ErrRunning when the job is
+// still running.
+func (s *Scheduler) Trigger(ctx context.Context, name string) error {
+	return s.start(ctx, name, "manual")
+}
+
+// start runs a job in the background unless it is already running, here or in another collector
+func (s *Scheduler) start(ctx context.Context, name, reason string) error {
+	s.mu.Lock()
+	e, ok := s.jobs[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	if e.status.Status == StatusRunning {
+		s.mu.Unlock()
+		return ErrRunning
+	}
+	previous := e.status.Status
+	e.status.Status = StatusRunning
+	job := e.job
+	s.mu.Unlock()
+
+	timeout := job.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if s.Store != nil {
+		// Een run die langer loopt dan zijn timeout is van een gestopte collector
+		acquired, err := s.Store.Acquire(ctx, name, s.Owner, timeout)
+		if err == nil && !acquired {
+			err = ErrRunning
+		}
+		if err != nil {
+			s.mu.Lock()
+			e.status.Status = previous
+			s.mu.Unlock()
+			return err
+		}
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.execute(ctx, e, reason, timeout)
+	}()
+	return nil
+}
+
+func (s *Scheduler) execute(ctx context.Context, e *entry, reason string, timeout time.Duration) {
+	name := e.job.Name
+	started := time.Now()
+	s.mu.Lock()
+	e.status.RunningBy = s.Owner
+	e.status.LastStart = timePtr(started)
+	s.mu.Unlock()
+	log.Printf("▶️ Job %s started (%s)", name, reason)
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := runJob(runCtx, e.job)
+	cancel()
+
+	finished := time.Now()
+	s.mu.Lock()
+	e.status.RunningBy = ""
+	e.status.LastEnd = timePtr(finished)
+	e.status.LastDuration = finished.Sub(started).Seconds()
+	e.status.Runs++
+	if err != nil {
+		e.status.Status = StatusFailed
+		e.status.LastError = err.Error()
+		e.status.Failures++
+	} else {
+		e.status.Status = StatusOK
+		e.status.LastError = ""
+	}
+	status := e.status
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("❌ Job %s failed after %s: %v", name, finished.Sub(started).Round(time.Millisecond), err)
+	} else {
+		log.Printf("✅ Job %s finished in %s", name, finished.Sub(started).Round(time.Millisecond))
+	}
+
+	if s.Store != nil {
+		// Ook bij het stoppen van de collector de uitkomst nog opslaan
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := s.Store.Finish(storeCtx, s.Owner, status); err != nil {
+			log.Printf("⚠️ Error storing status of job %s: %v", name, err)
+		}
+	}
+}
+
+// runJob runs a job and turns a panic into an error, so that one broken job does not stop the collector
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockStore is a Store whose run lock is held by another collector until released
+type lockStore struct {
+	mu       sync.Mutex
+	held     bool
+	stale    time.Duration
+	finished []Status
+}
+
+func (l *lockStore) Register(context.Context, string, string, time.Time) error { return nil }
+
+func (l *lockStore) Acquire(_ context.Context, _, _ string, stale time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stale = stale
+	return !l.held, nil
+}
+
+func (l *lockStore) Finish(_ context.Context, _ string, status Status) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finished = append(l.finished, status)
+	return nil
+}
+
+func (l *lockStore) Triggered(context.Context) ([]string, error) { return nil, nil }
+
+func TestJitterBounds(t *testing.T) {
+	s := New(nil)
+	s.rand = rand.New(rand.NewSource(1))
+	e := &entry{job: Job{Name: "prices", Schedule: MustParse("0 13 * * *", time.UTC), Jitter: 2 * time.Minute}}
+
+	now := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	scheduled := time.Date(2025, 6, 2, 13, 0, 0, 0, time.UTC)
+	var spread bool
+	for i := 0; i < 1000; i++ {
+		next := s.nextRun(e, now)
+		if next.Before(scheduled) || !next.Before(scheduled.Add(e.job.Jitter)) {
+			t.Fatalf("run at %v outside [13:00, 13:02)", next)
+		}
+		spread = spread || next.Sub(scheduled) > time.Minute
+	}
+	if !spread {
+		t.Error("jitter never beyond the first minute")
+	}
+
+	e.job.Jitter = 0
+	if next := s.nextRun(e, now); !next.Equal(scheduled) {
+		t.Errorf("run at %v without jitter, want 13:00", next)
+	}
+}
+
+func TestOverlapLock(t *testing.T) {
+	release := make(chan struct{})
+	var runs int
+	s := New(nil)
+	if err := s.Add(Job{Name: "history", Schedule: MustParse("@hourly", time.UTC), Run: func(context.Context) error {
+		runs++
+		<-release
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := s.Trigger(ctx, "history"); err != nil {
+		t.Fatal(err)
+	}
+	// Zolang de eerste run loopt wordt een tweede overgeslagen
+	if err := s.Trigger(ctx, "history"); !errors.Is(err, ErrRunning) {
+		t.Errorf("second trigger: %v, want ErrRunning", err)
+	}
+	close(release)
+	s.running.Wait()
+	if runs != 1 || s.Jobs()[0].Status != StatusOK {
+		t.Errorf("%d runs, status %s", runs, s.Jobs()[0].Status)
+	}
+	if err := s.Trigger(ctx, "missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("unknown job: %v", err)
+	}
+}
+
+func TestOverlapLockAcrossCollectors(t *testing.T) {
+	store := &lockStore{held: true}
+	s := New(store)
+	if err := s.Add(Job{Name: "history", Schedule: MustParse("@hourly", time.UTC), Timeout: 10 * time.Minute,
+		Run: func(context.Context) error { return errors.New("broken") }}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	// Een andere collector heeft de lock: de status blijft zoals hij was
+	if err := s.Trigger(ctx, "history"); !errors.Is(err, ErrRunning) {
+		t.Fatalf("trigger while locked: %v, want ErrRunning", err)
+	}
+	if status := s.Jobs()[0].Status; status != StatusIdle {
+		t.Errorf("status %s after a locked trigger, want %s", status, StatusIdle)
+	}
+	// Een lock ouder dan de timeout van de taak mag worden overgenomen
+	if store.stale != 10*time.Minute {
+		t.Errorf("stale after %v, want the job timeout", store.stale)
+	}
+
+	store.held = false
+	if err := s.Trigger(ctx, "history"); err != nil {
+		t.Fatal(err)
+	}
+	s.running.Wait()
+	if len(store.finished) != 1 || store.finished[0].Status != StatusFailed || store.finished[0].LastError != "broken" {
+		t.Errorf("finished %+v", store.finished)
+	}
+}
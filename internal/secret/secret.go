@@ -0,0 +1,99 @@
+// Package secret encrypts small secrets, such as the Tibber tokens of members, for storage in the
+// database. It uses AES-256-GCM with a key from the configuration, so a database dump alone does not
+// reveal the tokens.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of the key in bytes (AES-256)
+const KeySize = 32
+
+// prefix marks the format of a sealed value, so that the format can change later
+const prefix = "v1:"
+
+// ErrDecrypt is returned when a value cannot be decrypted, usually because the key changed
+var ErrDecrypt = errors.New("cannot decrypt secret (wrong key or damaged value)")
+
+// ParseKey decodes a key given as 64 hex characters or as base64 of 32 bytes
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 2*KeySize {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key must be 64 hex characters or base64, generate one with `openssl rand -base64 32`")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Box seals and opens secrets with one key
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox creates a Box for a key of KeySize bytes
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. The value is bound to context (for example the account ID), so a sealed
+// token that is copied to another row does not open there.
+func (b *Box) Seal(plaintext, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with the same key and context
+func (b *Box) Open(sealed, context string) (string, error) {
+	if !strings.HasPrefix(sealed, prefix) {
+		return "", ErrDecrypt
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// Hint returns the last characters of a secret, to recognize it in lists without revealing it
+func Hint(secret string) string {
+	if len(secret) <= 8 {
+		return "…"
+	}
+	return "…" + secret[len(secret)-4:]
+}
@@ -122,6 +122,7 @@ func (s *HomeService) GetHomeDetails(ctx context.Context) ([]model.Home, error)
 			AppNickname:  client.GetString(homeData, "appNickname"),
 			AppAvatar:    client.GetString(homeData, "appAvatar"),
 			MainFuseSize: client.GetInt(homeData, "mainFuseSize"),
+			TimeZone:     client.GetString(homeData, "timeZone"),
 		}
 
 		// Parse address if available
@@ -0,0 +1,498 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"ws/internal/client"
+	"ws/internal/model"
+	"ws/internal/notify"
+	"ws/internal/secret"
+)
+
+// ErrNoAccountsKey is returned when accounts are used without tibber.accounts_key
+var ErrNoAccountsKey = errors.New("tibber.accounts_key is not set, member tokens cannot be stored")
+
+// AccountService stores the Tibber accounts of the members with their tokens encrypted, and hands
+// out a rate limited API client per account. Homes without an account use Default, the token of
+// the installation (tibber.token).
+type AccountService struct {
+	DB       *sql.DB
+	Box      *secret.Box // Nil zonder tibber.accounts_key
+	Pool     *client.Pool
+	Default  *client.TibberClient
+	Notifier notify.Notifier
+}
+
+// accountContext binds an encrypted token to its account
+func accountContext(id int) string {
+	return "tibber_accounts:" + strconv.Itoa(id)
+}
+
+// NewAccount is a member account to add
+type NewAccount struct {
+	Name  string // Leeg: de naam van het Tibber account
+	Email string
+	Token string
+	// Homes are the homes the member shares. Nil shares every home of the account, also homes that
+	// are added to it later; otherwise other homes are never stored.
+	Homes []string
+	// Consents are the answers of the member per data category (model.Consent*)
+	Consents map[string]bool
+}
+
+// newClient returns an API client for a token outside the pool, so that a rejected token does not
+// end up in the pool
+func (s *AccountService) newClient(token string) *client.TibberClient {
+	c := client.NewClient(token)
+	if s.Pool.APIURL != "" {
+		c.APIURL = s.Pool.APIURL
+	}
+	return c
+}
+
+// Verify checks a token against the Tibber API and returns the name of the account
+func (s *AccountService) Verify(ctx context.Context, token string) (string, error) {
+	resp, err := s.newClient(token).QueryAPI(ctx, model.UserQuery, nil)
+	if err != nil {
+		return "", err
+	}
+	viewer, ok := resp.Data["viewer"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("no viewer data in response")
+	}
+	if name := client.GetString(viewer, "name"); name != "" {
+		return name, nil
+	}
+	return client.GetString(viewer, "login"), nil
+}
+
+// PreviewHomes returns the homes a token gives access to without storing anything, so that a
+// member can choose which homes to share
+func (s *AccountService) PreviewHomes(ctx context.Context, token string) ([]model.Home, error) {
+	homesData, err := queryHomes(ctx, s.newClient(strings.TrimSpace(token)))
+	if err != nil {
+		return nil, err
+	}
+	homes := make([]model.Home, 0, len(homesData))
+	for _, homeRaw := range homesData {
+		if homeData, ok := homeRaw.(map[string]interface{}); ok {
+			homes = append(homes, parseHome(homeData))
+		}
+	}
+	return homes, nil
+}
+
+// Add verifies a token and stores it as a new account, with the shared homes and the consents
+func (s *AccountService) Add(ctx context.Context, a NewAccount) (*model.Account, error) {
+	if s.Box == nil {
+		return nil, ErrNoAccountsKey
+	}
+	token := strings.TrimSpace(a.Token)
+	if token == "" {
+		return nil, fmt.Errorf("token is empty")
+	}
+	viewerName, err := s.Verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying token: %w", err)
+	}
+	name := a.Name
+	if name == "" {
+		name = viewerName
+	}
+
+	// Het ID is nodig om het token aan het account te binden, dus eerst de rij aanmaken
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	account := &model.Account{Name: name, Email: a.Email, TokenHint: secret.Hint(token), Status: model.AccountActive, Homes: []string{}}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO tibber_accounts (name, email, token_encrypted, token_hint, status, last_checked, share_new_homes)
+		VALUES ($1, $2, '', $3, $4, NOW(), $5)
+		RETURNING id, created_at`,
+		name, nullString(a.Email), account.TokenHint, account.Status, a.Homes == nil,
+	).Scan(&account.ID, &account.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error storing account: %w", err)
+	}
+	sealed, err := s.Box.Seal(token, accountContext(account.ID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tibber_accounts SET token_encrypted = $1 WHERE id = $2`, sealed, account.ID); err != nil {
+		return nil, fmt.Errorf("error storing token: %w", err)
+	}
+
+	// Gekozen huizen alvast koppelen; de collector slaat ze op en haalt hun historie op
+	for _, homeId := range a.Homes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO tibber_account_homes (home_id, account_id, discovered_at)
+			VALUES ($1, $2, NOW())
+			ON CONFLICT (home_id) DO UPDATE SET account_id = EXCLUDED.account_id, discovered_at = EXCLUDED.discovered_at`,
+			homeId, account.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error linking home %s: %w", homeId, err)
+		}
+		account.Homes = append(account.Homes, homeId)
+	}
+	for category, granted := range a.Consents {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO member_consents (account_id, category, granted, version, recorded_at)
+			VALUES ($1, $2, $3, $4, NOW())`, account.ID, category, granted, model.ConsentVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error storing consent: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return account, nil
+}
+
+// UpdateToken replaces the token of an account, for example after the member revoked the old one
+func (s *AccountService) UpdateToken(ctx context.Context, id int, token string) error {
+	if s.Box == nil {
+		return ErrNoAccountsKey
+	}
+	token = strings.TrimSpace(token)
+	if _, err := s.Verify(ctx, token); err != nil {
+		return fmt.Errorf("error verifying token: %w", err)
+	}
+	sealed, err := s.Box.Seal(token, accountContext(id))
+	if err != nil {
+		return err
+	}
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE tibber_accounts
+		SET token_encrypted = $1, token_hint = $2, status = $3, last_error = NULL,
+			last_checked = NOW(), updated_at = NOW()
+		WHERE id = $4`, sealed, secret.Hint(token), model.AccountActive, id)
+	if err != nil {
+		return fmt.Errorf("error updating token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("account %d not found", id)
+	}
+	return nil
+}
+
+// Remove deletes an account; its homes fall back to the installation token
+func (s *AccountService) Remove(ctx context.Context, id int) error {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM tibber_accounts WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error removing account: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("account %d not found", id)
+	}
+	s.Pool.Remove(accountContext(id))
+	return nil
+}
+
+// List returns all accounts with their homes, without tokens
+func (s *AccountService) List(ctx context.Context) ([]model.Account, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT a.id, a.name, COALESCE(a.email, ''), COALESCE(a.token_hint, ''), a.status,
+			a.last_checked, COALESCE(a.last_error, ''), a.created_at,
+			COALESCE(STRING_AGG(h.home_id, ',' ORDER BY h.home_id), '')
+		FROM tibber_accounts a
+		LEFT JOIN tibber_account_homes h ON h.account_id = a.id
+		GROUP BY a.id
+		ORDER BY a.id`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []model.Account
+	for rows.Next() {
+		var a model.Account
+		var lastChecked sql.NullTime
+		var homes string
+		if err := rows.Scan(&a.ID, &a.Name, &a.Email, &a.TokenHint, &a.Status, &lastChecked, &a.LastError, &a.CreatedAt, &homes); err != nil {
+			return nil, err
+		}
+		a.LastChecked = nullTimePtr(lastChecked)
+		a.Homes = []string{}
+		if homes != "" {
+			a.Homes = strings.Split(homes, ",")
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// Token returns the decrypted token of an account that has not been revoked
+func (s *AccountService) Token(ctx context.Context, id int) (string, error) {
+	if s.Box == nil {
+		return "", ErrNoAccountsKey
+	}
+	var sealed, status string
+	err := s.DB.QueryRowContext(ctx, `SELECT token_encrypted, status FROM tibber_accounts WHERE id = $1`, id).Scan(&sealed, &status)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("account %d not found", id)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching account: %w", err)
+	}
+	if status == model.AccountRevoked {
+		return "", fmt.Errorf("account %d: %w", id, client.ErrUnauthorized)
+	}
+	token, err := s.Box.Open(sealed, accountContext(id))
+	if err != nil {
+		return "", fmt.Errorf("account %d: %w", id, err)
+	}
+	return token, nil
+}
+
+// Client returns the rate limited API client of an account
+func (s *AccountService) Client(ctx context.Context, id int) (*client.TibberClient, error) {
+	token, err := s.Token(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Pool.Client(accountContext(id), token), nil
+}
+
+// ClientForHome returns the client of the account a home was discovered with, or Default for
+// homes that are not linked to an account
+func (s *AccountService) ClientForHome(ctx context.Context, homeId string) (*client.TibberClient, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx, `SELECT account_id FROM tibber_account_homes WHERE home_id = $1`, homeId).Scan(&id)
+	if err == sql.ErrNoRows {
+		if s.Default == nil {
+			return nil, fmt.Errorf("home %s has no Tibber account", homeId)
+		}
+		return s.Default, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching account of home %s: %w", homeId, err)
+	}
+	return s.Client(ctx, id)
+}
+
+// LinkHomes records that the homes were discovered with an account
+func (s *AccountService) LinkHomes(ctx context.Context, id int, homeIds []string) error {
+	for _, homeId := range homeIds {
+		_, err := s.DB.ExecContext(ctx, `
+			INSERT INTO tibber_account_homes (home_id, account_id, discovered_at)
+			VALUES ($1, $2, NOW())
+			ON CONFLICT (home_id) DO UPDATE SET account_id = EXCLUDED.account_id, discovered_at = EXCLUDED.discovered_at`,
+			homeId, id)
+		if err != nil {
+			return fmt.Errorf("error linking home %s to account %d: %w", homeId, id, err)
+		}
+	}
+	return nil
+}
+
+// Active returns the IDs of the accounts whose token has not been revoked
+func (s *AccountService) Active(ctx context.Context) ([]int, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id FROM tibber_accounts WHERE status <> $1 ORDER BY id`, model.AccountRevoked)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching accounts: %w", err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SharedHomes returns which homes of an account the member shares: the linked homes, and any
+// home when the account shares new homes
+func (s *AccountService) SharedHomes(ctx context.Context, id int) (func(homeId string) bool, error) {
+	var shareNew bool
+	if err := s.DB.QueryRowContext(ctx, `SELECT share_new_homes FROM tibber_accounts WHERE id = $1`, id).Scan(&shareNew); err != nil {
+		return nil, fmt.Errorf("error fetching account %d: %w", id, err)
+	}
+	if shareNew {
+		return func(string) bool { return true }, nil
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT home_id FROM tibber_account_homes WHERE account_id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching homes of account %d: %w", id, err)
+	}
+	defer rows.Close()
+	linked := make(map[string]bool)
+	for rows.Next() {
+		var homeId string
+		if err := rows.Scan(&homeId); err != nil {
+			return nil, err
+		}
+		linked[homeId] = true
+	}
+	return func(homeId string) bool { return linked[homeId] }, rows.Err()
+}
+
+// LinkedHomes returns all homes that are linked to an account, also of revoked accounts
+func (s *AccountService) LinkedHomes(ctx context.Context) ([]model.AccountHome, error) {
+	return s.accountHomes(ctx, `SELECT home_id, account_id FROM tibber_account_homes ORDER BY account_id, home_id`)
+}
+
+// PendingHomes returns the shared homes whose history has not been fetched yet
+func (s *AccountService) PendingHomes(ctx context.Context) ([]model.AccountHome, error) {
+	return s.accountHomes(ctx, `
+		SELECT ah.home_id, ah.account_id
+		FROM tibber_account_homes ah
+		JOIN tibber_accounts a ON a.id = ah.account_id
+		WHERE ah.onboarded_at IS NULL AND a.status <> $1
+		ORDER BY ah.account_id, ah.home_id`, model.AccountRevoked)
+}
+
+// MarkOnboarded records that the history of the homes has been fetched
+func (s *AccountService) MarkOnboarded(ctx context.Context, homeIds []string) error {
+	for _, homeId := range homeIds {
+		if _, err := s.DB.ExecContext(ctx, `UPDATE tibber_account_homes SET onboarded_at = NOW() WHERE home_id = $1`, homeId); err != nil {
+			return fmt.Errorf("error updating home %s: %w", homeId, err)
+		}
+	}
+	return nil
+}
+
+// LiveHomes returns the shared homes with a Tibber Pulse whose member agreed to live measurements
+func (s *AccountService) LiveHomes(ctx context.Context) ([]model.AccountHome, error) {
+	return s.accountHomes(ctx, `
+		SELECT ah.home_id, ah.account_id
+		FROM tibber_account_homes ah
+		JOIN tibber_accounts a ON a.id = ah.account_id
+		JOIN homes h ON h.id = ah.home_id
+		WHERE a.status <> $1 AND h.real_time_consumption_enabled
+			AND COALESCE((
+				SELECT c.granted FROM member_consents c
+				WHERE c.account_id = a.id AND c.category = $2
+				ORDER BY c.recorded_at DESC, c.id DESC
+				LIMIT 1
+			), FALSE)
+		ORDER BY ah.account_id, ah.home_id`, model.AccountRevoked, model.ConsentLive)
+}
+
+func (s *AccountService) accountHomes(ctx context.Context, query string, args ...interface{}) ([]model.AccountHome, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching account homes: %w", err)
+	}
+	defer rows.Close()
+	var homes []model.AccountHome
+	for rows.Next() {
+		var h model.AccountHome
+		if err := rows.Scan(&h.HomeID, &h.AccountID); err != nil {
+			return nil, err
+		}
+		homes = append(homes, h)
+	}
+	return homes, rows.Err()
+}
+
+// Check verifies the token of every account that is not revoked. A token that Tibber rejects is
+// marked REVOKED and the member is notified once; other errors are recorded and retried next time.
+// It returns the number of accounts that were revoked.
+func (s *AccountService) Check(ctx context.Context) (int, error) {
+	if s.Box == nil {
+		return 0, nil
+	}
+	ids, err := s.Active(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	var errs []error
+	for _, id := range ids {
+		c, err := s.Client(ctx, id)
+		if err == nil {
+			_, err = c.QueryAPI(ctx, model.UserQuery, nil)
+		}
+		switch {
+		case err == nil:
+			err = s.setStatus(ctx, id, model.AccountActive, "")
+		case errors.Is(err, client.ErrUnauthorized):
+			revoked++
+			if err = s.revoke(ctx, id, err); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		default:
+			log.Printf("⚠️ Token check of Tibber account %d failed: %v", id, err)
+			err = s.setStatus(ctx, id, model.AccountError, err.Error())
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return revoked, errors.Join(errs...)
+}
+
+// revoke marks an account as revoked and notifies the member and the operators
+func (s *AccountService) revoke(ctx context.Context, id int, cause error) error {
+	if err := s.setStatus(ctx, id, model.AccountRevoked, cause.Error()); err != nil {
+		return err
+	}
+	s.Pool.Remove(accountContext(id))
+
+	var name, email string
+	if err := s.DB.QueryRowContext(ctx, `SELECT name, COALESCE(email, '') FROM tibber_accounts WHERE id = $1`, id).Scan(&name, &email); err != nil {
+		return fmt.Errorf("error fetching account %d: %w", id, err)
+	}
+	log.Printf("❌ Tibber token of account %d (%s) was revoked", id, name)
+	if s.Notifier == nil {
+		return nil
+	}
+
+	msg := notify.Message{
+		Kind:     "TOKEN_REVOKED",
+		Severity: "WARNING",
+		Title:    "Tibber-token ingetrokken",
+		Body: fmt.Sprintf("Tibber accepteert het API-token van %s niet meer. Tot er een nieuw token is "+
+			"worden de prijzen, het verbruik en de productie van de huizen van dit account niet meer opgehaald. "+
+			"Maak een nieuw token aan op https://developer.tibber.com en geef het door aan de beheerder.", name),
+		Time: time.Now(),
+	}
+	if email != "" {
+		msg.To = []string{email}
+	}
+	if err := s.Notifier.Notify(ctx, msg); err != nil {
+		log.Printf("Error notifying revoked token of account %d: %v", id, err)
+	}
+	return nil
+}
+
+func (s *AccountService) setStatus(ctx context.Context, id int, status, lastError string) error {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE tibber_accounts
+		SET status = $1, last_error = $2, last_checked = NOW(), updated_at = NOW()
+		WHERE id = $3`, status, nullString(lastError), id)
+	if err != nil {
+		return fmt.Errorf("error updating account %d: %w", id, err)
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// homeClient returns the API client for a home: that of its account when accounts are set up,
+// else the single client of the service
+func homeClient(ctx context.Context, accounts *AccountService, fallback *client.TibberClient, homeId string) (*client.TibberClient, error) {
+	if accounts == nil {
+		return fallback, nil
+	}
+	return accounts.ClientForHome(ctx, homeId)
+}
@@ -0,0 +1,343 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"ws/internal/anomaly"
+	"ws/internal/forecast"
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/notify"
+	"ws/internal/webhook"
+)
+
+// AnomalyNightHistory is the number of earlier nights a night is compared with
+const AnomalyNightHistory = 28
+
+// AnomalyService detects, stores and reports unusual behaviour of homes
+type AnomalyService struct {
+	DB       *sql.DB
+	Notifier notify.Notifier
+	Solar    *SolarService
+	Usage    *ConsumptionForecastService
+	// Webhooks receives an anomaly.detected event per new anomaly; nil sends none
+	Webhooks *WebhookService
+}
+
+// Record stores an anomaly and sends a notification when it was not known yet
+func (s *AnomalyService) Record(ctx context.Context, event anomaly.Event) (bool, error) {
+	if event.DetectedAt.IsZero() {
+		event.DetectedAt = time.Now()
+	}
+
+	var id int64
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO anomalies (home_id, kind, severity, period_start, detected_at, value, expected, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (home_id, kind, period_start) DO NOTHING
+		RETURNING id
+	`,
+		event.HomeId,
+		event.Kind,
+		event.Severity,
+		event.PeriodStart.UTC(),
+		event.DetectedAt.UTC(),
+		event.Value,
+		event.Expected,
+		event.Message,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error storing anomaly: %w", err)
+	}
+
+	if s.Notifier != nil {
+		msg := notify.Message{
+			HomeId:   event.HomeId,
+			Kind:     event.Kind,
+			Severity: event.Severity,
+			Title:    anomalyTitle(event.Kind),
+			Body:     event.Message,
+			Time:     event.DetectedAt,
+		}
+		if err := s.Notifier.Notify(ctx, msg); err != nil {
+			log.Printf("Error notifying anomaly %d: %v", id, err)
+		}
+	}
+	if s.Webhooks != nil {
+		event.ID = id
+		if _, err := s.Webhooks.Emit(ctx, webhook.EventAnomaly, fmt.Sprintf("%s:%d", webhook.EventAnomaly, id), event.HomeId, event); err != nil {
+			log.Printf("Error queueing webhooks for anomaly %d: %v", id, err)
+		}
+	}
+	return true, nil
+}
+
+// List returns the anomalies of a home detected since a moment, newest first
+func (s *AnomalyService) List(ctx context.Context, homeId string, since time.Time) ([]anomaly.Event, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, home_id, kind, severity, period_start, detected_at,
+			COALESCE(value, 0), COALESCE(expected, 0), message
+		FROM anomalies
+		WHERE home_id = $1 AND detected_at >= $2 AND NOT acknowledged
+		ORDER BY detected_at DESC
+	`, homeId, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	var events []anomaly.Event
+	for rows.Next() {
+		var e anomaly.Event
+		if err := rows.Scan(&e.ID, &e.HomeId, &e.Kind, &e.Severity, &e.PeriodStart, &e.DetectedAt,
+			&e.Value, &e.Expected, &e.Message); err != nil {
+			return nil, fmt.Errorf("error scanning anomaly: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Acknowledge hides an anomaly of a home from the dashboard
+func (s *AnomalyService) Acknowledge(ctx context.Context, homeId string, id int64) error {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE anomalies SET acknowledged = TRUE WHERE id = $1 AND home_id = $2
+	`, id, homeId)
+	if err != nil {
+		return fmt.Errorf("error acknowledging anomaly: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("anomaly %d not found", id)
+	}
+	return nil
+}
+
+// DetectDaily runs the daily checks for the local day of t on all homes and records what it finds
+func (s *AnomalyService) DetectDaily(ctx context.Context, homes []model.Home, t time.Time) (int, error) {
+	var events []anomaly.Event
+
+	// Productie: eerst per huis de verhouding tot de verwachting, daarna vergelijken met de buren
+	type production struct {
+		home             model.Home
+		day              time.Time
+		actual, expected float64
+	}
+	var productions []production
+	for _, home := range homes {
+		if home.MeteringPointData.ProductionEan == "" || s.Solar == nil {
+			continue
+		}
+		p, err := s.dailyProduction(ctx, home, t)
+		if err != nil {
+			log.Printf("Skipping production check for home %s: %v", home.Id, err)
+			continue
+		}
+		productions = append(productions, production{home: home, day: p.day, actual: p.actual, expected: p.expected})
+	}
+	for i, p := range productions {
+		var peers []float64
+		for j, other := range productions {
+			if i != j && other.expected >= anomaly.MinExpectedKWh {
+				peers = append(peers, other.actual/other.expected)
+			}
+		}
+		if e := anomaly.CheckProduction(p.home.Id, p.day, p.actual, p.expected, peers); e != nil {
+			events = append(events, *e)
+		}
+	}
+
+	for _, home := range homes {
+		if e, err := s.checkNight(ctx, home, t); err != nil {
+			log.Printf("Skipping night check for home %s: %v", home.Id, err)
+		} else if e != nil {
+			events = append(events, *e)
+		}
+
+		if e, err := s.checkBaseload(ctx, home, t); err != nil {
+			log.Printf("Skipping baseload check for home %s: %v", home.Id, err)
+		} else if e != nil {
+			events = append(events, *e)
+		}
+	}
+
+	recorded := 0
+	for _, e := range events {
+		isNew, err := s.Record(ctx, e)
+		if err != nil {
+			return recorded, err
+		}
+		if isNew {
+			recorded++
+		}
+	}
+	return recorded, nil
+}
+
+type dayProduction struct {
+	day              time.Time
+	actual, expected float64
+}
+
+// dailyProduction returns the measured and modelled production of a home on the local day of t
+func (s *AnomalyService) dailyProduction(ctx context.Context, home model.Home, t time.Time) (*dayProduction, error) {
+	loc := localtime.Location(home.TimeZone)
+	from, to := localtime.DayBounds(t, loc)
+
+	var actual sql.NullFloat64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT SUM(production) FROM production
+		WHERE home_id = $1 AND resolution = 'HOURLY' AND from_time >= $2 AND from_time < $3
+	`, home.Id, from.UTC(), to.UTC()).Scan(&actual)
+	if err != nil {
+		return nil, fmt.Errorf("error querying production: %w", err)
+	}
+	if !actual.Valid {
+		return nil, fmt.Errorf("no hourly production for %s", from.Format("2006-01-02"))
+	}
+
+	m, err := s.Solar.Model(ctx, home)
+	if err != nil {
+		return nil, err
+	}
+	expected := forecast.Total(m.Predict(from, localtime.HoursInDay(from, loc), s.Solar.Weather))
+
+	return &dayProduction{day: from, actual: actual.Float64, expected: expected}, nil
+}
+
+// checkNight compares the average power between 00:00 and 06:00 with earlier nights
+func (s *AnomalyService) checkNight(ctx context.Context, home model.Home, t time.Time) (*anomaly.Event, error) {
+	if s.Usage == nil {
+		return nil, nil
+	}
+	loc := localtime.Location(home.TimeZone)
+	night := localtime.StartOfDay(t, loc)
+
+	history, err := s.Usage.HourlyConsumption(ctx, home.Id, night.AddDate(0, 0, -AnomalyNightHistory), night.Add(NightEndHour*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	// Gemiddeld vermogen in W per nacht
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, p := range history {
+		local := p.Start.In(loc)
+		if local.Hour() >= NightEndHour {
+			continue
+		}
+		key := local.Format("2006-01-02")
+		sums[key] += p.Value * 1000
+		counts[key]++
+	}
+
+	tonight := night.Format("2006-01-02")
+	if counts[tonight] < NightEndHour {
+		return nil, fmt.Errorf("night of %s is incomplete", tonight)
+	}
+
+	var keys []string
+	for key := range sums {
+		if key != tonight && counts[key] >= NightEndHour {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	previous := make([]float64, 0, len(keys))
+	for _, key := range keys {
+		previous = append(previous, sums[key]/float64(counts[key]))
+	}
+
+	return anomaly.CheckNightConsumption(home.Id, night, sums[tonight]/float64(counts[tonight]), previous), nil
+}
+
+// checkBaseload compares the baseload of the last nights with the weeks before
+func (s *AnomalyService) checkBaseload(ctx context.Context, home model.Home, t time.Time) (*anomaly.Event, error) {
+	loc := localtime.Location(home.TimeZone)
+	day := localtime.StartOfDay(t, loc)
+
+	var recent, previous sql.NullFloat64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			(SELECT PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY min_power) FROM baseload_nights
+				WHERE home_id = $1 AND night > $2::date - 3 AND night <= $2::date),
+			(SELECT PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY min_power) FROM baseload_nights
+				WHERE home_id = $1 AND night > $2::date - 17 AND night <= $2::date - 3)
+	`, home.Id, localtime.Date(t, loc)).Scan(&recent, &previous)
+	if err != nil {
+		return nil, fmt.Errorf("error querying baseload: %w", err)
+	}
+	if !recent.Valid || !previous.Valid {
+		return nil, nil
+	}
+
+	return anomaly.CheckBaseloadJump(home.Id, day, recent.Float64, previous.Float64), nil
+}
+
+// LiveProfile returns the expected power of a home from its consumption and solar models. Production
+// is only expected in hours the solar weather forecast calls sunny; without a forecast for the hour
+// the live outage check is skipped.
+func (s *AnomalyService) LiveProfile(ctx context.Context, home model.Home) (anomaly.Profile, error) {
+	if s.Usage == nil {
+		return nil, fmt.Errorf("no consumption model available")
+	}
+	consumption, err := s.Usage.Model(ctx, home)
+	if err != nil {
+		return nil, err
+	}
+
+	var solar *forecast.SolarModel
+	if s.Solar != nil && home.MeteringPointData.ProductionEan != "" {
+		if m, err := s.Solar.Model(ctx, home); err == nil {
+			solar = m
+		}
+	}
+
+	// Weerbericht per uur met instraling of bewolking
+	sunWeather := make(map[time.Time]forecast.Weather)
+	if solar != nil {
+		for _, w := range s.Solar.Weather {
+			if w.HasIrradiance || w.HasCloudCover {
+				sunWeather[w.Start.UTC().Truncate(time.Hour)] = w
+			}
+		}
+	}
+
+	return func(t time.Time) (float64, float64) {
+		expectedConsumption := consumption.Predict(t, 1, nil)[0].Value * 1000
+		var expectedProduction float64
+		if w, ok := sunWeather[t.UTC().Truncate(time.Hour)]; ok && solar != nil {
+			predicted := solar.Predict(t, 1, []forecast.Weather{w})[0].Value
+			clearSky := solar.ClearSky(t, 1)[0].Value
+			if clearSky > 0 && predicted >= clearSky*anomaly.SunnyClearSkyShare {
+				expectedProduction = predicted * 1000
+			}
+		}
+		return expectedConsumption, expectedProduction
+	}, nil
+}
+
+func anomalyTitle(kind string) string {
+	switch kind {
+	case anomaly.KindNoProduction:
+		return "Geen zonneproductie"
+	case anomaly.KindLowProduction:
+		return "Lage zonneproductie"
+	case anomaly.KindNightConsumption:
+		return "Hoog nachtverbruik"
+	case anomaly.KindBaseloadJump:
+		return "Sluipverbruik gestegen"
+	case anomaly.KindHighPower:
+		return "Aanhoudend hoog verbruik"
+	case anomaly.KindProductionOutage:
+		return "Omvormer levert niets"
+	}
+	return "Afwijking"
+}
@@ -0,0 +1,187 @@
+package service_db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ws/internal/model"
+	"ws/internal/secret"
+)
+
+// ErrInvalidAPIToken is returned for an unknown or revoked API token
+var ErrInvalidAPIToken = errors.New("invalid or revoked API token")
+
+// apiTokenPrefix makes the tokens recognizable, for example for secret scanners
+const apiTokenPrefix = "wsha_"
+
+// APITokenService manages the tokens of the Home Assistant API. Only a SHA-256 hash of a token
+// is stored: the tokens are long and random, so a slow password hash adds nothing.
+type APITokenService struct {
+	DB *sql.DB
+}
+
+// hashAPIToken returns the stored form of a token
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create stores a new token for an account, a single home or a single home of an account, and
+// returns the token itself; it cannot be retrieved later
+func (s *APITokenService) Create(ctx context.Context, accountID int, homeID, name string) (string, *model.APIToken, error) {
+	if accountID == 0 && homeID == "" {
+		return "", nil, fmt.Errorf("a token needs an account or a home")
+	}
+	if strings.TrimSpace(name) == "" {
+		return "", nil, fmt.Errorf("a token needs a name")
+	}
+	if accountID != 0 && homeID != "" {
+		var shared bool
+		err := s.DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM tibber_account_homes WHERE account_id = $1 AND home_id = $2)`,
+			accountID, homeID).Scan(&shared)
+		if err != nil {
+			return "", nil, fmt.Errorf("error checking home of account: %w", err)
+		}
+		if !shared {
+			return "", nil, fmt.Errorf("home %s is not shared by account %d", homeID, accountID)
+		}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, fmt.Errorf("error generating token: %w", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(random)
+
+	t := &model.APIToken{AccountID: accountID, HomeID: homeID, Name: strings.TrimSpace(name), TokenHint: secret.Hint(token)}
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (account_id, home_id, name, token_hash, token_hint)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		sql.NullInt64{Int64: int64(accountID), Valid: accountID != 0}, nullString(homeID), t.Name, hashAPIToken(token), t.TokenHint,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("error storing token: %w", err)
+	}
+	return token, t, nil
+}
+
+// List returns the tokens of an account, or all tokens for account 0, including revoked ones
+func (s *APITokenService) List(ctx context.Context, accountID int) ([]model.APIToken, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, COALESCE(account_id, 0), COALESCE(home_id, ''), name, COALESCE(token_hint, ''),
+			created_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE $1 = 0 OR account_id = $1
+		ORDER BY id`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tokens: %w", err)
+	}
+	defer rows.Close()
+	var tokens []model.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// scanAPIToken scans a row in the column order of List
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*model.APIToken, error) {
+	var t model.APIToken
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&t.ID, &t.AccountID, &t.HomeID, &t.Name, &t.TokenHint, &t.CreatedAt, &lastUsed, &revoked); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
+
+// Revoke makes a token unusable; it stays in the list
+func (s *APITokenService) Revoke(ctx context.Context, id int) error {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE api_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("token %d not found or already revoked", id)
+	}
+	return nil
+}
+
+// Authenticate returns the token that belongs to a presented token, and records its use
+func (s *APITokenService) Authenticate(ctx context.Context, token string) (*model.APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+	t, err := scanAPIToken(s.DB.QueryRowContext(ctx, `
+		SELECT id, COALESCE(account_id, 0), COALESCE(home_id, ''), name, COALESCE(token_hint, ''),
+			created_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE token_hash = $1 AND revoked_at IS NULL`, hashAPIToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking token: %w", err)
+	}
+
+	// Home Assistant vraagt elke halve minuut; het gebruik per minuut vastleggen is genoeg
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > time.Minute {
+		if _, err := s.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`, t.ID); err != nil {
+			return nil, fmt.Errorf("error recording token use: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// TokenHome is a home a token gives access to
+type TokenHome struct {
+	ID   string `json:"id"`
+	Name string `json:"name"` // Bijnaam in de Tibber app, leeg als die er niet is
+}
+
+// Homes returns the homes a token gives access to: its home, as long as its account still shares
+// it, or all shared homes of its account
+func (s *APITokenService) Homes(ctx context.Context, t *model.APIToken) ([]TokenHome, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT ids.home_id, COALESCE(h.app_nickname, '')
+		FROM (
+			SELECT $2::VARCHAR AS home_id WHERE $1 = 0
+			UNION
+			SELECT home_id FROM tibber_account_homes
+			WHERE $1 <> 0 AND account_id = $1 AND ($2 = '' OR home_id = $2)
+		) ids
+		LEFT JOIN homes h ON h.id = ids.home_id
+		ORDER BY ids.home_id`, t.AccountID, t.HomeID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching homes of token: %w", err)
+	}
+	defer rows.Close()
+	var homes []TokenHome
+	for rows.Next() {
+		var home TokenHome
+		if err := rows.Scan(&home.ID, &home.Name); err != nil {
+			return nil, err
+		}
+		homes = append(homes, home)
+	}
+	return homes, rows.Err()
+}
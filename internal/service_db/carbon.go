@@ -0,0 +1,113 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/carbon"
+	"ws/internal/model"
+)
+
+// CarbonService stores grid carbon intensities and computes the carbon account of homes
+type CarbonService struct {
+	DB *sql.DB
+}
+
+// Zone returns the carbon intensity zone of a home
+func Zone(home model.Home) string {
+	if home.MeteringPointData.PriceAreaCode != "" {
+		return home.MeteringPointData.PriceAreaCode
+	}
+	return carbon.DefaultZone
+}
+
+// Import stores hourly intensities, replacing earlier values for the same zone and hour
+func (s *CarbonService) Import(ctx context.Context, intensities []carbon.Intensity, source string) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO carbon_intensity (zone, starts_at, intensity, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (zone, starts_at) DO UPDATE SET
+			intensity = EXCLUDED.intensity,
+			source = EXCLUDED.source
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing carbon intensity insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, i := range intensities {
+		if _, err := stmt.ExecContext(ctx, i.Zone, i.Start.UTC(), i.GramsPerKWh, source); err != nil {
+			return 0, fmt.Errorf("error storing carbon intensity: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing carbon intensities: %w", err)
+	}
+	return len(intensities), nil
+}
+
+// Table loads the intensities of a zone in [from, to)
+func (s *CarbonService) Table(ctx context.Context, zone string, from, to time.Time) (*carbon.Table, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT zone, starts_at, intensity FROM carbon_intensity
+		WHERE zone = $1 AND starts_at >= $2 AND starts_at < $3
+	`, zone, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying carbon intensity: %w", err)
+	}
+	defer rows.Close()
+
+	var intensities []carbon.Intensity
+	for rows.Next() {
+		var i carbon.Intensity
+		if err := rows.Scan(&i.Zone, &i.Start, &i.GramsPerKWh); err != nil {
+			return nil, fmt.Errorf("error scanning carbon intensity: %w", err)
+		}
+		intensities = append(intensities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return carbon.NewTable(intensities), nil
+}
+
+// Footprint computes the emissions of the grid consumption and the avoided emissions of the
+// production of a home in [from, to), using hourly data when available
+func (s *CarbonService) Footprint(ctx context.Context, home model.Home, from, to time.Time) (carbon.Footprint, error) {
+	intervals, err := (&TariffService{DB: s.DB}).Intervals(ctx, home.Id, from, to)
+	if err != nil {
+		return carbon.Footprint{}, err
+	}
+	table, err := s.Table(ctx, Zone(home), from, to)
+	if err != nil {
+		return carbon.Footprint{}, err
+	}
+
+	usage := make([]carbon.Usage, len(intervals))
+	for i, iv := range intervals {
+		usage[i] = carbon.Usage{Start: iv.Start, End: iv.End, Consumption: iv.Import, Production: iv.Export}
+	}
+	return carbon.Account(usage, Zone(home), table), nil
+}
+
+// Community adds up the footprints of all homes in [from, to)
+func (s *CarbonService) Community(ctx context.Context, homes []model.Home, from, to time.Time) (carbon.Footprint, error) {
+	var total carbon.Footprint
+	for _, home := range homes {
+		f, err := s.Footprint(ctx, home, from, to)
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(f)
+	}
+	return total, nil
+}
@@ -0,0 +1,101 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/model"
+)
+
+// CommunityService computes figures over all homes of the community
+type CommunityService struct {
+	DB *sql.DB
+}
+
+// SelfSufficiency is the share of what the homes take from the grid that is covered by what other
+// homes of the community feed in at the same time. The meters only see the grid connection, so
+// solar power a home uses itself is not part of it.
+type SelfSufficiency struct {
+	Percent     float64   `json:"percent"`
+	Homes       int       `json:"homes"`
+	Consumption float64   `json:"consumption"` // Afname van het net: W live, kWh per periode
+	Production  float64   `json:"production"`  // Teruglevering aan het net: W live, kWh per periode
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+}
+
+// calculate fills in the percentage; without consumption everything is covered
+func (s *SelfSufficiency) calculate(covered float64) {
+	s.Percent = 100
+	if s.Consumption > 0 {
+		s.Percent = math.Round(covered/s.Consumption*1000) / 10
+	}
+}
+
+// LiveSelfSufficiency uses the latest live measurement since a moment of every home with live
+// consent; sql.ErrNoRows when no home measured anything
+func (s *CommunityService) LiveSelfSufficiency(ctx context.Context, since time.Time) (*SelfSufficiency, error) {
+	result := &SelfSufficiency{From: since, To: time.Now()}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(power), 0), COALESCE(SUM(power_production), 0)
+		FROM (
+			SELECT DISTINCT ON (m.home_id) m.power, m.power_production
+			FROM real_time_measurements m
+			JOIN homes h ON h.id = m.home_id
+			WHERE m.timestamp >= $1 AND `+consentFilter(2)+` AND `+consentFilter(3)+`
+			ORDER BY m.home_id, m.timestamp DESC
+		) latest`, since, model.ConsentCommunity, model.ConsentLive,
+	).Scan(&result.Homes, &result.Consumption, &result.Production)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching live measurements of the community: %w", err)
+	}
+	if result.Homes == 0 {
+		return nil, sql.ErrNoRows
+	}
+	result.calculate(math.Min(result.Consumption, result.Production))
+	return result, nil
+}
+
+// DailySelfSufficiency uses the hourly consumption and production in [from, to); per hour at most
+// the production of that hour counts as covered. sql.ErrNoRows when there is no data.
+func (s *CommunityService) DailySelfSufficiency(ctx context.Context, from, to time.Time) (*SelfSufficiency, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT c.from_time, COUNT(DISTINCT c.home_id), SUM(COALESCE(c.consumption, 0)), SUM(COALESCE(p.production, 0))
+		FROM consumption c
+		JOIN homes h ON h.id = c.home_id
+		LEFT JOIN production p ON p.home_id = c.home_id AND p.resolution = c.resolution AND p.from_time = c.from_time
+		WHERE c.resolution = 'HOURLY' AND c.from_time >= $1 AND c.from_time < $2 AND `+consentFilter(3)+`
+		GROUP BY c.from_time`, from, to, model.ConsentCommunity)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching hourly data of the community: %w", err)
+	}
+	defer rows.Close()
+
+	result := &SelfSufficiency{From: from, To: to}
+	var covered float64
+	for rows.Next() {
+		var start time.Time
+		var homes int
+		var consumption, production float64
+		if err := rows.Scan(&start, &homes, &consumption, &production); err != nil {
+			return nil, err
+		}
+		result.Homes = max(result.Homes, homes)
+		result.Consumption += consumption
+		result.Production += production
+		covered += math.Min(consumption, production)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if result.Homes == 0 {
+		return nil, sql.ErrNoRows
+	}
+	result.calculate(covered)
+	result.Consumption = math.Round(result.Consumption*1000) / 1000
+	result.Production = math.Round(result.Production*1000) / 1000
+	return result, nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"ws/internal/client"
+	"ws/internal/localtime"
 	"ws/internal/model"
 )
 
@@ -14,10 +15,23 @@ import (
 type ConsumptionService struct {
 	Client *client.TibberClient
 	DB     *sql.DB
+	// Accounts selects the token of the member that owns a home; nil means Client for every home
+	Accounts *AccountService
 }
 
 // GetConsumption fetches consumption data for a specific home and stores new data in the database
 func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string, resolution string, lastEntries int) (*model.Home, error) {
+	return s.getConsumption(ctx, homeId, resolution, lastEntries, time.Time{}, time.Time{})
+}
+
+// Backfill fetches and stores the consumption of a home in [from, to). The API counts back from now,
+// so all intervals since from are fetched and only those before to are stored.
+func (s *ConsumptionService) Backfill(ctx context.Context, homeId string, resolution string, from, to time.Time) (*model.Home, error) {
+	return s.getConsumption(ctx, homeId, resolution, BackfillEntries(resolution, from, time.Now()), from, to)
+}
+
+// getConsumption fetches the last entries and stores those that start in [from, to); a zero bound is open
+func (s *ConsumptionService) getConsumption(ctx context.Context, homeId string, resolution string, lastEntries int, from, to time.Time) (*model.Home, error) {
 	// Set up variables for the query
 	variables := map[string]interface{}{
 		"homeId":     homeId,
@@ -26,7 +40,11 @@ func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string,
 	}
 
 	// Execute the query
-	resp, err := s.Client.QueryAPI(ctx, model.ConsumptionQuery, variables)
+	c, err := homeClient(ctx, s.Accounts, s.Client, homeId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.QueryAPI(ctx, model.ConsumptionQuery, variables)
 	if err != nil {
 		return nil, fmt.Errorf("API query failed: %w", err)
 	}
@@ -60,6 +78,9 @@ func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string,
 		return nil, fmt.Errorf("no consumption nodes in response")
 	}
 
+	// Local days are derived in the home's own time zone
+	loc := homeLocation(ctx, s.DB, homeId)
+
 	// Begin a transaction for batch inserts
 	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -69,9 +90,15 @@ func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string,
 
 	// Prepare the insert statement
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO consumption (home_id, from_date, to_time, consumption, cost, currency)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (home_id, from_date) DO NOTHING
+		INSERT INTO consumption (home_id, resolution, from_time, from_date, to_time, consumption, cost, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (home_id, resolution, from_time) DO UPDATE SET
+			to_time = EXCLUDED.to_time,
+			consumption = EXCLUDED.consumption,
+			cost = EXCLUDED.cost,
+			currency = EXCLUDED.currency,
+			source = 'TIBBER',
+			import_id = NULL
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare statement: %w", err)
@@ -98,6 +125,9 @@ func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string,
 		if err != nil {
 			continue // Skip invalid times
 		}
+		if (!from.IsZero() && fromTime.Before(from)) || (!to.IsZero() && !fromTime.Before(to)) {
+			continue // Outside the requested period
+		}
 
 		// Create consumption entry
 		consumption := model.Consumption{
@@ -114,11 +144,13 @@ func (s *ConsumptionService) GetConsumption(ctx context.Context, homeId string,
 		// Add to home's consumption list
 		home.Consumption = append(home.Consumption, consumption)
 
-		// Store in database - note we now use fromTime.Truncate(24*time.Hour) to get just the date
+		// Store in database as UTC instant plus the local date of the home
 		_, err = stmt.ExecContext(ctx,
 			homeId,
-			fromTime.Truncate(24*time.Hour),
-			toTime,
+			resolution,
+			fromTime.UTC(),
+			localtime.Date(fromTime, loc),
+			toTime.UTC(),
 			consumption.Consumption,
 			consumption.Cost,
 			consumption.Currency,
@@ -161,13 +193,16 @@ func (s *ConsumptionService) GetDailySummary(ctx context.Context, homeId string,
 
 // getDailySummaryFromDB retrieves consumption summary from the database
 func (s *ConsumptionService) getDailySummaryFromDB(ctx context.Context, homeId string, days int) ([]model.ConsumptionSummary, error) {
+	loc := homeLocation(ctx, s.DB, homeId)
+
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT from_date, to_time, consumption, cost, currency
+		SELECT from_time, to_time, consumption, cost, currency
 		FROM consumption
 		WHERE home_id = $1
-		AND from_date >= CURRENT_DATE - INTERVAL '1 day' * $2
-		ORDER BY from_date DESC
-	`, homeId, days)
+		AND resolution = 'DAILY'
+		AND from_date >= $2
+		ORDER BY from_time DESC
+	`, homeId, localtime.Date(time.Now().In(loc).AddDate(0, 0, -days), loc))
 	if err != nil {
 		return nil, err
 	}
@@ -176,14 +211,14 @@ func (s *ConsumptionService) getDailySummaryFromDB(ctx context.Context, homeId s
 	var summaries []model.ConsumptionSummary
 	for rows.Next() {
 		var summary model.ConsumptionSummary
-		var fromDate time.Time
+		var fromTime time.Time
 		var toTime time.Time
-		err := rows.Scan(&fromDate, &toTime, &summary.Consumption, &summary.Cost, &summary.Currency)
+		err := rows.Scan(&fromTime, &toTime, &summary.Consumption, &summary.Cost, &summary.Currency)
 		if err != nil {
 			return nil, err
 		}
-		summary.From = fromDate.Format(time.RFC3339)
-		summary.To = toTime.Format(time.RFC3339)
+		summary.From = fromTime.In(loc).Format(time.RFC3339)
+		summary.To = toTime.In(loc).Format(time.RFC3339)
 		summaries = append(summaries, summary)
 	}
 
@@ -197,3 +232,25 @@ func getFloat64(data map[string]interface{}, key string) float64 {
 	}
 	return 0
 }
+
+// LatestFrom returns the start of the newest consumption interval from Tibber, or the zero time when there is none
+func (s *ConsumptionService) LatestFrom(ctx context.Context, homeId string, resolution string) (time.Time, error) {
+	var latest sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT MAX(from_time) FROM consumption
+		WHERE home_id = $1 AND resolution = $2 AND source = $3`,
+		homeId, resolution, SourceTibber).Scan(&latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying latest consumption: %w", err)
+	}
+	return latest.Time, nil
+}
+
+// BackfillEntries returns how many of the last intervals of a resolution reach back from now to from
+func BackfillEntries(resolution string, from, now time.Time) int {
+	hours := int(now.Sub(from).Hours()) + 1
+	if resolution == "HOURLY" {
+		return max(hours, 1)
+	}
+	return max(hours/24+1, 1)
+}
@@ -0,0 +1,141 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/forecast"
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// ConsumptionHistoryDays is how much hourly consumption history the consumption model is fitted on
+const ConsumptionHistoryDays = 56
+
+// NightEndHour is the local hour until which measurements count towards the nightly minimum
+const NightEndHour = 6
+
+// ConsumptionForecastService predicts consumption and determines the baseload of homes
+type ConsumptionForecastService struct {
+	DB      *sql.DB
+	Weather []forecast.Weather // Optioneel: temperatuur per uur
+}
+
+// Model fits the consumption model of a home on its recent hourly consumption
+func (s *ConsumptionForecastService) Model(ctx context.Context, home model.Home) (*forecast.ConsumptionModel, error) {
+	now := time.Now()
+	history, err := s.HourlyConsumption(ctx, home.Id, now.AddDate(0, 0, -ConsumptionHistoryDays), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return forecast.FitConsumption(history, localtime.Location(home.TimeZone), s.Weather)
+}
+
+// Forecast returns the expected hourly consumption of a home from the current hour
+func (s *ConsumptionForecastService) Forecast(ctx context.Context, home model.Home, hours int) ([]forecast.Point, error) {
+	m, err := s.Model(ctx, home)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Predict(time.Now(), hours, s.Weather), nil
+}
+
+// HourlyConsumption loads the hourly consumption of a home in [from, to)
+func (s *ConsumptionForecastService) HourlyConsumption(ctx context.Context, homeId string, from, to time.Time) ([]forecast.Point, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT from_time, consumption
+		FROM consumption
+		WHERE home_id = $1
+		AND resolution = 'HOURLY'
+		AND consumption IS NOT NULL
+		AND from_time >= $2
+		AND from_time < $3
+		ORDER BY from_time
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying hourly consumption: %w", err)
+	}
+	defer rows.Close()
+
+	var points []forecast.Point
+	for rows.Next() {
+		var p forecast.Point
+		if err := rows.Scan(&p.Start, &p.Value); err != nil {
+			return nil, fmt.Errorf("error scanning hourly consumption: %w", err)
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// RecordBaseload stores the minimum power of the night ending on the local day of t.
+// Real-time measurements are only kept for 24 hours, so this has to run every morning.
+func (s *ConsumptionForecastService) RecordBaseload(ctx context.Context, homeId string, t time.Time) error {
+	loc := homeLocation(ctx, s.DB, homeId)
+	from := localtime.StartOfDay(t, loc)
+	until := from.Add(NightEndHour * time.Hour)
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO baseload_nights (home_id, night, min_power, samples)
+		SELECT $1, $4, MIN(COALESCE(NULLIF(min_power, 0), power)), COUNT(*)
+		FROM real_time_measurements
+		WHERE home_id = $1
+		AND timestamp >= $2
+		AND timestamp < $3
+		AND power > 0
+		HAVING COUNT(*) > 0
+		ON CONFLICT (home_id, night) DO UPDATE SET
+			min_power = EXCLUDED.min_power,
+			samples = EXCLUDED.samples
+	`, homeId, from.UTC(), until.UTC(), localtime.Date(t, loc))
+	if err != nil {
+		return fmt.Errorf("error recording baseload for home %s: %w", homeId, err)
+	}
+	return nil
+}
+
+// Baseload estimates the always-on consumption of a home and what it costs per year
+// at the average price of the last 30 days
+func (s *ConsumptionForecastService) Baseload(ctx context.Context, homeId string) (*forecast.Baseload, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT min_power FROM (
+			SELECT night, min_power
+			FROM baseload_nights
+			WHERE home_id = $1
+			ORDER BY night DESC
+			LIMIT $2
+		) recent
+		ORDER BY night
+	`, homeId, forecast.BaseloadNights)
+	if err != nil {
+		return nil, fmt.Errorf("error querying baseload: %w", err)
+	}
+	defer rows.Close()
+
+	var minima []float64
+	for rows.Next() {
+		var v float64
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("error scanning baseload: %w", err)
+		}
+		minima = append(minima, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var price sql.NullFloat64
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT AVG(total) FROM prices
+		WHERE home_id = $1 AND starts_at >= $2 AND starts_at < $3
+	`, homeId, time.Now().UTC().AddDate(0, 0, -30), time.Now().UTC()).Scan(&price)
+	if err != nil {
+		return nil, fmt.Errorf("error querying average price: %w", err)
+	}
+
+	return forecast.EstimateBaseload(minima, price.Float64)
+}
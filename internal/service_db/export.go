@@ -0,0 +1,241 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/export"
+	"ws/internal/localtime"
+)
+
+// ExportQuery selects the rows of an export
+type ExportQuery struct {
+	Dataset    string
+	HomeId     string // Leeg voor alle huizen van de gemeenschap
+	From, To   time.Time
+	Resolution string // HOURLY of DAILY; prijzen zijn altijd per uur
+}
+
+// exportDataset is a table or rollup that can be exported. The query gets the first params of:
+// the home id ($1, empty for all homes), the period ($2, $3), the resolution ($4) and the default
+// time zone ($5).
+type exportDataset struct {
+	columns []export.Column
+	query   string
+	params  int
+}
+
+var exportDatasets = map[string]exportDataset{
+	"prices": {
+		columns: []export.Column{
+			{Name: "home_id", Type: export.String},
+			{Name: "starts_at", Type: export.Time},
+			{Name: "price_date", Type: export.Date},
+			{Name: "hour_of_day", Type: export.Int},
+			{Name: "total", Type: export.Float},
+			{Name: "energy", Type: export.Float},
+			{Name: "tax", Type: export.Float},
+			{Name: "currency", Type: export.String},
+			{Name: "level", Type: export.String},
+			{Name: "source", Type: export.String},
+		},
+		query: `
+			SELECT home_id, starts_at, price_date, hour_of_day, total, energy, tax, currency, level, source
+			FROM prices
+			WHERE ($1 = '' OR home_id = $1) AND starts_at >= $2 AND starts_at < $3
+			ORDER BY home_id, starts_at`,
+		params: 3,
+	},
+	"consumption": {
+		columns: []export.Column{
+			{Name: "home_id", Type: export.String},
+			{Name: "resolution", Type: export.String},
+			{Name: "from_time", Type: export.Time},
+			{Name: "from_date", Type: export.Date},
+			{Name: "to_time", Type: export.Time},
+			{Name: "consumption", Type: export.Float},
+			{Name: "cost", Type: export.Float},
+			{Name: "currency", Type: export.String},
+			{Name: "source", Type: export.String},
+		},
+		query: `
+			SELECT home_id, resolution, from_time, from_date, to_time, consumption, cost, currency, source
+			FROM consumption
+			WHERE ($1 = '' OR home_id = $1) AND from_time >= $2 AND from_time < $3 AND resolution = $4
+			ORDER BY home_id, from_time`,
+		params: 4,
+	},
+	"production": {
+		columns: []export.Column{
+			{Name: "home_id", Type: export.String},
+			{Name: "resolution", Type: export.String},
+			{Name: "from_time", Type: export.Time},
+			{Name: "from_date", Type: export.Date},
+			{Name: "to_time", Type: export.Time},
+			{Name: "production", Type: export.Float},
+			{Name: "profit", Type: export.Float},
+			{Name: "currency", Type: export.String},
+			{Name: "source", Type: export.String},
+		},
+		query: `
+			SELECT home_id, resolution, from_time, from_date, to_time, production, profit, currency, source
+			FROM production
+			WHERE ($1 = '' OR home_id = $1) AND from_time >= $2 AND from_time < $3 AND resolution = $4
+			ORDER BY home_id, from_time`,
+		params: 4,
+	},
+	// Live metingen per uur of per lokale dag; de dagtotalen van de meter beginnen om middernacht
+	// opnieuw, dus het verbruik in een periode is het verschil tussen hoogste en laagste stand
+	"live": {
+		columns: []export.Column{
+			{Name: "home_id", Type: export.String},
+			{Name: "period_start", Type: export.Time},
+			{Name: "samples", Type: export.Int},
+			{Name: "average_power", Type: export.Float},
+			{Name: "max_power", Type: export.Float},
+			{Name: "average_power_production", Type: export.Float},
+			{Name: "max_power_production", Type: export.Float},
+			{Name: "consumption", Type: export.Float},
+			{Name: "production", Type: export.Float},
+		},
+		query: `
+			WITH measurements AS (
+				SELECT m.*, COALESCE(NULLIF(h.time_zone, ''), $5) AS tz
+				FROM real_time_measurements m
+				JOIN homes h ON h.id = m.home_id
+				WHERE ($1 = '' OR m.home_id = $1) AND m.timestamp >= $2 AND m.timestamp < $3
+			)
+			SELECT home_id,
+				date_trunc(CASE WHEN $4 = 'DAILY' THEN 'day' ELSE 'hour' END, timestamp AT TIME ZONE tz) AT TIME ZONE tz AS period_start,
+				COUNT(*),
+				AVG(power), MAX(power),
+				AVG(power_production), MAX(power_production),
+				MAX(accumulated_consumption) - MIN(accumulated_consumption),
+				MAX(accumulated_production) - MIN(accumulated_production)
+			FROM measurements
+			GROUP BY home_id, period_start, tz
+			ORDER BY home_id, period_start`,
+		params: 5,
+	},
+	// Afrekening per periode: kosten van verbruik tegen opbrengst van teruglevering
+	"settlements": {
+		columns: []export.Column{
+			{Name: "home_id", Type: export.String},
+			{Name: "resolution", Type: export.String},
+			{Name: "from_time", Type: export.Time},
+			{Name: "from_date", Type: export.Date},
+			{Name: "cost", Type: export.Float},
+			{Name: "profit", Type: export.Float},
+			{Name: "netto_profit", Type: export.Float},
+		},
+		query: `
+			SELECT home_id, resolution, from_time, from_date, cost, profit, netto_profit
+			FROM netto_profit
+			WHERE ($1 = '' OR home_id = $1) AND from_time >= $2 AND from_time < $3 AND resolution = $4
+			ORDER BY home_id, from_time`,
+		params: 4,
+	},
+}
+
+// ExportDatasets returns the names of the datasets that can be exported
+func ExportDatasets() []string {
+	names := make([]string, 0, len(exportDatasets))
+	for name := range exportDatasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportService streams stored data to CSV, JSON Lines or Parquet
+type ExportService struct {
+	DB *sql.DB
+}
+
+// Export writes the rows of a dataset to w and returns the number of rows. Rows are read from the
+// database cursor one at a time, so the size of an export is not limited by memory.
+func (s *ExportService) Export(ctx context.Context, q ExportQuery, format export.Format, w io.Writer) (int, error) {
+	dataset, ok := exportDatasets[q.Dataset]
+	if !ok {
+		return 0, fmt.Errorf("unknown dataset %q (%s)", q.Dataset, strings.Join(ExportDatasets(), ", "))
+	}
+	resolution := strings.ToUpper(q.Resolution)
+	if resolution == "" {
+		resolution = "DAILY"
+	}
+	if resolution != "HOURLY" && resolution != "DAILY" {
+		return 0, fmt.Errorf("unknown resolution %q (HOURLY or DAILY)", q.Resolution)
+	}
+	if !q.To.After(q.From) {
+		return 0, fmt.Errorf("period is empty")
+	}
+
+	args := []any{q.HomeId, q.From.UTC(), q.To.UTC(), resolution, localtime.DefaultTimeZone}
+	rows, err := s.DB.QueryContext(ctx, dataset.query, args[:dataset.params]...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying %s: %w", q.Dataset, err)
+	}
+	defer rows.Close()
+
+	writer, err := export.NewWriter(format, w, dataset.columns)
+	if err != nil {
+		return 0, err
+	}
+
+	// Per kolom een scanbare waarde die NULL toestaat
+	dest := make([]any, len(dataset.columns))
+	for i, col := range dataset.columns {
+		switch col.Type {
+		case export.String:
+			dest[i] = new(sql.NullString)
+		case export.Float:
+			dest[i] = new(sql.NullFloat64)
+		case export.Int:
+			dest[i] = new(sql.NullInt64)
+		case export.Time, export.Date:
+			dest[i] = new(sql.NullTime)
+		}
+	}
+
+	count := 0
+	row := make([]any, len(dest))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, fmt.Errorf("error scanning %s: %w", q.Dataset, err)
+		}
+		for i, d := range dest {
+			row[i] = nil
+			switch v := d.(type) {
+			case *sql.NullString:
+				if v.Valid {
+					row[i] = v.String
+				}
+			case *sql.NullFloat64:
+				if v.Valid {
+					row[i] = v.Float64
+				}
+			case *sql.NullInt64:
+				if v.Valid {
+					row[i] = v.Int64
+				}
+			case *sql.NullTime:
+				if v.Valid {
+					row[i] = v.Time
+				}
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("error reading %s: %w", q.Dataset, err)
+	}
+	return count, writer.Close()
+}
@@ -0,0 +1,156 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// recordingDriver is a database/sql driver that records the arguments of every INSERT and UPDATE and
+// answers queries from a fixed table, so that services can be tested without Postgres
+type recordingDriver struct{}
+
+// recordingDB is the state behind one DSN of the recording driver
+type recordingDB struct {
+	mu      sync.Mutex
+	inserts map[string][][]driver.Value // Tabel -> argumenten per INSERT
+	updates map[string][][]driver.Value // Tabel -> argumenten per UPDATE
+	// answers maps a fragment of a query to the single-column row it returns; other queries fail
+	answers map[string]driver.Value
+}
+
+// noRows is an answer for a query that returns nothing
+type noRows struct{}
+
+// row is an answer with more than one column
+type row []driver.Value
+
+var (
+	recordingDBs   sync.Map
+	recordingCount atomic.Int64
+)
+
+func init() {
+	sql.Register("recording", recordingDriver{})
+}
+
+// openRecordingDB opens a fresh recording database with the given query answers
+func openRecordingDB(t *testing.T, answers map[string]driver.Value) (*sql.DB, *recordingDB) {
+	t.Helper()
+	dsn := fmt.Sprintf("db%d", recordingCount.Add(1))
+	rec := &recordingDB{inserts: make(map[string][][]driver.Value), updates: make(map[string][][]driver.Value), answers: answers}
+	recordingDBs.Store(dsn, rec)
+	db, err := sql.Open("recording", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, rec
+}
+
+// rows returns the recorded INSERT arguments for a table
+func (r *recordingDB) rows(table string) [][]driver.Value {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inserts[table]
+}
+
+// updated returns the recorded UPDATE arguments for a table
+func (r *recordingDB) updated(table string) [][]driver.Value {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.updates[table]
+}
+
+func (recordingDriver) Open(dsn string) (driver.Conn, error) {
+	rec, ok := recordingDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown recording database %q", dsn)
+	}
+	return &recordingConn{db: rec.(*recordingDB)}, nil
+}
+
+type recordingConn struct{ db *recordingDB }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{db: c.db, query: query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return recordingTx{}, nil }
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+type recordingStmt struct {
+	db    *recordingDB
+	query string
+}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fields := strings.Fields(s.query)
+	args = append([]driver.Value(nil), args...)
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	switch {
+	case len(fields) >= 3 && strings.EqualFold(fields[0], "INSERT"):
+		s.db.inserts[fields[2]] = append(s.db.inserts[fields[2]], args)
+	case len(fields) >= 2 && strings.EqualFold(fields[0], "UPDATE"):
+		s.db.updates[fields[1]] = append(s.db.updates[fields[1]], args)
+	default:
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	for fragment, value := range s.db.answers {
+		if strings.Contains(s.query, fragment) {
+			return &recordingRows{value: value, done: value == noRows{}}, nil
+		}
+	}
+	return nil, errors.New("recording driver: no answer for query")
+}
+
+// recordingRows is a single row with a single column, or the columns of a row answer
+type recordingRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (r *recordingRows) Columns() []string {
+	if columns, ok := r.value.(row); ok {
+		return make([]string, len(columns))
+	}
+	return []string{"value"}
+}
+func (r *recordingRows) Close() error { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	if columns, ok := r.value.(row); ok {
+		copy(dest, columns)
+		return nil
+	}
+	dest[0] = r.value
+	return nil
+}
+
+var _ driver.ConnBeginTx = (*recordingConn)(nil)
+
+func (c *recordingConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return recordingTx{}, nil
+}
@@ -0,0 +1,242 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/forecast"
+	"ws/internal/model"
+	"ws/internal/pricesource"
+)
+
+// ForecastHistoryDays is how much price history the forecast model is fitted on
+const ForecastHistoryDays = 56
+
+// ForecastService predicts prices beyond the published day-ahead horizon and tracks their error
+type ForecastService struct {
+	DB      *sql.DB
+	Weather []forecast.Weather // Optioneel: weerdata voor historie en voorspelling
+}
+
+// Generate forecasts the prices of a home from the end of the published prices up to
+// now + hours, stores them and returns them as forecast prices
+func (s *ForecastService) Generate(ctx context.Context, homeId string, hours int) ([]model.Price, error) {
+	loc := homeLocation(ctx, s.DB, homeId)
+	now := time.Now().UTC()
+
+	history, tax, currency, err := s.history(ctx, homeId, now.AddDate(0, 0, -ForecastHistoryDays))
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("no price history for home %s", homeId)
+	}
+
+	m, err := forecast.FitPrices(history, loc, s.Weather)
+	if err != nil {
+		return nil, err
+	}
+
+	// Voorspel alleen de uren na de laatst gepubliceerde prijs
+	from := history[len(history)-1].Start.Add(time.Hour)
+	until := now.Truncate(time.Hour).Add(time.Duration(hours) * time.Hour)
+	count := int(until.Sub(from).Hours())
+	if count <= 0 {
+		return nil, nil
+	}
+
+	points := m.Predict(from, count, s.Weather)
+	prices := make([]model.Price, 0, len(points))
+	for _, p := range points {
+		prices = append(prices, model.Price{
+			Total:      round4(p.Value + tax),
+			Energy:     round4(p.Value),
+			Tax:        tax,
+			StartTime:  p.Start.In(loc).Format(time.RFC3339),
+			EndTime:    p.Start.Add(time.Hour).In(loc).Format(time.RFC3339),
+			Currency:   currency,
+			IsForecast: true,
+		})
+	}
+	pricesource.AssignLevels(prices)
+
+	if err := s.store(ctx, homeId, now, points, prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// history loads the published energy prices since a moment, with the latest tax and currency
+func (s *ForecastService) history(ctx context.Context, homeId string, since time.Time) ([]forecast.Point, float64, string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT starts_at, energy, tax, currency
+		FROM prices
+		WHERE home_id = $1 AND starts_at >= $2
+		ORDER BY starts_at
+	`, homeId, since)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("error querying price history: %w", err)
+	}
+	defer rows.Close()
+
+	var points []forecast.Point
+	var tax float64
+	var currency string
+	for rows.Next() {
+		var p forecast.Point
+		if err := rows.Scan(&p.Start, &p.Value, &tax, &currency); err != nil {
+			return nil, 0, "", fmt.Errorf("error scanning price history: %w", err)
+		}
+		points = append(points, p)
+	}
+	return points, tax, currency, rows.Err()
+}
+
+// store writes a forecast run into price_forecasts
+func (s *ForecastService) store(ctx context.Context, homeId string, issuedAt time.Time, points []forecast.Point, prices []model.Price) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO price_forecasts (home_id, starts_at, issued_at, horizon_hours, energy, total)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (home_id, starts_at, issued_at) DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, p := range points {
+		horizon := int(p.Start.Sub(issuedAt).Hours())
+		if _, err := stmt.ExecContext(ctx, homeId, p.Start, issuedAt, horizon, prices[i].Energy, prices[i].Total); err != nil {
+			return fmt.Errorf("failed to store forecast: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateErrors fills in the realized price and error of forecasts whose published price has arrived
+func (s *ForecastService) UpdateErrors(ctx context.Context, homeId string) (int64, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE price_forecasts f
+		SET actual_total = p.total, error = f.total - p.total
+		FROM prices p
+		WHERE f.home_id = $1
+		AND f.actual_total IS NULL
+		AND p.home_id = f.home_id
+		AND p.starts_at = f.starts_at
+	`, homeId)
+	if err != nil {
+		return 0, fmt.Errorf("error updating forecast errors: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Accuracy returns the forecast error of a home over the last days per 24-hour horizon
+func (s *ForecastService) Accuracy(ctx context.Context, homeId string, days int) ([]forecast.Accuracy, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT horizon_hours, error
+		FROM price_forecasts
+		WHERE home_id = $1
+		AND error IS NOT NULL
+		AND starts_at >= $2
+	`, homeId, time.Now().UTC().AddDate(0, 0, -days))
+	if err != nil {
+		return nil, fmt.Errorf("error querying forecast accuracy: %w", err)
+	}
+	defer rows.Close()
+
+	var evaluations []forecast.Evaluation
+	for rows.Next() {
+		var e forecast.Evaluation
+		if err := rows.Scan(&e.HorizonHours, &e.Error); err != nil {
+			return nil, fmt.Errorf("error scanning forecast accuracy: %w", err)
+		}
+		evaluations = append(evaluations, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return forecast.Summarize(evaluations), nil
+}
+
+// Prices returns the hourly prices of a home from the current hour for the given number of hours:
+// published prices where available, the latest forecast for the remaining hours
+func (s *ForecastService) Prices(ctx context.Context, homeId string, hours int) ([]model.Price, error) {
+	loc := homeLocation(ctx, s.DB, homeId)
+	from := time.Now().UTC().Truncate(time.Hour)
+	until := from.Add(time.Duration(hours) * time.Hour)
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT starts_at, total, energy, tax, currency, level, FALSE
+		FROM prices
+		WHERE home_id = $1 AND starts_at >= $2 AND starts_at < $3
+		UNION ALL
+		SELECT * FROM (
+			-- Per uur de meest recente voorspelling
+			SELECT DISTINCT ON (f.starts_at) f.starts_at, f.total, f.energy, f.total - f.energy, '', '', TRUE
+			FROM price_forecasts f
+			WHERE f.home_id = $1 AND f.starts_at >= $2 AND f.starts_at < $3
+			AND NOT EXISTS (SELECT 1 FROM prices p WHERE p.home_id = f.home_id AND p.starts_at = f.starts_at)
+			ORDER BY f.starts_at, f.issued_at DESC
+		) latest
+		ORDER BY 1
+	`, homeId, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("error querying prices: %w", err)
+	}
+	defer rows.Close()
+
+	var prices []model.Price
+	for rows.Next() {
+		var price model.Price
+		var startsAt time.Time
+		var level sql.NullString
+		if err := rows.Scan(&startsAt, &price.Total, &price.Energy, &price.Tax, &price.Currency, &level, &price.IsForecast); err != nil {
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+		price.Level = level.String
+		price.StartTime = startsAt.In(loc).Format(time.RFC3339)
+		price.EndTime = startsAt.Add(time.Hour).In(loc).Format(time.RFC3339)
+		prices = append(prices, price)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Voorspellingen hebben geen valuta en niveau opgeslagen; niveaus gelden ten opzichte van de hele reeks
+	levels := make([]model.Price, len(prices))
+	copy(levels, prices)
+	pricesource.AssignLevels(levels)
+
+	var currency string
+	for _, p := range prices {
+		if !p.IsForecast {
+			currency = p.Currency
+		}
+	}
+	for i := range prices {
+		if prices[i].IsForecast {
+			prices[i].Currency = currency
+			prices[i].Level = levels[i].Level
+		}
+	}
+
+	return prices, nil
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,197 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"ws/internal/forecast"
+	"ws/internal/gas"
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// Bronnen van gasgegevens
+const (
+	GasSourceDSMR   = "DSMR"
+	GasSourceImport = "IMPORT"
+)
+
+// GasService stores gas meter readings and computes gas use, costs and degree-day normalization
+type GasService struct {
+	DB      *sql.DB
+	Tariff  gas.Tariff
+	Weather []forecast.Weather // Uurlijks weer voor graaddagen, optioneel
+}
+
+// StoreReading stores a cumulative gas meter reading. Readings are only stored when they change,
+// the meter repeats its last reading in every telegram.
+func (s *GasService) StoreReading(ctx context.Context, homeId string, reading gas.Reading, source string) (bool, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		INSERT INTO gas_readings (home_id, timestamp, reading, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (home_id, timestamp) DO NOTHING
+	`, homeId, reading.Timestamp.UTC(), reading.M3, source)
+	if err != nil {
+		return false, fmt.Errorf("error storing gas reading: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// Rollup recomputes the hourly and daily gas use of a home in [from, to) from its meter readings
+func (s *GasService) Rollup(ctx context.Context, home model.Home, from, to time.Time) error {
+	loc := localtime.Location(home.TimeZone)
+	from = localtime.StartOfDay(from, loc)
+
+	// Eén meterstand voor het begin is nodig voor het verbruik van het eerste uur
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT timestamp, reading FROM gas_readings
+		WHERE home_id = $1 AND timestamp < $3
+		AND timestamp >= COALESCE(
+			(SELECT MAX(timestamp) FROM gas_readings WHERE home_id = $1 AND timestamp < $2), $2
+		)
+		ORDER BY timestamp
+	`, home.Id, from.UTC(), to.UTC())
+	if err != nil {
+		return fmt.Errorf("error querying gas readings: %w", err)
+	}
+	var readings []gas.Reading
+	for rows.Next() {
+		var r gas.Reading
+		if err := rows.Scan(&r.Timestamp, &r.M3); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning gas reading: %w", err)
+		}
+		readings = append(readings, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	hours, days := gas.Intervals(readings, from, loc)
+	if len(hours) == 0 {
+		return nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for resolution, intervals := range map[string][]gas.Interval{"HOURLY": hours, "DAILY": days} {
+		for _, iv := range intervals {
+			if err := s.storeInterval(ctx, tx, home.Id, resolution, iv, loc, GasSourceDSMR); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing gas use: %w", err)
+	}
+	return nil
+}
+
+// StoreConsumption stores gas use that was measured per interval elsewhere, such as in an export of the grid operator
+func (s *GasService) StoreConsumption(ctx context.Context, home model.Home, resolution string, intervals []gas.Interval, source string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	loc := localtime.Location(home.TimeZone)
+	for _, iv := range intervals {
+		if err := s.storeInterval(ctx, tx, home.Id, resolution, iv, loc, source); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing gas use: %w", err)
+	}
+	return nil
+}
+
+func (s *GasService) storeInterval(ctx context.Context, tx *sql.Tx, homeId, resolution string, iv gas.Interval, loc *time.Location, source string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO gas_consumption (home_id, resolution, from_time, from_date, to_time, consumption, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (home_id, resolution, from_time) DO UPDATE SET
+			to_time = EXCLUDED.to_time,
+			consumption = EXCLUDED.consumption,
+			source = EXCLUDED.source
+	`, homeId, resolution, iv.Start.UTC(), iv.Start.In(loc).Format("2006-01-02"), iv.End.UTC(), iv.M3, source)
+	if err != nil {
+		return fmt.Errorf("error storing gas use: %w", err)
+	}
+	return nil
+}
+
+// Consumption returns the stored gas use of a home in [from, to) for a resolution (HOURLY or DAILY)
+func (s *GasService) Consumption(ctx context.Context, homeId, resolution string, from, to time.Time) ([]model.GasConsumption, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT from_time, to_time, consumption, source FROM gas_consumption
+		WHERE home_id = $1 AND resolution = $2 AND from_time >= $3 AND from_time < $4
+		ORDER BY from_time
+	`, homeId, resolution, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying gas use: %w", err)
+	}
+	defer rows.Close()
+
+	var result []model.GasConsumption
+	for rows.Next() {
+		var start, end time.Time
+		var c model.GasConsumption
+		if err := rows.Scan(&start, &end, &c.Consumption, &c.Source); err != nil {
+			return nil, fmt.Errorf("error scanning gas use: %w", err)
+		}
+		c.From = start.Format(time.RFC3339)
+		c.To = end.Format(time.RFC3339)
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// Summary returns the daily gas use of a home over the last days with costs, CO2 and degree days
+func (s *GasService) Summary(ctx context.Context, home model.Home, days int) (*gas.Summary, error) {
+	loc := localtime.Location(home.TimeZone)
+	to := localtime.StartOfDay(time.Now(), loc)
+	from := to.AddDate(0, 0, -days)
+
+	daily, err := s.Consumption(ctx, home.Id, "DAILY", from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(daily) == 0 {
+		return nil, nil
+	}
+
+	degreeDays := gas.DailyDegreeDays(s.Weather, loc)
+	result := make([]gas.Day, 0, len(daily))
+	for _, c := range daily {
+		start, err := time.Parse(time.RFC3339, c.From)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing gas day: %w", err)
+		}
+		day := gas.Day{
+			Date:  start.In(loc).Format("2006-01-02"),
+			M3:    c.Consumption,
+			KWh:   s.Tariff.KWh(c.Consumption),
+			Cost:  s.Tariff.Cost(c.Consumption, 1),
+			CO2Kg: gas.CO2(c.Consumption),
+		}
+		day.DegreeDays, day.HasWeather = degreeDays[day.Date]
+		result = append(result, day)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+
+	summary := gas.Summarize(from, to, s.Tariff, result)
+	return &summary, nil
+}
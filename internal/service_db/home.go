@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log"
 	"time"
 
 	"ws/internal/client"
+	"ws/internal/localtime"
 	"ws/internal/model"
 )
 
@@ -14,6 +16,8 @@ import (
 type HomeService struct {
 	Client *client.TibberClient
 	DB     *sql.DB
+	// Accounts are the Tibber accounts of the members; nil when only Client is used
+	Accounts *AccountService
 }
 
 // GetHomes fetches basic information about all homes
@@ -40,10 +44,82 @@ func (s *HomeService) GetHomeDetails(ctx context.Context) ([]model.Home, error)
 	return s.fetchAndStoreHomes(ctx)
 }
 
-// fetchAndStoreHomes fetches homes from API and stores them in database
+// SyncHomes fetches the homes from the Tibber API and stores them, also when they are already in the database
+func (s *HomeService) SyncHomes(ctx context.Context) ([]model.Home, error) {
+	return s.fetchAndStoreHomes(ctx)
+}
+
+// SyncAccountHomes fetches the homes of one member account, stores the homes the member shares and
+// links them to the account, so that their prices, consumption and production are fetched with that
+// account's token. Homes the member did not share are not stored.
+func (s *HomeService) SyncAccountHomes(ctx context.Context, accountId int) ([]model.Home, error) {
+	c, err := s.Accounts.Client(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := s.Accounts.SharedHomes(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	homes, err := s.storeHomes(ctx, c, shared)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(homes))
+	for i, home := range homes {
+		ids[i] = home.Id
+	}
+	if err := s.Accounts.LinkHomes(ctx, accountId, ids); err != nil {
+		return nil, err
+	}
+	return homes, nil
+}
+
+// fetchAndStoreHomes fetches the homes of the installation token and of every member account and
+// stores them in the database. An account that fails is logged and skipped, so that one revoked
+// token does not hide the homes of the other members.
 func (s *HomeService) fetchAndStoreHomes(ctx context.Context) ([]model.Home, error) {
+	var homes []model.Home
+	seen := make(map[string]bool)
+	add := func(found []model.Home) {
+		for _, home := range found {
+			if !seen[home.Id] {
+				seen[home.Id] = true
+				homes = append(homes, home)
+			}
+		}
+	}
+
+	if s.Client != nil && s.Client.APIToken != "" {
+		found, err := s.storeHomes(ctx, s.Client, nil)
+		if err != nil {
+			return nil, err
+		}
+		add(found)
+	}
+	if s.Accounts == nil || s.Accounts.Box == nil {
+		return homes, nil
+	}
+
+	ids, err := s.Accounts.Active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		found, err := s.SyncAccountHomes(ctx, id)
+		if err != nil {
+			log.Printf("⚠️ Error fetching homes of Tibber account %d: %v", id, err)
+			continue
+		}
+		add(found)
+	}
+	return homes, nil
+}
+
+// queryHomes fetches the raw home data visible to an API client
+func queryHomes(ctx context.Context, c *client.TibberClient) ([]interface{}, error) {
 	// Execute the homes query
-	resp, err := s.Client.QueryAPI(ctx, model.HomeDetailsQuery, nil)
+	resp, err := c.QueryAPI(ctx, model.HomeDetailsQuery, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +135,65 @@ func (s *HomeService) fetchAndStoreHomes(ctx context.Context) ([]model.Home, err
 	if !ok {
 		return nil, fmt.Errorf("no homes data in response")
 	}
+	return homesData, nil
+}
+
+// parseHome converts the data of one home, without its owner
+func parseHome(homeData map[string]interface{}) model.Home {
+	// Create basic home info
+	home := model.Home{
+		Id:           client.GetString(homeData, "id"),
+		Type:         client.GetString(homeData, "type"),
+		Size:         client.GetInt(homeData, "size"),
+		AppNickname:  client.GetString(homeData, "appNickname"),
+		AppAvatar:    client.GetString(homeData, "appAvatar"),
+		MainFuseSize: client.GetInt(homeData, "mainFuseSize"),
+		TimeZone:     client.GetString(homeData, "timeZone"),
+	}
+
+	// Parse address if available
+	if addressData, ok := homeData["address"].(map[string]interface{}); ok {
+		home.Address = model.Address{
+			Address1:   client.GetString(addressData, "address1"),
+			Address2:   client.GetString(addressData, "address2"),
+			PostalCode: client.GetString(addressData, "postalCode"),
+			City:       client.GetString(addressData, "city"),
+			Country:    client.GetString(addressData, "country"),
+			Latitude:   client.GetString(addressData, "latitude"),
+			Longitude:  client.GetString(addressData, "longitude"),
+		}
+	}
+
+	// Parse meteringPointData if available
+	if mpData, ok := homeData["meteringPointData"].(map[string]interface{}); ok {
+		home.MeteringPointData = model.MeteringPointData{
+			ConsumptionEan:             client.GetString(mpData, "consumptionEan"),
+			GridCompany:                client.GetString(mpData, "gridCompany"),
+			GridAreaCode:               client.GetString(mpData, "gridAreaCode"),
+			PriceAreaCode:              client.GetString(mpData, "priceAreaCode"),
+			ProductionEan:              client.GetString(mpData, "productionEan"),
+			EnergyTaxType:              client.GetString(mpData, "energyTaxType"),
+			VatType:                    client.GetString(mpData, "vatType"),
+			EstimatedAnnualConsumption: float64(client.GetInt(mpData, "estimatedAnnualConsumption")),
+		}
+	}
+
+	// Parse features if available
+	if featuresData, ok := homeData["features"].(map[string]interface{}); ok {
+		home.Features = model.HomeFeatures{
+			RealTimeConsumptionEnabled: featuresData["realTimeConsumptionEnabled"] == true,
+		}
+	}
+	return home
+}
+
+// storeHomes fetches the homes visible to an API client and stores them in the database. With a
+// keep function only the homes it accepts are stored and returned.
+func (s *HomeService) storeHomes(ctx context.Context, c *client.TibberClient, keep func(homeId string) bool) ([]model.Home, error) {
+	homesData, err := queryHomes(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
 	// Begin a transaction for batch inserts
 	tx, err := s.DB.BeginTx(ctx, nil)
@@ -119,48 +254,9 @@ func (s *HomeService) fetchAndStoreHomes(ctx context.Context) ([]model.Home, err
 			continue
 		}
 
-		// Create basic home info
-		home := model.Home{
-			Id:           client.GetString(homeData, "id"),
-			Type:         client.GetString(homeData, "type"),
-			Size:         client.GetInt(homeData, "size"),
-			AppNickname:  client.GetString(homeData, "appNickname"),
-			AppAvatar:    client.GetString(homeData, "appAvatar"),
-			MainFuseSize: client.GetInt(homeData, "mainFuseSize"),
-		}
-
-		// Parse address if available
-		if addressData, ok := homeData["address"].(map[string]interface{}); ok {
-			home.Address = model.Address{
-				Address1:   client.GetString(addressData, "address1"),
-				Address2:   client.GetString(addressData, "address2"),
-				PostalCode: client.GetString(addressData, "postalCode"),
-				City:       client.GetString(addressData, "city"),
-				Country:    client.GetString(addressData, "country"),
-				Latitude:   client.GetString(addressData, "latitude"),
-				Longitude:  client.GetString(addressData, "longitude"),
-			}
-		}
-
-		// Parse meteringPointData if available
-		if mpData, ok := homeData["meteringPointData"].(map[string]interface{}); ok {
-			home.MeteringPointData = model.MeteringPointData{
-				ConsumptionEan:             client.GetString(mpData, "consumptionEan"),
-				GridCompany:                client.GetString(mpData, "gridCompany"),
-				GridAreaCode:               client.GetString(mpData, "gridAreaCode"),
-				PriceAreaCode:              client.GetString(mpData, "priceAreaCode"),
-				ProductionEan:              client.GetString(mpData, "productionEan"),
-				EnergyTaxType:              client.GetString(mpData, "energyTaxType"),
-				VatType:                    client.GetString(mpData, "vatType"),
-				EstimatedAnnualConsumption: float64(client.GetInt(mpData, "estimatedAnnualConsumption")),
-			}
-		}
-
-		// Parse features if available
-		if featuresData, ok := homeData["features"].(map[string]interface{}); ok {
-			home.Features = model.HomeFeatures{
-				RealTimeConsumptionEnabled: featuresData["realTimeConsumptionEnabled"] == true,
-			}
+		home := parseHome(homeData)
+		if keep != nil && !keep(home.Id) {
+			continue
 		}
 
 		// Parse owner if available
@@ -337,3 +433,14 @@ func (s *HomeService) GetHomesWithProductionCapability(ctx context.Context) ([]m
 
 	return productionHomes, nil
 }
+
+// homeLocation returns the time zone of a home as stored in the homes table,
+// falling back to the default time zone when the home is unknown
+func homeLocation(ctx context.Context, db *sql.DB, homeId string) *time.Location {
+	var timeZone sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT time_zone FROM homes WHERE id = $1`, homeId).Scan(&timeZone)
+	if err != nil {
+		return localtime.Location("")
+	}
+	return localtime.Location(timeZone.String)
+}
@@ -0,0 +1,285 @@
+package service_db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/meterdata"
+	"ws/internal/model"
+)
+
+// Bronnen van verbruik en productie
+const (
+	SourceTibber = "TIBBER"
+	SourceImport = "IMPORT"
+)
+
+// ErrAlreadyImported is returned for a file that was imported before for the same home
+var ErrAlreadyImported = errors.New("file has already been imported")
+
+// ImportService imports metering data that members download from the portal of their grid operator
+type ImportService struct {
+	DB       *sql.DB
+	Profiles []meterdata.Profile
+}
+
+// importRow is an hour or day of consumption or production to store
+type importRow struct {
+	table      string
+	resolution string
+	interval   meterdata.Interval
+	value      float64
+}
+
+// Import parses a CSV or XLSX export and stores it as hourly and daily consumption and production.
+// The EAN codes in the file, or ean when the file has none, must be connections of the home.
+// Periods that Tibber already delivered are kept; periods of an earlier import are replaced.
+func (s *ImportService) Import(ctx context.Context, home model.Home, filename string, data []byte, profile, ean string) (*model.Import, error) {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	var existing int
+	err := s.DB.QueryRowContext(ctx, `SELECT id FROM imports WHERE home_id = $1 AND checksum = $2`, home.Id, checksum).Scan(&existing)
+	if err == nil {
+		return nil, ErrAlreadyImported
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error checking imports: %w", err)
+	}
+
+	profiles := s.Profiles
+	if profiles == nil {
+		profiles = meterdata.Profiles
+	}
+	loc := localtime.Location(home.TimeZone)
+	file, err := meterdata.Parse(filename, data, profiles, profile, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, usedEAN, err := importRows(home, file, meterdata.NormalizeEAN(ean), loc)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("file contains no complete hours or days")
+	}
+
+	result := &model.Import{
+		HomeId:     home.Id,
+		Filename:   filename,
+		Profile:    file.Profile,
+		EAN:        usedEAN,
+		Rows:       file.Rows,
+		Duplicates: file.Duplicates,
+	}
+	from, to := rows[0].interval.Start, rows[0].interval.End
+	for _, r := range rows {
+		if r.interval.Start.Before(from) {
+			from = r.interval.Start
+		}
+		if r.interval.End.After(to) {
+			to = r.interval.End
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO imports (home_id, filename, profile, ean, checksum, rows, duplicates, from_time, to_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`, home.Id, filename, file.Profile, usedEAN, checksum, file.Rows, file.Duplicates, from.UTC(), to.UTC()).Scan(&result.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error storing import: %w", err)
+	}
+
+	for _, r := range rows {
+		var source string
+		err := tx.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT source FROM %s WHERE home_id = $1 AND resolution = $2 AND from_time = $3
+		`, r.table), home.Id, r.resolution, r.interval.Start.UTC()).Scan(&source)
+		switch {
+		case err == sql.ErrNoRows:
+			result.Stored++
+		case err != nil:
+			return nil, fmt.Errorf("error checking %s: %w", r.table, err)
+		case source == SourceImport:
+			result.Replaced++
+		default:
+			// Gegevens van Tibber zijn leidend
+			result.Skipped++
+			continue
+		}
+
+		if err := storeImportRow(ctx, tx, home.Id, result.Id, r, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE imports SET stored = $2, skipped = $3, replaced = $4 WHERE id = $1
+	`, result.Id, result.Stored, result.Skipped, result.Replaced)
+	if err != nil {
+		return nil, fmt.Errorf("error updating import: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing import: %w", err)
+	}
+
+	result.From = from.Format(time.RFC3339)
+	result.To = to.Format(time.RFC3339)
+	result.CreatedAt = time.Now().Format(time.RFC3339)
+	log.Printf("Imported %s for home %s: %d stored, %d skipped, %d replaced", filename, home.Id, result.Stored, result.Skipped, result.Replaced)
+	return result, nil
+}
+
+// importRows checks the EAN codes of a parsed file and turns it into hours and days to store.
+// Consumption comes from the consumption connection, production from the production connection
+// or, when the home has no separate production EAN, from the same connection.
+func importRows(home model.Home, file *meterdata.File, ean string, loc *time.Location) ([]importRow, string, error) {
+	consumptionEAN := meterdata.NormalizeEAN(home.MeteringPointData.ConsumptionEan)
+	productionEAN := meterdata.NormalizeEAN(home.MeteringPointData.ProductionEan)
+	if consumptionEAN == "" && productionEAN == "" {
+		return nil, "", fmt.Errorf("home %s has no EAN code", home.Id)
+	}
+
+	byEAN := make(map[string][]meterdata.Interval)
+	for _, iv := range file.Intervals {
+		code := iv.EAN
+		if code == "" {
+			code = ean
+		}
+		if ean != "" && code != ean {
+			continue // Alleen de gekozen aansluiting uit een bestand met meerdere
+		}
+		byEAN[code] = append(byEAN[code], iv)
+	}
+	if _, ok := byEAN[""]; ok {
+		return nil, "", fmt.Errorf("file has no EAN code, enter the EAN code of the connection")
+	}
+	if len(byEAN) == 0 {
+		return nil, "", fmt.Errorf("file has no data for EAN %s", ean)
+	}
+
+	codes := make([]string, 0, len(byEAN))
+	for code := range byEAN {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	var rows []importRow
+	for _, code := range codes {
+		if err := meterdata.ValidateEAN(code); err != nil {
+			return nil, "", err
+		}
+		isConsumption := code == consumptionEAN
+		isProduction := code == productionEAN || (productionEAN == "" && isConsumption)
+		if !isConsumption && !isProduction {
+			return nil, "", fmt.Errorf("EAN %s is not a connection of this home", code)
+		}
+
+		hours, days := meterdata.Aggregate(byEAN[code], loc)
+		for resolution, list := range map[string][]meterdata.Interval{"HOURLY": hours, "DAILY": days} {
+			for _, iv := range list {
+				if isConsumption {
+					rows = append(rows, importRow{table: "consumption", resolution: resolution, interval: iv, value: iv.Import})
+				}
+				if isProduction {
+					rows = append(rows, importRow{table: "production", resolution: resolution, interval: iv, value: iv.Export})
+				}
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].interval.Start.Before(rows[j].interval.Start) })
+	return rows, strings.Join(codes, ","), nil
+}
+
+// storeImportRow writes an imported hour or day. Costs and profit follow from the stored prices
+// of the period, averaged per hour.
+func storeImportRow(ctx context.Context, tx *sql.Tx, homeId string, importId int, r importRow, loc *time.Location) error {
+	query := `
+		INSERT INTO consumption (home_id, resolution, from_time, from_date, to_time, consumption, cost, currency, source, import_id)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			COALESCE($6 * (SELECT AVG(total) FROM prices WHERE home_id = $1 AND starts_at >= $3 AND starts_at < $5), 0),
+			'EUR', $7, $8)
+		ON CONFLICT (home_id, resolution, from_time) DO UPDATE SET
+			to_time = EXCLUDED.to_time,
+			consumption = EXCLUDED.consumption,
+			cost = EXCLUDED.cost,
+			currency = EXCLUDED.currency,
+			source = EXCLUDED.source,
+			import_id = EXCLUDED.import_id
+	`
+	if r.table == "production" {
+		query = `
+		INSERT INTO production (home_id, resolution, from_time, from_date, to_time, production, profit, currency, source, import_id)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			COALESCE($6 * (SELECT AVG(energy) FROM prices WHERE home_id = $1 AND starts_at >= $3 AND starts_at < $5), 0),
+			'EUR', $7, $8)
+		ON CONFLICT (home_id, resolution, from_time) DO UPDATE SET
+			to_time = EXCLUDED.to_time,
+			production = EXCLUDED.production,
+			profit = EXCLUDED.profit,
+			currency = EXCLUDED.currency,
+			source = EXCLUDED.source,
+			import_id = EXCLUDED.import_id
+	`
+	}
+
+	_, err := tx.ExecContext(ctx, query, homeId, r.resolution, r.interval.Start.UTC(),
+		r.interval.Start.In(loc).Format("2006-01-02"), r.interval.End.UTC(), r.value, SourceImport, importId)
+	if err != nil {
+		return fmt.Errorf("error storing imported %s: %w", r.table, err)
+	}
+	return nil
+}
+
+// List returns the most recent imports of a home
+func (s *ImportService) List(ctx context.Context, homeId string, limit int) ([]model.Import, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, filename, profile, COALESCE(ean, ''), rows, duplicates, stored, skipped, replaced,
+			from_time, to_time, created_at
+		FROM imports
+		WHERE home_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, homeId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying imports: %w", err)
+	}
+	defer rows.Close()
+
+	var result []model.Import
+	for rows.Next() {
+		var i model.Import
+		var from, to sql.NullTime
+		var created time.Time
+		if err := rows.Scan(&i.Id, &i.Filename, &i.Profile, &i.EAN, &i.Rows, &i.Duplicates, &i.Stored, &i.Skipped, &i.Replaced, &from, &to, &created); err != nil {
+			return nil, fmt.Errorf("error scanning import: %w", err)
+		}
+		i.HomeId = homeId
+		if from.Valid {
+			i.From = from.Time.Format(time.RFC3339)
+		}
+		if to.Valid {
+			i.To = to.Time.Format(time.RFC3339)
+		}
+		i.CreatedAt = created.Format(time.RFC3339)
+		result = append(result, i)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,101 @@
+package service_db
+
+import (
+	"context"
+	"database/sql/driver"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"ws/internal/model"
+)
+
+// importedConsumption turns the recorded consumption rows of an import into the shape the dashboard reads them in
+func importedConsumption(t *testing.T, rows [][]driver.Value, loc *time.Location) []model.Consumption {
+	t.Helper()
+	var result []model.Consumption
+	for _, args := range rows {
+		// home_id, resolution, from_time, from_date, to_time, consumption, source, import_id
+		from, ok1 := args[2].(time.Time)
+		to, ok2 := args[4].(time.Time)
+		value, ok3 := args[5].(float64)
+		if !ok1 || !ok2 || !ok3 || args[1] != "HOURLY" || args[6] != SourceImport {
+			t.Fatalf("unexpected consumption row %v", args)
+		}
+		result = append(result, model.Consumption{
+			From:            from.In(loc).Format(time.RFC3339),
+			To:              to.In(loc).Format(time.RFC3339),
+			Consumption:     value,
+			ConsumptionUnit: "kWh",
+		})
+	}
+	return result
+}
+
+func TestImportFixtures(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	tests := []struct {
+		file string
+		want []model.Consumption
+	}{
+		{"liander.xlsx", []model.Consumption{
+			{From: "2025-01-15T00:00:00+01:00", To: "2025-01-15T01:00:00+01:00", Consumption: 0.25, ConsumptionUnit: "kWh"},
+			{From: "2025-01-15T01:00:00+01:00", To: "2025-01-15T02:00:00+01:00", Consumption: 0.5, ConsumptionUnit: "kWh"},
+			{From: "2025-01-15T02:00:00+01:00", To: "2025-01-15T03:00:00+01:00", Consumption: 0.375, ConsumptionUnit: "kWh"},
+		}},
+		{"stedin.xlsx", []model.Consumption{
+			{From: "2025-01-15T00:00:00+01:00", To: "2025-01-15T01:00:00+01:00", Consumption: 0.4, ConsumptionUnit: "kWh"},
+			{From: "2025-01-15T01:00:00+01:00", To: "2025-01-15T02:00:00+01:00", Consumption: 0.4, ConsumptionUnit: "kWh"},
+		}},
+		// Het uur van 01:00 mist een kwartier en wordt niet opgeslagen
+		{"enexis.csv", []model.Consumption{
+			{From: "2025-01-15T00:00:00+01:00", To: "2025-01-15T01:00:00+01:00", Consumption: 0.5, ConsumptionUnit: "kWh"},
+			{From: "2025-01-15T02:00:00+01:00", To: "2025-01-15T03:00:00+01:00", Consumption: 1.375, ConsumptionUnit: "kWh"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile("../meterdata/testdata/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, rec := openRecordingDB(t, map[string]driver.Value{
+				"FROM imports WHERE home_id = $1 AND checksum": noRows{},
+				"INSERT INTO imports":                          int64(7),
+				"SELECT source FROM":                           noRows{},
+			})
+			home := model.Home{Id: "home-1", TimeZone: "Europe/Amsterdam"}
+			home.MeteringPointData.ConsumptionEan = "871000000000000013"
+
+			svc := &ImportService{DB: db}
+			result, err := svc.Import(context.Background(), home, tt.file, data, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Id != 7 || result.EAN != "871000000000000013" || result.Stored != 2*len(tt.want) || result.Skipped != 0 {
+				t.Errorf("import = %+v, want id 7 with %d stored rows", result, 2*len(tt.want))
+			}
+
+			got := importedConsumption(t, rec.rows("consumption"), loc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d consumption rows, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.From != want.From || g.To != want.To || math.Abs(g.Consumption-want.Consumption) > 1e-9 {
+					t.Errorf("row %d = %+v, want %+v", i, g, want)
+				}
+			}
+			// Zonder aparte productie-EAN komt de teruglevering van dezelfde aansluiting
+			if n := len(rec.rows("production")); n != len(tt.want) {
+				t.Errorf("got %d production rows, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package service_db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ws/internal/client"
+)
+
+// tibberServer answers every GraphQL query with the given data
+func tibberServer(t *testing.T, data map[string]interface{}) *client.TibberClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	c := client.NewClient("test")
+	c.APIURL = srv.URL
+	return c
+}
+
+// hoursBetween returns the starts of the hours in [from, to) as Tibber sends them: local time with offset
+func hoursBetween(from, to time.Time, loc *time.Location) []time.Time {
+	var hours []time.Time
+	for h := from; h.Before(to); h = h.Add(time.Hour) {
+		hours = append(hours, h.In(loc))
+	}
+	return hours
+}
+
+// countPerDate counts the recorded rows per local date in argument column
+func countPerDate(t *testing.T, rows [][]driver.Value, column int) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for _, row := range rows {
+		date, ok := row[column].(time.Time)
+		if !ok {
+			t.Fatalf("column %d is %T, want time.Time", column, row[column])
+		}
+		counts[date.Format("2006-01-02")]++
+	}
+	return counts
+}
+
+var dstDays = []struct {
+	name          string
+	from, to      time.Time // Drie lokale dagen rond de overgang
+	before, dst   string
+	after         string
+	hoursOnDSTDay int
+}{
+	{"spring forward", time.Date(2025, 3, 28, 23, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 22, 0, 0, 0, time.UTC),
+		"2025-03-29", "2025-03-30", "2025-03-31", 23},
+	{"fall back", time.Date(2025, 10, 24, 22, 0, 0, 0, time.UTC), time.Date(2025, 10, 27, 23, 0, 0, 0, time.UTC),
+		"2025-10-25", "2025-10-26", "2025-10-27", 25},
+}
+
+func TestPricesLocalDays(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	for _, tt := range dstDays {
+		t.Run(tt.name, func(t *testing.T) {
+			var today []interface{}
+			for _, h := range hoursBetween(tt.from, tt.to, loc) {
+				today = append(today, map[string]interface{}{
+					"startsAt": h.Format("2006-01-02T15:04:05.000-07:00"),
+					"total":    0.25, "energy": 0.1, "tax": 0.15, "currency": "EUR", "level": "NORMAL",
+				})
+			}
+			svc := &PriceService{Client: tibberServer(t, map[string]interface{}{
+				"viewer": map[string]interface{}{"homes": []interface{}{map[string]interface{}{
+					"id": "home-1",
+					"currentSubscription": map[string]interface{}{
+						"priceInfo": map[string]interface{}{"today": today},
+					},
+				}}},
+			})}
+			var rec *recordingDB
+			svc.DB, rec = openRecordingDB(t, map[string]driver.Value{"time_zone": "Europe/Amsterdam"})
+
+			if _, err := svc.GetPrices(context.Background(), "home-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			counts := countPerDate(t, rec.rows("prices"), 2)
+			want := map[string]int{tt.before: 24, tt.dst: tt.hoursOnDSTDay, tt.after: 24}
+			for date, n := range want {
+				if counts[date] != n {
+					t.Errorf("%s has %d hourly prices, want %d", date, counts[date], n)
+				}
+			}
+			if len(counts) != len(want) {
+				t.Errorf("prices on dates %v, want only %v", counts, want)
+			}
+
+			// Het uur 02:00 bestaat in maart niet en komt in oktober twee keer voor
+			hours := make(map[int]int)
+			for _, row := range rec.rows("prices") {
+				if row[2].(time.Time).Format("2006-01-02") == tt.dst {
+					hours[int(row[3].(int64))]++
+				}
+			}
+			if want := tt.hoursOnDSTDay - 23; hours[2] != want {
+				t.Errorf("hour 2 occurs %d times on %s, want %d", hours[2], tt.dst, want)
+			}
+		})
+	}
+}
+
+func TestConsumptionLocalDays(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+
+	for _, tt := range dstDays {
+		t.Run(tt.name, func(t *testing.T) {
+			var nodes []interface{}
+			for _, h := range hoursBetween(tt.from, tt.to, loc) {
+				nodes = append(nodes, map[string]interface{}{
+					"from":        h.Format(time.RFC3339),
+					"to":          h.Add(time.Hour).In(loc).Format(time.RFC3339),
+					"consumption": 0.5, "cost": 0.125, "currency": "EUR",
+				})
+			}
+			svc := &ConsumptionService{Client: tibberServer(t, map[string]interface{}{
+				"viewer": map[string]interface{}{"home": map[string]interface{}{
+					"consumption": map[string]interface{}{"nodes": nodes},
+				}},
+			})}
+			var rec *recordingDB
+			svc.DB, rec = openRecordingDB(t, map[string]driver.Value{"time_zone": "Europe/Amsterdam"})
+
+			if _, err := svc.GetConsumption(context.Background(), "home-1", "HOURLY", len(nodes)); err != nil {
+				t.Fatal(err)
+			}
+
+			counts := countPerDate(t, rec.rows("consumption"), 3)
+			want := map[string]int{tt.before: 24, tt.dst: tt.hoursOnDSTDay, tt.after: 24}
+			for date, n := range want {
+				if counts[date] != n {
+					t.Errorf("%s has %d hourly rows, want %d", date, counts[date], n)
+				}
+			}
+			if len(counts) != len(want) {
+				t.Errorf("rows on dates %v, want only %v", counts, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/model"
+	"ws/internal/opendata"
+)
+
+// OpenDataService reads the data of the homes for the open data feed and stores what is published
+type OpenDataService struct {
+	DB *sql.DB
+}
+
+// PublishedDays are the days of a dataset that have been published
+type PublishedDays struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	Days  int       `json:"days"`
+}
+
+// consentFilter leaves out homes of members without the consent of the category in parameter $n;
+// homes without account are those of the installation
+func consentFilter(n int) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM tibber_account_homes ah
+		WHERE ah.home_id = h.id AND NOT COALESCE((
+			SELECT c.granted FROM member_consents c
+			WHERE c.account_id = ah.account_id AND c.category = $%d
+			ORDER BY c.recorded_at DESC, c.id DESC
+			LIMIT 1
+		), FALSE))`, n)
+}
+
+// Samples returns the value of every home per hour in [from, to) for a dataset, placed in its
+// area. Homes of members who did not consent are left out; the live dataset also needs consent
+// for live measurements.
+func (s *OpenDataService) Samples(ctx context.Context, d opendata.Dataset, from, to time.Time, level, community string, digits int) ([]opendata.Sample, error) {
+	var query string
+	args := []interface{}{from, to, model.ConsentCommunity}
+	switch d.Name {
+	case "energy":
+		query = `
+			SELECT COALESCE(h.postal_code, ''), c.home_id, c.from_time,
+				COALESCE(c.consumption, 0), COALESCE(p.production, 0)
+			FROM consumption c
+			JOIN homes h ON h.id = c.home_id
+			LEFT JOIN production p ON p.home_id = c.home_id AND p.resolution = c.resolution AND p.from_time = c.from_time
+			WHERE c.resolution = 'HOURLY' AND c.from_time >= $1 AND c.from_time < $2
+				AND ` + consentFilter(3)
+	case "live":
+		query = `
+			SELECT COALESCE(h.postal_code, ''), m.home_id, date_trunc('hour', m.timestamp),
+				AVG(m.power), AVG(m.power_production)
+			FROM real_time_measurements m
+			JOIN homes h ON h.id = m.home_id
+			WHERE m.timestamp >= $1 AND m.timestamp < $2
+				AND ` + consentFilter(3) + ` AND ` + consentFilter(4) + `
+			GROUP BY 1, 2, 3`
+		args = append(args, model.ConsentLive)
+	default:
+		return nil, fmt.Errorf("unknown open data dataset %q", d.Name)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s samples: %w", d.Name, err)
+	}
+	defer rows.Close()
+	var samples []opendata.Sample
+	for rows.Next() {
+		var postalCode string
+		var a, b float64
+		sample := opendata.Sample{}
+		if err := rows.Scan(&postalCode, &sample.HomeID, &sample.Start, &a, &b); err != nil {
+			return nil, err
+		}
+		sample.Area = opendata.Area(level, community, postalCode, digits)
+		sample.Values = []float64{a, b}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// IsPublished reports whether a day of a dataset has been published
+func (s *OpenDataService) IsPublished(ctx context.Context, dataset string, day time.Time) (bool, error) {
+	var published bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM open_data_days WHERE dataset = $1 AND day = $2)`,
+		dataset, day.Format("2006-01-02")).Scan(&published)
+	if err != nil {
+		return false, fmt.Errorf("error checking published open data: %w", err)
+	}
+	return published, nil
+}
+
+// Publish stores the rows of a day of a dataset. A published day never changes.
+func (s *OpenDataService) Publish(ctx context.Context, d opendata.Dataset, day time.Time, level string, rows []opendata.Row, suppressed int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert := func(r opendata.Row, metric string, value float64) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO open_data (dataset, area, starts_at, metric, value)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (dataset, area, starts_at, metric) DO NOTHING`,
+			d.Name, r.Area, r.Start, metric, value)
+		return err
+	}
+	for _, r := range rows {
+		if err := insert(r, opendata.HomesMetric, r.Homes); err != nil {
+			return fmt.Errorf("error storing open data: %w", err)
+		}
+		for i, m := range d.Metrics {
+			if err := insert(r, m.Name, r.Values[i]); err != nil {
+				return fmt.Errorf("error storing open data: %w", err)
+			}
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO open_data_days (dataset, day, level, rows, suppressed, published_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())`,
+		d.Name, day.Format("2006-01-02"), level, len(rows), suppressed)
+	if err != nil {
+		return fmt.Errorf("error storing published day: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rows returns the published rows of a dataset in [from, to), optionally of one area
+func (s *OpenDataService) Rows(ctx context.Context, d opendata.Dataset, from, to time.Time, area string) ([]opendata.Row, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT area, starts_at, metric, value
+		FROM open_data
+		WHERE dataset = $1 AND starts_at >= $2 AND starts_at < $3 AND ($4 = '' OR area = $4)
+		ORDER BY starts_at, area`, d.Name, from, to, area)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching open data: %w", err)
+	}
+	defer rows.Close()
+
+	index := make(map[string]int, len(d.Metrics))
+	for i, m := range d.Metrics {
+		index[m.Name] = i
+	}
+	var result []opendata.Row
+	for rows.Next() {
+		var area, metric string
+		var start time.Time
+		var value float64
+		if err := rows.Scan(&area, &start, &metric, &value); err != nil {
+			return nil, err
+		}
+		// Rijen staan op volgorde, dus een nieuw gebied of uur begint een nieuwe rij
+		if n := len(result); n == 0 || result[n-1].Area != area || !result[n-1].Start.Equal(start) {
+			result = append(result, opendata.Row{Area: area, Start: start, Values: make([]float64, len(d.Metrics))})
+		}
+		row := &result[len(result)-1]
+		if metric == opendata.HomesMetric {
+			row.Homes = value
+		} else if i, ok := index[metric]; ok {
+			row.Values[i] = value
+		}
+	}
+	return result, rows.Err()
+}
+
+// Published returns the published days per dataset
+func (s *OpenDataService) Published(ctx context.Context) (map[string]PublishedDays, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT dataset, MIN(day), MAX(day), COUNT(*)
+		FROM open_data_days
+		GROUP BY dataset`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching published days: %w", err)
+	}
+	defer rows.Close()
+	published := make(map[string]PublishedDays)
+	for rows.Next() {
+		var dataset string
+		var p PublishedDays
+		if err := rows.Scan(&dataset, &p.First, &p.Last, &p.Days); err != nil {
+			return nil, err
+		}
+		published[dataset] = p
+	}
+	return published, rows.Err()
+}
@@ -0,0 +1,108 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"ws/internal/db"
+	"ws/internal/model"
+	"ws/internal/opendata"
+)
+
+// testPostgres opens the database in TEST_DATABASE_URL with the schema in a fresh Postgres schema
+// that is dropped afterwards. Without TEST_DATABASE_URL the test is skipped: the consent rules are
+// SQL and cannot be checked with the recording driver.
+func testPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Eén verbinding, zodat search_path voor elke query geldt
+	conn.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	for _, stmt := range []string{"CREATE SCHEMA " + schema, "SET search_path TO " + schema} {
+		if _, err := conn.Exec(stmt); err != nil {
+			conn.Close()
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	t.Cleanup(func() {
+		conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+		conn.Close()
+	})
+	if err := db.InitSchema(conn); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestSamplesConsent(t *testing.T) {
+	conn := testPostgres(t)
+	ctx := context.Background()
+	hour := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	granted, revoked := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC), time.Date(2025, 5, 20, 9, 0, 0, 0, time.UTC)
+
+	// Per lid de toestemmingen voor community in volgorde van vastleggen; nil is een huis zonder account
+	homes := []struct {
+		id       string
+		consents []bool
+		at       []time.Time
+	}{
+		{id: "own"},
+		{id: "granted", consents: []bool{true}, at: []time.Time{granted}},
+		{id: "revoked", consents: []bool{true, false}, at: []time.Time{granted, revoked}},
+		{id: "granted-again", consents: []bool{false, true}, at: []time.Time{granted, revoked}},
+		// Zelfde tijdstip: de laatst toegevoegde rij geldt
+		{id: "revoked-same-time", consents: []bool{true, false}, at: []time.Time{revoked, revoked}},
+		{id: "never-asked", consents: []bool{}},
+	}
+	for _, h := range homes {
+		mustExec(t, conn, `INSERT INTO homes (id, postal_code) VALUES ($1, '3511 AB')`, h.id)
+		mustExec(t, conn, `INSERT INTO consumption (home_id, resolution, from_time, consumption) VALUES ($1, 'HOURLY', $2, 1)`, h.id, hour)
+		if h.consents == nil {
+			continue
+		}
+		var account int
+		if err := conn.QueryRow(`INSERT INTO tibber_accounts (name, token_encrypted) VALUES ($1, 'v1:x') RETURNING id`, h.id).Scan(&account); err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, conn, `INSERT INTO tibber_account_homes (home_id, account_id) VALUES ($1, $2)`, h.id, account)
+		for i, given := range h.consents {
+			mustExec(t, conn, `INSERT INTO member_consents (account_id, category, granted, version, recorded_at) VALUES ($1, $2, $3, $4, $5)`,
+				account, model.ConsentCommunity, given, model.ConsentVersion, h.at[i])
+		}
+	}
+
+	energy, _ := opendata.Find("energy")
+	service := &OpenDataService{DB: conn}
+	samples, err := service.Samples(ctx, energy, hour, hour.Add(time.Hour), opendata.LevelPostalCode, "", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range samples {
+		got = append(got, s.HomeID)
+	}
+	sort.Strings(got)
+	want := []string{"granted", "granted-again", "own"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("homes %v, want %v", got, want)
+	}
+}
+
+func mustExec(t *testing.T, conn *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := conn.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
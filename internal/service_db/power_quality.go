@@ -0,0 +1,371 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/powerquality"
+	"ws/internal/tibber"
+)
+
+// PowerQualityService analyses the phase currents and voltages of the real-time measurements
+type PowerQualityService struct {
+	DB *sql.DB
+}
+
+// Analyze computes the power quality of a home over [from, to) from the stored real-time measurements
+func (s *PowerQualityService) Analyze(ctx context.Context, home model.Home, from, to time.Time) (powerquality.Summary, error) {
+	measurements, err := s.measurements(ctx, home.Id, from, to)
+	if err != nil {
+		return powerquality.Summary{}, err
+	}
+	return powerquality.Analyze(home.Id, home.MainFuseSize, measurements), nil
+}
+
+// Record stores the power quality of the local day of t. Real-time measurements are only
+// kept for 24 hours, so this has to run shortly after midnight.
+func (s *PowerQualityService) Record(ctx context.Context, home model.Home, t time.Time) (*powerquality.Summary, error) {
+	loc := localtime.Location(home.TimeZone)
+	from, to := localtime.DayBounds(t, loc)
+
+	summary, err := s.Analyze(ctx, home, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if summary.Samples == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO power_quality_daily (
+			home_id, day, samples, fuse_size, mean_imbalance, max_imbalance,
+			max_fuse_utilization, p95_fuse_utilization, high_fuse_minutes,
+			min_voltage, max_voltage, undervoltage_count, overvoltage_minutes, overvoltage_events
+		) VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (home_id, day) DO UPDATE SET
+			samples = EXCLUDED.samples,
+			fuse_size = EXCLUDED.fuse_size,
+			mean_imbalance = EXCLUDED.mean_imbalance,
+			max_imbalance = EXCLUDED.max_imbalance,
+			max_fuse_utilization = EXCLUDED.max_fuse_utilization,
+			p95_fuse_utilization = EXCLUDED.p95_fuse_utilization,
+			high_fuse_minutes = EXCLUDED.high_fuse_minutes,
+			min_voltage = EXCLUDED.min_voltage,
+			max_voltage = EXCLUDED.max_voltage,
+			undervoltage_count = EXCLUDED.undervoltage_count,
+			overvoltage_minutes = EXCLUDED.overvoltage_minutes,
+			overvoltage_events = EXCLUDED.overvoltage_events
+	`,
+		home.Id,
+		localtime.Date(t, loc),
+		summary.Samples,
+		summary.FuseSize,
+		summary.MeanImbalance,
+		summary.MaxImbalance,
+		summary.MaxFuseUtilization,
+		summary.P95FuseUtilization,
+		summary.HighFuseMinutes,
+		summary.MinVoltage,
+		summary.MaxVoltage,
+		summary.UndervoltageCount,
+		summary.OvervoltageMinutes,
+		summary.EventCount,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error storing power quality for home %s: %w", home.Id, err)
+	}
+
+	// Gebeurtenissen van de dag opnieuw schrijven zodat een herhaalde run niets dubbel telt
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM voltage_events WHERE home_id = $1 AND started_at >= $2 AND started_at < $3
+	`, home.Id, from.UTC(), to.UTC()); err != nil {
+		return nil, fmt.Errorf("error clearing voltage events: %w", err)
+	}
+	for _, e := range summary.Events {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO voltage_events (home_id, phase, started_at, ended_at, max_voltage, production)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, home.Id, e.Phase, e.Start.UTC(), e.End.UTC(), e.MaxVoltage, e.Production); err != nil {
+			return nil, fmt.Errorf("error storing voltage event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing power quality: %w", err)
+	}
+	return &summary, nil
+}
+
+// Daily returns the stored daily power quality of a home, or of all homes when homeId is empty
+func (s *PowerQualityService) Daily(ctx context.Context, homeId string, from, to time.Time) ([]powerquality.Summary, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT home_id, day, samples, COALESCE(fuse_size, 0),
+			COALESCE(mean_imbalance, 0), COALESCE(max_imbalance, 0),
+			COALESCE(max_fuse_utilization, 0), COALESCE(p95_fuse_utilization, 0), COALESCE(high_fuse_minutes, 0),
+			COALESCE(min_voltage, 0), COALESCE(max_voltage, 0), undervoltage_count,
+			COALESCE(overvoltage_minutes, 0), overvoltage_events
+		FROM power_quality_daily
+		WHERE ($1 = '' OR home_id = $1) AND day >= $2 AND day < $3
+		ORDER BY home_id, day
+	`, homeId, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("error querying power quality: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []powerquality.Summary
+	for rows.Next() {
+		var s powerquality.Summary
+		var day time.Time
+		if err := rows.Scan(&s.HomeId, &day, &s.Samples, &s.FuseSize,
+			&s.MeanImbalance, &s.MaxImbalance,
+			&s.MaxFuseUtilization, &s.P95FuseUtilization, &s.HighFuseMinutes,
+			&s.MinVoltage, &s.MaxVoltage, &s.UndervoltageCount,
+			&s.OvervoltageMinutes, &s.EventCount); err != nil {
+			return nil, fmt.Errorf("error scanning power quality: %w", err)
+		}
+		s.From = day
+		s.To = day.AddDate(0, 0, 1)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// Events returns the overvoltage events of a home, or of all homes when homeId is empty
+func (s *PowerQualityService) Events(ctx context.Context, homeId string, from, to time.Time) ([]powerquality.VoltageEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT home_id, phase, started_at, ended_at, max_voltage, COALESCE(production, 0)
+		FROM voltage_events
+		WHERE ($1 = '' OR home_id = $1) AND started_at >= $2 AND started_at < $3
+		ORDER BY started_at
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying voltage events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []powerquality.VoltageEvent
+	for rows.Next() {
+		var e powerquality.VoltageEvent
+		if err := rows.Scan(&e.HomeId, &e.Phase, &e.Start, &e.End, &e.MaxVoltage, &e.Production); err != nil {
+			return nil, fmt.Errorf("error scanning voltage event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// PostalAreas maps every home to its postal area at the given aggregation level
+func (s *PowerQualityService) PostalAreas(ctx context.Context, digits int) (map[string]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, COALESCE(postal_code, '') FROM homes`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying postal codes: %w", err)
+	}
+	defer rows.Close()
+
+	areas := make(map[string]string)
+	for rows.Next() {
+		var id, postalCode string
+		if err := rows.Scan(&id, &postalCode); err != nil {
+			return nil, fmt.Errorf("error scanning postal code: %w", err)
+		}
+		areas[id] = powerquality.PostalArea(postalCode, digits)
+	}
+	return areas, rows.Err()
+}
+
+// AreaReport aggregates the stored power quality of all homes per postal area over [from, to)
+func (s *PowerQualityService) AreaReport(ctx context.Context, from, to time.Time, digits int) ([]powerquality.Area, error) {
+	areas, err := s.PostalAreas(ctx, digits)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := s.Daily(ctx, "", from, to)
+	if err != nil {
+		return nil, err
+	}
+	return powerquality.Aggregate(summaries, areas), nil
+}
+
+// measurements loads the real-time measurements of a home in [from, to) in time order
+func (s *PowerQualityService) measurements(ctx context.Context, homeId string, from, to time.Time) ([]tibber.Measurement, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT timestamp, power, power_production,
+			current_l1, current_l2, current_l3,
+			voltage_phase1, voltage_phase2, voltage_phase3
+		FROM real_time_measurements
+		WHERE home_id = $1 AND timestamp >= $2 AND timestamp < $3
+		ORDER BY timestamp
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying measurements: %w", err)
+	}
+	defer rows.Close()
+
+	var measurements []tibber.Measurement
+	for rows.Next() {
+		var m tibber.Measurement
+		if err := rows.Scan(&m.Timestamp, &m.Power, &m.PowerProduction,
+			&m.CurrentL1, &m.CurrentL2, &m.CurrentL3,
+			&m.VoltagePhase1, &m.VoltagePhase2, &m.VoltagePhase3); err != nil {
+			return nil, fmt.Errorf("error scanning measurement: %w", err)
+		}
+		measurements = append(measurements, m)
+	}
+	return measurements, rows.Err()
+}
+
+// DetectCurtailment looks for inverters switching off on high voltage during the local day of t.
+// All production homes are analysed together so each home can be compared with the others.
+func (s *PowerQualityService) DetectCurtailment(ctx context.Context, homes []model.Home, t time.Time) (int, error) {
+	from, to := localtime.DayBounds(t, localtime.Location(""))
+
+	series := make(map[string][]tibber.Measurement)
+	for _, home := range homes {
+		if home.MeteringPointData.ProductionEan == "" {
+			continue
+		}
+		ms, err := s.measurements(ctx, home.Id, from, to)
+		if err != nil {
+			return 0, err
+		}
+		if len(ms) > 0 {
+			series[home.Id] = ms
+		}
+	}
+
+	stored := 0
+	for homeId, ms := range series {
+		peers := make(map[string][]tibber.Measurement, len(series)-1)
+		for otherId, other := range series {
+			if otherId != homeId {
+				peers[otherId] = other
+			}
+		}
+
+		prices, err := s.hourlyPrices(ctx, homeId, from, to)
+		if err != nil {
+			return stored, err
+		}
+		price := func(t time.Time) float64 {
+			return prices[t.Truncate(time.Hour).Unix()]
+		}
+
+		episodes := powerquality.DetectCurtailment(homeId, ms, powerquality.NewPeerOutput(peers), price)
+		if err := s.storeCurtailment(ctx, homeId, from, to, episodes); err != nil {
+			return stored, err
+		}
+		stored += len(episodes)
+	}
+	return stored, nil
+}
+
+// storeCurtailment replaces the episodes of a home in [from, to)
+func (s *PowerQualityService) storeCurtailment(ctx context.Context, homeId string, from, to time.Time, episodes []powerquality.CurtailmentEpisode) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM curtailment_episodes WHERE home_id = $1 AND started_at >= $2 AND started_at < $3
+	`, homeId, from.UTC(), to.UTC()); err != nil {
+		return fmt.Errorf("error clearing curtailment episodes: %w", err)
+	}
+
+	for _, e := range episodes {
+		var peerRatio sql.NullFloat64
+		if e.PeerRatio >= 0 {
+			peerRatio = sql.NullFloat64{Float64: e.PeerRatio, Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO curtailment_episodes (
+				home_id, started_at, ended_at, max_voltage, reference_power,
+				lost_kwh, price, lost_revenue, peer_ratio, confidence
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, homeId, e.Start.UTC(), e.End.UTC(), e.MaxVoltage, e.ReferenceW,
+			e.LostKWh, e.Price, e.LostRevenue, peerRatio, e.Confidence); err != nil {
+			return fmt.Errorf("error storing curtailment episode: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing curtailment episodes: %w", err)
+	}
+	return nil
+}
+
+// Curtailment returns the stored curtailment episodes of a home, or of all homes when homeId is empty
+func (s *PowerQualityService) Curtailment(ctx context.Context, homeId string, from, to time.Time) ([]powerquality.CurtailmentEpisode, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT home_id, started_at, ended_at, max_voltage, reference_power,
+			lost_kwh, COALESCE(price, 0), COALESCE(lost_revenue, 0), COALESCE(peer_ratio, -1), confidence
+		FROM curtailment_episodes
+		WHERE ($1 = '' OR home_id = $1) AND started_at >= $2 AND started_at < $3
+		ORDER BY started_at
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying curtailment episodes: %w", err)
+	}
+	defer rows.Close()
+
+	var episodes []powerquality.CurtailmentEpisode
+	for rows.Next() {
+		var e powerquality.CurtailmentEpisode
+		if err := rows.Scan(&e.HomeId, &e.Start, &e.End, &e.MaxVoltage, &e.ReferenceW,
+			&e.LostKWh, &e.Price, &e.LostRevenue, &e.PeerRatio, &e.Confidence); err != nil {
+			return nil, fmt.Errorf("error scanning curtailment episode: %w", err)
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+// CurtailmentByArea totals the curtailment of all homes per month and postal area
+func (s *PowerQualityService) CurtailmentByArea(ctx context.Context, from, to time.Time, digits int) ([]powerquality.CurtailmentMonth, error) {
+	areas, err := s.PostalAreas(ctx, digits)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := s.Curtailment(ctx, "", from, to)
+	if err != nil {
+		return nil, err
+	}
+	return powerquality.SummarizeCurtailment(episodes, func(homeId string) string {
+		return areas[homeId]
+	}, localtime.Location("")), nil
+}
+
+// hourlyPrices returns the total price per hour of a home keyed by the Unix time of the hour
+func (s *PowerQualityService) hourlyPrices(ctx context.Context, homeId string, from, to time.Time) (map[int64]float64, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT starts_at, total FROM prices
+		WHERE home_id = $1 AND starts_at >= $2 AND starts_at < $3
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying prices: %w", err)
+	}
+	defer rows.Close()
+
+	prices := make(map[int64]float64)
+	for rows.Next() {
+		var startsAt time.Time
+		var total float64
+		if err := rows.Scan(&startsAt, &total); err != nil {
+			return nil, fmt.Errorf("error scanning price: %w", err)
+		}
+		prices[startsAt.Truncate(time.Hour).Unix()] = total
+	}
+	return prices, rows.Err()
+}
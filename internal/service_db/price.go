@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"ws/internal/client"
+	"ws/internal/localtime"
 	"ws/internal/model"
 )
 
@@ -14,12 +15,18 @@ import (
 type PriceService struct {
 	Client *client.TibberClient
 	DB     *sql.DB
+	// Accounts selects the token of the member that owns a home; nil means Client for every home
+	Accounts *AccountService
 }
 
 // Update GetPrices to handle the homes array response and store in database
 func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Home, error) {
 	// Note: No variables needed for the query now
-	resp, err := s.Client.QueryAPI(ctx, model.PriceQuery, nil)
+	c, err := homeClient(ctx, s.Accounts, s.Client, homeId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.QueryAPI(ctx, model.PriceQuery, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +65,7 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 				// Extract priceInfo data if available
 				if priceInfoData, ok := subscriptionData["priceInfo"].(map[string]interface{}); ok {
 					priceInfo := model.PriceInfo{}
+					loc := homeLocation(ctx, s.DB, homeId)
 
 					// Begin a transaction for batch inserts
 					tx, err := s.DB.BeginTx(ctx, nil)
@@ -68,9 +76,14 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 
 					// Prepare the insert statement
 					stmt, err := tx.PrepareContext(ctx, `
-						INSERT INTO prices (home_id, price_date, hour_of_day, total, energy, tax, currency, level)
-						VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-						ON CONFLICT (home_id, price_date, hour_of_day) DO NOTHING
+						INSERT INTO prices (home_id, starts_at, price_date, hour_of_day, total, energy, tax, currency, level)
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+						ON CONFLICT (home_id, starts_at) DO UPDATE SET
+							total = EXCLUDED.total,
+							energy = EXCLUDED.energy,
+							tax = EXCLUDED.tax,
+							currency = EXCLUDED.currency,
+							level = EXCLUDED.level
 					`)
 					if err != nil {
 						return nil, fmt.Errorf("failed to prepare statement: %w", err)
@@ -83,7 +96,7 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 						priceInfo.Current = current
 
 						// Store current price in database
-						if err := storePriceInDB(ctx, stmt, homeId, current); err != nil {
+						if err := storePriceInDB(ctx, stmt, homeId, current, loc); err != nil {
 							return nil, fmt.Errorf("failed to store current price: %w", err)
 						}
 					}
@@ -97,7 +110,7 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 								today = append(today, price)
 
 								// Store today's price in database
-								if err := storePriceInDB(ctx, stmt, homeId, price); err != nil {
+								if err := storePriceInDB(ctx, stmt, homeId, price, loc); err != nil {
 									return nil, fmt.Errorf("failed to store today's price: %w", err)
 								}
 							}
@@ -114,7 +127,7 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 								tomorrow = append(tomorrow, price)
 
 								// Store tomorrow's price in database
-								if err := storePriceInDB(ctx, stmt, homeId, price); err != nil {
+								if err := storePriceInDB(ctx, stmt, homeId, price, loc); err != nil {
 									return nil, fmt.Errorf("failed to store tomorrow's price: %w", err)
 								}
 							}
@@ -142,17 +155,19 @@ func (s *PriceService) GetPrices(ctx context.Context, homeId string) (*model.Hom
 	return targetHome, nil
 }
 
-// Helper function to store a price in the database
-func storePriceInDB(ctx context.Context, stmt *sql.Stmt, homeId string, price model.Price) error {
-	startTime, err := time.Parse(time.RFC3339, price.StartTime)
+// Helper function to store a price in the database.
+// The start time is stored in UTC; date and hour are derived in the home's time zone.
+func storePriceInDB(ctx context.Context, stmt *sql.Stmt, homeId string, price model.Price, loc *time.Location) error {
+	startTime, err := localtime.ParseTimestamp(price.StartTime)
 	if err != nil {
 		return fmt.Errorf("invalid start time format: %w", err)
 	}
 
 	_, err = stmt.ExecContext(ctx,
 		homeId,
-		startTime.Truncate(24*time.Hour),
-		startTime.Hour(),
+		startTime.UTC(),
+		localtime.Date(startTime, loc),
+		localtime.Hour(startTime, loc),
 		price.Total,
 		price.Energy,
 		price.Tax,
@@ -186,25 +201,26 @@ func (s *PriceService) GetCurrentPrice(ctx context.Context, homeId string) (*mod
 // getCurrentPriceFromDB retrieves the current price from the database
 func (s *PriceService) getCurrentPriceFromDB(ctx context.Context, homeId string) (*model.Price, error) {
 	var price model.Price
-	var priceDate time.Time
-	var hourOfDay int
+	var startsAt time.Time
 
+	// Compare instants instead of CURRENT_DATE/hour so the session time zone doesn't matter
 	err := s.DB.QueryRowContext(ctx, `
-		SELECT price_date, hour_of_day, total, energy, tax, currency, level
+		SELECT starts_at, total, energy, tax, currency, level
 		FROM prices
 		WHERE home_id = $1
-		AND price_date = CURRENT_DATE
-		AND hour_of_day = EXTRACT(HOUR FROM CURRENT_TIMESTAMP)::INTEGER
+		AND starts_at <= $2
+		AND starts_at > $2 - INTERVAL '1 hour'
+		ORDER BY starts_at DESC
 		LIMIT 1
-	`, homeId).Scan(&priceDate, &hourOfDay, &price.Total, &price.Energy, &price.Tax, &price.Currency, &price.Level)
+	`, homeId, time.Now().UTC()).Scan(&startsAt, &price.Total, &price.Energy, &price.Tax, &price.Currency, &price.Level)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Reconstruct the start time from date and hour
-	startTime := time.Date(priceDate.Year(), priceDate.Month(), priceDate.Day(), hourOfDay, 0, 0, 0, priceDate.Location())
-	price.StartTime = startTime.Format(time.RFC3339)
+	loc := homeLocation(ctx, s.DB, homeId)
+	price.StartTime = startsAt.In(loc).Format(time.RFC3339)
+	price.EndTime = startsAt.Add(time.Hour).In(loc).Format(time.RFC3339)
 	return &price, nil
 }
 
@@ -254,29 +270,27 @@ func (s *PriceService) FindLowestPriceHour(ctx context.Context, homeId string, i
 
 // findLowestPriceFromDB finds the lowest price in the database
 func (s *PriceService) findLowestPriceFromDB(ctx context.Context, homeId string, includeTomorrow bool) (*model.Price, error) {
+	// Today (and tomorrow) are local days of the home, which can be 23 or 25 hours long
+	loc := homeLocation(ctx, s.DB, homeId)
+	from, until := localtime.DayBounds(time.Now(), loc)
+	if includeTomorrow {
+		until = localtime.NextDay(until, loc)
+	}
+
 	query := `
-		SELECT price_date, hour_of_day, total, energy, tax, currency, level
+		SELECT starts_at, total, energy, tax, currency, level
 		FROM prices
 		WHERE home_id = $1
-		AND price_date = CURRENT_DATE
+		AND starts_at >= $2
+		AND starts_at < $3
+		ORDER BY total ASC LIMIT 1
 	`
-	if includeTomorrow {
-		query = `
-			SELECT price_date, hour_of_day, total, energy, tax, currency, level
-			FROM prices
-			WHERE home_id = $1
-			AND price_date IN (CURRENT_DATE, CURRENT_DATE + INTERVAL '1 day')
-		`
-	}
-	query += ` ORDER BY total ASC LIMIT 1`
 
 	var price model.Price
-	var priceDate time.Time
-	var hourOfDay int
+	var startsAt time.Time
 
-	err := s.DB.QueryRowContext(ctx, query, homeId).Scan(
-		&priceDate,
-		&hourOfDay,
+	err := s.DB.QueryRowContext(ctx, query, homeId, from.UTC(), until.UTC()).Scan(
+		&startsAt,
 		&price.Total,
 		&price.Energy,
 		&price.Tax,
@@ -288,9 +302,8 @@ func (s *PriceService) findLowestPriceFromDB(ctx context.Context, homeId string,
 		return nil, err
 	}
 
-	// Reconstruct the start time from date and hour
-	startTime := time.Date(priceDate.Year(), priceDate.Month(), priceDate.Day(), hourOfDay, 0, 0, 0, priceDate.Location())
-	price.StartTime = startTime.Format(time.RFC3339)
+	price.StartTime = startsAt.In(loc).Format(time.RFC3339)
+	price.EndTime = startsAt.Add(time.Hour).In(loc).Format(time.RFC3339)
 	return &price, nil
 }
 
@@ -308,3 +321,46 @@ func parsePriceEntry(entryData map[string]interface{}) model.Price {
 		Level:     client.GetString(entryData, "level"),
 	}
 }
+
+// HasPrices reports whether every hour in [from, to) has a published price for the home
+func (s *PriceService) HasPrices(ctx context.Context, homeId string, from, to time.Time) (bool, error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM prices
+		WHERE home_id = $1 AND starts_at >= $2 AND starts_at < $3`,
+		homeId, from.UTC(), to.UTC()).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error counting prices: %w", err)
+	}
+	return count >= int(to.Sub(from).Hours()), nil
+}
+
+// PricesBetween returns the stored prices of a home in [from, to), ordered by start
+func (s *PriceService) PricesBetween(ctx context.Context, homeId string, from, to time.Time) ([]model.Price, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT starts_at, total, energy, tax, currency, level
+		FROM prices
+		WHERE home_id = $1 AND starts_at >= $2 AND starts_at < $3
+		ORDER BY starts_at`,
+		homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching prices: %w", err)
+	}
+	defer rows.Close()
+
+	loc := homeLocation(ctx, s.DB, homeId)
+	var prices []model.Price
+	for rows.Next() {
+		var price model.Price
+		var startsAt time.Time
+		var level sql.NullString
+		if err := rows.Scan(&startsAt, &price.Total, &price.Energy, &price.Tax, &price.Currency, &level); err != nil {
+			return nil, err
+		}
+		price.Level = level.String
+		price.StartTime = startsAt.In(loc).Format(time.RFC3339)
+		price.EndTime = startsAt.Add(time.Hour).In(loc).Format(time.RFC3339)
+		prices = append(prices, price)
+	}
+	return prices, rows.Err()
+}
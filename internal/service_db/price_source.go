@@ -0,0 +1,135 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/pricesource"
+)
+
+// PriceSourceService handles the per-home price source configuration
+type PriceSourceService struct {
+	DB *sql.DB
+}
+
+// GetConfig returns the price source configuration of a home.
+// Homes without configuration use Tibber, with the default tariff for when they switch.
+func (s *PriceSourceService) GetConfig(ctx context.Context, homeId string) (*pricesource.MemberConfig, error) {
+	config := &pricesource.MemberConfig{
+		HomeId: homeId,
+		Source: pricesource.SourceTibber,
+		Tariff: pricesource.DefaultTariff(),
+	}
+
+	var biddingZone sql.NullString
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT source, bidding_zone, supplier_markup, energy_tax, vat_rate
+		FROM price_sources
+		WHERE home_id = $1
+	`, homeId).Scan(
+		&config.Source,
+		&biddingZone,
+		&config.Tariff.Markup,
+		&config.Tariff.EnergyTax,
+		&config.Tariff.VatRate,
+	)
+	if err == sql.ErrNoRows {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading price source for home %s: %w", homeId, err)
+	}
+
+	config.BiddingZone = biddingZone.String
+	return config, nil
+}
+
+// SaveConfig stores the price source configuration of a home
+func (s *PriceSourceService) SaveConfig(ctx context.Context, config pricesource.MemberConfig) error {
+	if config.Source != pricesource.SourceTibber && config.Source != pricesource.SourceEntsoe {
+		return fmt.Errorf("unknown price source %q", config.Source)
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO price_sources (home_id, source, bidding_zone, supplier_markup, energy_tax, vat_rate, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (home_id) DO UPDATE SET
+			source = EXCLUDED.source,
+			bidding_zone = EXCLUDED.bidding_zone,
+			supplier_markup = EXCLUDED.supplier_markup,
+			energy_tax = EXCLUDED.energy_tax,
+			vat_rate = EXCLUDED.vat_rate,
+			updated_at = EXCLUDED.updated_at
+	`,
+		config.HomeId,
+		config.Source,
+		config.BiddingZone,
+		config.Tariff.Markup,
+		config.Tariff.EnergyTax,
+		config.Tariff.VatRate,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("error storing price source for home %s: %w", config.HomeId, err)
+	}
+	return nil
+}
+
+// StorePrices writes prices from an alternative source into the prices table
+func (s *PriceSourceService) StorePrices(ctx context.Context, homeId string, source string, prices []model.Price) error {
+	loc := homeLocation(ctx, s.DB, homeId)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO prices (home_id, starts_at, price_date, hour_of_day, total, energy, tax, currency, level, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (home_id, starts_at) DO UPDATE SET
+			total = EXCLUDED.total,
+			energy = EXCLUDED.energy,
+			tax = EXCLUDED.tax,
+			currency = EXCLUDED.currency,
+			level = EXCLUDED.level,
+			source = EXCLUDED.source
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, price := range prices {
+		startTime, err := localtime.ParseTimestamp(price.StartTime)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.ExecContext(ctx,
+			homeId,
+			startTime.UTC(),
+			localtime.Date(startTime, loc),
+			localtime.Hour(startTime, loc),
+			price.Total,
+			price.Energy,
+			price.Tax,
+			price.Currency,
+			price.Level,
+			source,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store price: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
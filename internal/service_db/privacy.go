@@ -0,0 +1,451 @@
+package service_db
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"ws/internal/export"
+	"ws/internal/model"
+)
+
+// PrivacyService handles the rights of members over their data: consent per category, a copy of
+// everything stored about them, erasure when they leave and the log of who viewed their data.
+type PrivacyService struct {
+	DB *sql.DB
+}
+
+// Subject is the member whose data is exported or erased: an account with its shared homes, or
+// a single home
+type Subject struct {
+	AccountID int // 0 voor een los huis
+	HomeIDs   []string
+}
+
+// homeTables are the tables with personal data per home; all have a home_id column. The homes
+// and owners themselves are handled separately.
+var homeTables = []string{
+	"consumption",
+	"production",
+	"prices",
+	"price_sources",
+	"price_forecasts",
+	"real_time_measurements",
+	"baseload_nights",
+	"anomalies",
+	"power_quality_daily",
+	"voltage_events",
+	"curtailment_episodes",
+	"gas_readings",
+	"gas_consumption",
+	"imports",
+	"tibber_account_homes",
+	"data_access_log",
+	"webhook_events",
+}
+
+// pseudonymDrop are the tables that are deleted also when the rest is pseudonymized: the live
+// measurements, and messages, files and logs that say more about the member than the statistics need
+var pseudonymDrop = map[string]bool{
+	"real_time_measurements": true,
+	"anomalies":              true,
+	"imports":                true,
+	"tibber_account_homes":   true,
+	"data_access_log":        true,
+	"webhook_events":         true,
+}
+
+// AccountSubject returns an account with the homes it shares
+func (s *PrivacyService) AccountSubject(ctx context.Context, accountID int) (*Subject, error) {
+	var exists bool
+	if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tibber_accounts WHERE id = $1)`, accountID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error fetching account: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("account %d not found", accountID)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT home_id FROM tibber_account_homes WHERE account_id = $1 ORDER BY home_id`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching homes of account %d: %w", accountID, err)
+	}
+	defer rows.Close()
+	subject := &Subject{AccountID: accountID}
+	for rows.Next() {
+		var homeId string
+		if err := rows.Scan(&homeId); err != nil {
+			return nil, err
+		}
+		subject.HomeIDs = append(subject.HomeIDs, homeId)
+	}
+	return subject, rows.Err()
+}
+
+// SetConsent records that a member gives or withdraws consent for a category. Earlier answers
+// stay as evidence; the latest applies.
+func (s *PrivacyService) SetConsent(ctx context.Context, accountID int, category string, granted bool) error {
+	if !slices.Contains(model.ConsentCategories, category) {
+		return fmt.Errorf("unknown consent category %q", category)
+	}
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO member_consents (account_id, category, granted, version, recorded_at)
+		VALUES ($1, $2, $3, $4, NOW())`, accountID, category, granted, model.ConsentVersion)
+	if err != nil {
+		return fmt.Errorf("error storing consent of account %d: %w", accountID, err)
+	}
+	return nil
+}
+
+// Consents returns the latest consent of an account per category
+func (s *PrivacyService) Consents(ctx context.Context, accountID int) ([]model.Consent, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (category) account_id, category, granted, version, recorded_at
+		FROM member_consents
+		WHERE account_id = $1
+		ORDER BY category, recorded_at DESC, id DESC`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching consents: %w", err)
+	}
+	defer rows.Close()
+	var consents []model.Consent
+	for rows.Next() {
+		var c model.Consent
+		if err := rows.Scan(&c.AccountID, &c.Category, &c.Granted, &c.Version, &c.RecordedAt); err != nil {
+			return nil, err
+		}
+		consents = append(consents, c)
+	}
+	return consents, rows.Err()
+}
+
+// LogAccess stores views of the data of homes in the dashboard
+func (s *PrivacyService) LogAccess(ctx context.Context, entries []model.DataAccess) error {
+	for _, e := range entries {
+		_, err := s.DB.ExecContext(ctx, `
+			INSERT INTO data_access_log (accessed_at, viewer, remote_addr, home_id, resource)
+			VALUES ($1, $2, $3, $4, $5)`,
+			e.AccessedAt, nullString(e.Viewer), e.RemoteAddr, e.HomeID, e.Resource)
+		if err != nil {
+			return fmt.Errorf("error logging access to home %s: %w", e.HomeID, err)
+		}
+	}
+	return nil
+}
+
+// AccessLog returns who viewed the data of a home since a moment, newest first
+func (s *PrivacyService) AccessLog(ctx context.Context, homeId string, since time.Time) ([]model.DataAccess, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT accessed_at, COALESCE(viewer, ''), COALESCE(remote_addr, ''), home_id, resource
+		FROM data_access_log
+		WHERE home_id = $1 AND accessed_at >= $2
+		ORDER BY accessed_at DESC`, homeId, since)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching access log: %w", err)
+	}
+	defer rows.Close()
+	var entries []model.DataAccess
+	for rows.Next() {
+		var e model.DataAccess
+		if err := rows.Scan(&e.AccessedAt, &e.Viewer, &e.RemoteAddr, &e.HomeID, &e.Resource); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// exportReadme explains the archive to the member
+const exportReadme = `Dit archief bevat alle gegevens die de energiegemeenschap over u heeft opgeslagen,
+met een CSV-bestand per tabel. Tijdstippen zijn in UTC (RFC 3339).
+
+account.csv            uw aanmelding, zonder het Tibber-token zelf
+member_consents.csv    elke keer dat u toestemming gaf of introk
+owners.csv, homes.csv  uw gegevens en die van uw huizen zoals Tibber ze doorgeeft
+data_access_log.csv    wie uw gegevens in het dashboard heeft bekeken
+webhook_endpoints.csv  uw webhooks, zonder de geheime sleutel
+overige bestanden      prijzen, verbruik, productie, live metingen en analyses per huis
+`
+
+// archiveFile is a file of the export archive: a query, run once per set of arguments
+type archiveFile struct {
+	name  string
+	query string
+	args  [][]interface{}
+}
+
+// Export writes a ZIP archive with every row stored about the subject, a CSV file per table. It
+// returns the number of rows.
+func (s *PrivacyService) Export(ctx context.Context, subject *Subject, w io.Writer) (int64, error) {
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("LEESMIJ.txt")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(f, exportReadme); err != nil {
+		return 0, err
+	}
+
+	perHome := make([][]interface{}, 0, len(subject.HomeIDs))
+	for _, homeId := range subject.HomeIDs {
+		perHome = append(perHome, []interface{}{homeId})
+	}
+	var perAccount [][]interface{}
+	if subject.AccountID != 0 {
+		perAccount = [][]interface{}{{subject.AccountID}}
+	}
+
+	files := []archiveFile{
+		{"account", `
+			SELECT id, name, email, token_hint, status, last_checked, last_error, share_new_homes, created_at, updated_at
+			FROM tibber_accounts WHERE id = $1`, perAccount},
+		{"member_consents", `SELECT * FROM member_consents WHERE account_id = $1 ORDER BY recorded_at, id`, perAccount},
+		{"api_tokens", `
+			SELECT id, name, home_id, token_hint, created_at, last_used_at, revoked_at
+			FROM api_tokens WHERE account_id = $1 ORDER BY id`, perAccount},
+		{"webhook_endpoints", `
+			SELECT id, url, events, description, active, created_at, updated_at
+			FROM webhook_endpoints WHERE account_id = $1 ORDER BY id`, perAccount},
+		{"owners", `SELECT o.* FROM owners o JOIN homes h ON h.owner_id = o.id WHERE h.id = $1`, perHome},
+		{"homes", `SELECT * FROM homes WHERE id = $1`, perHome},
+	}
+	for _, table := range homeTables {
+		files = append(files, archiveFile{table, fmt.Sprintf(`SELECT * FROM %s WHERE home_id = $1`, table), perHome})
+	}
+
+	var total int64
+	for _, file := range files {
+		n, err := s.exportFile(ctx, zw, file.name+".csv", file.query, file.args)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", file.name, err)
+		}
+		total += n
+	}
+	return total, zw.Close()
+}
+
+// exportFile writes the rows of a query, run once per set of arguments, as a CSV file in the
+// archive. The columns follow the query; no arguments writes no file.
+func (s *PrivacyService) exportFile(ctx context.Context, zw *zip.Writer, name, query string, args [][]interface{}) (int64, error) {
+	var (
+		writer  export.Writer
+		columns []export.Column
+		count   int64
+	)
+	for _, a := range args {
+		rows, err := s.DB.QueryContext(ctx, query, a...)
+		if err != nil {
+			return count, err
+		}
+		if writer == nil {
+			types, err := rows.ColumnTypes()
+			if err != nil {
+				rows.Close()
+				return count, err
+			}
+			for _, t := range types {
+				columns = append(columns, export.Column{Name: t.Name(), Type: columnType(t.DatabaseTypeName())})
+			}
+			f, err := zw.Create(name)
+			if err != nil {
+				rows.Close()
+				return count, err
+			}
+			if writer, err = export.NewWriter(export.FormatCSV, f, columns); err != nil {
+				rows.Close()
+				return count, err
+			}
+		}
+
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return count, err
+			}
+			row := make([]any, len(columns))
+			for i, v := range values {
+				row[i] = exportValue(columns[i].Type, v)
+			}
+			if err := writer.Write(row); err != nil {
+				rows.Close()
+				return count, err
+			}
+			count++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return count, err
+		}
+	}
+	if writer == nil {
+		return 0, nil
+	}
+	return count, writer.Close()
+}
+
+// columnType maps a Postgres column type to an export column type
+func columnType(databaseType string) export.Type {
+	switch databaseType {
+	case "INT2", "INT4", "INT8":
+		return export.Int
+	case "NUMERIC", "FLOAT4", "FLOAT8":
+		return export.Float
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return export.Time
+	case "DATE":
+		return export.Date
+	}
+	return export.String
+}
+
+// exportValue converts a scanned value to the types the export writers accept
+func exportValue(t export.Type, v interface{}) any {
+	switch x := v.(type) {
+	case []byte:
+		if t == export.Float {
+			if f, err := strconv.ParseFloat(string(x), 64); err == nil {
+				return f
+			}
+		}
+		return string(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case float32:
+		return float64(x)
+	case int32:
+		return int64(x)
+	}
+	return v
+}
+
+// Erase removes the data of a member who leaves. With pseudonymize the consumption, production,
+// prices and daily analyses stay for the community statistics under a random home ID, with only
+// the first four digits of the postal code; everything else, such as the owner, the address, the
+// EANs and the live measurements, is deleted either way. An account is deleted with its token
+// and consents. The erasure is recorded without personal data.
+func (s *PrivacyService) Erase(ctx context.Context, subject *Subject, pseudonymize bool) (*model.Erasure, error) {
+	mode := model.ErasureDelete
+	if pseudonymize {
+		mode = model.ErasurePseudonymize
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var total int64
+	exec := func(query string, args ...interface{}) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		total += n
+		return nil
+	}
+
+	for _, homeId := range subject.HomeIDs {
+		// Een account dat nieuwe huizen deelt zou dit huis anders opnieuw ophalen
+		if subject.AccountID == 0 {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE tibber_accounts SET share_new_homes = FALSE, updated_at = NOW()
+				WHERE id IN (SELECT account_id FROM tibber_account_homes WHERE home_id = $1)`, homeId); err != nil {
+				return nil, fmt.Errorf("error updating account of home %s: %w", homeId, err)
+			}
+		}
+
+		var ownerId sql.NullInt64
+		err := tx.QueryRowContext(ctx, `SELECT owner_id FROM homes WHERE id = $1`, homeId).Scan(&ownerId)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error fetching home %s: %w", homeId, err)
+		}
+
+		pseudonym := ""
+		if pseudonymize {
+			if pseudonym, err = newPseudonym(); err != nil {
+				return nil, err
+			}
+			// Eerst het nieuwe huis, zodat de foreign keys van de tabellen blijven kloppen
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO homes (id, type, size, main_fuse_size, number_of_residents, time_zone,
+					postal_code, country, grid_company, grid_area_code, price_area_code, energy_tax_type,
+					vat_type, estimated_annual_consumption, real_time_consumption_enabled)
+				SELECT $2, type, size, main_fuse_size, number_of_residents, time_zone,
+					LEFT(postal_code, 4), country, grid_company, grid_area_code, price_area_code, energy_tax_type,
+					vat_type, estimated_annual_consumption, real_time_consumption_enabled
+				FROM homes WHERE id = $1`, homeId, pseudonym); err != nil {
+				return nil, fmt.Errorf("error pseudonymizing home %s: %w", homeId, err)
+			}
+		}
+
+		for _, table := range homeTables {
+			if pseudonymize && !pseudonymDrop[table] {
+				err = exec(fmt.Sprintf(`UPDATE %s SET home_id = $2 WHERE home_id = $1`, table), homeId, pseudonym)
+			} else {
+				err = exec(fmt.Sprintf(`DELETE FROM %s WHERE home_id = $1`, table), homeId)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error erasing %s of home %s: %w", table, homeId, err)
+			}
+		}
+
+		// Tokens voor alleen dit huis; tokens van het account gaan mee met het account
+		if err := exec(`DELETE FROM api_tokens WHERE home_id = $1`, homeId); err != nil {
+			return nil, fmt.Errorf("error erasing API tokens of home %s: %w", homeId, err)
+		}
+		if err := exec(`DELETE FROM homes WHERE id = $1`, homeId); err != nil {
+			return nil, fmt.Errorf("error erasing home %s: %w", homeId, err)
+		}
+		if ownerId.Valid {
+			if err := exec(`DELETE FROM owners WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM homes WHERE owner_id = $1)`, ownerId.Int64); err != nil {
+				return nil, fmt.Errorf("error erasing owner of home %s: %w", homeId, err)
+			}
+		}
+	}
+
+	if subject.AccountID != 0 {
+		// Toestemmingen en gekoppelde huizen gaan mee via ON DELETE CASCADE
+		if err := exec(`DELETE FROM tibber_accounts WHERE id = $1`, subject.AccountID); err != nil {
+			return nil, fmt.Errorf("error erasing account %d: %w", subject.AccountID, err)
+		}
+	}
+
+	erasure := &model.Erasure{Mode: mode, AccountID: subject.AccountID, Homes: len(subject.HomeIDs), Rows: total}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO privacy_erasures (mode, account_id, homes, rows)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, erased_at`,
+		mode, sql.NullInt64{Int64: int64(subject.AccountID), Valid: subject.AccountID != 0}, erasure.Homes, total,
+	).Scan(&erasure.ID, &erasure.ErasedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error recording erasure: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return erasure, nil
+}
+
+// newPseudonym returns a random home ID that cannot be traced back to the member
+func newPseudonym() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error creating pseudonym: %w", err)
+	}
+	return "anon-" + hex.EncodeToString(b), nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"ws/internal/client"
+	"ws/internal/localtime"
 	"ws/internal/model"
 )
 
@@ -14,10 +15,23 @@ import (
 type ProductionService struct {
 	Client *client.TibberClient
 	DB     *sql.DB
+	// Accounts selects the token of the member that owns a home; nil means Client for every home
+	Accounts *AccountService
 }
 
 // GetProduction fetches production data for a specific home and stores new data in the database
 func (s *ProductionService) GetProduction(ctx context.Context, homeId string, resolution string, lastEntries int) (*model.Home, error) {
+	return s.getProduction(ctx, homeId, resolution, lastEntries, time.Time{}, time.Time{})
+}
+
+// Backfill fetches and stores the production of a home in [from, to). The API counts back from now,
+// so all intervals since from are fetched and only those before to are stored.
+func (s *ProductionService) Backfill(ctx context.Context, homeId string, resolution string, from, to time.Time) (*model.Home, error) {
+	return s.getProduction(ctx, homeId, resolution, BackfillEntries(resolution, from, time.Now()), from, to)
+}
+
+// getProduction fetches the last entries and stores those that start in [from, to); a zero bound is open
+func (s *ProductionService) getProduction(ctx context.Context, homeId string, resolution string, lastEntries int, from, to time.Time) (*model.Home, error) {
 	// Set up variables for the query
 	variables := map[string]interface{}{
 		"homeId":     homeId,
@@ -26,7 +40,11 @@ func (s *ProductionService) GetProduction(ctx context.Context, homeId string, re
 	}
 
 	// Execute the query
-	resp, err := s.Client.QueryAPI(ctx, model.ProductionQuery, variables)
+	c, err := homeClient(ctx, s.Accounts, s.Client, homeId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.QueryAPI(ctx, model.ProductionQuery, variables)
 	if err != nil {
 		return nil, fmt.Errorf("API query failed: %w", err)
 	}
@@ -60,6 +78,9 @@ func (s *ProductionService) GetProduction(ctx context.Context, homeId string, re
 		return nil, fmt.Errorf("no production nodes in response")
 	}
 
+	// Local days are derived in the home's own time zone
+	loc := homeLocation(ctx, s.DB, homeId)
+
 	// Begin a transaction for batch inserts
 	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
@@ -69,9 +90,15 @@ func (s *ProductionService) GetProduction(ctx context.Context, homeId string, re
 
 	// Prepare the insert statement
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO production (home_id, from_date, to_time, production, profit, currency)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (home_id, from_date) DO NOTHING
+		INSERT INTO production (home_id, resolution, from_time, from_date, to_time, production, profit, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (home_id, resolution, from_time) DO UPDATE SET
+			to_time = EXCLUDED.to_time,
+			production = EXCLUDED.production,
+			profit = EXCLUDED.profit,
+			currency = EXCLUDED.currency,
+			source = 'TIBBER',
+			import_id = NULL
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare statement: %w", err)
@@ -98,6 +125,9 @@ func (s *ProductionService) GetProduction(ctx context.Context, homeId string, re
 		if err != nil {
 			continue // Skip invalid times
 		}
+		if (!from.IsZero() && fromTime.Before(from)) || (!to.IsZero() && !fromTime.Before(to)) {
+			continue // Outside the requested period
+		}
 
 		// Create production entry
 		production := model.Production{
@@ -114,11 +144,13 @@ func (s *ProductionService) GetProduction(ctx context.Context, homeId string, re
 		// Add to home's production list
 		home.Production = append(home.Production, production)
 
-		// Store in database - note we now use fromTime.Truncate(24*time.Hour) to get just the date
+		// Store in database as UTC instant plus the local date of the home
 		_, err = stmt.ExecContext(ctx,
 			homeId,
-			fromTime.Truncate(24*time.Hour),
-			toTime,
+			resolution,
+			fromTime.UTC(),
+			localtime.Date(fromTime, loc),
+			toTime.UTC(),
 			production.Production,
 			production.Profit,
 			production.Currency,
@@ -161,13 +193,16 @@ func (s *ProductionService) GetDailySummary(ctx context.Context, homeId string,
 
 // getDailySummaryFromDB retrieves production summary from the database
 func (s *ProductionService) getDailySummaryFromDB(ctx context.Context, homeId string, days int) ([]model.ProductionSummary, error) {
+	loc := homeLocation(ctx, s.DB, homeId)
+
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT from_date, to_time, production, profit, currency
+		SELECT from_time, to_time, production, profit, currency
 		FROM production
 		WHERE home_id = $1
-		AND from_date >= CURRENT_DATE - INTERVAL '1 day' * $2
-		ORDER BY from_date DESC
-	`, homeId, days)
+		AND resolution = 'DAILY'
+		AND from_date >= $2
+		ORDER BY from_time DESC
+	`, homeId, localtime.Date(time.Now().In(loc).AddDate(0, 0, -days), loc))
 	if err != nil {
 		return nil, err
 	}
@@ -176,14 +211,14 @@ func (s *ProductionService) getDailySummaryFromDB(ctx context.Context, homeId st
 	var summaries []model.ProductionSummary
 	for rows.Next() {
 		var summary model.ProductionSummary
-		var fromDate time.Time
+		var fromTime time.Time
 		var toTime time.Time
-		err := rows.Scan(&fromDate, &toTime, &summary.Production, &summary.Profit, &summary.Currency)
+		err := rows.Scan(&fromTime, &toTime, &summary.Production, &summary.Profit, &summary.Currency)
 		if err != nil {
 			return nil, err
 		}
-		summary.From = fromDate.Format(time.RFC3339)
-		summary.To = toTime.Format(time.RFC3339)
+		summary.From = fromTime.In(loc).Format(time.RFC3339)
+		summary.To = toTime.In(loc).Format(time.RFC3339)
 		summaries = append(summaries, summary)
 	}
 
@@ -194,3 +229,16 @@ func (s *ProductionService) getDailySummaryFromDB(ctx context.Context, homeId st
 func (s *ProductionService) HasProduction(home model.Home) bool {
 	return home.MeteringPointData.ProductionEan != ""
 }
+
+// LatestFrom returns the start of the newest production interval from Tibber, or the zero time when there is none
+func (s *ProductionService) LatestFrom(ctx context.Context, homeId string, resolution string) (time.Time, error) {
+	var latest sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT MAX(from_time) FROM production
+		WHERE home_id = $1 AND resolution = $2 AND source = $3`,
+		homeId, resolution, SourceTibber).Scan(&latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error querying latest production: %w", err)
+	}
+	return latest.Time, nil
+}
@@ -96,7 +96,34 @@ func (s *RealTimeService) GetLatestMeasurements(ctx context.Context, homeID stri
 		return nil, fmt.Errorf("error querying latest measurements: %w", err)
 	}
 	defer rows.Close()
+	return scanMeasurements(rows)
+}
+
+// MeasurementsSince returns up to limit measurements of a home after since, oldest first, for
+// following a home that is measured by the collector
+func (s *RealTimeService) MeasurementsSince(ctx context.Context, homeID string, since time.Time, limit int) ([]tibber.Measurement, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT
+			timestamp, power, power_production,
+			min_power, average_power, max_power, max_power_production,
+			accumulated_consumption, accumulated_production,
+			last_meter_consumption, last_meter_production,
+			current_l1, current_l2, current_l3,
+			voltage_phase1, voltage_phase2, voltage_phase3
+		FROM real_time_measurements
+		WHERE home_id = $1 AND timestamp > $2
+		ORDER BY timestamp
+		LIMIT $3
+	`, homeID, since.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying measurements: %w", err)
+	}
+	defer rows.Close()
+	return scanMeasurements(rows)
+}
 
+// scanMeasurements reads the columns of GetLatestMeasurements
+func scanMeasurements(rows *sql.Rows) ([]tibber.Measurement, error) {
 	var measurements []tibber.Measurement
 	for rows.Next() {
 		var m tibber.Measurement
@@ -127,7 +154,7 @@ func (s *RealTimeService) GetLatestMeasurements(ctx context.Context, homeID stri
 		measurements = append(measurements, m)
 	}
 
-	return measurements, nil
+	return measurements, rows.Err()
 }
 
 // CleanupOldMeasurements removes measurements older than the specified duration
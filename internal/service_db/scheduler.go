@@ -0,0 +1,156 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/scheduler"
+)
+
+// SchedulerService stores the status of the collector jobs and implements scheduler.Store
+type SchedulerService struct {
+	DB *sql.DB
+}
+
+// Register adds a job or updates its schedule and next run, keeping its history
+func (s *SchedulerService) Register(ctx context.Context, name, schedule string, next time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO scheduler_jobs (name, schedule, next_run)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET
+			schedule = EXCLUDED.schedule,
+			next_run = EXCLUDED.next_run,
+			updated_at = NOW()`,
+		name, schedule, nullTime(next))
+	if err != nil {
+		return fmt.Errorf("error registering job %s: %w", name, err)
+	}
+	return nil
+}
+
+// Acquire takes the run-lock of a job. A lock older than stale belongs to a collector that
+// stopped during the run and is taken over.
+func (s *SchedulerService) Acquire(ctx context.Context, name, owner string, stale time.Duration) (bool, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE scheduler_jobs SET
+			status = $3,
+			running_by = $2,
+			running_since = NOW(),
+			last_start = NOW(),
+			updated_at = NOW()
+		WHERE name = $1
+			AND (running_since IS NULL OR running_since < NOW() - make_interval(secs => $4))`,
+		name, owner, scheduler.StatusRunning, stale.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("error acquiring job %s: %w", name, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error acquiring job %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// Finish stores the result of a run and releases the run-lock, unless another collector has
+// taken the lock over in the meantime
+func (s *SchedulerService) Finish(ctx context.Context, owner string, status scheduler.Status) error {
+	var lastError sql.NullString
+	if status.LastError != "" {
+		lastError = sql.NullString{String: status.LastError, Valid: true}
+	}
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE scheduler_jobs SET
+			status = $2,
+			running_by = NULL,
+			running_since = NULL,
+			last_end = $3,
+			last_duration = $4,
+			last_error = $5,
+			next_run = $6,
+			runs = runs + 1,
+			failures = failures + CASE WHEN $2 = 'FAILED' THEN 1 ELSE 0 END,
+			updated_at = NOW()
+		WHERE name = $1 AND (running_by IS NULL OR running_by = $7)`,
+		status.Name, status.Status, status.LastEnd, status.LastDuration, lastError, status.NextRun, owner)
+	if err != nil {
+		return fmt.Errorf("error storing job %s: %w", status.Name, err)
+	}
+	return nil
+}
+
+// Triggered returns the manually triggered jobs and clears their trigger
+func (s *SchedulerService) Triggered(ctx context.Context) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE scheduler_jobs SET triggered_at = NULL, updated_at = NOW()
+		WHERE triggered_at IS NOT NULL
+		RETURNING name`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading job triggers: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning job trigger: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Trigger asks the collector to run a job as soon as possible
+func (s *SchedulerService) Trigger(ctx context.Context, name string) error {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE scheduler_jobs SET triggered_at = NOW(), updated_at = NOW()
+		WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("error triggering job %s: %w", name, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", scheduler.ErrUnknownJob, name)
+	}
+	return nil
+}
+
+// Jobs returns the stored status of all jobs
+func (s *SchedulerService) Jobs(ctx context.Context) ([]scheduler.Status, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT name, schedule, status, COALESCE(running_by, ''), last_start, last_end,
+			COALESCE(last_duration, 0), COALESCE(last_error, ''), next_run, runs, failures
+		FROM scheduler_jobs
+		ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []scheduler.Status
+	for rows.Next() {
+		var job scheduler.Status
+		var lastStart, lastEnd, nextRun sql.NullTime
+		if err := rows.Scan(&job.Name, &job.Schedule, &job.Status, &job.RunningBy, &lastStart, &lastEnd,
+			&job.LastDuration, &job.LastError, &nextRun, &job.Runs, &job.Failures); err != nil {
+			return nil, fmt.Errorf("error scanning job: %w", err)
+		}
+		job.LastStart = nullTimePtr(lastStart)
+		job.LastEnd = nullTimePtr(lastEnd)
+		job.NextRun = nullTimePtr(nextRun)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,81 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/forecast"
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// SolarHistoryDays is how much hourly production history the PV model is fitted on
+const SolarHistoryDays = 60
+
+// SolarService forecasts PV production of homes with a production connection
+type SolarService struct {
+	DB      *sql.DB
+	Weather []forecast.Weather // Optioneel: instraling of bewolking per uur
+}
+
+// Model fits the PV model of a home on its recent hourly production
+func (s *SolarService) Model(ctx context.Context, home model.Home) (*forecast.SolarModel, error) {
+	if home.MeteringPointData.ProductionEan == "" {
+		return nil, fmt.Errorf("home %s has no production connection", home.Id)
+	}
+
+	lat, lon, err := forecast.ParseCoordinates(home.Address.Latitude, home.Address.Longitude)
+	if err != nil {
+		return nil, fmt.Errorf("home %s: %w", home.Id, err)
+	}
+
+	now := time.Now()
+	history, err := s.HourlyProduction(ctx, home.Id, now.AddDate(0, 0, -SolarHistoryDays), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return forecast.FitSolar(history, lat, lon, localtime.Location(home.TimeZone))
+}
+
+// Forecast returns the expected hourly production of a home for the local day of t
+func (s *SolarService) Forecast(ctx context.Context, home model.Home, t time.Time) ([]forecast.Point, error) {
+	m, err := s.Model(ctx, home)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := localtime.Location(home.TimeZone)
+	from := localtime.StartOfDay(t, loc)
+	return m.Predict(from, localtime.HoursInDay(from, loc), s.Weather), nil
+}
+
+// HourlyProduction loads the hourly production of a home in [from, to)
+func (s *SolarService) HourlyProduction(ctx context.Context, homeId string, from, to time.Time) ([]forecast.Point, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT from_time, production
+		FROM production
+		WHERE home_id = $1
+		AND resolution = 'HOURLY'
+		AND production IS NOT NULL
+		AND from_time >= $2
+		AND from_time < $3
+		ORDER BY from_time
+	`, homeId, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying hourly production: %w", err)
+	}
+	defer rows.Close()
+
+	var points []forecast.Point
+	for rows.Next() {
+		var p forecast.Point
+		if err := rows.Scan(&p.Start, &p.Value); err != nil {
+			return nil, fmt.Errorf("error scanning hourly production: %w", err)
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,119 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"ws/internal/carbon"
+	"ws/internal/localtime"
+	"ws/internal/model"
+)
+
+// Statement is the monthly overview of a member: energy, money and CO2,
+// next to the totals of the whole community
+type Statement struct {
+	HomeId      string           `json:"homeId"`
+	Month       string           `json:"month"` // YYYY-MM
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Consumption float64          `json:"consumption"` // kWh
+	Cost        float64          `json:"cost"`
+	Production  float64          `json:"production"` // kWh
+	Profit      float64          `json:"profit"`
+	NetCost     float64          `json:"netCost"`
+	Carbon      carbon.Footprint `json:"carbon"`
+	Community   carbon.Footprint `json:"community"`
+}
+
+// StatementService builds monthly member statements
+type StatementService struct {
+	DB     *sql.DB
+	Carbon *CarbonService
+}
+
+// Monthly returns the statement of a home for the local month of t.
+// community are the homes whose carbon account is added up for comparison.
+func (s *StatementService) Monthly(ctx context.Context, home model.Home, t time.Time, community []model.Home) (*Statement, error) {
+	loc := localtime.Location(home.TimeZone)
+	local := t.In(loc)
+	from := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 1, 0)
+
+	statement := &Statement{
+		HomeId: home.Id,
+		Month:  from.Format("2006-01"),
+		From:   from,
+		To:     to,
+	}
+
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE((SELECT SUM(consumption) FROM consumption
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3), 0),
+			COALESCE((SELECT SUM(cost) FROM consumption
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3), 0),
+			COALESCE((SELECT SUM(production) FROM production
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3), 0),
+			COALESCE((SELECT SUM(profit) FROM production
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3), 0)
+	`, home.Id, from.UTC(), to.UTC()).Scan(&statement.Consumption, &statement.Cost, &statement.Production, &statement.Profit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying monthly totals: %w", err)
+	}
+	statement.NetCost = math.Round((statement.Cost-statement.Profit)*100) / 100
+
+	if s.Carbon != nil {
+		if statement.Carbon, err = s.Carbon.Footprint(ctx, home, from, to); err != nil {
+			return nil, err
+		}
+		if statement.Community, err = s.Carbon.Community(ctx, community, from, to); err != nil {
+			return nil, err
+		}
+	}
+
+	return statement, nil
+}
+
+// Settlement is the cost and revenue of a home over one local day
+type Settlement struct {
+	HomeId      string  `json:"homeId"`
+	Date        string  `json:"date"`        // YYYY-MM-DD
+	Consumption float64 `json:"consumption"` // kWh
+	Cost        float64 `json:"cost"`
+	Production  float64 `json:"production"` // kWh
+	Profit      float64 `json:"profit"`
+	NetCost     float64 `json:"netCost"`
+	Currency    string  `json:"currency,omitempty"`
+}
+
+// Daily returns the settlement of a home for the local day of t. It returns sql.ErrNoRows while
+// the daily consumption of that day has not been stored yet.
+func (s *StatementService) Daily(ctx context.Context, home model.Home, t time.Time) (*Settlement, error) {
+	loc := localtime.Location(home.TimeZone)
+	from := localtime.StartOfDay(t, loc)
+	to := localtime.NextDay(from, loc)
+
+	settlement := &Settlement{HomeId: home.Id, Date: from.Format("2006-01-02")}
+	var currency sql.NullString
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT c.consumption, COALESCE(c.cost, 0), c.currency,
+			COALESCE(p.production, 0), COALESCE(p.profit, 0)
+		FROM consumption c
+		LEFT JOIN production p ON p.home_id = c.home_id AND p.resolution = c.resolution AND p.from_time = c.from_time
+		WHERE c.home_id = $1 AND c.resolution = 'DAILY' AND c.from_time >= $2 AND c.from_time < $3
+			AND c.consumption IS NOT NULL
+		LIMIT 1
+	`, home.Id, from.UTC(), to.UTC()).Scan(&settlement.Consumption, &settlement.Cost, &currency, &settlement.Production, &settlement.Profit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying daily settlement: %w", err)
+	}
+	settlement.Currency = currency.String
+	settlement.NetCost = math.Round((settlement.Cost-settlement.Profit)*100) / 100
+	return settlement, nil
+}
@@ -0,0 +1,148 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ws/internal/localtime"
+	"ws/internal/model"
+	"ws/internal/tariff"
+)
+
+// TariffService recomputes historical costs of homes under other contracts
+type TariffService struct {
+	DB *sql.DB
+}
+
+// Intervals loads the metered intervals of a home in [from, to).
+// Hourly data is used when available, otherwise daily data with the average price of the day.
+func (s *TariffService) Intervals(ctx context.Context, homeId string, from, to time.Time) ([]tariff.Interval, error) {
+	resolution := "DAILY"
+	var hourly int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM consumption
+		WHERE home_id = $1 AND resolution = 'HOURLY' AND from_time >= $2 AND from_time < $3
+	`, homeId, from.UTC(), to.UTC()).Scan(&hourly)
+	if err != nil {
+		return nil, fmt.Errorf("error counting hourly consumption: %w", err)
+	}
+	if hourly > 0 {
+		resolution = "HOURLY"
+	}
+
+	// Prijzen in de database zijn all-in; voor de spotprijs halen we BTW en opslag van de bron eraf
+	sources := &PriceSourceService{DB: s.DB}
+	config, err := sources.GetConfig(ctx, homeId)
+	if err != nil {
+		return nil, err
+	}
+	vat := 1 + config.Tariff.VatRate
+	if config.Tariff.VatRate == 0 {
+		vat = 1.21
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT
+			c.from_time,
+			c.to_time,
+			c.consumption,
+			COALESCE(p.production, 0),
+			(
+				SELECT AVG(pr.energy) FROM prices pr
+				WHERE pr.home_id = c.home_id
+				AND pr.starts_at >= c.from_time
+				AND pr.starts_at < c.to_time
+			)
+		FROM consumption c
+		LEFT JOIN production p ON p.home_id = c.home_id
+			AND p.resolution = c.resolution
+			AND p.from_time = c.from_time
+		WHERE c.home_id = $1
+		AND c.resolution = $2
+		AND c.from_time >= $3
+		AND c.from_time < $4
+		ORDER BY c.from_time
+	`, homeId, resolution, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying intervals: %w", err)
+	}
+	defer rows.Close()
+
+	var intervals []tariff.Interval
+	for rows.Next() {
+		var iv tariff.Interval
+		var energy sql.NullFloat64
+		if err := rows.Scan(&iv.Start, &iv.End, &iv.Import, &iv.Export, &energy); err != nil {
+			return nil, fmt.Errorf("error scanning interval: %w", err)
+		}
+		if energy.Valid {
+			iv.SpotPrice = energy.Float64/vat - config.Tariff.Markup
+			iv.HasSpot = true
+		}
+		intervals = append(intervals, iv)
+	}
+
+	return intervals, rows.Err()
+}
+
+// Compare calculates the given contracts over the intervals of a home in [from, to)
+func (s *TariffService) Compare(ctx context.Context, home model.Home, contracts []tariff.Contract, from, to time.Time, nettingShare float64) ([]tariff.Result, error) {
+	intervals, err := s.Intervals(ctx, home.Id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(intervals) == 0 {
+		return nil, fmt.Errorf("no consumption data for home %s in this period", home.Id)
+	}
+
+	return tariff.Compare(contracts, intervals, tariff.Options{
+		Location:     localtime.Location(home.TimeZone),
+		GridCompany:  home.MeteringPointData.GridCompany,
+		NettingShare: nettingShare,
+	})
+}
+
+// ActualCost returns what Tibber reported as net cost (consumption cost minus production profit) in [from, to)
+func (s *TariffService) ActualCost(ctx context.Context, homeId string, from, to time.Time) (float64, error) {
+	var cost float64
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE((
+				SELECT SUM(cost) FROM consumption
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3
+			), 0) - COALESCE((
+				SELECT SUM(profit) FROM production
+				WHERE home_id = $1 AND resolution = 'DAILY' AND from_time >= $2 AND from_time < $3
+			), 0)
+	`, homeId, from.UTC(), to.UTC()).Scan(&cost)
+	if err != nil {
+		return 0, fmt.Errorf("error querying actual cost: %w", err)
+	}
+	return cost, nil
+}
+
+// NetMetering calculates the phase-out scenarios of the salderingsregeling for one calendar year of a home
+func (s *TariffService) NetMetering(ctx context.Context, home model.Home, contract tariff.Contract, year int, scenarios []tariff.Scenario) (*tariff.NetMeteringReport, error) {
+	loc := localtime.Location(home.TimeZone)
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(1, 0, 0)
+
+	intervals, err := s.Intervals(ctx, home.Id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(intervals) == 0 {
+		return nil, fmt.Errorf("no consumption data for home %s in %d", home.Id, year)
+	}
+
+	reports, err := tariff.NetMetering(contract, intervals, tariff.Options{
+		Location:    loc,
+		GridCompany: home.MeteringPointData.GridCompany,
+	}, scenarios)
+	if err != nil {
+		return nil, err
+	}
+	return &reports[0], nil
+}
@@ -0,0 +1,424 @@
+package service_db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ws/internal/model"
+	"ws/internal/secret"
+	"ws/internal/webhook"
+)
+
+// ErrNoWebhookKey is returned when an endpoint is added or delivered to without tibber.accounts_key
+var ErrNoWebhookKey = errors.New("tibber.accounts_key is not set, webhook secrets cannot be sealed or opened")
+
+// WebhookService stores the webhook endpoints and queues the deliveries of events in Postgres. The
+// collector delivers them, see collector.DeliverWebhooks. The secrets of the endpoints are sealed
+// with Box, like the tokens of members.
+type WebhookService struct {
+	DB  *sql.DB
+	Box *secret.Box // Nil zonder tibber.accounts_key
+}
+
+// endpointContext binds a sealed secret to its endpoint
+func endpointContext(id int) string {
+	return "webhook_endpoints:" + strconv.Itoa(id)
+}
+
+// AddEndpoint registers an endpoint for an account, or for the operator with account 0, and returns
+// the secret for the signatures; it is shown only once. Endpoints of members must use https.
+func (s *WebhookService) AddEndpoint(ctx context.Context, accountID int, url string, events []string, description string) (*model.WebhookEndpoint, string, error) {
+	url = strings.TrimSpace(url)
+	if err := webhook.CheckURL(url, accountID != 0); err != nil {
+		return nil, "", err
+	}
+	if len(events) == 0 {
+		return nil, "", fmt.Errorf("an endpoint needs at least one event, one of %s", strings.Join(webhook.EventTypes, ", "))
+	}
+	for _, e := range events {
+		if !webhook.ValidEventType(e) {
+			return nil, "", fmt.Errorf("unknown event %q, expected one of %s", e, strings.Join(webhook.EventTypes, ", "))
+		}
+	}
+	if s.Box == nil {
+		return nil, "", ErrNoWebhookKey
+	}
+	key, err := webhook.NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Het ID is nodig om de sleutel aan het endpoint te binden, dus eerst de rij aanmaken
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	endpoint := &model.WebhookEndpoint{AccountID: accountID, URL: url, Events: events, Description: description, Active: true}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO webhook_endpoints (account_id, url, secret, events, description)
+		VALUES ($1, $2, '', $3, $4)
+		RETURNING id, created_at`,
+		sql.NullInt64{Int64: int64(accountID), Valid: accountID != 0}, url, strings.Join(events, ","), nullString(description),
+	).Scan(&endpoint.ID, &endpoint.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("error storing webhook endpoint: %w", err)
+	}
+	sealed, err := s.Box.Seal(key, endpointContext(endpoint.ID))
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE webhook_endpoints SET secret = $1 WHERE id = $2`, sealed, endpoint.ID); err != nil {
+		return nil, "", fmt.Errorf("error storing webhook secret: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return endpoint, key, nil
+}
+
+// SealSecrets seals the secrets that endpoints from before the encryption still have in plain
+// text, and returns how many it sealed. Without Box it does nothing; their deliveries then fail
+// with ErrNoWebhookKey.
+func (s *WebhookService) SealSecrets(ctx context.Context) (int, error) {
+	if s.Box == nil {
+		return 0, nil
+	}
+	// Versleutelde waarden beginnen met de versie van secret.Box
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, secret FROM webhook_endpoints WHERE secret NOT LIKE 'v1:%'`)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching webhook secrets: %w", err)
+	}
+	plain := map[int]string{}
+	for rows.Next() {
+		var id int
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain[id] = key
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, key := range plain {
+		sealed, err := s.Box.Seal(key, endpointContext(id))
+		if err != nil {
+			return 0, err
+		}
+		// Alleen als de sleutel intussen niet is veranderd
+		if _, err := s.DB.ExecContext(ctx, `
+			UPDATE webhook_endpoints SET secret = $1 WHERE id = $2 AND secret = $3`, sealed, id, key); err != nil {
+			return 0, fmt.Errorf("error sealing webhook secret of endpoint %d: %w", id, err)
+		}
+	}
+	return len(plain), nil
+}
+
+// Endpoints returns the endpoints of an account, or all endpoints for account 0
+func (s *WebhookService) Endpoints(ctx context.Context, accountID int) ([]model.WebhookEndpoint, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, COALESCE(account_id, 0), url, events, COALESCE(description, ''), active, created_at
+		FROM webhook_endpoints
+		WHERE $1 = 0 OR account_id = $1
+		ORDER BY id`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+	var endpoints []model.WebhookEndpoint
+	for rows.Next() {
+		var e model.WebhookEndpoint
+		var events string
+		if err := rows.Scan(&e.ID, &e.AccountID, &e.URL, &events, &e.Description, &e.Active, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Events = strings.Split(events, ",")
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// RemoveEndpoint deletes an endpoint with its deliveries. A member (account not 0) can only remove
+// its own endpoints.
+func (s *WebhookService) RemoveEndpoint(ctx context.Context, id, accountID int) error {
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM webhook_endpoints WHERE id = $1 AND ($2 = 0 OR account_id = $2)`, id, accountID)
+	if err != nil {
+		return fmt.Errorf("error removing webhook endpoint: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook endpoint %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// SetActive pauses or resumes an endpoint; a paused endpoint gets no new deliveries
+func (s *WebhookService) SetActive(ctx context.Context, id int, active bool) error {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE webhook_endpoints SET active = $2, updated_at = NOW() WHERE id = $1`, id, active)
+	if err != nil {
+		return fmt.Errorf("error updating webhook endpoint: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook endpoint %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// Emit stores an event and queues a delivery for every active endpoint that subscribed to its type
+// and may see its home. The key makes an event unique: emitting the same key again does nothing,
+// so jobs can emit on every run. It returns the number of queued deliveries.
+func (s *WebhookService) Emit(ctx context.Context, eventType, key, homeID string, data interface{}) (int, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding %s event: %w", eventType, err)
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var eventID int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO webhook_events (event_key, type, home_id, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_key) DO NOTHING
+		RETURNING id`, key, eventType, nullString(homeID), string(payload)).Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error storing %s event: %w", eventType, err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, next_attempt_at)
+		SELECT e.id, $1::BIGINT, NOW()
+		FROM webhook_endpoints e
+		WHERE e.active AND $2 = ANY(string_to_array(e.events, ','))
+			AND (e.account_id IS NULL OR EXISTS (
+				SELECT 1 FROM tibber_account_homes ah WHERE ah.account_id = e.account_id AND ah.home_id = $3))`,
+		eventID, eventType, homeID)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing %s deliveries: %w", eventType, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// Ping queues a test event for one endpoint, also when it is paused. A member (account not 0) can
+// only test its own endpoints.
+func (s *WebhookService) Ping(ctx context.Context, endpointID, accountID int) error {
+	var exists bool
+	if err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM webhook_endpoints WHERE id = $1 AND ($2 = 0 OR account_id = $2))`,
+		endpointID, accountID).Scan(&exists); err != nil {
+		return fmt.Errorf("error fetching webhook endpoint: %w", err)
+	} else if !exists {
+		return fmt.Errorf("webhook endpoint %d: %w", endpointID, sql.ErrNoRows)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Elke ping is een nieuwe gebeurtenis
+	key := fmt.Sprintf("%s:%d:%d", webhook.EventPing, endpointID, time.Now().UnixNano())
+	var eventID int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO webhook_events (event_key, type, payload) VALUES ($1, $2, '{}')
+		RETURNING id`, key, webhook.EventPing).Scan(&eventID)
+	if err != nil {
+		return fmt.Errorf("error storing ping: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, next_attempt_at) VALUES ($1, $2, NOW())`,
+		endpointID, eventID); err != nil {
+		return fmt.Errorf("error queueing ping: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DueDelivery is a claimed delivery with what is needed to send it
+type DueDelivery struct {
+	ID         int64
+	Attempts   int // Eerdere pogingen
+	URL        string
+	Secret     string // Versleuteld, zie WebhookService.OpenSecret
+	AccountID  int    // 0 voor een endpoint van de beheerder
+	EndpointID int
+	Event      webhook.Event
+}
+
+// Claim takes up to limit deliveries that are due. They are leased until lease has passed, so
+// that a collector that stops halfway does not lose them and two collectors do not send them twice.
+func (s *WebhookService) Claim(ctx context.Context, limit int, lease time.Duration) ([]DueDelivery, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $3)
+		FROM due, webhook_endpoints e, webhook_events ev
+		WHERE d.id = due.id AND e.id = d.endpoint_id AND ev.id = d.event_id
+		RETURNING d.id, d.attempts, e.url, e.secret, COALESCE(e.account_id, 0), e.id,
+			ev.id, ev.type, COALESCE(ev.home_id, ''), ev.created_at, ev.payload`,
+		model.DeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+	var due []DueDelivery
+	for rows.Next() {
+		var d DueDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.Attempts, &d.URL, &d.Secret, &d.AccountID, &d.EndpointID,
+			&d.Event.ID, &d.Event.Type, &d.Event.HomeID, &d.Event.CreatedAt, &payload); err != nil {
+			return nil, err
+		}
+		d.Event.Data = payload
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// OpenSecret decrypts the secret of the endpoint of a claimed delivery, to sign it
+func (s *WebhookService) OpenSecret(d DueDelivery) (string, error) {
+	if s.Box == nil {
+		return "", ErrNoWebhookKey
+	}
+	key, err := s.Box.Open(d.Secret, endpointContext(d.EndpointID))
+	if err != nil {
+		return "", fmt.Errorf("webhook endpoint %d: %w", d.EndpointID, err)
+	}
+	return key, nil
+}
+
+// Record stores the outcome of an attempt: delivered, another attempt after webhook.Backoff, or
+// failed after webhook.MaxAttempts
+func (s *WebhookService) Record(ctx context.Context, d DueDelivery, result webhook.Result) error {
+	attempts := d.Attempts + 1
+	status, next := model.DeliveryPending, sql.NullTime{Time: time.Now().Add(webhook.Backoff(attempts)), Valid: true}
+	var lastError sql.NullString
+	if result.Err == nil {
+		status, next = model.DeliveryDelivered, sql.NullTime{}
+	} else {
+		lastError = sql.NullString{String: truncate(result.Err.Error(), 500), Valid: true}
+		if attempts >= webhook.MaxAttempts {
+			status, next = model.DeliveryFailed, sql.NullTime{}
+		}
+	}
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = NOW(),
+			response_status = $5, last_error = $6,
+			delivered_at = CASE WHEN $2 = 'DELIVERED' THEN NOW() END
+		WHERE id = $1`,
+		d.ID, status, attempts, next, sql.NullInt64{Int64: int64(result.Status), Valid: result.Status != 0}, lastError)
+	if err != nil {
+		return fmt.Errorf("error recording webhook delivery %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+// truncate shortens a string to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// DeliveryFilter selects deliveries for the delivery log
+type DeliveryFilter struct {
+	AccountID  int    // Alleen endpoints van dit account; 0 voor alle
+	EndpointID int    // 0 voor alle endpoints
+	Status     string // Leeg voor alle statussen
+	Limit      int
+}
+
+// Deliveries returns the newest deliveries that match a filter
+func (s *WebhookService) Deliveries(ctx context.Context, f DeliveryFilter) ([]model.WebhookDelivery, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT d.id, d.endpoint_id, e.url, d.event_id, ev.type, COALESCE(ev.home_id, ''), d.status,
+			d.attempts, d.next_attempt_at, d.last_attempt_at, COALESCE(d.response_status, 0),
+			COALESCE(d.last_error, ''), COALESCE(d.replay_of, 0), d.created_at, d.delivered_at
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		JOIN webhook_events ev ON ev.id = d.event_id
+		WHERE ($1 = 0 OR e.account_id = $1) AND ($2 = 0 OR d.endpoint_id = $2) AND ($3 = '' OR d.status = $3)
+		ORDER BY d.id DESC
+		LIMIT $4`, f.AccountID, f.EndpointID, f.Status, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var d model.WebhookDelivery
+		var next, last, delivered sql.NullTime
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.URL, &d.EventID, &d.EventType, &d.HomeID, &d.Status,
+			&d.Attempts, &next, &last, &d.ResponseStatus, &d.LastError, &d.ReplayOf, &d.CreatedAt, &delivered); err != nil {
+			return nil, err
+		}
+		// Een geclaimde aflevering heeft een lease als volgende poging; alleen wachtende tonen er een
+		if next.Valid && d.Status == model.DeliveryPending {
+			d.NextAttemptAt = &next.Time
+		}
+		if last.Valid {
+			d.LastAttemptAt = &last.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Replay queues a delivery again as a new delivery with the same event, whatever the outcome of
+// the original. A member (account not 0) can only replay deliveries of its own endpoints.
+func (s *WebhookService) Replay(ctx context.Context, deliveryID int64, accountID int) (int64, error) {
+	var id int64
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, next_attempt_at, replay_of)
+		SELECT d.endpoint_id, d.event_id, NOW(), d.id
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.id = $1 AND ($2 = 0 OR e.account_id = $2)
+		RETURNING id`, deliveryID, accountID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("webhook delivery %d: %w", deliveryID, err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error replaying webhook delivery %d: %w", deliveryID, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,95 @@
+package service_db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ws/internal/secret"
+)
+
+func testBox(t *testing.T) *secret.Box {
+	t.Helper()
+	key := make([]byte, secret.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	box, err := secret.NewBox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestAddEndpointSealsSecret(t *testing.T) {
+	db, rec := openRecordingDB(t, map[string]driver.Value{
+		"INSERT INTO webhook_endpoints": row{int64(5), time.Now()},
+	})
+	svc := &WebhookService{DB: db, Box: testBox(t)}
+
+	endpoint, key, err := svc.AddEndpoint(context.Background(), 0, "https://example.org/hook", []string{"prices.tomorrow"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.ID != 5 || !strings.HasPrefix(key, "whsec_") {
+		t.Fatalf("endpoint %d with secret %q", endpoint.ID, key)
+	}
+
+	// Alleen de versleutelde sleutel komt in de database
+	updates := rec.updated("webhook_endpoints")
+	if len(updates) != 1 || updates[0][1] != int64(5) {
+		t.Fatalf("updates = %v", updates)
+	}
+	sealed, _ := updates[0][0].(string)
+	if !strings.HasPrefix(sealed, "v1:") || strings.Contains(sealed, strings.TrimPrefix(key, "whsec_")) {
+		t.Fatalf("stored secret %q is not sealed", sealed)
+	}
+
+	opened, err := svc.OpenSecret(DueDelivery{Secret: sealed, EndpointID: 5})
+	if err != nil || opened != key {
+		t.Errorf("OpenSecret = %q, %v; want %q", opened, err, key)
+	}
+	// Gebonden aan het endpoint: overgezet naar een ander endpoint gaat hij niet open
+	if _, err := svc.OpenSecret(DueDelivery{Secret: sealed, EndpointID: 6}); !errors.Is(err, secret.ErrDecrypt) {
+		t.Errorf("secret of endpoint 5 opened for endpoint 6: %v", err)
+	}
+}
+
+func TestAddEndpointWithoutKey(t *testing.T) {
+	db, _ := openRecordingDB(t, nil)
+	svc := &WebhookService{DB: db}
+	if _, _, err := svc.AddEndpoint(context.Background(), 0, "https://example.org/hook", []string{"prices.tomorrow"}, ""); err != ErrNoWebhookKey {
+		t.Errorf("AddEndpoint without a key: %v", err)
+	}
+	if _, err := svc.OpenSecret(DueDelivery{Secret: "whsec_0123", EndpointID: 1}); err != ErrNoWebhookKey {
+		t.Errorf("OpenSecret without a key: %v", err)
+	}
+	if n, err := svc.SealSecrets(context.Background()); n != 0 || err != nil {
+		t.Errorf("SealSecrets without a key = %d, %v", n, err)
+	}
+}
+
+func TestSealSecrets(t *testing.T) {
+	db, rec := openRecordingDB(t, map[string]driver.Value{
+		"SELECT id, secret FROM webhook_endpoints": row{int64(3), "whsec_0123456789"},
+	})
+	svc := &WebhookService{DB: db, Box: testBox(t)}
+
+	n, err := svc.SealSecrets(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("SealSecrets = %d, %v", n, err)
+	}
+	updates := rec.updated("webhook_endpoints")
+	if len(updates) != 1 || updates[0][1] != int64(3) || updates[0][2] != "whsec_0123456789" {
+		t.Fatalf("updates = %v", updates)
+	}
+	sealed, _ := updates[0][0].(string)
+	opened, err := svc.OpenSecret(DueDelivery{Secret: sealed, EndpointID: 3})
+	if err != nil || opened != "whsec_0123456789" {
+		t.Errorf("OpenSecret = %q, %v", opened, err)
+	}
+}
@@ -0,0 +1,222 @@
+package tariff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contracttypes
+const (
+	TypeFixed    = "FIXED"
+	TypeVariable = "VARIABLE"
+	TypeDynamic  = "DYNAMIC"
+)
+
+// Contract describes an energy contract declaratively.
+// All amounts are in EUR excluding VAT; energy prices per kWh, charges per day.
+type Contract struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// FIXED: enkeltarief, of normaal- en daltarief met een daltijdvenster
+	Single      float64     `json:"single,omitempty"`
+	Day         float64     `json:"day,omitempty"`
+	Night       float64     `json:"night,omitempty"`
+	NightWindow *TimeWindow `json:"nightWindow,omitempty"`
+
+	// VARIABLE: tarief per maand ("2025-01"), met Single als terugval
+	Monthly map[string]float64 `json:"monthly,omitempty"`
+
+	// DYNAMIC: spotprijs per uur plus opslag
+	Markup float64 `json:"markup,omitempty"`
+
+	FeedIn       FeedIn             `json:"feedIn"`
+	DailyCharges float64            `json:"dailyCharges"`       // Vaste leveringskosten
+	GridFees     map[string]float64 `json:"gridFees,omitempty"` // Netbeheerkosten per dag per netbeheerder, "*" als standaard
+	EnergyTax    EnergyTax          `json:"energyTax"`
+	VatRate      float64            `json:"vatRate"`
+}
+
+// TimeWindow is a local clock window such as 23:00-07:00 for the night tariff
+type TimeWindow struct {
+	Start    string `json:"start"` // "23:00"
+	End      string `json:"end"`   // "07:00"
+	Weekends bool   `json:"weekends"`
+}
+
+// FeedIn describes the compensation for electricity returned to the grid
+type FeedIn struct {
+	Rate      float64 `json:"rate,omitempty"`      // Vaste terugleververgoeding per kWh
+	SpotBased bool    `json:"spotBased,omitempty"` // Vergoeding is spotprijs plus Markup
+	Markup    float64 `json:"markup,omitempty"`
+	Fee       float64 `json:"fee,omitempty"` // Terugleverkosten per teruggeleverde kWh
+}
+
+// EnergyTax describes the yearly energy tax brackets and the tax credit
+type EnergyTax struct {
+	Brackets  []TaxBracket `json:"brackets"`
+	TaxCredit float64      `json:"taxCredit"` // Vermindering energiebelasting per jaar
+}
+
+// TaxBracket is the rate up to a yearly cumulative consumption; UpTo 0 means unlimited
+type TaxBracket struct {
+	UpTo float64 `json:"upTo"`
+	Rate float64 `json:"rate"`
+}
+
+// Validate checks whether the contract is complete enough to calculate with
+func (c Contract) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("contract without name")
+	}
+
+	switch c.Type {
+	case TypeFixed:
+		if c.Single == 0 && (c.Day == 0 || c.Night == 0) {
+			return fmt.Errorf("contract %s: fixed contract needs a single or a day and night tariff", c.Name)
+		}
+		if c.Day != 0 && c.NightWindow == nil {
+			return fmt.Errorf("contract %s: double tariff needs a night window", c.Name)
+		}
+	case TypeVariable:
+		if len(c.Monthly) == 0 && c.Single == 0 {
+			return fmt.Errorf("contract %s: variable contract needs monthly tariffs", c.Name)
+		}
+	case TypeDynamic:
+	default:
+		return fmt.Errorf("contract %s: unknown type %q", c.Name, c.Type)
+	}
+
+	if c.NightWindow != nil {
+		if _, err := parseClock(c.NightWindow.Start); err != nil {
+			return fmt.Errorf("contract %s: %w", c.Name, err)
+		}
+		if _, err := parseClock(c.NightWindow.End); err != nil {
+			return fmt.Errorf("contract %s: %w", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadContracts reads a JSON file with a list of contracts
+func LoadContracts(path string) ([]Contract, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading contracts: %w", err)
+	}
+
+	var contracts []Contract
+	if err := json.Unmarshal(data, &contracts); err != nil {
+		return nil, fmt.Errorf("error parsing contracts: %w", err)
+	}
+
+	for _, c := range contracts {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return contracts, nil
+}
+
+// DutchEnergyTax2025 returns the 2025 Dutch energy tax brackets and tax credit
+func DutchEnergyTax2025() EnergyTax {
+	return EnergyTax{
+		Brackets: []TaxBracket{
+			{UpTo: 10000, Rate: 0.10154},
+			{UpTo: 50000, Rate: 0.06937},
+			{UpTo: 10000000, Rate: 0.03868},
+			{UpTo: 0, Rate: 0.00321},
+		},
+		TaxCredit: 524.95,
+	}
+}
+
+// DefaultContracts returns a set of typical Dutch contracts for comparison
+func DefaultContracts() []Contract {
+	gridFees := map[string]float64{"*": 0.90}
+
+	return []Contract{
+		{
+			Name:         "Vast enkeltarief",
+			Type:         TypeFixed,
+			Single:       0.115,
+			FeedIn:       FeedIn{Rate: 0.07},
+			DailyCharges: 0.20,
+			GridFees:     gridFees,
+			EnergyTax:    DutchEnergyTax2025(),
+			VatRate:      0.21,
+		},
+		{
+			Name:         "Vast dubbeltarief",
+			Type:         TypeFixed,
+			Day:          0.125,
+			Night:        0.105,
+			NightWindow:  &TimeWindow{Start: "23:00", End: "07:00", Weekends: true},
+			FeedIn:       FeedIn{Rate: 0.07},
+			DailyCharges: 0.20,
+			GridFees:     gridFees,
+			EnergyTax:    DutchEnergyTax2025(),
+			VatRate:      0.21,
+		},
+		{
+			Name:         "Variabel",
+			Type:         TypeVariable,
+			Single:       0.12,
+			FeedIn:       FeedIn{Rate: 0.06},
+			DailyCharges: 0.20,
+			GridFees:     gridFees,
+			EnergyTax:    DutchEnergyTax2025(),
+			VatRate:      0.21,
+		},
+		{
+			Name:         "Dynamisch",
+			Type:         TypeDynamic,
+			Markup:       0.0165,
+			FeedIn:       FeedIn{SpotBased: true, Markup: -0.0165},
+			DailyCharges: 0.165,
+			GridFees:     gridFees,
+			EnergyTax:    DutchEnergyTax2025(),
+			VatRate:      0.21,
+		},
+	}
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid clock time %q", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid clock time %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid clock time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether local time t falls in the window
+func (w TimeWindow) contains(t time.Time) bool {
+	if w.Weekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return true
+	}
+
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	minute := t.Hour()*60 + t.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// Venster over middernacht, bijvoorbeeld 23:00-07:00
+	return minute >= start || minute < end
+}
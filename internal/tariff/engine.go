@@ -0,0 +1,263 @@
+package tariff
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Interval is a metered period of a home with its wholesale price
+type Interval struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Import    float64   `json:"import"`    // kWh afgenomen van het net
+	Export    float64   `json:"export"`    // kWh teruggeleverd aan het net
+	SpotPrice float64   `json:"spotPrice"` // EUR/kWh excl. BTW
+	HasSpot   bool      `json:"hasSpot"`
+}
+
+// Options control how a contract is applied to a set of intervals
+type Options struct {
+	Location    *time.Location // Tijdzone van het huis voor dal-/normaaltijden en dagen
+	GridCompany string         // Netbeheerder voor de netbeheerkosten
+
+	// NettingShare is the share of exported kWh (up to the imported kWh of the same year)
+	// that is netted against import: 1 is full salderingsregeling, 0 is no netting at all
+	NettingShare float64
+}
+
+// Result is the cost breakdown of a contract over a period. Amounts are in EUR;
+// everything except FeedInRevenue is excluding VAT, VAT is listed separately.
+type Result struct {
+	Contract string    `json:"contract"`
+	Type     string    `json:"type"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Days     int       `json:"days"`
+
+	Import float64 `json:"import"`
+	Export float64 `json:"export"`
+	Netted float64 `json:"netted"`
+
+	EnergyCost    float64 `json:"energyCost"`
+	NettedValue   float64 `json:"nettedValue"`
+	FeedInRevenue float64 `json:"feedInRevenue"`
+	FeedInFees    float64 `json:"feedInFees"`
+	FixedCharges  float64 `json:"fixedCharges"`
+	GridFees      float64 `json:"gridFees"`
+	EnergyTax     float64 `json:"energyTax"`
+	TaxCredit     float64 `json:"taxCredit"`
+	VAT           float64 `json:"vat"`
+	Total         float64 `json:"total"`
+
+	MissingPrices int `json:"missingPrices"`
+}
+
+// Calculate recomputes what the intervals would have cost under the contract
+func Calculate(contract Contract, intervals []Interval, opts Options) (*Result, error) {
+	if err := contract.Validate(); err != nil {
+		return nil, err
+	}
+	if len(intervals) == 0 {
+		return nil, fmt.Errorf("no intervals to calculate")
+	}
+
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	sorted := make([]Interval, len(intervals))
+	copy(sorted, intervals)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	result := &Result{
+		Contract: contract.Name,
+		Type:     contract.Type,
+		From:     sorted[0].Start,
+		To:       sorted[len(sorted)-1].End,
+	}
+
+	// Gemiddelde spotprijs als terugval voor intervallen zonder prijs
+	var spotSum float64
+	var spotCount int
+	for _, iv := range sorted {
+		if iv.HasSpot {
+			spotSum += iv.SpotPrice
+			spotCount++
+		}
+	}
+	var fallbackSpot float64
+	if spotCount > 0 {
+		fallbackSpot = spotSum / float64(spotCount)
+	}
+
+	// Saldering en energiebelasting werken per kalenderjaar
+	type yearTotals struct {
+		imported, exported float64
+		days               map[string]bool
+	}
+	years := make(map[int]*yearTotals)
+	for _, iv := range sorted {
+		local := iv.Start.In(loc)
+		y, ok := years[local.Year()]
+		if !ok {
+			y = &yearTotals{days: make(map[string]bool)}
+			years[local.Year()] = y
+		}
+		y.imported += iv.Import
+		y.exported += iv.Export
+		y.days[local.Format("2006-01-02")] = true
+	}
+
+	for _, iv := range sorted {
+		local := iv.Start.In(loc)
+		y := years[local.Year()]
+
+		spot := iv.SpotPrice
+		if !iv.HasSpot {
+			spot = fallbackSpot
+			if contract.Type == TypeDynamic || contract.FeedIn.SpotBased {
+				result.MissingPrices++
+			}
+		}
+
+		rate := contract.energyRate(local, spot)
+		result.Import += iv.Import
+		result.Export += iv.Export
+		result.EnergyCost += iv.Import * rate
+
+		// Het gesaldeerde deel van de teruglevering wordt verrekend tegen het leveringstarief van dat moment
+		share := nettedShare(opts.NettingShare, y.imported, y.exported)
+		netted := iv.Export * share
+		rest := iv.Export - netted
+
+		result.Netted += netted
+		result.NettedValue += netted * rate
+		result.FeedInRevenue += rest * contract.feedInRate(spot)
+		result.FeedInFees += iv.Export * contract.FeedIn.Fee
+	}
+
+	// Vaste kosten, netbeheer, energiebelasting en heffingskorting per jaar
+	for year, y := range years {
+		days := len(y.days)
+		result.Days += days
+		result.FixedCharges += float64(days) * contract.DailyCharges
+		result.GridFees += float64(days) * contract.gridFee(opts.GridCompany)
+
+		daysInYear := time.Date(year+1, 1, 1, 0, 0, 0, 0, loc).Sub(time.Date(year, 1, 1, 0, 0, 0, 0, loc)).Hours() / 24
+		daysInYear = math.Round(daysInYear)
+		result.TaxCredit += contract.EnergyTax.TaxCredit * float64(days) / daysInYear
+
+		taxable := y.imported - y.exported*nettedShare(opts.NettingShare, y.imported, y.exported)
+		result.EnergyTax += contract.EnergyTax.amount(taxable)
+	}
+
+	vatBase := result.EnergyCost - result.NettedValue + result.FeedInFees +
+		result.FixedCharges + result.GridFees + result.EnergyTax - result.TaxCredit
+	result.VAT = vatBase * contract.VatRate
+
+	// Terugleververgoeding van particulieren valt buiten de BTW
+	result.Total = vatBase + result.VAT - result.FeedInRevenue
+
+	result.round()
+	return result, nil
+}
+
+// Compare calculates every contract over the same intervals, cheapest first
+func Compare(contracts []Contract, intervals []Interval, opts Options) ([]Result, error) {
+	results := make([]Result, 0, len(contracts))
+	for _, contract := range contracts {
+		result, err := Calculate(contract, intervals, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Total < results[j].Total })
+	return results, nil
+}
+
+// energyRate returns the supply rate at local time t
+func (c Contract) energyRate(t time.Time, spot float64) float64 {
+	switch c.Type {
+	case TypeDynamic:
+		return spot + c.Markup
+	case TypeVariable:
+		if rate, ok := c.Monthly[t.Format("2006-01")]; ok {
+			return rate
+		}
+		return c.Single
+	default:
+		if c.NightWindow != nil && c.Day != 0 {
+			if c.NightWindow.contains(t) {
+				return c.Night
+			}
+			return c.Day
+		}
+		return c.Single
+	}
+}
+
+// feedInRate returns the compensation for non-netted export
+func (c Contract) feedInRate(spot float64) float64 {
+	if c.FeedIn.SpotBased {
+		return spot + c.FeedIn.Markup
+	}
+	return c.FeedIn.Rate
+}
+
+// gridFee returns the daily grid operator charge for a grid company
+func (c Contract) gridFee(gridCompany string) float64 {
+	if fee, ok := c.GridFees[gridCompany]; ok {
+		return fee
+	}
+	return c.GridFees["*"]
+}
+
+// amount applies the brackets to a yearly taxable consumption
+func (t EnergyTax) amount(kwh float64) float64 {
+	if kwh <= 0 {
+		return 0
+	}
+
+	var total, lower float64
+	for _, b := range t.Brackets {
+		upper := b.UpTo
+		if upper == 0 || kwh < upper {
+			upper = kwh
+		}
+		if upper > lower {
+			total += (upper - lower) * b.Rate
+		}
+		if b.UpTo == 0 || kwh <= b.UpTo {
+			break
+		}
+		lower = b.UpTo
+	}
+	return total
+}
+
+// nettedShare returns the part of the export that is netted, capped at the import of the year
+func nettedShare(nettingShare, imported, exported float64) float64 {
+	if exported <= 0 || nettingShare <= 0 {
+		return 0
+	}
+	share := nettingShare
+	if limit := imported / exported; limit < 1 {
+		share *= limit
+	}
+	return share
+}
+
+func (r *Result) round() {
+	for _, v := range []*float64{
+		&r.Import, &r.Export, &r.Netted,
+		&r.EnergyCost, &r.NettedValue, &r.FeedInRevenue, &r.FeedInFees,
+		&r.FixedCharges, &r.GridFees, &r.EnergyTax, &r.TaxCredit, &r.VAT, &r.Total,
+	} {
+		*v = math.Round(*v*100) / 100
+	}
+}
@@ -0,0 +1,234 @@
+package tariff
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func amsterdam(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("tzdata not available: %v", err)
+	}
+	return loc
+}
+
+// testContract has round amounts so that the expected costs can be worked out by hand
+func testContract() Contract {
+	return Contract{
+		Name:         "Test",
+		Type:         TypeFixed,
+		Single:       0.20,
+		FeedIn:       FeedIn{Rate: 0.05},
+		DailyCharges: 0.50,
+		GridFees:     map[string]float64{"*": 1.00, "Liander": 0.80},
+		EnergyTax: EnergyTax{
+			Brackets:  []TaxBracket{{UpTo: 100, Rate: 0.10}, {UpTo: 0, Rate: 0.01}},
+			TaxCredit: 36.5,
+		},
+		VatRate: 0.21,
+	}
+}
+
+// hour returns an interval of one hour from start (UTC)
+func hour(start string, imported, exported float64) Interval {
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		panic(err)
+	}
+	return Interval{Start: t, End: t.Add(time.Hour), Import: imported, Export: exported}
+}
+
+func TestEnergyTaxAmount(t *testing.T) {
+	tax := testContract().EnergyTax
+	tests := []struct {
+		kwh, want float64
+	}{
+		{-5, 0},
+		{0, 0},
+		{50, 5},
+		{100, 10},
+		{150, 10.5},
+	}
+	for _, tt := range tests {
+		if got := tax.amount(tt.kwh); !near(got, tt.want) {
+			t.Errorf("amount(%v) = %v, want %v", tt.kwh, got, tt.want)
+		}
+	}
+
+	// 2025: 10.000 kWh in de eerste schijf, daarna 0,06937
+	if got, want := DutchEnergyTax2025().amount(12000), 10000*0.10154+2000*0.06937; !near(got, want) {
+		t.Errorf("2025 tax on 12000 kWh = %v, want %v", got, want)
+	}
+}
+
+func TestCalculateBracketsPerYear(t *testing.T) {
+	loc := amsterdam(t)
+	// 23:00 en 00:00 lokale tijd: oudejaarsavond en nieuwjaarsdag
+	intervals := []Interval{
+		hour("2024-12-31T22:00:00Z", 150, 0),
+		hour("2024-12-31T23:00:00Z", 150, 0),
+	}
+
+	tests := []struct {
+		name      string
+		loc       *time.Location
+		days      int
+		energyTax float64
+		taxCredit float64
+	}{
+		// Elk jaar begint opnieuw in de eerste schijf: 2 × (100 × 0,10 + 50 × 0,01)
+		{"local years", loc, 2, 21.00, 0.20},
+		// In UTC valt alles in 2024: 100 × 0,10 + 200 × 0,01
+		{"utc", time.UTC, 1, 12.00, 0.10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Calculate(testContract(), intervals, Options{Location: tt.loc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Days != tt.days || result.EnergyTax != tt.energyTax || result.TaxCredit != tt.taxCredit {
+				t.Errorf("days %d, energy tax %v, tax credit %v; want %d, %v, %v",
+					result.Days, result.EnergyTax, result.TaxCredit, tt.days, tt.energyTax, tt.taxCredit)
+			}
+		})
+	}
+}
+
+func TestCalculateNetting(t *testing.T) {
+	// Eén dag in 2025 in UTC: vaste kosten 0,50, netbeheer 1,00 en heffingskorting 36,5 / 365 = 0,10
+	tests := []struct {
+		name             string
+		imported, export float64
+		share            float64
+		feedInFee        float64
+		want             Result
+	}{
+		{
+			// Alle teruglevering wordt gesaldeerd, belasting over 10 − 4 kWh
+			name: "import above export", imported: 10, export: 4, share: 1,
+			want: Result{Netted: 4, EnergyCost: 2.00, NettedValue: 0.80, EnergyTax: 0.60, VAT: 0.67, Total: 3.87},
+		},
+		{
+			// Saldering tot de afname, de rest krijgt de terugleververgoeding; geen belasting
+			name: "export above import", imported: 4, export: 10, share: 1,
+			want: Result{Netted: 4, EnergyCost: 0.80, NettedValue: 0.80, FeedInRevenue: 0.30, VAT: 0.29, Total: 1.39},
+		},
+		{
+			name: "half netting", imported: 10, export: 4, share: 0.5,
+			want: Result{Netted: 2, EnergyCost: 2.00, NettedValue: 0.40, FeedInRevenue: 0.10, EnergyTax: 0.80, VAT: 0.80, Total: 4.50},
+		},
+		{
+			name: "no netting", imported: 10, export: 4, share: 0,
+			want: Result{EnergyCost: 2.00, FeedInRevenue: 0.20, EnergyTax: 1.00, VAT: 0.92, Total: 5.12},
+		},
+		{
+			// Terugleverkosten over alle teruggeleverde kWh, met BTW
+			name: "no netting with feed-in fee", imported: 10, export: 4, share: 0, feedInFee: 0.10,
+			want: Result{EnergyCost: 2.00, FeedInRevenue: 0.20, FeedInFees: 0.40, EnergyTax: 1.00, VAT: 1.01, Total: 5.61},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contract := testContract()
+			contract.FeedIn.Fee = tt.feedInFee
+			intervals := []Interval{hour("2025-06-02T10:00:00Z", tt.imported, tt.export)}
+
+			got, err := Calculate(contract, intervals, Options{Location: time.UTC, NettingShare: tt.share})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Import != tt.imported || got.Export != tt.export || got.FixedCharges != 0.50 || got.GridFees != 1.00 || got.TaxCredit != 0.10 {
+				t.Errorf("import %v export %v fixed %v grid %v credit %v", got.Import, got.Export, got.FixedCharges, got.GridFees, got.TaxCredit)
+			}
+			w := tt.want
+			if got.Netted != w.Netted || got.EnergyCost != w.EnergyCost || got.NettedValue != w.NettedValue ||
+				got.FeedInRevenue != w.FeedInRevenue || got.FeedInFees != w.FeedInFees || got.EnergyTax != w.EnergyTax ||
+				got.VAT != w.VAT || got.Total != w.Total {
+				t.Errorf("got %+v\nwant %+v", *got, w)
+			}
+		})
+	}
+}
+
+func TestCalculateFixedCosts(t *testing.T) {
+	loc := amsterdam(t)
+	// 23:30 UTC is 01:30 de volgende dag in Amsterdam: drie lokale dagen
+	intervals := []Interval{
+		hour("2025-06-01T23:30:00Z", 1, 0),
+		hour("2025-06-02T12:00:00Z", 1, 0),
+		hour("2025-06-02T23:30:00Z", 1, 0),
+		hour("2025-06-03T23:30:00Z", 1, 0),
+	}
+
+	tests := []struct {
+		grid     string
+		gridFees float64
+	}{
+		{"Liander", 2.40},
+		{"Stedin", 3.00}, // Standaard "*"
+	}
+	for _, tt := range tests {
+		result, err := Calculate(testContract(), intervals, Options{Location: loc, GridCompany: tt.grid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Days != 3 || result.FixedCharges != 1.50 || result.GridFees != tt.gridFees {
+			t.Errorf("%s: %d days, fixed %v, grid %v; want 3 days, 1.5, %v", tt.grid, result.Days, result.FixedCharges, result.GridFees, tt.gridFees)
+		}
+		// Afname 4 × 0,20 en belasting 4 × 0,10, heffingskorting 3 × 0,10
+		base := 0.80 + 1.50 + tt.gridFees + 0.40 - 0.30
+		if !near(result.VAT, round2(base*0.21)) || !near(result.Total, round2(base*1.21)) {
+			t.Errorf("%s: VAT %v total %v, want %v %v", tt.grid, result.VAT, result.Total, round2(base*0.21), round2(base*1.21))
+		}
+	}
+}
+
+func TestCalculateRates(t *testing.T) {
+	loc := amsterdam(t)
+	double := testContract()
+	double.Single = 0
+	double.Day, double.Night = 0.30, 0.10
+	double.NightWindow = &TimeWindow{Start: "23:00", End: "07:00", Weekends: true}
+
+	dynamic := testContract()
+	dynamic.Type, dynamic.Markup = TypeDynamic, 0.02
+
+	// Maandag 2 juni 2025: 06:00 en 07:00 lokale tijd, en zaterdag 7 juni 12:00
+	intervals := []Interval{
+		hour("2025-06-02T04:00:00Z", 1, 0),
+		hour("2025-06-02T05:00:00Z", 1, 0),
+		hour("2025-06-07T10:00:00Z", 1, 0),
+	}
+	intervals[0].SpotPrice, intervals[0].HasSpot = 0.08, true
+	intervals[1].SpotPrice, intervals[1].HasSpot = 0.12, true
+
+	result, err := Calculate(double, intervals, Options{Location: loc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EnergyCost != 0.50 { // Dal, normaal, weekend dal
+		t.Errorf("double tariff energy cost %v, want 0.5", result.EnergyCost)
+	}
+
+	// Zonder prijs geldt de gemiddelde spotprijs, en telt het interval als ontbrekend
+	result, err = Calculate(dynamic, intervals, Options{Location: loc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EnergyCost != 0.36 || result.MissingPrices != 1 {
+		t.Errorf("dynamic energy cost %v with %d missing prices, want 0.36 with 1", result.EnergyCost, result.MissingPrices)
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,161 @@
+package tariff
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultFeedInFee is the typical fee per returned kWh that suppliers charge once netting ends
+const DefaultFeedInFee = 0.10
+
+// Scenario is a netting situation to calculate a year with
+type Scenario struct {
+	Name         string  `json:"name"`
+	NettingShare float64 `json:"nettingShare"`
+	FeedIn       *FeedIn `json:"feedIn,omitempty"` // Overschrijft de terugleverregeling van het contract
+}
+
+// NetMeteringScenarios returns the standard scenarios for the end of the salderingsregeling:
+// full netting, no netting with the contract's feed-in compensation, and no netting with a feed-in fee
+func NetMeteringScenarios(contract Contract, feedInFee float64) []Scenario {
+	withFee := contract.FeedIn
+	withFee.Fee = feedInFee
+
+	return []Scenario{
+		{Name: "Volledige saldering", NettingShare: 1},
+		{Name: "Geen saldering", NettingShare: 0},
+		{Name: "Geen saldering, met terugleverkosten", NettingShare: 0, FeedIn: &withFee},
+	}
+}
+
+// ScenarioResult is the outcome of one scenario
+type ScenarioResult struct {
+	Scenario   Scenario `json:"scenario"`
+	Result     Result   `json:"result"`
+	Difference float64  `json:"difference"` // Meerkosten ten opzichte van het eerste scenario
+}
+
+// NetMeteringReport is the yearly impact of the phase-out of netting for one contract
+type NetMeteringReport struct {
+	Year     int    `json:"year"`
+	Contract string `json:"contract"`
+	Days     int    `json:"days"`
+
+	Import   float64 `json:"import"`
+	Export   float64 `json:"export"`
+	Coverage float64 `json:"coverage"` // Deel van de afname dat met eigen teruglevering te salderen is
+
+	// Volumegewogen gemiddelde spotprijs van afname en teruglevering
+	ImportSpot float64 `json:"importSpot"`
+	ExportSpot float64 `json:"exportSpot"`
+
+	// TimeOfUseEffect is what the export is worth less because it happens at cheaper hours
+	// than the import: export × (ImportSpot − ExportSpot)
+	TimeOfUseEffect float64 `json:"timeOfUseEffect"`
+
+	Scenarios     []ScenarioResult `json:"scenarios"`
+	MissingPrices int              `json:"missingPrices"`
+}
+
+// NetMetering calculates the scenarios for every calendar year in the intervals
+func NetMetering(contract Contract, intervals []Interval, opts Options, scenarios []Scenario) ([]NetMeteringReport, error) {
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("no scenarios to calculate")
+	}
+
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	// Per kalenderjaar, in volgorde van voorkomen
+	var years []int
+	byYear := make(map[int][]Interval)
+	for _, iv := range intervals {
+		year := iv.Start.In(loc).Year()
+		if _, ok := byYear[year]; !ok {
+			years = append(years, year)
+		}
+		byYear[year] = append(byYear[year], iv)
+	}
+
+	reports := make([]NetMeteringReport, 0, len(years))
+	for _, year := range years {
+		report, err := netMeteringYear(contract, byYear[year], opts, scenarios)
+		if err != nil {
+			return nil, fmt.Errorf("year %d: %w", year, err)
+		}
+		report.Year = year
+		reports = append(reports, *report)
+	}
+
+	return reports, nil
+}
+
+func netMeteringYear(contract Contract, intervals []Interval, opts Options, scenarios []Scenario) (*NetMeteringReport, error) {
+	report := &NetMeteringReport{Contract: contract.Name}
+
+	var importSpot, exportSpot float64
+	for _, iv := range intervals {
+		report.Import += iv.Import
+		report.Export += iv.Export
+		if iv.HasSpot {
+			importSpot += iv.Import * iv.SpotPrice
+			exportSpot += iv.Export * iv.SpotPrice
+		}
+	}
+	if report.Import > 0 {
+		report.ImportSpot = importSpot / report.Import
+		report.Coverage = math.Min(report.Export/report.Import, 1)
+	}
+	if report.Export > 0 {
+		report.ExportSpot = exportSpot / report.Export
+	}
+	report.TimeOfUseEffect = report.Export * (report.ImportSpot - report.ExportSpot)
+
+	for i, scenario := range scenarios {
+		c := contract
+		if scenario.FeedIn != nil {
+			c.FeedIn = *scenario.FeedIn
+		}
+
+		scenarioOpts := opts
+		scenarioOpts.NettingShare = scenario.NettingShare
+
+		result, err := Calculate(c, intervals, scenarioOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		sr := ScenarioResult{Scenario: scenario, Result: *result}
+		if i > 0 {
+			sr.Difference = math.Round((result.Total-report.Scenarios[0].Result.Total)*100) / 100
+		}
+		report.Scenarios = append(report.Scenarios, sr)
+		report.Days = result.Days
+		report.MissingPrices = result.MissingPrices
+	}
+
+	for _, v := range []*float64{&report.Import, &report.Export, &report.TimeOfUseEffect} {
+		*v = math.Round(*v*100) / 100
+	}
+	report.Coverage = math.Round(report.Coverage*1000) / 1000
+	report.ImportSpot = math.Round(report.ImportSpot*100000) / 100000
+	report.ExportSpot = math.Round(report.ExportSpot*100000) / 100000
+
+	return report, nil
+}
+
+// ContractByName returns the contract with the given name, or the first dynamic contract when name is empty
+func ContractByName(contracts []Contract, name string) (Contract, error) {
+	for _, c := range contracts {
+		if (name == "" && c.Type == TypeDynamic) || (name != "" && c.Name == name) {
+			return c, nil
+		}
+	}
+	if name == "" && len(contracts) > 0 {
+		return contracts[0], nil
+	}
+	return Contract{}, fmt.Errorf("contract %q not found", name)
+}
@@ -0,0 +1,99 @@
+package tariff
+
+import (
+	"testing"
+	"time"
+)
+
+// spot sets the day-ahead price of an interval
+func spot(iv Interval, price float64) Interval {
+	iv.SpotPrice, iv.HasSpot = price, true
+	return iv
+}
+
+func TestNetMeteringPerLocalYear(t *testing.T) {
+	loc := amsterdam(t)
+	intervals := []Interval{
+		// 22:00 en 23:00 lokale tijd op oudejaarsavond: afname bij een hoge, teruglevering bij een lage prijs
+		spot(hour("2024-12-31T21:00:00Z", 10, 0), 0.30),
+		spot(hour("2024-12-31T22:00:00Z", 0, 4), 0.10),
+		// 00:30 op nieuwjaarsdag, in UTC nog 2024; meer teruglevering dan afname
+		spot(hour("2024-12-31T23:30:00Z", 4, 10), 0.10),
+	}
+	scenarios := NetMeteringScenarios(testContract(), 0.10)
+
+	reports, err := NetMetering(testContract(), intervals, Options{Location: loc}, scenarios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Year != 2024 || reports[1].Year != 2025 {
+		t.Fatalf("got %d reports, want 2024 and 2025", len(reports))
+	}
+
+	tests := []struct {
+		report                   NetMeteringReport
+		coverage, timeOfUse      float64
+		totals                   [3]float64
+		differenceNone, withFees float64
+	}{
+		{
+			// Teruglevering dekt 4 / 10 van de afname en is 0,20 per kWh minder waard
+			report: reports[0], coverage: 0.4, timeOfUse: 0.80,
+			totals: [3]float64{3.87, 5.12, 5.61}, differenceNone: 1.25, withFees: 1.74,
+		},
+		{
+			// Meer teruglevering dan afname: de dekking wordt afgekapt op 1
+			report: reports[1], coverage: 1, timeOfUse: 0,
+			totals: [3]float64{1.39, 2.65, 3.86}, differenceNone: 1.26, withFees: 2.47,
+		},
+	}
+	for _, tt := range tests {
+		r := tt.report
+		if r.Days != 1 || r.Coverage != tt.coverage || r.TimeOfUseEffect != tt.timeOfUse || r.MissingPrices != 0 {
+			t.Errorf("%d: %d days, coverage %v, time-of-use effect %v, %d missing prices", r.Year, r.Days, r.Coverage, r.TimeOfUseEffect, r.MissingPrices)
+		}
+		if len(r.Scenarios) != 3 {
+			t.Fatalf("%d: %d scenarios", r.Year, len(r.Scenarios))
+		}
+		for i, s := range r.Scenarios {
+			if s.Result.Total != tt.totals[i] {
+				t.Errorf("%d %s: total %v, want %v", r.Year, s.Scenario.Name, s.Result.Total, tt.totals[i])
+			}
+		}
+		// Het verschil is steeds ten opzichte van het eerste scenario, volledige saldering
+		if d := r.Scenarios[0].Difference; d != 0 {
+			t.Errorf("%d: first scenario has difference %v", r.Year, d)
+		}
+		if r.Scenarios[1].Difference != tt.differenceNone || r.Scenarios[2].Difference != tt.withFees {
+			t.Errorf("%d: differences %v and %v, want %v and %v", r.Year,
+				r.Scenarios[1].Difference, r.Scenarios[2].Difference, tt.differenceNone, tt.withFees)
+		}
+	}
+	if r := reports[0]; r.ImportSpot != 0.30 || r.ExportSpot != 0.10 || r.Import != 10 || r.Export != 4 {
+		t.Errorf("2024: import %v at %v, export %v at %v", r.Import, r.ImportSpot, r.Export, r.ExportSpot)
+	}
+
+	// In UTC valt alles in 2024
+	reports, err = NetMetering(testContract(), intervals, Options{Location: time.UTC}, scenarios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Year != 2024 || reports[0].Import != 14 || reports[0].Export != 14 || reports[0].Coverage != 1 {
+		t.Errorf("utc: %+v", reports)
+	}
+}
+
+func TestNetMeteringWithoutImport(t *testing.T) {
+	reports, err := NetMetering(testContract(), []Interval{hour("2025-06-02T10:00:00Z", 0, 5)},
+		Options{Location: time.UTC}, NetMeteringScenarios(testContract(), DefaultFeedInFee))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reports[0]; r.Coverage != 0 || r.ImportSpot != 0 {
+		t.Errorf("coverage %v and import spot %v without import", r.Coverage, r.ImportSpot)
+	}
+
+	if _, err := NetMetering(testContract(), nil, Options{}, nil); err == nil {
+		t.Error("expected an error without scenarios")
+	}
+}
@@ -26,6 +26,21 @@ type WebsocketClient struct {
 type Client struct {
 	WebsocketClient *WebsocketClient
 	Wg              *sync.WaitGroup
+	APIURL          string // GraphQL endpoint for VerifyAccess, empty means DefaultEndpoints.APIURL
+}
+
+// Endpoints are the Tibber hosts the client talks to
+type Endpoints struct {
+	APIURL        string
+	WebsocketHost string
+	WebsocketPath string
+}
+
+// DefaultEndpoints are the public Tibber endpoints
+var DefaultEndpoints = Endpoints{
+	APIURL:        "https://api.tibber.com/v1-beta/gql",
+	WebsocketHost: "websocket-api.tibber.com",
+	WebsocketPath: "/v1-beta/gql/subscriptions",
 }
 
 type Message struct {
@@ -64,17 +79,23 @@ func NewWebsocketConfig(config *WebsocketConfig) *WebsocketConfig {
 }
 
 func NewClient(token, houseId string) *Client {
+	return NewClientWithEndpoints(token, houseId, DefaultEndpoints)
+}
+
+// NewClientWithEndpoints is NewClient for other endpoints, such as a proxy or test server
+func NewClientWithEndpoints(token, houseId string, endpoints Endpoints) *Client {
 	client := &Client{
 		WebsocketClient: &WebsocketClient{
 			Config: NewWebsocketConfig(&WebsocketConfig{
 				Token: token,
-				Host:  "websocket-api.tibber.com",
-				Path:  "/v1-beta/gql/subscriptions",
+				Host:  endpoints.WebsocketHost,
+				Path:  endpoints.WebsocketPath,
 				Id:    houseId,
 			}),
 			Data: make(chan Measurement),
 		},
-		Wg: &sync.WaitGroup{},
+		Wg:     &sync.WaitGroup{},
+		APIURL: endpoints.APIURL,
 	}
 
 	if err := client.VerifyAccess(); err != nil {
@@ -106,7 +127,11 @@ func (c *Client) VerifyAccess() error {
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", "https://api.tibber.com/v1-beta/gql", bytes.NewBuffer(body))
+	apiURL := c.APIURL
+	if apiURL == "" {
+		apiURL = DefaultEndpoints.APIURL
+	}
+	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
 	}
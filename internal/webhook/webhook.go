@@ -0,0 +1,210 @@
+// Package webhook signs and delivers events to the endpoints that members and the operator register.
+// The body is JSON; the signature is an HMAC-SHA256 over the timestamp and the body with the secret
+// of the endpoint, so that a receiver can check where a call comes from and that it is recent.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Soorten gebeurtenissen
+const (
+	// EventTomorrowPrices: the day-ahead prices of tomorrow are in for a home
+	EventTomorrowPrices = "prices.tomorrow"
+	// EventSettlement: the cost and revenue of a home for a day are complete, after the nightly catch-up
+	EventSettlement = "settlement.completed"
+	// EventAnomaly: an anomaly was detected for a home
+	EventAnomaly = "anomaly.detected"
+	// EventPing is a test event for one endpoint
+	EventPing = "webhook.ping"
+)
+
+// EventTypes are the events an endpoint can subscribe to
+var EventTypes = []string{EventTomorrowPrices, EventSettlement, EventAnomaly}
+
+// Headers van een aflevering
+const (
+	HeaderID        = "X-Webhook-Id" // ID van de gebeurtenis, gelijk bij een nieuwe poging of replay
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Afleveren: na MaxAttempts mislukte pogingen geeft de wachtrij het op
+const (
+	MaxAttempts = 10
+	Timeout     = 10 * time.Second
+	// maxBackoff caps the wait between two attempts
+	maxBackoff = 6 * time.Hour
+)
+
+// Event is the body of a delivery
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	HomeID    string          `json:"homeId,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ValidEventType reports whether an endpoint can subscribe to an event type
+func ValidEventType(t string) bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random secret for a new endpoint
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of a body sent at a timestamp (Unix seconds)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received delivery; a timestamp further than tolerance from now
+// is rejected, so that a captured delivery cannot be replayed later by someone else
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("timestamp is %s off", d.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// Backoff returns the wait after a failed attempt: a minute after the first, doubling up to six hours
+func Backoff(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// CheckURL validates the URL of an endpoint. Endpoints of members must use https.
+func CheckURL(raw string, httpsOnly bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", raw)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && !httpsOnly:
+	default:
+		if httpsOnly {
+			return fmt.Errorf("URL must use https, got %q", raw)
+		}
+		return fmt.Errorf("URL must use http or https, got %q", raw)
+	}
+	return nil
+}
+
+// ErrPrivateAddress is returned when an endpoint of a member resolves to an address in a private
+// network, such as the network of the server itself
+var ErrPrivateAddress = errors.New("endpoint resolves to a private address")
+
+// Client returns the HTTP client for deliveries. With public set it only connects to public
+// addresses, checked after DNS resolution, so that a member cannot reach the internal network.
+// Redirects are not followed.
+func Client(public bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if public {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   Timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Result is the outcome of one attempt
+type Result struct {
+	Status   int // HTTP status, 0 zonder antwoord
+	Duration time.Duration
+	Err      error
+}
+
+// Deliver posts an event to an endpoint once. A 2xx status is success; anything else, including a
+// redirect, is an error.
+func Deliver(ctx context.Context, client *http.Client, endpoint, secret string, event Event) Result {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Result{Err: fmt.Errorf("error encoding event: %w", err)}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Result{Err: fmt.Errorf("error creating request: %w", err)}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ws-webhooks/1")
+	req.Header.Set(HeaderID, strconv.FormatInt(event.ID, 10))
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Een stukje van het antwoord helpt de ontvanger bij het zoeken naar de fout
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		result.Err = fmt.Errorf("endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return result
+}